@@ -0,0 +1,89 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	replay "marketplace_server/cmd/engine_replay/src"
+	engine "marketplace_server/cmd/transaction_server/src"
+	"marketplace_server/config"
+	"marketplace_server/internal/common/logs"
+	"os"
+
+	"github.com/spf13/pflag"
+)
+
+// 交易引擎的回放工具
+// 將 指令日誌 (transaction_server 的 journalPath, 或自行編寫相同格式的 json lines) 送入交易引擎
+// 使用記憶體的持久層 與 模擬時鐘, 不需要 mysql redis rabbit mq, 輸出 成交紀錄 用戶餘額 與 最後的訂單簿
+//
+//	go run ./cmd/engine_replay -c cmd/transaction_server/config.yaml \
+//		--scenario cmd/engine_replay/example/scenario.json \
+//		--journal cmd/engine_replay/example/journal.jsonl --out -
+func main() {
+
+	journalPath := pflag.String("journal", "", "指令日誌 (每行一筆 JournalEntry, 檔案最後需要換行)")
+	scenarioPath := pflag.String("scenario", "", "初始狀態 json (上架的商品 用戶的餘額 背包)")
+	snapshotPath := pflag.String("snapshot", "", "訂單簿快照, 從快照還原後 只回放快照之後的指令")
+	outPath := pflag.String("out", "replay_result.json", "結果輸出的檔案 (- = stdout)")
+	policy := pflag.String("policy", "", "覆蓋所有商品的搓合策略 fifo | prorata (比較搓合策略)")
+	runAfter := pflag.Duration("run-after", 0, "最後一筆指令之後 繼續執行排程任務的時間 例如 1h (訂單到期 K線結束)")
+
+	// 初始化配置 (預設使用 transaction_server 的設定: 搓合策略 手續費 熔斷)
+	cfg := config.NewYmlConfig("../transaction_server/config.yaml")
+	logs.Init(cfg.Log)
+
+	if len(*journalPath) == 0 {
+		fmt.Fprintln(os.Stderr, "usage: engine_replay -c <config.yaml> --journal <journal.jsonl> [--scenario <scenario.json>] [--snapshot <snapshot.json>]")
+		pflag.PrintDefaults()
+		os.Exit(2)
+	}
+
+	// 回放時不寫入指令日誌 與 快照
+	cfg.Engine.JournalPath = ""
+	cfg.Engine.SnapshotPath = ""
+	if len(*policy) > 0 {
+		cfg.Engine.MatchingPolicy = *policy
+		for i := range cfg.Engine.Products {
+			cfg.Engine.Products[i].MatchingPolicy = ""
+		}
+	}
+
+	scenario, err := replay.LoadScenario(*scenarioPath)
+	if err != nil {
+		logs.Fatalf("loadScenario fail path:%v, err:%v", *scenarioPath, err)
+	}
+
+	var snapshot *engine.Snapshot
+	if len(*snapshotPath) > 0 {
+		if snapshot, err = engine.LoadSnapshot(*snapshotPath); err != nil || snapshot == nil {
+			logs.Fatalf("loadSnapshot fail path:%v, err:%v", *snapshotPath, err)
+		}
+	}
+
+	replayer, err := replay.NewReplayer(cfg, scenario, snapshot, *journalPath)
+	if err != nil {
+		logs.Fatalf("newReplayer fail err:%v", err)
+	}
+	if err = replayer.Run(*journalPath, *runAfter); err != nil {
+		logs.Fatalf("replay fail err:%v", err)
+	}
+
+	result, err := replayer.Result()
+	if err != nil {
+		logs.Fatalf("result fail err:%v", err)
+	}
+	byteArray, err := json.MarshalIndent(result, "", "  ")
+	if err != nil {
+		logs.Fatalf("marshal result fail err:%v", err)
+	}
+	byteArray = append(byteArray, '\n')
+
+	if *outPath == "-" {
+		os.Stdout.Write(byteArray)
+		return
+	}
+	if err = os.WriteFile(*outPath, byteArray, 0644); err != nil {
+		logs.Fatalf("write result fail path:%v, err:%v", *outPath, err)
+	}
+	logs.Debugf("回放完成 指令數量:%d, 成交筆數:%d, 結果:%s", result.Commands, len(result.Trades), *outPath)
+}
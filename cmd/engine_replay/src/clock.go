@@ -0,0 +1,31 @@
+package src
+
+import (
+	"sync"
+	"time"
+)
+
+// 模擬時鐘, 由回放依指令的時間推進 (不會倒退), 相同的輸入 產生相同的結果
+type ManualClock struct {
+	lock sync.RWMutex
+	now  time.Time
+}
+
+func NewManualClock(now time.Time) *ManualClock {
+	return &ManualClock{now: now.UTC()}
+}
+
+func (c *ManualClock) Now() time.Time {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	return c.now
+}
+
+// 設定目前時間, 比目前時間早就忽略
+func (c *ManualClock) Set(now time.Time) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if now.After(c.now) {
+		c.now = now.UTC()
+	}
+}
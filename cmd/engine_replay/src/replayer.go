@@ -0,0 +1,375 @@
+package src
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	engine "marketplace_server/cmd/transaction_server/src"
+	"marketplace_server/config"
+	Infrastructure_backpack "marketplace_server/internal/backpack/Infrastructure_layer"
+	Infrastructure_bill "marketplace_server/internal/bill/Infrastructure_layer"
+	domain_bill "marketplace_server/internal/bill/domain_layer"
+	"marketplace_server/internal/common/logs"
+	Infrastructure_product "marketplace_server/internal/product/Infrastructure_layer"
+	model_product "marketplace_server/internal/product/model"
+	Infrastructure_server "marketplace_server/internal/servers/Infrastructure_layer"
+	Infrastructure_user "marketplace_server/internal/user/Infrastructure_layer"
+	application_user "marketplace_server/internal/user/application_layer"
+	domain_user "marketplace_server/internal/user/domain_layer"
+	"marketplace_server/internal/user/model"
+	"sort"
+	"time"
+)
+
+// 找到第一筆指令 停止讀取
+var errFirstEntry = errors.New("first entry")
+
+// 回放工具
+// 使用記憶體的持久層 與 模擬時鐘, 依序將指令送入交易引擎 (不需要 mysql redis rabbit mq)
+// 買賣單 先依 marketplace_server 受理的方式 寫入交易單 凍結商品 預扣金額, 再送給引擎搓合
+// 模擬時鐘經過的排程時間 (CronInterval) 執行排程任務, 引擎內部的指令 (集合競價 熔斷結束) 由排程重新產生
+type Replayer struct {
+	cfg          *config.Config
+	clock        *ManualClock
+	repos        *Infrastructure_server.RepositoriesManager
+	users        *Infrastructure_user.MemoryUserRepo
+	backpacks    *Infrastructure_backpack.MemoryBackpackRepo
+	transactions *Infrastructure_bill.MemoryTransactionRepo
+	trades       *Infrastructure_bill.MemoryTradeRepo
+	acceptor     *application_user.OrderAcceptor // 與 marketplace_server 相同的受理方式
+	engine       *engine.TransactionEgine
+	fromSeq      int64     // 快照包含到的日誌序號, 只回放之後的指令
+	startTime    time.Time // 開始時間
+	nextCron     time.Time // 下一次執行排程任務的時間
+	commands     int64     // 送入引擎的指令數量
+	skipped      int64     // 略過的引擎內部指令數量
+	rejected     []*RejectedCommand
+}
+
+// 受理時被拒絕的指令 (餘額不足 商品不足 商品不存在)
+type RejectedCommand struct {
+	Seq     int64                           `json:"seq"`     // 日誌序號
+	Command *model.ProductTransactionNotify `json:"command"` // 指令
+	Reason  string                          `json:"reason"`  // 拒絕原因
+}
+
+// 建立回放工具, 寫入初始狀態 有快照時從快照還原訂單簿
+// 開始時間依序使用 初始狀態的開始時間 快照時間 第一筆指令的時間
+func NewReplayer(cfg *config.Config, scenario *Scenario, snapshot *engine.Snapshot, journalPath string) (*Replayer, error) {
+
+	startTime, err := scenario.GetStartTime()
+	if err != nil {
+		return nil, fmt.Errorf("parse start_time fail err:%v", err)
+	}
+	if startTime.IsZero() && snapshot != nil {
+		startTime = time.Unix(0, snapshot.Time)
+	}
+	if startTime.IsZero() {
+		if startTime, err = firstEntryTime(journalPath); err != nil {
+			return nil, err
+		}
+	}
+
+	r := &Replayer{
+		cfg:          cfg,
+		clock:        NewManualClock(startTime),
+		users:        Infrastructure_user.NewMemoryUserRepo(),
+		backpacks:    Infrastructure_backpack.NewMemoryBackpackRepo(),
+		transactions: Infrastructure_bill.NewMemoryTransactionRepo(),
+		trades:       Infrastructure_bill.NewMemoryTradeRepo(),
+	}
+	r.startTime = r.clock.Now()
+	r.nextCron = r.startTime.Add(engine.CronInterval)
+	r.repos = &Infrastructure_server.RepositoriesManager{
+		AuthRepo:        Infrastructure_user.NewMemoryAuthRepo(),
+		UserRepo:        r.users,
+		TransactionRepo: r.transactions,
+		TradeRepo:       r.trades,
+		ProductRepo:     Infrastructure_product.NewMemoryProductRepo(),
+		CandleRepo:      Infrastructure_product.NewMemoryCandleRepo(),
+		DepthRepo:       Infrastructure_product.NewMemoryDepthRepo(),
+		BackpackRepo:    r.backpacks,
+	}
+	fee, err := domain_bill.NewFeeService(cfg.Fee)
+	if err != nil {
+		return nil, err
+	}
+	r.acceptor = application_user.NewOrderAcceptor(r.repos.AuthRepo, r.transactions, r.backpacks, domain_user.NewRateService(), fee)
+
+	if err = r.seed(scenario); err != nil {
+		return nil, err
+	}
+	if snapshot != nil {
+		r.fromSeq = snapshot.Seq
+		if err = r.seedSnapshot(snapshot); err != nil {
+			return nil, err
+		}
+	}
+
+	r.engine, err = engine.NewReplayEgine(cfg, r.repos, r.clock, snapshot)
+	if err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// 第一筆指令的時間
+func firstEntryTime(journalPath string) (startTime time.Time, err error) {
+	err = engine.ReadJournal(journalPath, 0, func(entry *engine.JournalEntry) error {
+		startTime = time.Unix(0, entry.Time)
+		return errFirstEntry
+	})
+	if err != nil && err != errFirstEntry {
+		return startTime, err
+	}
+	if startTime.IsZero() || startTime.UnixNano() <= 0 {
+		return startTime, fmt.Errorf("start time not found, set start_time in scenario")
+	}
+	return startTime, nil
+}
+
+// 寫入初始狀態: 上架商品與市場價格, 用戶 用戶緩存 與 背包, 平台收入帳戶
+func (r *Replayer) seed(scenario *Scenario) error {
+
+	for _, scenarioProduct := range scenario.Products {
+		product := scenarioProduct.ToDomain(r.startTime)
+		if err := r.repos.ProductRepo.Save(product); err != nil {
+			return err
+		}
+		marketPriceDetail := product.ToMarketPriceRedis()
+		marketPriceDetail.UpdateTime = r.startTime.String()
+		marketPriceJson, err := marketPriceDetail.ToJson()
+		if err != nil {
+			return err
+		}
+		err = r.repos.ProductRepo.RedisSetMarketPrice(Infrastructure_product.Redis_MarketPrice,
+			map[string]string{product.ProductName: marketPriceJson})
+		if err != nil {
+			return err
+		}
+	}
+
+	for _, scenarioUser := range scenario.Users {
+		if err := r.seedUser(scenarioUser); err != nil {
+			return err
+		}
+	}
+
+	// 平台收入帳戶 不存在就建立 (收取手續費時需要)
+	if platformUserID := r.cfg.Fee.PlatformUserID; platformUserID > 0 {
+		if _, err := r.users.GetUserInfo(platformUserID); err != nil {
+			if err = r.seedUser(&ScenarioUser{UserID: platformUserID}); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// 寫入用戶 用戶緩存 與 背包
+func (r *Replayer) seedUser(scenarioUser *ScenarioUser) error {
+
+	user := scenarioUser.ToDomain(r.startTime)
+	if _, err := r.users.Save(user); err != nil {
+		return err
+	}
+	_, err := r.repos.AuthRepo.Set(&model.AuthInfo{
+		UserID:   user.UserID,
+		Currency: user.Currency,
+		Amount:   user.Amount,
+	})
+	if err != nil {
+		return err
+	}
+
+	productNames := make([]string, 0, len(scenarioUser.Products))
+	for productName := range scenarioUser.Products {
+		productNames = append(productNames, productName)
+	}
+	sort.Strings(productNames)
+	for _, productName := range productNames {
+		if err = r.backpacks.Save(scenarioUser.ToBackpack(productName, r.startTime)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// 快照內的訂單 寫入交易單 並凍結商品 預扣金額 (依建立時間排序)
+func (r *Replayer) seedSnapshot(snapshot *engine.Snapshot) error {
+
+	var orders []*model.ProductTransactionParams
+	for _, book := range snapshot.OrderBooks {
+		orders = append(orders, book.Bids...)
+		orders = append(orders, book.Asks...)
+	}
+	for _, stopBook := range snapshot.StopBooks {
+		orders = append(orders, stopBook.Orders...)
+	}
+	sort.Slice(orders, func(i, j int) bool {
+		if orders[i].TimeStamp != orders[j].TimeStamp {
+			return orders[i].TimeStamp < orders[j].TimeStamp
+		}
+		return orders[i].TransactionID < orders[j].TransactionID
+	})
+
+	for _, order := range orders {
+		params := *order
+		params.OperateCount = order.RemainCount
+		if err := r.acceptOrder(&params); err != nil {
+			return fmt.Errorf("seed snapshot order fail transactionID:%v, err:%v", order.TransactionID, err)
+		}
+	}
+	return nil
+}
+
+// 依序回放日誌中的指令 (快照之後的), 最後再執行 runAfter 時間內的排程任務
+func (r *Replayer) Run(journalPath string, runAfter time.Duration) error {
+
+	err := engine.ReadJournal(journalPath, r.fromSeq, r.apply)
+	if err != nil {
+		return err
+	}
+
+	r.advance(r.clock.Now().Add(runAfter))
+	return nil
+}
+
+// 回放一筆指令
+func (r *Replayer) apply(entry *engine.JournalEntry) error {
+
+	if entry.Command == nil {
+		return fmt.Errorf("command == nil seq:%d", entry.Seq)
+	}
+	if entry.Time > 0 {
+		r.advance(time.Unix(0, entry.Time))
+	}
+
+	command := entry.Command
+	switch command.Cmd {
+	case model.Notify_Cmd_AuctionStart, model.Notify_Cmd_AuctionEnd, model.Notify_Cmd_HaltEnd, model.Notify_Cmd_Expire:
+		// 引擎內部的指令 由排程依模擬時鐘重新產生
+		r.skipped++
+		return nil
+	case model.Notify_Cmd_Purchase, model.Notify_Cmd_Sell:
+		if err := r.acceptCommand(command); err != nil {
+			logs.Warnf("reject command seq:%d, err:%v", entry.Seq, err)
+			r.rejected = append(r.rejected, &RejectedCommand{Seq: entry.Seq, Command: command, Reason: err.Error()})
+			return nil
+		}
+	case model.Notify_Cmd_Amend:
+		if err := r.prepareAmend(command); err != nil {
+			return fmt.Errorf("prepare amend fail seq:%d, err:%v", entry.Seq, err)
+		}
+	}
+
+	// 日誌已依套用順序排列, 不再依指令序號重新排序
+	r.commands++
+	if err := r.engine.ReplayCommand(command); err != nil {
+		// 引擎拒絕的指令 (例如 取消找不到的訂單) 記錄後繼續回放
+		logs.Warnf("engine reject command seq:%d, err:%v", entry.Seq, err)
+		r.rejected = append(r.rejected, &RejectedCommand{Seq: entry.Seq, Command: command, Reason: err.Error()})
+	}
+	return nil
+}
+
+// 推進模擬時鐘, 依序執行途中經過的排程任務
+func (r *Replayer) advance(now time.Time) {
+	for !r.nextCron.After(now) {
+		r.clock.Set(r.nextCron)
+		r.engine.Cron()
+		r.nextCron = r.nextCron.Add(engine.CronInterval)
+	}
+	r.clock.Set(now)
+}
+
+// 受理買賣單, 已經受理過的交易單號 (重送的指令) 交給引擎判斷重複
+func (r *Replayer) acceptCommand(command *model.ProductTransactionNotify) error {
+
+	params, err := decodeOrder(command.Data)
+	if err != nil {
+		return err
+	}
+	if len(params.TransactionID) > 0 {
+		if _, err = r.transactions.GetTransactionInfo(params.TransactionID); err == nil {
+			return nil
+		}
+	}
+
+	if err = r.acceptOrder(params); err != nil {
+		return err
+	}
+	command.Data = params
+	return nil
+}
+
+// 依 marketplace_server 受理的方式處理訂單
+// 補上沒有填的 幣種 時間戳 後 交給 OrderAcceptor: 賣單凍結商品數量, 買單預扣金額 (含手續費), 寫入等待搓合的交易單
+func (r *Replayer) acceptOrder(params *model.ProductTransactionParams) error {
+
+	marketPriceDetail, err := r.getMarketPrice(params.ProductName)
+	if err != nil {
+		return err
+	}
+	if len(params.Currency) == 0 {
+		params.Currency = marketPriceDetail.Currency
+	}
+	if params.TimeStamp == 0 {
+		params.TimeStamp = r.clock.Now().UnixNano()
+	}
+	if params.OperateCount <= 0 {
+		return fmt.Errorf("error operate_count:%d", params.OperateCount)
+	}
+
+	user, err := r.users.GetUserInfo(params.UserID)
+	if err != nil {
+		return fmt.Errorf("getUserInfo fail userID:%v, err:%v", params.UserID, err)
+	}
+	_, err = r.acceptor.Accept(user, params, marketPriceDetail.Amount, r.clock.Now())
+	return err
+}
+
+// 修改訂單 沒有時間戳時 使用模擬時鐘的時間
+func (r *Replayer) prepareAmend(command *model.ProductTransactionNotify) error {
+
+	byteArray, err := json.Marshal(command.Data)
+	if err != nil {
+		return err
+	}
+	var params model.ProductAmendParams
+	if err = json.Unmarshal(byteArray, &params); err != nil {
+		return err
+	}
+	if params.TimeStamp == 0 {
+		params.TimeStamp = r.clock.Now().UnixNano()
+	}
+	command.Data = &params
+	return nil
+}
+
+// 取得商品的市場價格
+func (r *Replayer) getMarketPrice(productName string) (*model_product.MarketPriceRedis, error) {
+
+	dataMap, err := r.repos.ProductRepo.RedisGetMarketPrice(Infrastructure_product.Redis_MarketPrice)
+	if err != nil {
+		return nil, err
+	}
+	marketPriceJson, ok := dataMap[productName]
+	if !ok {
+		return nil, fmt.Errorf("product not found productName:%v", productName)
+	}
+	return model_product.NewMarketPriceRedis(marketPriceJson)
+}
+
+// 解析買賣單
+func decodeOrder(data interface{}) (*model.ProductTransactionParams, error) {
+	byteArray, err := json.Marshal(data)
+	if err != nil {
+		return nil, err
+	}
+	params := &model.ProductTransactionParams{}
+	if err = json.Unmarshal(byteArray, params); err != nil {
+		return nil, err
+	}
+	return params, nil
+}
@@ -0,0 +1,140 @@
+package src
+
+import (
+	engine "marketplace_server/cmd/transaction_server/src"
+	model_bill "marketplace_server/internal/bill/model"
+	Infrastructure_product "marketplace_server/internal/product/Infrastructure_layer"
+	model_product "marketplace_server/internal/product/model"
+	"sort"
+	"time"
+
+	"github.com/shopspring/decimal"
+)
+
+// 回放結果
+type Result struct {
+	StartTime    string                                     `json:"start_time"`    // 開始時間 (模擬時鐘)
+	EndTime      string                                     `json:"end_time"`      // 結束時間 (模擬時鐘)
+	Commands     int64                                      `json:"commands"`      // 送入引擎的指令數量
+	Skipped      int64                                      `json:"skipped"`       // 略過的引擎內部指令數量
+	Rejected     []*RejectedCommand                         `json:"rejected"`      // 受理時被拒絕的指令
+	Trades       []*TradeResult                             `json:"trades"`        // 成交紀錄 (依成交順序)
+	Balances     []*BalanceResult                           `json:"balances"`      // 用戶的餘額 與 背包
+	Books        []*engine.S2A_Orders                       `json:"books"`         // 最後的訂單簿
+	MarketPrices map[string]*model_product.MarketPriceRedis `json:"market_prices"` // 最後的市場價格
+	Stats        *engine.S2A_EngineStats                    `json:"stats"`         // 引擎統計
+}
+
+// 成交紀錄
+type TradeResult struct {
+	TradeID           string          `json:"trade_id"`            // 成交單號
+	ProductName       string          `json:"product_name"`        // 商品名稱
+	BuyTransactionID  string          `json:"buy_transaction_id"`  // 買方交易單號
+	SellTransactionID string          `json:"sell_transaction_id"` // 賣方交易單號
+	BuyUserID         int64           `json:"buy_user_id"`         // 買方用戶ID
+	SellUserID        int64           `json:"sell_user_id"`        // 賣方用戶ID
+	Price             decimal.Decimal `json:"price"`               // 成交價
+	Count             int64           `json:"count"`               // 成交數量
+	Amount            decimal.Decimal `json:"amount"`              // 成交金額
+	BuyFee            decimal.Decimal `json:"buy_fee"`             // 買方手續費
+	SellFee           decimal.Decimal `json:"sell_fee"`            // 賣方手續費
+	TakerMode         int             `json:"taker_mode"`          // 吃單方 0:買 1:賣
+	Currency          string          `json:"currency"`            // 報價幣種
+	ExecutedAt        string          `json:"executed_at"`         // 成交時間 (模擬時鐘)
+}
+
+// 用戶的餘額 與 背包
+type BalanceResult struct {
+	UserID   int64            `json:"user_id"`  // 用戶ID
+	Currency string           `json:"currency"` // 幣種
+	Amount   decimal.Decimal  `json:"amount"`   // 餘額 (成交時結算)
+	Products []*HoldingResult `json:"products"` // 背包
+}
+
+// 背包內的商品
+type HoldingResult struct {
+	ProductName string `json:"product_name"` // 商品名稱
+	Count       int64  `json:"count"`        // 可用數量
+	HoldCount   int64  `json:"hold_count"`   // 凍結數量 (掛賣單中)
+}
+
+func NewTradeResult(trade *model_bill.Trade) *TradeResult {
+	return &TradeResult{
+		TradeID:           trade.TradeID,
+		ProductName:       trade.ProductName,
+		BuyTransactionID:  trade.BuyTransactionID,
+		SellTransactionID: trade.SellTransactionID,
+		BuyUserID:         trade.BuyUserID,
+		SellUserID:        trade.SellUserID,
+		Price:             trade.Price,
+		Count:             trade.Count,
+		Amount:            trade.Amount,
+		BuyFee:            trade.BuyFee,
+		SellFee:           trade.SellFee,
+		TakerMode:         trade.TakerMode,
+		Currency:          trade.Currency,
+		ExecutedAt:        trade.ExecutedAt.UTC().Format(time.RFC3339Nano),
+	}
+}
+
+// 產生回放結果
+func (r *Replayer) Result() (*Result, error) {
+
+	result := &Result{
+		StartTime: r.startTime.Format(time.RFC3339Nano),
+		EndTime:   r.clock.Now().Format(time.RFC3339Nano),
+		Commands:  r.commands,
+		Skipped:   r.skipped,
+		Rejected:  r.rejected,
+		Trades:    []*TradeResult{},
+		Balances:  []*BalanceResult{},
+		Books:     []*engine.S2A_Orders{},
+		Stats:     r.engine.GetStats(),
+	}
+	if result.Rejected == nil {
+		result.Rejected = []*RejectedCommand{}
+	}
+
+	for _, trade := range r.trades.GetTradeList() {
+		result.Trades = append(result.Trades, NewTradeResult(trade))
+	}
+
+	for _, user := range r.users.GetUserList() {
+		balance := &BalanceResult{
+			UserID:   user.UserID,
+			Currency: user.Currency,
+			Amount:   user.Amount,
+			Products: []*HoldingResult{},
+		}
+		backpacks, err := r.backpacks.FindAll(user.UserID)
+		if err != nil {
+			return nil, err
+		}
+		sort.Slice(backpacks, func(i, j int) bool { return backpacks[i].ProductName < backpacks[j].ProductName })
+		for _, backpack := range backpacks {
+			balance.Products = append(balance.Products, &HoldingResult{
+				ProductName: backpack.ProductName,
+				Count:       backpack.ProductCount,
+				HoldCount:   backpack.HoldCount,
+			})
+		}
+		result.Balances = append(result.Balances, balance)
+	}
+
+	// 統計已依商品名稱排序
+	for _, bookStats := range result.Stats.Books {
+		result.Books = append(result.Books, r.engine.GetOrders(bookStats.ProductName))
+	}
+
+	dataMap, err := r.repos.ProductRepo.RedisGetMarketPrice(Infrastructure_product.Redis_MarketPrice)
+	if err != nil {
+		return nil, err
+	}
+	result.MarketPrices = make(map[string]*model_product.MarketPriceRedis)
+	for productName, marketPriceJson := range dataMap {
+		if result.MarketPrices[productName], err = model_product.NewMarketPriceRedis(marketPriceJson); err != nil {
+			return nil, err
+		}
+	}
+	return result, nil
+}
@@ -0,0 +1,101 @@
+package src
+
+import (
+	"encoding/json"
+	"fmt"
+	model_backpack "marketplace_server/internal/backpack/model"
+	model_product "marketplace_server/internal/product/model"
+	"marketplace_server/internal/user/model"
+	"os"
+	"time"
+
+	"github.com/shopspring/decimal"
+)
+
+// 回放的初始狀態 (上架的商品 與 用戶的餘額 背包)
+type Scenario struct {
+	StartTime string             `json:"start_time"` // 開始時間 RFC3339 (不填 = 快照 或 第一筆指令的時間)
+	Products  []*ScenarioProduct `json:"products"`   // 上架的商品
+	Users     []*ScenarioUser    `json:"users"`      // 用戶
+}
+
+// 上架的商品
+type ScenarioProduct struct {
+	ProductName  string          `json:"product_name"`  // 商品名稱
+	ProductCount int64           `json:"product_count"` // 上架的商品數量
+	Currency     string          `json:"currency"`      // 報價幣種
+	BaseAmount   decimal.Decimal `json:"base_amount"`   // 初始的市場價格
+	AuctionTime  int64           `json:"auction_time"`  // 開始後 集合競價的時間 (秒, 0 = 不集合競價)
+	PriceBand    decimal.Decimal `json:"price_band"`    // 價格限制 %
+}
+
+// 用戶
+type ScenarioUser struct {
+	UserID   int64            `json:"user_id"`   // 用戶ID
+	Currency string           `json:"currency"`  // 幣種
+	Amount   decimal.Decimal  `json:"amount"`    // 餘額
+	VipLevel int              `json:"vip_level"` // vip 等級
+	Products map[string]int64 `json:"products"`  // 背包 key=商品名稱 value=可用數量
+}
+
+// 讀取初始狀態 (json), 沒有指定檔案回傳空的初始狀態
+func LoadScenario(path string) (*Scenario, error) {
+
+	scenario := &Scenario{}
+	if len(path) == 0 {
+		return scenario, nil
+	}
+
+	byteArray, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	if err = json.Unmarshal(byteArray, scenario); err != nil {
+		return nil, fmt.Errorf("unmarshal scenario fail path:%v, err:%v", path, err)
+	}
+	return scenario, nil
+}
+
+// 開始時間, 沒有設定回傳零值
+func (s *Scenario) GetStartTime() (time.Time, error) {
+	if len(s.StartTime) == 0 {
+		return time.Time{}, nil
+	}
+	return time.Parse(time.RFC3339, s.StartTime)
+}
+
+func (p *ScenarioProduct) ToDomain(start time.Time) *model_product.Product {
+	product := &model_product.Product{
+		ProductName:  p.ProductName,
+		ProductCount: p.ProductCount,
+		Currency:     p.Currency,
+		BaseAmount:   p.BaseAmount,
+		PriceBand:    p.PriceBand,
+	}
+	if p.AuctionTime > 0 {
+		product.AuctionEnd = start.Unix() + p.AuctionTime
+	}
+	return product
+}
+
+func (u *ScenarioUser) ToDomain(start time.Time) *model.User {
+	return &model.User{
+		UserID:    u.UserID,
+		Username:  fmt.Sprintf("user%d", u.UserID),
+		Currency:  u.Currency,
+		Amount:    u.Amount,
+		VipLevel:  u.VipLevel,
+		CreatedAt: start,
+		UpdateAt:  start,
+	}
+}
+
+func (u *ScenarioUser) ToBackpack(productName string, start time.Time) *model_backpack.Backpack {
+	return &model_backpack.Backpack{
+		UserID:       u.UserID,
+		ProductName:  productName,
+		ProductCount: u.Products[productName],
+		CreatedAt:    start,
+		UodateAt:     start,
+	}
+}
@@ -8,6 +8,7 @@ import (
 	"marketplace_server/internal/common/signals"
 	Infrastructure_server "marketplace_server/internal/servers/Infrastructure_layer"
 	application_server "marketplace_server/internal/servers/application_layer"
+	"marketplace_server/internal/servers/mq"
 	"marketplace_server/internal/servers/web"
 	"os"
 )
@@ -49,10 +50,11 @@ func NewServers(cfg *config.Config) servers.ServerInterface {
 	repos := Infrastructure_server.NewRepositories(cfg)
 	repos.Automigrate()
 	// 建立 應用層 管理物件
-	apps := application_server.NewApps(repos)
+	apps := application_server.NewApps(cfg, repos)
 
 	servers := servers.NewServers()
 	servers.AddServer(web.NewWebServer(cfg, apps))
+	servers.AddServer(mq.NewMqServer(cfg, apps)) // 接收 transaction_server 的成交回報
 
 	return servers
 }
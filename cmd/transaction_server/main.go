@@ -37,10 +37,12 @@ func main() {
 	// 監聽rabbit message
 	transactionEgine := src.NewTransactionEgine(cfg)
 	go transactionEgine.Run()
+	go transactionEgine.RunSnapshot()
 
 	// 先用簡易的 gin
 	gin.SetMode(cfg.Web.Mode)
 	router := gin.Default()
 	router.GET("/test", GerData)
+	src.WithAdminRouter(router, transactionEgine, cfg.Admin.Token) // 管理員 api
 	router.Run(fmt.Sprintf(":%s", cfg.Web.Port))
 }
@@ -0,0 +1,216 @@
+package src
+
+import (
+	"encoding/json"
+	"fmt"
+	"marketplace_server/internal/common/logs"
+	"marketplace_server/internal/common/rabbitmqx"
+	"marketplace_server/internal/user/model"
+	"sort"
+	"time"
+)
+
+// 引擎統計
+type EngineStats struct {
+	StartTime time.Time `json:"start_time"` // 啟動時間
+	Commands  int64     `json:"commands"`   // 啟動後處理的指令數量
+	Fills     int64     `json:"fills"`      // 啟動後的成交筆數
+}
+
+// 商品的訂單簿統計
+type BookStats struct {
+	ProductName string `json:"product_name"` // 商品名稱
+	Bids        int    `json:"bids"`         // 買單筆數
+	Asks        int    `json:"asks"`         // 賣單筆數
+	Stops       int    `json:"stops"`        // 等待觸發的停損單筆數
+	Paused      bool   `json:"paused"`       // 是否暫停搓合
+	AuctionEnd  int64  `json:"auction_end"`  // 集合競價結束時間 unix 秒 (0 = 連續搓合中)
+	HaltEnd     int64  `json:"halt_end"`     // 熔斷暫停交易的結束時間 unix 秒 (0 = 交易中)
+	MarketPrice string `json:"market_price"` // 市場最新價格
+}
+
+// 引擎狀態 (管理員 api)
+type S2A_EngineStats struct {
+	EngineStats
+	Uptime     string       `json:"uptime"`      // 已啟動時間
+	JournalSeq int64        `json:"journal_seq"` // 指令日誌最後序號 (未啟用為 0)
+	Books      []*BookStats `json:"books"`       // 各商品的訂單簿
+}
+
+// 商品的 L2 深度 (管理員 api)
+type S2A_Depth struct {
+	ProductName string        `json:"product_name"` // 商品名稱
+	Paused      bool          `json:"paused"`       // 是否暫停搓合
+	Bids        []*PriceLevel `json:"bids"`         // 買方檔位 (價格高 -> 低)
+	Asks        []*PriceLevel `json:"asks"`         // 賣方檔位 (價格低 -> 高)
+}
+
+// 商品的訂單明細 (管理員 api)
+type S2A_Orders struct {
+	ProductName string                            `json:"product_name"` // 商品名稱
+	Bids        []*model.ProductTransactionParams `json:"bids"`         // 買單 (依優先順序)
+	Asks        []*model.ProductTransactionParams `json:"asks"`         // 賣單 (依優先順序)
+	Stops       []*model.ProductTransactionParams `json:"stops"`        // 等待觸發的停損單
+}
+
+// 暫停 / 恢復 商品搓合, 恢復時立即搓合暫停期間進來的訂單
+func (t *TransactionEgine) PauseProduct(productTransactionNotify *model.ProductTransactionNotify) error {
+
+	// 解析封包
+	byteArray, err := json.Marshal(productTransactionNotify.Data)
+	if err != nil {
+		return rabbitmqx.Permanent(err)
+	}
+	var productPauseParams model.ProductPauseParams
+	err = json.Unmarshal(byteArray, &productPauseParams)
+	if err != nil {
+		return rabbitmqx.Permanent(err)
+	}
+	if len(productPauseParams.ProductName) == 0 {
+		return rabbitmqx.Permanent(fmt.Errorf("error params productPauseParams:%+v", productPauseParams))
+	}
+
+	if productTransactionNotify.Cmd == model.Notify_Cmd_Pause {
+		t.paused[productPauseParams.ProductName] = true
+		logs.Debugf("暫停搓合 productName:%v", productPauseParams.ProductName)
+		return nil
+	}
+
+	delete(t.paused, productPauseParams.ProductName)
+	logs.Debugf("恢復搓合 productName:%v", productPauseParams.ProductName)
+	t.matchBook(productPauseParams.ProductName, t.getOrderBook(productPauseParams.ProductName))
+	return nil
+}
+
+// 設定商品 暫停 / 恢復 搓合
+func (t *TransactionEgine) SetPaused(productName string, paused bool) error {
+	t.DataLock.Lock()
+	defer t.DataLock.Unlock()
+
+	cmd := model.Notify_Cmd_Resume
+	if paused {
+		cmd = model.Notify_Cmd_Pause
+	}
+	return t.applyCommand(cmd, &model.ProductPauseParams{ProductName: productName})
+}
+
+// 強制取消訂單 (不檢查下單用戶)
+func (t *TransactionEgine) ForceCancel(transactionID string) error {
+	t.DataLock.Lock()
+	defer t.DataLock.Unlock()
+
+	order := t.findOrder(transactionID)
+	if order == nil {
+		return fmt.Errorf("order not found transactionID:%v", transactionID)
+	}
+
+	logs.Warnf("管理員強制取消訂單:%+v", order)
+	return t.applyCommand(model.Notify_Cmd_Cancel, &model.ProductCancelParams{
+		TransactionID: order.TransactionID,
+		UserID:        order.UserID,
+	})
+}
+
+// 取得商品的 L2 深度
+func (t *TransactionEgine) GetDepth(productName string, level int) *S2A_Depth {
+	t.DataLock.RLock()
+	defer t.DataLock.RUnlock()
+
+	depth := &S2A_Depth{
+		ProductName: productName,
+		Paused:      t.paused[productName],
+		Bids:        []*PriceLevel{},
+		Asks:        []*PriceLevel{},
+	}
+	if book, ok := t.OrderBooks[productName]; ok {
+		depth.Bids, depth.Asks = book.Depth(level)
+	}
+	return depth
+}
+
+// 取得商品的訂單明細 (複製一份, 避免回傳後被搓合修改)
+func (t *TransactionEgine) GetOrders(productName string) *S2A_Orders {
+	t.DataLock.RLock()
+	defer t.DataLock.RUnlock()
+
+	orders := &S2A_Orders{
+		ProductName: productName,
+	}
+	if book, ok := t.OrderBooks[productName]; ok {
+		orders.Bids = copyOrders(book.Bids)
+		orders.Asks = copyOrders(book.Asks)
+	}
+	if stopBook, ok := t.StopBooks[productName]; ok {
+		orders.Stops = copyOrders(stopBook.Orders)
+	}
+	return orders
+}
+
+// 取得單筆訂單, 找不到回傳 nil
+func (t *TransactionEgine) GetOrder(transactionID string) *model.ProductTransactionParams {
+	t.DataLock.RLock()
+	defer t.DataLock.RUnlock()
+
+	order := t.findOrder(transactionID)
+	if order == nil {
+		return nil
+	}
+	orderCopy := *order
+	return &orderCopy
+}
+
+// 取得引擎狀態
+func (t *TransactionEgine) GetStats() *S2A_EngineStats {
+	t.DataLock.RLock()
+	defer t.DataLock.RUnlock()
+
+	stats := &S2A_EngineStats{
+		EngineStats: t.stats,
+		Uptime:      t.now().Sub(t.stats.StartTime).Round(time.Second).String(),
+		Books:       []*BookStats{},
+	}
+	if t.journal != nil {
+		stats.JournalSeq = t.journal.Seq()
+	}
+
+	// 有訂單簿 或 停損單 的商品, 依名稱排序
+	productNames := make(map[string]bool)
+	for productName := range t.OrderBooks {
+		productNames[productName] = true
+	}
+	for productName := range t.StopBooks {
+		productNames[productName] = true
+	}
+	for productName := range productNames {
+		bookStats := &BookStats{
+			ProductName: productName,
+			Paused:      t.paused[productName],
+			AuctionEnd:  t.auctions[productName],
+			HaltEnd:     t.halts[productName],
+			MarketPrice: t.marketPriceMap[productName],
+		}
+		if book, ok := t.OrderBooks[productName]; ok {
+			bookStats.Bids = len(book.Bids)
+			bookStats.Asks = len(book.Asks)
+		}
+		if stopBook, ok := t.StopBooks[productName]; ok {
+			bookStats.Stops = stopBook.Len()
+		}
+		stats.Books = append(stats.Books, bookStats)
+	}
+	sort.Slice(stats.Books, func(i, j int) bool {
+		return stats.Books[i].ProductName < stats.Books[j].ProductName
+	})
+
+	return stats
+}
+
+// 複製訂單清單
+func copyOrders(list []*model.ProductTransactionParams) []*model.ProductTransactionParams {
+	orders := make([]*model.ProductTransactionParams, 0, len(list))
+	for _, order := range list {
+		orderCopy := *order
+		orders = append(orders, &orderCopy)
+	}
+	return orders
+}
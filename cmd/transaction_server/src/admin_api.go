@@ -0,0 +1,170 @@
+package src
+
+import (
+	"crypto/subtle"
+	"marketplace_server/internal/common/logs"
+	"marketplace_server/internal/servers/web/response"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	AdminTokenKey = "X-Admin-Token" // 管理員憑證的 header
+)
+
+// 暫停 / 恢復 商品搓合
+type A2S_PauseProduct struct {
+	ProductName string `json:"product_name"` // 商品名稱
+}
+
+// 強制取消訂單
+type A2S_CancelOrder struct {
+	TransactionID string `json:"transaction_id"` // 交易單號
+}
+
+// 管理員 api (查看訂單簿 與 控制搓合)
+type AdminHandler struct {
+	engine *TransactionEgine
+	token  string // 管理員憑證, 空的代表不開放
+}
+
+// 註冊管理員 api
+func WithAdminRouter(router *gin.Engine, engine *TransactionEgine, token string) {
+
+	if len(token) == 0 {
+		logs.Warnf("admin token 未設定 不開放管理員 api")
+	}
+
+	h := &AdminHandler{
+		engine: engine,
+		token:  token,
+	}
+
+	admin := router.Group("/admin")
+	admin.Use(h.Auth)
+	admin.GET("/stats", h.Stats)            // 引擎狀態
+	admin.GET("/depth", h.Depth)            // 商品的 L2 深度
+	admin.GET("/orders", h.Orders)          // 商品的訂單明細
+	admin.GET("/order", h.Order)            // 單筆訂單
+	admin.POST("/pause", h.Pause)           // 暫停商品搓合
+	admin.POST("/resume", h.Resume)         // 恢復商品搓合
+	admin.POST("/cancel", h.Cancel)         // 強制取消訂單
+	admin.POST("/snapshot", h.TakeSnapshot) // 產生快照
+}
+
+// 檢查管理員憑證
+func (h *AdminHandler) Auth(c *gin.Context) {
+	token := c.GetHeader(AdminTokenKey)
+	if len(h.token) == 0 || subtle.ConstantTimeCompare([]byte(token), []byte(h.token)) != 1 {
+		response.Err(c, http.StatusUnauthorized, "permission denied")
+		c.Abort()
+		return
+	}
+}
+
+// 引擎狀態
+func (h *AdminHandler) Stats(c *gin.Context) {
+	response.Ok(c, h.engine.GetStats())
+}
+
+// 商品的 L2 深度, level 不填 = 全部檔位
+func (h *AdminHandler) Depth(c *gin.Context) {
+
+	productName := c.Query("product_name")
+	if len(productName) == 0 {
+		response.Err(c, http.StatusBadRequest, "product_name is empty")
+		return
+	}
+	level, _ := strconv.Atoi(c.Query("level"))
+
+	response.Ok(c, h.engine.GetDepth(productName, level))
+}
+
+// 商品的訂單明細
+func (h *AdminHandler) Orders(c *gin.Context) {
+
+	productName := c.Query("product_name")
+	if len(productName) == 0 {
+		response.Err(c, http.StatusBadRequest, "product_name is empty")
+		return
+	}
+
+	response.Ok(c, h.engine.GetOrders(productName))
+}
+
+// 單筆訂單
+func (h *AdminHandler) Order(c *gin.Context) {
+
+	order := h.engine.GetOrder(c.Query("transaction_id"))
+	if order == nil {
+		response.Err(c, http.StatusNotFound, "order not found")
+		return
+	}
+
+	response.Ok(c, order)
+}
+
+// 暫停商品搓合
+func (h *AdminHandler) Pause(c *gin.Context) {
+	h.setPaused(c, true)
+}
+
+// 恢復商品搓合
+func (h *AdminHandler) Resume(c *gin.Context) {
+	h.setPaused(c, false)
+}
+
+func (h *AdminHandler) setPaused(c *gin.Context, paused bool) {
+
+	req := &A2S_PauseProduct{}
+	if err := c.ShouldBindJSON(req); err != nil || len(req.ProductName) == 0 {
+		response.Err(c, http.StatusBadRequest, "product_name is empty")
+		return
+	}
+
+	if err := h.engine.SetPaused(req.ProductName, paused); err != nil {
+		logs.Errorf("setPaused fail productName:%v, paused:%v, err:%v", req.ProductName, paused, err)
+		response.Err(c, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	logs.Warnf("管理員 productName:%v, paused:%v", req.ProductName, paused)
+	response.Ok(c)
+}
+
+// 強制取消訂單
+func (h *AdminHandler) Cancel(c *gin.Context) {
+
+	req := &A2S_CancelOrder{}
+	if err := c.ShouldBindJSON(req); err != nil || len(req.TransactionID) == 0 {
+		response.Err(c, http.StatusBadRequest, "transaction_id is empty")
+		return
+	}
+
+	if err := h.engine.ForceCancel(req.TransactionID); err != nil {
+		logs.Errorf("forceCancel fail transactionID:%v, err:%v", req.TransactionID, err)
+		response.Err(c, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	response.Ok(c)
+}
+
+// 產生快照
+func (h *AdminHandler) TakeSnapshot(c *gin.Context) {
+
+	if h.engine.journal == nil {
+		response.Err(c, http.StatusBadRequest, "journal is not enabled")
+		return
+	}
+
+	if err := h.engine.TakeSnapshot(); err != nil {
+		logs.Errorf("takeSnapshot fail err:%v", err)
+		response.Err(c, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	response.Ok(c)
+}
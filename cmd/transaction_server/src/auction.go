@@ -0,0 +1,290 @@
+package src
+
+import (
+	"encoding/json"
+	"fmt"
+	model_transaction "marketplace_server/internal/bill/model"
+	"marketplace_server/internal/common/logs"
+	"marketplace_server/internal/common/rabbitmqx"
+	model_product "marketplace_server/internal/product/model"
+	Infrastructure_server "marketplace_server/internal/servers/Infrastructure_layer"
+	"marketplace_server/internal/user/model"
+	"sort"
+	"time"
+
+	"github.com/shopspring/decimal"
+)
+
+// 集合競價 (新上架商品)
+// 競價期間訂單只進入訂單簿不搓合, 結束時找出成交量最大的單一價格,
+// 所有可成交的訂單都以該價格成交, 成交價寫入市場價格後 才開始連續搓合
+
+// 訂單在集合競價價格 price 是否可成交 (市價單任何價格都可成交)
+func auctionEligible(order *model.ProductTransactionParams, price decimal.Decimal) bool {
+	if model.TransferType(order.TransferType) == model.MarketPrice {
+		return true
+	}
+	switch model.TransferMode(order.TransferMode) {
+	case model.Purchase:
+		return order.Amount.GreaterThanOrEqual(price)
+	case model.Sell:
+		return order.Amount.LessThanOrEqual(price)
+	}
+	return false
+}
+
+// 計算集合競價的成交價與成交量
+// 依序比較: 成交量最大 -> 買賣量差最小 -> 最接近參考價 -> 價格較低
+func ClearingPrice(book *OrderBook, reference decimal.Decimal) (price decimal.Decimal, volume int64) {
+
+	// 候選價格: 所有限價單的價格, 只有市價單時使用參考價
+	var candidates []decimal.Decimal
+	for _, list := range [][]*model.ProductTransactionParams{book.Bids, book.Asks} {
+		for _, order := range list {
+			if model.TransferType(order.TransferType) != model.MarketPrice {
+				candidates = append(candidates, order.Amount)
+			}
+		}
+	}
+	if len(candidates) == 0 {
+		candidates = append(candidates, reference)
+	}
+
+	var bestImbalance int64
+	for _, candidate := range candidates {
+		var buyCount, sellCount int64
+		for _, bid := range book.Bids {
+			if auctionEligible(bid, candidate) {
+				buyCount += bid.RemainCount
+			}
+		}
+		for _, ask := range book.Asks {
+			if auctionEligible(ask, candidate) {
+				sellCount += ask.RemainCount
+			}
+		}
+		executed := buyCount
+		if sellCount < executed {
+			executed = sellCount
+		}
+		imbalance := buyCount - sellCount
+		if imbalance < 0 {
+			imbalance = -imbalance
+		}
+
+		better := false
+		switch {
+		case executed != volume:
+			better = executed > volume
+		case imbalance != bestImbalance:
+			better = imbalance < bestImbalance
+		default:
+			distance := candidate.Sub(reference).Abs()
+			bestDistance := price.Sub(reference).Abs()
+			if !distance.Equal(bestDistance) {
+				better = distance.LessThan(bestDistance)
+			} else {
+				better = candidate.LessThan(price)
+			}
+		}
+		if price.IsZero() || better {
+			price, volume, bestImbalance = candidate, executed, imbalance
+		}
+	}
+
+	return price, volume
+}
+
+// 集合競價 開始 / 結束
+func (t *TransactionEgine) AuctionProduct(productTransactionNotify *model.ProductTransactionNotify) error {
+
+	// 解析封包
+	byteArray, err := json.Marshal(productTransactionNotify.Data)
+	if err != nil {
+		return rabbitmqx.Permanent(err)
+	}
+	var productAuctionParams model.ProductAuctionParams
+	err = json.Unmarshal(byteArray, &productAuctionParams)
+	if err != nil {
+		return rabbitmqx.Permanent(err)
+	}
+	if len(productAuctionParams.ProductName) == 0 {
+		return rabbitmqx.Permanent(fmt.Errorf("error params productAuctionParams:%+v", productAuctionParams))
+	}
+
+	if productTransactionNotify.Cmd == model.Notify_Cmd_AuctionStart {
+		t.auctions[productAuctionParams.ProductName] = productAuctionParams.AuctionEnd
+		logs.Debugf("集合競價開始 productName:%v, auctionEnd:%v",
+			productAuctionParams.ProductName, productAuctionParams.AuctionEnd)
+		return nil
+	}
+
+	if _, ok := t.auctions[productAuctionParams.ProductName]; !ok {
+		return rejected(fmt.Errorf("product not in auction productAuctionParams:%+v", productAuctionParams))
+	}
+	delete(t.auctions, productAuctionParams.ProductName)
+
+	// 集合競價成交後 開始連續搓合
+	book := t.getOrderBook(productAuctionParams.ProductName)
+	t.uncross(productAuctionParams.ProductName, book)
+	t.matchBook(productAuctionParams.ProductName, book)
+	return nil
+}
+
+// 集合競價撮合: 所有可成交的訂單 以單一成交價成交, 並以成交價更新市場價格
+func (t *TransactionEgine) uncross(productName string, book *OrderBook) {
+
+	marketPriceDetail, err := t.getMarketPrice(productName)
+	if err != nil {
+		logs.Warnf("getMarketPrice fail productName:%v, err:%v", productName, err)
+		marketPriceDetail = &model_product.MarketPriceRedis{}
+	}
+
+	price, volume := ClearingPrice(book, marketPriceDetail.Amount)
+	logs.Debugf("集合競價結束 productName:%v, 成交價:%v, 成交量:%d", productName, price.String(), volume)
+
+	remain := volume
+	for remain > 0 {
+		purchaseData, sellData := findAuctionMatch(book, price)
+		if purchaseData == nil || sellData == nil {
+			break
+		}
+
+		fillCount := remain
+		if purchaseData.RemainCount < fillCount {
+			fillCount = purchaseData.RemainCount
+		}
+		if sellData.RemainCount < fillCount {
+			fillCount = sellData.RemainCount
+		}
+
+		// 寫進db (使用 transaction(事務) 失敗就Rollback), 重播日誌時 當初已寫入 只還原訂單簿
+		var trade *model_transaction.Trade
+		if !t.replaying {
+			err = t.Repos.Transaction(func(uow *Infrastructure_server.UnitOfWork) (err error) {
+				trade, err = t.settle(uow, purchaseData, sellData, price, fillCount)
+				return
+			})
+			if err != nil {
+				logs.Errorf("settle fail purchase:%v, sell:%v, err:%v",
+					purchaseData.TransactionID, sellData.TransactionID, err)
+				break
+			}
+		}
+		purchaseData.RemainCount -= fillCount
+		sellData.RemainCount -= fillCount
+		remain -= fillCount
+		t.stats.Fills++
+		t.addCandle(trade)
+		t.publishTrade(trade)
+
+		// 回報成交 給 marketplace_server
+		t.reportFill(purchaseData, trade)
+		t.reportFill(sellData, trade)
+
+		// 刪除 已全部成交的搓合單
+		if purchaseData.RemainCount <= 0 {
+			book.Remove(purchaseData.TransactionID)
+		}
+		if sellData.RemainCount <= 0 {
+			book.Remove(sellData.TransactionID)
+		}
+	}
+
+	// 有成交 以成交價當作市場價格, 並結束集合競價狀態
+	if remain < volume {
+		marketPriceDetail.Amount = price
+	}
+	marketPriceDetail.AuctionEnd = 0
+	marketPriceDetail.UpdateTime = t.now().String()
+	t.setMarketPrice(productName, marketPriceDetail)
+}
+
+// 找出集合競價價格可成交的 買單 與 賣單 (依優先順序, 相同用戶不成交)
+func findAuctionMatch(book *OrderBook, price decimal.Decimal) (purchaseData, sellData *model.ProductTransactionParams) {
+	for _, bid := range book.Bids {
+		if !auctionEligible(bid, price) {
+			break
+		}
+		for _, ask := range book.Asks {
+			if !auctionEligible(ask, price) {
+				break
+			}
+			if bid.UserID == ask.UserID {
+				continue
+			}
+			return bid, ask
+		}
+	}
+	return nil, nil
+}
+
+// 同步新上架商品的集合競價狀態, 並結束已到時間的集合競價 (寫入指令日誌 重啟後可重播)
+func (t *TransactionEgine) syncAuctions(now time.Time) {
+
+	var productNames []string
+	for productName := range t.marketPriceMap {
+		productNames = append(productNames, productName)
+	}
+	sort.Strings(productNames)
+
+	for _, productName := range productNames {
+		marketPriceDetail, err := model_product.NewMarketPriceRedis(t.marketPriceMap[productName])
+		if err != nil || marketPriceDetail.AuctionEnd <= 0 {
+			continue
+		}
+		if _, ok := t.auctions[productName]; ok {
+			continue
+		}
+		err = t.applyCommand(model.Notify_Cmd_AuctionStart, &model.ProductAuctionParams{
+			ProductName: productName,
+			AuctionEnd:  marketPriceDetail.AuctionEnd,
+		})
+		if err != nil {
+			logs.Errorf("auction start fail productName:%v, err:%v", productName, err)
+		}
+	}
+
+	for _, productName := range productNames {
+		auctionEnd, ok := t.auctions[productName]
+		if !ok || now.Unix() < auctionEnd {
+			continue
+		}
+		err := t.applyCommand(model.Notify_Cmd_AuctionEnd, &model.ProductAuctionParams{
+			ProductName: productName,
+			AuctionEnd:  auctionEnd,
+		})
+		if err != nil {
+			logs.Errorf("auction end fail productName:%v, err:%v", productName, err)
+		}
+	}
+}
+
+// 新上架商品的第一筆訂單, 先從 redis 載入市場價格 並同步集合競價狀態
+// 需在訂單寫入指令日誌前處理, 重播時 集合競價開始 才會在訂單之前
+func (t *TransactionEgine) prepareNewProduct(productTransactionNotify *model.ProductTransactionNotify) {
+
+	switch productTransactionNotify.Cmd {
+	case model.Notify_Cmd_Purchase, model.Notify_Cmd_Sell:
+	default:
+		return
+	}
+
+	byteArray, err := json.Marshal(productTransactionNotify.Data)
+	if err != nil {
+		return
+	}
+	var params model.ProductTransactionParams
+	if err = json.Unmarshal(byteArray, &params); err != nil {
+		return
+	}
+	if _, ok := t.marketPriceMap[params.ProductName]; ok {
+		return
+	}
+
+	if _, err = t.getMarketPrice(params.ProductName); err != nil {
+		logs.Warnf("getMarketPrice fail productName:%v, err:%v", params.ProductName, err)
+		return
+	}
+	t.syncAuctions(t.now())
+}
@@ -0,0 +1,121 @@
+package src
+
+import (
+	"marketplace_server/internal/user/model"
+	"testing"
+	"time"
+
+	"github.com/shopspring/decimal"
+)
+
+func Test_ClearingPrice(t *testing.T) {
+	book := NewOrderBook("BTC")
+	book.Add(newTestOrder("b1", model.Purchase, 1, "102", 2, 1))
+	book.Add(newTestOrder("b2", model.Purchase, 1, "101", 3, 2))
+	book.Add(newTestOrder("b3", model.Purchase, 1, "100", 1, 3))
+	book.Add(newTestOrder("s1", model.Sell, 2, "99", 2, 4))
+	book.Add(newTestOrder("s2", model.Sell, 2, "100", 2, 5))
+	book.Add(newTestOrder("s3", model.Sell, 2, "103", 5, 6))
+
+	// 100 與 101 的成交量都是 4, 101 的買賣量差較小
+	if price, volume := ClearingPrice(book, decimal.NewFromInt(100)); !price.Equal(decimal.NewFromInt(101)) || volume != 4 {
+		t.Fatalf("price:%v, volume:%d", price, volume)
+	}
+
+	// 成交量 買賣量差相同, 取最接近參考價的價格
+	book = NewOrderBook("BTC")
+	book.Add(newTestOrder("b1", model.Purchase, 1, "101", 1, 1))
+	book.Add(newTestOrder("s1", model.Sell, 2, "100", 1, 2))
+	if price, volume := ClearingPrice(book, decimal.NewFromInt(105)); !price.Equal(decimal.NewFromInt(101)) || volume != 1 {
+		t.Fatalf("price:%v, volume:%d", price, volume)
+	}
+	if price, volume := ClearingPrice(book, decimal.NewFromInt(90)); !price.Equal(decimal.NewFromInt(100)) || volume != 1 {
+		t.Fatalf("price:%v, volume:%d", price, volume)
+	}
+
+	// 只有市價單 使用參考價
+	book = NewOrderBook("BTC")
+	for _, order := range []*model.ProductTransactionParams{
+		newTestOrder("b1", model.Purchase, 1, "0", 1, 1),
+		newTestOrder("s1", model.Sell, 2, "0", 1, 2),
+	} {
+		order.TransferType = int(model.MarketPrice)
+		book.Add(order)
+	}
+	if price, volume := ClearingPrice(book, decimal.NewFromInt(100)); !price.Equal(decimal.NewFromInt(100)) || volume != 1 {
+		t.Fatalf("price:%v, volume:%d", price, volume)
+	}
+}
+
+func Test_Auction_Uncross(t *testing.T) {
+	e := newTestEngine(t, nil)
+
+	// 開始集合競價 10 分鐘後結束
+	err := e.notify(model.Notify_Cmd_AuctionStart, 0, &model.ProductAuctionParams{
+		ProductName: "BTC",
+		AuctionEnd:  e.clock.now.Add(10 * time.Minute).Unix(),
+	})
+	if err != nil {
+		t.Fatalf("err:%v", err)
+	}
+	for userID := int64(1); userID <= 6; userID++ {
+		e.addUser(userID, "10000", 5)
+	}
+
+	// 競價期間 訂單只進入訂單簿 不搓合
+	for _, order := range []*model.ProductTransactionParams{
+		newTestOrder("1-1-1", model.Purchase, 1, "102", 2, 1),
+		newTestOrder("3-1-1", model.Purchase, 3, "101", 3, 2),
+		newTestOrder("5-1-1", model.Purchase, 5, "100", 1, 3),
+		newTestOrder("2-1-1", model.Sell, 2, "99", 2, 4),
+		newTestOrder("4-1-1", model.Sell, 4, "100", 2, 5),
+		newTestOrder("6-1-1", model.Sell, 6, "103", 5, 6),
+	} {
+		if err := e.submit(order); err != nil {
+			t.Fatalf("err:%v", err)
+		}
+	}
+	if !e.isHalted("BTC") || len(e.trades.GetTradeList()) != 0 || e.book().Len() != 6 {
+		t.Fatalf("auctions:%+v, trades:%d, book:%d", e.auctions, len(e.trades.GetTradeList()), e.book().Len())
+	}
+
+	// 還沒到結束時間
+	e.clock.now = e.clock.now.Add(9 * time.Minute)
+	e.syncAuctions(e.now())
+	if !e.isHalted("BTC") {
+		t.Fatalf("auction should not end")
+	}
+
+	// 結束 所有可成交的訂單都以 101 成交, 成交量 4
+	e.clock.now = e.clock.now.Add(time.Minute)
+	e.syncAuctions(e.now())
+	if e.isHalted("BTC") {
+		t.Fatalf("auctions:%+v", e.auctions)
+	}
+	trades := e.trades.GetTradeList()
+	var volume int64
+	for _, trade := range trades {
+		if !trade.Price.Equal(decimal.NewFromInt(101)) {
+			t.Fatalf("trade:%+v", trade)
+		}
+		volume += trade.Count
+	}
+	if volume != 4 {
+		t.Fatalf("trades:%+v", trades)
+	}
+
+	// 成交價寫入市場價格, 剩下的訂單 沒有交叉 留在訂單簿
+	marketPriceDetail, err := e.getMarketPrice("BTC")
+	if err != nil || !marketPriceDetail.Amount.Equal(decimal.NewFromInt(101)) || marketPriceDetail.AuctionEnd != 0 {
+		t.Fatalf("marketPrice:%+v, err:%v", marketPriceDetail, err)
+	}
+	if ids := orderIDs(e.book().Bids); !equalIDs(ids, []string{"3-1-1", "5-1-1"}) || e.book().Bids[0].RemainCount != 1 {
+		t.Fatalf("bids:%v", ids)
+	}
+	if ids := orderIDs(e.book().Asks); !equalIDs(ids, []string{"6-1-1"}) {
+		t.Fatalf("asks:%v", ids)
+	}
+	if !e.userAmount(1).Equal(decimal.NewFromInt(9798)) || e.backpack(1).ProductCount != 7 {
+		t.Fatalf("amount:%v, backpack:%+v", e.userAmount(1), e.backpack(1))
+	}
+}
@@ -0,0 +1,98 @@
+package src
+
+import (
+	model_transaction "marketplace_server/internal/bill/model"
+	"marketplace_server/internal/common/logs"
+	model_product "marketplace_server/internal/product/model"
+	"sort"
+	"time"
+)
+
+// K線 (OHLCV)
+// 成交後彙整到各週期目前的K線 並寫入 redis, 週期結束 (下一筆成交 或 定時任務) 才寫入 db
+// 重播日誌時沒有成交紀錄 不彙整, 目前的K線 重啟後從 redis 載入
+
+// 商品各週期目前的K線 key=週期
+type CandleSet map[model_product.CandleInterval]*model_product.Candle
+
+// 成交彙整到商品各週期的K線
+func (t *TransactionEgine) addCandle(trade *model_transaction.Trade) {
+	if trade == nil {
+		return
+	}
+
+	candles, ok := t.candles[trade.ProductName]
+	if !ok {
+		candles = make(CandleSet)
+		t.candles[trade.ProductName] = candles
+	}
+
+	for _, interval := range model_product.CandleIntervals {
+		candle := candles[interval]
+		if candle != nil && !candle.Contains(trade.ExecutedAt) {
+			t.saveCandle(candle)
+			candle = nil
+		}
+		if candle == nil {
+			candle = model_product.NewCandle(trade.ProductName, interval, trade.ExecutedAt, trade.Price)
+			candles[interval] = candle
+		}
+		candle.Add(trade.Price, trade.Count, trade.Amount)
+
+		if err := t.Repos.CandleRepo.RedisSetCandle(candle); err != nil {
+			logs.Errorf("redisSetCandle fail candle:%+v, err:%v", candle, err)
+		}
+		t.publishCandle(candle)
+	}
+}
+
+// 寫入週期已結束的K線 db
+func (t *TransactionEgine) saveCandle(candle *model_product.Candle) {
+	if err := t.Repos.CandleRepo.Save(candle); err != nil {
+		logs.Errorf("candleRepo save fail candle:%+v, err:%v", candle, err)
+	}
+}
+
+// 寫入週期已結束的K線 (一段時間沒有成交的商品)
+func (t *TransactionEgine) flushCandles(now time.Time) {
+
+	var productNames []string
+	for productName := range t.candles {
+		productNames = append(productNames, productName)
+	}
+	sort.Strings(productNames)
+
+	for _, productName := range productNames {
+		candles := t.candles[productName]
+		for _, interval := range model_product.CandleIntervals {
+			candle, ok := candles[interval]
+			if !ok || !candle.IsClosed(now) {
+				continue
+			}
+			t.saveCandle(candle)
+			delete(candles, interval)
+		}
+	}
+}
+
+// 從 redis 載入商品目前的K線, 週期已結束的寫入 db
+func (t *TransactionEgine) loadCandles(now time.Time) {
+
+	for productName := range t.marketPriceMap {
+		list, err := t.Repos.CandleRepo.RedisGetCandles(productName)
+		if err != nil {
+			logs.Warnf("redisGetCandles fail productName:%v, err:%v", productName, err)
+			continue
+		}
+		for _, candle := range list {
+			if candle.IsClosed(now) {
+				t.saveCandle(candle)
+				continue
+			}
+			if _, ok := t.candles[productName]; !ok {
+				t.candles[productName] = make(CandleSet)
+			}
+			t.candles[productName][candle.Interval] = candle
+		}
+	}
+}
@@ -0,0 +1,96 @@
+package src
+
+import (
+	model_transaction "marketplace_server/internal/bill/model"
+	model_product "marketplace_server/internal/product/model"
+	Infrastructure_server "marketplace_server/internal/servers/Infrastructure_layer"
+	"testing"
+	"time"
+
+	"github.com/shopspring/decimal"
+)
+
+// 測試用的K線持久層 (記憶體)
+type testCandleRepo struct {
+	saved   []*model_product.Candle
+	current map[model_product.CandleInterval]*model_product.Candle
+}
+
+func (r *testCandleRepo) Save(candle *model_product.Candle) error {
+	candleCopy := *candle
+	r.saved = append(r.saved, &candleCopy)
+	return nil
+}
+
+func (r *testCandleRepo) GetCandleList(params *model_product.CandleParams) ([]*model_product.Candle, error) {
+	return r.saved, nil
+}
+
+func (r *testCandleRepo) RedisSetCandle(candle *model_product.Candle) error {
+	candleCopy := *candle
+	r.current[candle.Interval] = &candleCopy
+	return nil
+}
+
+func (r *testCandleRepo) RedisGetCandle(productName string, interval model_product.CandleInterval) (*model_product.Candle, error) {
+	return r.current[interval], nil
+}
+
+func (r *testCandleRepo) RedisGetCandles(productName string) ([]*model_product.Candle, error) {
+	var list []*model_product.Candle
+	for _, candle := range r.current {
+		list = append(list, candle)
+	}
+	return list, nil
+}
+
+func newTestTrade(price string, count int64, executedAt time.Time) *model_transaction.Trade {
+	p := decimal.RequireFromString(price)
+	return &model_transaction.Trade{
+		ProductName: "BTC",
+		Price:       p,
+		Count:       count,
+		Amount:      p.Mul(decimal.NewFromInt(count)),
+		ExecutedAt:  executedAt,
+	}
+}
+
+func Test_AddCandle(t *testing.T) {
+	repo := &testCandleRepo{current: make(map[model_product.CandleInterval]*model_product.Candle)}
+	engine := &TransactionEgine{
+		Repos:   &Infrastructure_server.RepositoriesManager{CandleRepo: repo},
+		candles: make(map[string]CandleSet),
+	}
+	start := time.Date(2024, 1, 1, 10, 0, 0, 0, time.UTC)
+
+	engine.addCandle(newTestTrade("10", 1, start.Add(5*time.Second)))
+	engine.addCandle(newTestTrade("12", 2, start.Add(20*time.Second)))
+	engine.addCandle(newTestTrade("9", 1, start.Add(40*time.Second)))
+	engine.addCandle(nil) // 重播日誌 沒有成交紀錄
+
+	candle := repo.current[model_product.Candle_1m]
+	if candle.OpenTime != start.Unix() || !candle.Open.Equal(decimal.NewFromInt(10)) ||
+		!candle.High.Equal(decimal.NewFromInt(12)) || !candle.Low.Equal(decimal.NewFromInt(9)) ||
+		!candle.Close.Equal(decimal.NewFromInt(9)) || candle.Volume != 4 ||
+		!candle.Amount.Equal(decimal.NewFromInt(43)) || candle.TradeCount != 3 {
+		t.Fatalf("candle:%+v", candle)
+	}
+	if len(repo.saved) != 0 {
+		t.Fatalf("saved:%+v", repo.saved)
+	}
+
+	// 下一分鐘的成交, 1m 的K線寫入 db, 其他週期繼續彙整
+	engine.addCandle(newTestTrade("11", 1, start.Add(70*time.Second)))
+	if len(repo.saved) != 1 || repo.saved[0].Interval != model_product.Candle_1m || repo.saved[0].Volume != 4 {
+		t.Fatalf("saved:%+v", repo.saved)
+	}
+	if candle := repo.current[model_product.Candle_5m]; candle.Volume != 5 || !candle.Close.Equal(decimal.NewFromInt(11)) {
+		t.Fatalf("candle 5m:%+v", candle)
+	}
+
+	// 定時任務 寫入週期已結束的K線 (1m 5m 1h), 1d 還在週期內
+	engine.flushCandles(start.Add(time.Hour))
+	if len(repo.saved) != 4 || len(engine.candles["BTC"]) != 1 {
+		t.Fatalf("saved:%d, candles:%+v", len(repo.saved), engine.candles["BTC"])
+	}
+}
@@ -0,0 +1,204 @@
+package src
+
+import (
+	"encoding/json"
+	"fmt"
+	"marketplace_server/config"
+	"marketplace_server/internal/common/logs"
+	"marketplace_server/internal/common/rabbitmqx"
+	model_product "marketplace_server/internal/product/model"
+	"marketplace_server/internal/user/model"
+	"sort"
+	"time"
+
+	"github.com/shopspring/decimal"
+)
+
+// 熔斷
+// 成交價與時間窗內的成交價 (沒有成交時使用市場價格) 差距超過門檻, 這筆成交不執行 並暫停交易,
+// 時間窗使用訂單的時間搓, 暫停的結束時間使用引擎的時鐘 (重播日誌時使用日誌的寫入時間 結果相同),
+// 暫停期間訂單只進入訂單簿, 到期後由排程恢復交易: 先以集合競價重新開盤 (觸發熔斷的買賣單以單一價格成交),
+// 成交價當作新的參考價 清空時間窗 再開始連續搓合, 觸發熔斷的那組買賣單不會再次觸發
+
+// 時間窗內的成交價
+type PricePoint struct {
+	Time  int64           `json:"time"`  // 成交時間 (UnixNano, 買賣雙方較晚的訂單時間)
+	Price decimal.Decimal `json:"price"` // 成交價
+}
+
+// 熔斷設定
+type Breaker struct {
+	Percent decimal.Decimal // 成交價變動超過 % 觸發熔斷
+	Window  time.Duration   // 時間窗
+	Halt    time.Duration   // 暫停交易的時間
+}
+
+// 依設定建立熔斷, 沒有啟用回傳 nil
+func NewBreaker(cfg config.CircuitBreaker) (*Breaker, error) {
+
+	if len(cfg.Percent) == 0 {
+		return nil, nil
+	}
+	percent, err := decimal.NewFromString(cfg.Percent)
+	if err != nil {
+		return nil, fmt.Errorf("circuit breaker percent:%v, err:%v", cfg.Percent, err)
+	}
+	if !percent.IsPositive() {
+		return nil, nil
+	}
+	window, err := time.ParseDuration(cfg.Window)
+	if err != nil || window <= 0 {
+		return nil, fmt.Errorf("circuit breaker window:%v, err:%v", cfg.Window, err)
+	}
+	halt, err := time.ParseDuration(cfg.Halt)
+	if err != nil || halt <= 0 {
+		return nil, fmt.Errorf("circuit breaker halt:%v, err:%v", cfg.Halt, err)
+	}
+
+	return &Breaker{
+		Percent: percent,
+		Window:  window,
+		Halt:    halt,
+	}, nil
+}
+
+// 成交價與參考價的差距是否超過門檻
+func (b *Breaker) Exceeded(price, reference decimal.Decimal) bool {
+	if !reference.IsPositive() {
+		return false
+	}
+	move := price.Sub(reference).Abs().Mul(decimal.NewFromInt(100)).Div(reference)
+	return move.GreaterThan(b.Percent)
+}
+
+// 取得商品的熔斷設定, 沒有設定使用預設 (沒有啟用回傳 nil)
+func (t *TransactionEgine) getBreaker(productName string) *Breaker {
+	if breaker, ok := t.breakers[productName]; ok {
+		return breaker
+	}
+	return t.breaker
+}
+
+// 成交時間: 買賣雙方較晚的訂單時間
+func fillTime(fill *Fill) int64 {
+	if fill.Sell.TimeStamp > fill.Purchase.TimeStamp {
+		return fill.Sell.TimeStamp
+	}
+	return fill.Purchase.TimeStamp
+}
+
+// 檢查成交是否觸發熔斷, 觸發時暫停交易 並將熔斷結束時間寫入市場價格
+func (t *TransactionEgine) tripBreaker(productName string, fill *Fill, marketPriceDetail *model_product.MarketPriceRedis) bool {
+
+	breaker := t.getBreaker(productName)
+	if breaker == nil {
+		return false
+	}
+	now := fillTime(fill)
+
+	// 移除時間窗之前的成交價
+	var window []*PricePoint
+	for _, point := range t.priceWindows[productName] {
+		if point.Time >= now-breaker.Window.Nanoseconds() {
+			window = append(window, point)
+		}
+	}
+	t.priceWindows[productName] = window
+
+	// 時間窗內沒有成交 使用市場價格當參考價
+	references := []decimal.Decimal{marketPriceDetail.Amount}
+	if len(window) > 0 {
+		references = references[:0]
+		for _, point := range window {
+			references = append(references, point.Price)
+		}
+	}
+
+	for _, reference := range references {
+		if !breaker.Exceeded(fill.Price, reference) {
+			continue
+		}
+
+		haltEnd := t.now().Add(breaker.Halt).Unix()
+		t.halts[productName] = haltEnd
+		delete(t.priceWindows, productName)
+		logs.Warnf("熔斷 暫停交易 productName:%v, 成交價:%v, 參考價:%v, haltEnd:%v",
+			productName, fill.Price.String(), reference.String(), haltEnd)
+
+		marketPriceDetail.HaltEnd = haltEnd
+		marketPriceDetail.UpdateTime = t.now().String()
+		t.setMarketPrice(productName, marketPriceDetail)
+		return true
+	}
+	return false
+}
+
+// 記錄成交價 (熔斷的時間窗)
+func (t *TransactionEgine) recordPrice(productName string, fill *Fill) {
+	if t.getBreaker(productName) == nil {
+		return
+	}
+	t.priceWindows[productName] = append(t.priceWindows[productName], &PricePoint{
+		Time:  fillTime(fill),
+		Price: fill.Price,
+	})
+}
+
+// 熔斷結束 恢復交易, 以集合競價重新開盤 再連續搓合暫停期間進來的訂單
+func (t *TransactionEgine) HaltProduct(productTransactionNotify *model.ProductTransactionNotify) error {
+
+	// 解析封包
+	byteArray, err := json.Marshal(productTransactionNotify.Data)
+	if err != nil {
+		return rabbitmqx.Permanent(err)
+	}
+	var productHaltParams model.ProductHaltParams
+	err = json.Unmarshal(byteArray, &productHaltParams)
+	if err != nil {
+		return rabbitmqx.Permanent(err)
+	}
+	if len(productHaltParams.ProductName) == 0 {
+		return rabbitmqx.Permanent(fmt.Errorf("error params productHaltParams:%+v", productHaltParams))
+	}
+
+	if _, ok := t.halts[productHaltParams.ProductName]; !ok {
+		return rejected(fmt.Errorf("product not halted productHaltParams:%+v", productHaltParams))
+	}
+	delete(t.halts, productHaltParams.ProductName)
+	logs.Debugf("熔斷結束 恢復交易 productName:%v", productHaltParams.ProductName)
+
+	if marketPriceDetail, err := t.getMarketPrice(productHaltParams.ProductName); err == nil {
+		marketPriceDetail.HaltEnd = 0
+		marketPriceDetail.UpdateTime = t.now().String()
+		t.setMarketPrice(productHaltParams.ProductName, marketPriceDetail)
+	}
+
+	// 集合競價重新開盤, 開盤價寫入市場價格 當作新的參考價 (清空時間窗)
+	book := t.getOrderBook(productHaltParams.ProductName)
+	t.uncross(productHaltParams.ProductName, book)
+	delete(t.priceWindows, productHaltParams.ProductName)
+	t.matchBook(productHaltParams.ProductName, book)
+	return nil
+}
+
+// 結束已到時間的熔斷 (寫入指令日誌 重啟後可重播)
+func (t *TransactionEgine) syncHalts(now time.Time) {
+
+	var productNames []string
+	for productName, haltEnd := range t.halts {
+		if now.Unix() >= haltEnd {
+			productNames = append(productNames, productName)
+		}
+	}
+	sort.Strings(productNames)
+
+	for _, productName := range productNames {
+		err := t.applyCommand(model.Notify_Cmd_HaltEnd, &model.ProductHaltParams{
+			ProductName: productName,
+			HaltEnd:     t.halts[productName],
+		})
+		if err != nil {
+			logs.Errorf("halt end fail productName:%v, err:%v", productName, err)
+		}
+	}
+}
@@ -0,0 +1,108 @@
+package src
+
+import (
+	"marketplace_server/config"
+	model_product "marketplace_server/internal/product/model"
+	"marketplace_server/internal/user/model"
+	"testing"
+	"time"
+
+	"github.com/shopspring/decimal"
+)
+
+func Test_NewBreaker(t *testing.T) {
+	if breaker, err := NewBreaker(config.CircuitBreaker{}); err != nil || breaker != nil {
+		t.Fatalf("breaker:%+v, err:%v", breaker, err)
+	}
+	if _, err := NewBreaker(config.CircuitBreaker{Percent: "10", Window: "x", Halt: "10m"}); err == nil {
+		t.Fatalf("error window should fail")
+	}
+}
+
+func Test_TripBreaker(t *testing.T) {
+	breaker, err := NewBreaker(config.CircuitBreaker{Percent: "10", Window: "5m", Halt: "10m"})
+	if err != nil {
+		t.Fatalf("err:%v", err)
+	}
+	engine := &TransactionEgine{
+		replaying:      true,
+		breaker:        breaker,
+		priceWindows:   make(map[string][]*PricePoint),
+		halts:          make(map[string]int64),
+		marketPriceMap: make(map[string]string),
+		clock:          FixedClock(time.Unix(1700000000, 0)),
+	}
+	marketPrice := &model_product.MarketPriceRedis{Amount: decimal.NewFromInt(100)}
+	now := time.Now().UnixNano()
+
+	// 變動 5% 不觸發, 記錄成交價
+	fill := &Fill{
+		Purchase: newTestOrder("b1", model.Purchase, 1, "105", 1, now),
+		Sell:     newTestOrder("s1", model.Sell, 2, "105", 1, now),
+		Price:    decimal.NewFromInt(105),
+		Count:    1,
+	}
+	if engine.tripBreaker("BTC", fill, marketPrice) {
+		t.Fatalf("5%% should not trip")
+	}
+	engine.recordPrice("BTC", fill)
+
+	// 與時間窗內的成交價 105 相比變動超過 10%, 觸發熔斷
+	fill = &Fill{
+		Purchase: newTestOrder("b2", model.Purchase, 1, "120", 1, now+1),
+		Sell:     newTestOrder("s2", model.Sell, 2, "117", 1, now+1),
+		Price:    decimal.NewFromInt(117),
+		Count:    1,
+	}
+	if !engine.tripBreaker("BTC", fill, marketPrice) {
+		t.Fatalf("should trip")
+	}
+	// 熔斷結束時間使用引擎的時鐘, 不是訂單的時間搓
+	if !engine.isHalted("BTC") || marketPrice.HaltEnd != time.Unix(1700000000, 0).Add(10*time.Minute).Unix() {
+		t.Fatalf("halts:%+v, marketPrice:%+v", engine.halts, marketPrice)
+	}
+}
+
+func Test_HaltEnd_ReopenByAuction(t *testing.T) {
+	e := newTestEngine(t, &config.Config{ConfigBase: &config.ConfigBase{
+		Engine: config.Engine{CircuitBreaker: config.CircuitBreaker{Percent: "10", Window: "5m", Halt: "10m"}},
+	}})
+	e.addUser(1, "10000", 0)
+	e.addUser(2, "0", 1)
+
+	// 成交價 120 與市場價格 100 相比變動 20%, 觸發熔斷 不成交
+	if err := e.submit(newTestOrder("2-1-1", model.Sell, 2, "120", 1, 1)); err != nil {
+		t.Fatalf("err:%v", err)
+	}
+	if err := e.submit(newTestOrder("1-1-1", model.Purchase, 1, "120", 1, 2)); err != nil {
+		t.Fatalf("err:%v", err)
+	}
+	if !e.isHalted("BTC") || len(e.trades.GetTradeList()) != 0 {
+		t.Fatalf("halts:%+v, trades:%d", e.halts, len(e.trades.GetTradeList()))
+	}
+
+	// 還沒到熔斷結束時間
+	e.clock.now = e.clock.now.Add(9 * time.Minute)
+	e.syncHalts(e.now())
+	if !e.isHalted("BTC") {
+		t.Fatalf("halt should not end")
+	}
+
+	// 熔斷結束 以集合競價重新開盤, 觸發熔斷的買賣單以 120 成交 不再次觸發
+	e.clock.now = e.clock.now.Add(time.Minute)
+	e.syncHalts(e.now())
+	if e.isHalted("BTC") {
+		t.Fatalf("halts:%+v", e.halts)
+	}
+	trades := e.trades.GetTradeList()
+	if len(trades) != 1 || !trades[0].Price.Equal(decimal.NewFromInt(120)) {
+		t.Fatalf("trades:%+v", trades)
+	}
+	marketPriceDetail, err := e.getMarketPrice("BTC")
+	if err != nil || !marketPriceDetail.Amount.Equal(decimal.NewFromInt(120)) || marketPriceDetail.HaltEnd != 0 {
+		t.Fatalf("marketPrice:%+v, err:%v", marketPriceDetail, err)
+	}
+	if len(e.book().Bids) != 0 || len(e.book().Asks) != 0 {
+		t.Fatalf("bids:%+v, asks:%+v", e.book().Bids, e.book().Asks)
+	}
+}
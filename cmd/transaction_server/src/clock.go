@@ -0,0 +1,31 @@
+package src
+
+import "time"
+
+// 時鐘, 引擎取得目前時間 都透過時鐘
+// 回放工具使用模擬時鐘, 相同的指令 產生相同的結果
+type Clock interface {
+	Now() time.Time
+}
+
+// 系統時鐘
+type SystemClock struct{}
+
+func (SystemClock) Now() time.Time {
+	return time.Now()
+}
+
+// 固定時間的時鐘 (重播指令日誌時 使用日誌的寫入時間)
+type FixedClock time.Time
+
+func (c FixedClock) Now() time.Time {
+	return time.Time(c)
+}
+
+// 目前時間 (沒有設定時鐘 使用系統時間)
+func (t *TransactionEgine) now() time.Time {
+	if t.clock == nil {
+		return time.Now()
+	}
+	return t.clock.Now()
+}
@@ -0,0 +1,75 @@
+package src
+
+import (
+	"encoding/json"
+	model_transaction "marketplace_server/internal/bill/model"
+	"marketplace_server/internal/common/logs"
+	"marketplace_server/internal/user/model"
+)
+
+// 是否為重複的指令 (mq 重送), 依交易單號判斷
+// 1. 買賣單的交易單號 已經在訂單簿內 或 db 狀態已經不是等待搓合
+// 2. 取消的交易單號 已經不在訂單簿內 且 db 狀態已經是取消
+// 修改 與 取消全部 重複套用結果相同 不需要判斷
+// 指令的順序 由指令序號另外檢查 (command_order.go)
+func (t *TransactionEgine) isDuplicate(productTransactionNotify *model.ProductTransactionNotify) bool {
+
+	byteArray, err := json.Marshal(productTransactionNotify.Data)
+	if err != nil {
+		return false
+	}
+
+	switch productTransactionNotify.Cmd {
+	case model.Notify_Cmd_Purchase, model.Notify_Cmd_Sell:
+		var params model.ProductTransactionParams
+		if err = json.Unmarshal(byteArray, &params); err != nil || len(params.TransactionID) == 0 {
+			return false
+		}
+		if t.isKnownOrder(&params) {
+			logs.Warnf("重複的訂單 transactionID:%v", params.TransactionID)
+			return true
+		}
+	case model.Notify_Cmd_Cancel:
+		var params model.ProductCancelParams
+		if err = json.Unmarshal(byteArray, &params); err != nil || len(params.TransactionID) == 0 {
+			return false
+		}
+		if t.isCancelled(&params) {
+			logs.Warnf("重複的取消 transactionID:%v", params.TransactionID)
+			return true
+		}
+	}
+	return false
+}
+
+// 交易單號是否已經處理過
+func (t *TransactionEgine) isKnownOrder(params *model.ProductTransactionParams) bool {
+
+	if list, _ := t.getOrderBook(params.ProductName).Find(params.TransactionID); list != nil {
+		return true
+	}
+	if t.getStopBook(params.ProductName).Find(params.TransactionID) >= 0 {
+		return true
+	}
+
+	// 不在訂單簿內, db 狀態不是等待搓合 代表已經處理完 (成交 取消 過期)
+	transaction, err := t.Repos.TransactionRepo.GetTransactionInfo(params.TransactionID)
+	if err != nil {
+		return false
+	}
+	return transaction.Status != int8(model_transaction.Transaction_Status_Wait)
+}
+
+// 訂單是否已經被取消 (不在訂單簿內, db 狀態為取消)
+func (t *TransactionEgine) isCancelled(params *model.ProductCancelParams) bool {
+
+	if t.findOrder(params.TransactionID) != nil {
+		return false
+	}
+	transaction, err := t.Repos.TransactionRepo.GetTransactionInfo(params.TransactionID)
+	if err != nil {
+		return false
+	}
+	return transaction.FromUserID == params.UserID &&
+		transaction.Status == int8(model_transaction.Transaction_Status_Cancel)
+}
@@ -0,0 +1,166 @@
+package src
+
+import (
+	"encoding/json"
+	model_bill "marketplace_server/internal/bill/model"
+	"marketplace_server/internal/common/rabbitmqx"
+	"marketplace_server/internal/user/model"
+	"testing"
+)
+
+func Test_Seq_HoldGap(t *testing.T) {
+	e := newTestEngine(t, nil)
+	e.addUser(1, "0", 10)
+
+	if err := e.submit(newTestOrder("1-1-1", model.Sell, 1, "100", 1, 1)); err != nil {
+		t.Fatalf("err:%v", err)
+	}
+
+	// 序號 3 先到, 等待序號 2
+	e.seqs[1] = 2
+	if err := e.submit(newTestOrder("1-1-3", model.Sell, 1, "102", 1, 3)); err != nil {
+		t.Fatalf("err:%v", err)
+	}
+	if len(e.book().Asks) != 1 {
+		t.Fatalf("asks:%d", len(e.book().Asks))
+	}
+
+	// 序號 2 到達後 依序套用 2, 3
+	e.seqs[1] = 1
+	if err := e.submit(newTestOrder("1-1-2", model.Sell, 1, "101", 1, 2)); err != nil {
+		t.Fatalf("err:%v", err)
+	}
+	if !equalIDs(orderIDs(e.book().Asks), []string{"1-1-1", "1-1-2", "1-1-3"}) {
+		t.Fatalf("asks:%v", orderIDs(e.book().Asks))
+	}
+	if e.userSeq[1] != 3 || len(e.heldCommands) != 0 {
+		t.Fatalf("userSeq:%d, held:%d", e.userSeq[1], len(e.heldCommands))
+	}
+}
+
+func Test_Seq_HoldTimeout(t *testing.T) {
+	e := newTestEngine(t, nil)
+	e.addUser(1, "0", 10)
+
+	if err := e.submit(newTestOrder("1-1-1", model.Sell, 1, "100", 1, 1)); err != nil {
+		t.Fatalf("err:%v", err)
+	}
+	e.seqs[1] = 2
+	if err := e.submit(newTestOrder("1-1-3", model.Sell, 1, "102", 1, 3)); err != nil {
+		t.Fatalf("err:%v", err)
+	}
+
+	// 還沒逾時 繼續等待
+	e.clock.now = e.clock.now.Add(SeqHoldTimeout / 2)
+	e.releaseExpiredHeld(e.now())
+	if len(e.book().Asks) != 1 {
+		t.Fatalf("asks:%d", len(e.book().Asks))
+	}
+
+	// 逾時 跳過缺少的序號
+	e.clock.now = e.clock.now.Add(SeqHoldTimeout)
+	e.releaseExpiredHeld(e.now())
+	if len(e.book().Asks) != 2 || e.userSeq[1] != 3 {
+		t.Fatalf("asks:%d, userSeq:%d", len(e.book().Asks), e.userSeq[1])
+	}
+
+	// 之後才到的序號 2 順序顛倒 被拒絕, 退還凍結的商品
+	e.seqs[1] = 1
+	err := e.submit(newTestOrder("1-1-2", model.Sell, 1, "101", 1, 2))
+	if !IsRejected(err) {
+		t.Fatalf("err:%v", err)
+	}
+	if len(e.book().Asks) != 2 {
+		t.Fatalf("asks:%d", len(e.book().Asks))
+	}
+	if status := e.transaction("1-1-2").Status; status != int8(model_bill.Transaction_Status_Error) {
+		t.Fatalf("status:%d", status)
+	}
+	if backpack := e.backpack(1); backpack.ProductCount != 8 || backpack.HoldCount != 2 {
+		t.Fatalf("backpack:%+v", backpack)
+	}
+}
+
+func Test_Seq_StaleRedelivery(t *testing.T) {
+	e := newTestEngine(t, nil)
+	e.addUser(1, "0", 10)
+
+	first := newTestOrder("1-1-1", model.Sell, 1, "100", 1, 1)
+	if err := e.submit(first); err != nil {
+		t.Fatalf("err:%v", err)
+	}
+	if err := e.submit(newTestOrder("1-1-2", model.Sell, 1, "101", 1, 2)); err != nil {
+		t.Fatalf("err:%v", err)
+	}
+
+	// mq 重送 已處理的指令 (序號較舊) 丟棄, 不拒絕
+	if err := e.notifySeq(model.Notify_Cmd_Sell, 1, 1, first); err != nil {
+		t.Fatalf("err:%v", err)
+	}
+	if len(e.book().Asks) != 2 {
+		t.Fatalf("asks:%d", len(e.book().Asks))
+	}
+	if status := e.transaction("1-1-1").Status; status != int8(model_bill.Transaction_Status_Wait) {
+		t.Fatalf("status:%d", status)
+	}
+}
+
+func Test_Dedup_Cancel(t *testing.T) {
+	e := newTestEngine(t, nil)
+	e.addUser(1, "0", 10)
+
+	if err := e.submit(newTestOrder("1-1-1", model.Sell, 1, "100", 1, 1)); err != nil {
+		t.Fatalf("err:%v", err)
+	}
+	cancel := &model.ProductCancelParams{TransactionID: "1-1-1", UserID: 1}
+	if err := e.notify(model.Notify_Cmd_Cancel, 1, cancel); err != nil {
+		t.Fatalf("err:%v", err)
+	}
+
+	// 重送的取消 不是錯誤 (不送到死信佇列)
+	if err := e.notify(model.Notify_Cmd_Cancel, 1, cancel); err != nil {
+		t.Fatalf("duplicate cancel err:%v", err)
+	}
+
+	// 取消不存在的訂單 被拒絕 (回報用戶), 不重試 也不送到死信佇列
+	err := e.notify(model.Notify_Cmd_Cancel, 1, &model.ProductCancelParams{TransactionID: "1-1-9", UserID: 1})
+	if !IsRejected(err) || rabbitmqx.IsPermanent(err) {
+		t.Fatalf("err:%v", err)
+	}
+}
+
+func Test_NotifyTransaction_Poison(t *testing.T) {
+	e := newTestEngine(t, nil)
+
+	// 無法解析的訊息 不重試 直接送到死信佇列
+	if err := e.NotifyTransaction([]byte("{")); err == nil || !rabbitmqx.IsPermanent(err) {
+		t.Fatalf("err:%v", err)
+	}
+}
+
+func Test_NotifyTransaction_Rejected(t *testing.T) {
+	e := newTestEngine(t, nil)
+	e.addUser(1, "0", 10)
+
+	// 業務上拒絕的指令 已回報用戶, 回覆 mq (不重試 不送到死信佇列)
+	byteArray, err := json.Marshal(&model.ProductTransactionNotify{
+		Cmd:    model.Notify_Cmd_Cancel,
+		UserID: 1,
+		Seq:    1,
+		Data:   &model.ProductCancelParams{TransactionID: "1-1-9", UserID: 1},
+	})
+	if err != nil {
+		t.Fatalf("err:%v", err)
+	}
+	if err = e.NotifyTransaction(byteArray); err != nil {
+		t.Fatalf("err:%v", err)
+	}
+	if e.userSeq[1] != 1 {
+		t.Fatalf("userSeq:%d", e.userSeq[1])
+	}
+
+	// 未知的指令 送到死信佇列
+	if err = e.notify(model.Notify_Cmd(99), 1, nil); !rabbitmqx.IsPermanent(err) {
+		t.Fatalf("err:%v", err)
+	}
+}
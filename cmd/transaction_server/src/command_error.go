@@ -0,0 +1,38 @@
+package src
+
+import (
+	"errors"
+	"marketplace_server/internal/common/rabbitmqx"
+)
+
+// 業務上拒絕的指令 (已回報給用戶), 不需要重試 也不送到死信佇列
+type RejectedError struct {
+	Err error
+}
+
+func (e *RejectedError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *RejectedError) Unwrap() error {
+	return e.Err
+}
+
+// 包裝成業務上拒絕的錯誤
+func rejected(err error) error {
+	if err == nil {
+		return nil
+	}
+	return &RejectedError{Err: err}
+}
+
+// 是否為業務上拒絕的錯誤
+func IsRejected(err error) bool {
+	var rejectedErr *RejectedError
+	return errors.As(err, &rejectedErr)
+}
+
+// 是否需要重試 (db redis 等暫時的錯誤), 無法解析 資料錯誤 與 業務上拒絕 不重試
+func isRetryable(err error) bool {
+	return err != nil && !rabbitmqx.IsPermanent(err) && !IsRejected(err)
+}
@@ -0,0 +1,167 @@
+package src
+
+import (
+	"encoding/json"
+	"fmt"
+	"marketplace_server/internal/common/logs"
+	"marketplace_server/internal/common/rabbitmqx"
+	"marketplace_server/internal/user/model"
+	"sort"
+	"time"
+)
+
+const (
+	SeqHoldTimeout = time.Second * 30 // 序號不連續的指令 等待前面指令的最長時間, 超過就跳過缺少的序號
+)
+
+// 指令序號的檢查結果
+type seqOrder int
+
+const (
+	seqInOrder seqOrder = iota // 依序 (或沒有序號) 直接套用
+	seqStale                   // 序號小於已處理的序號 (比之後送出的指令晚到)
+	seqGap                     // 序號不連續 前面的指令還沒到
+)
+
+// 等待前面指令的指令
+type heldCommand struct {
+	notify *model.ProductTransactionNotify
+	heldAt time.Time
+}
+
+// 檢查用戶的指令序號 (呼叫端需持有資料鎖)
+// 1. 舊版本的指令沒有序號, 沒有記錄的用戶 (新用戶 重啟後沒有快照) 直接套用
+// 2. 序號 1 代表 redis 重置後序號重新開始
+// 3. 序號等於已處理的序號 為 mq 重送, 交給重複指令判斷
+func (t *TransactionEgine) checkSeq(productTransactionNotify *model.ProductTransactionNotify) seqOrder {
+
+	seq := productTransactionNotify.Seq
+	if seq <= 0 {
+		return seqInOrder
+	}
+	lastSeq := t.userSeq[productTransactionNotify.UserID]
+	switch {
+	case lastSeq == 0 || seq == 1:
+		return seqInOrder
+	case seq < lastSeq:
+		return seqStale
+	case seq <= lastSeq+1:
+		return seqInOrder
+	}
+	return seqGap
+}
+
+// 記錄用戶已處理的指令序號
+func (t *TransactionEgine) acceptSeq(productTransactionNotify *model.ProductTransactionNotify) {
+	seq := productTransactionNotify.Seq
+	if seq > t.userSeq[productTransactionNotify.UserID] || seq == 1 {
+		t.userSeq[productTransactionNotify.UserID] = seq
+	}
+}
+
+// 拒絕順序顛倒的指令, 買賣單結束並退還預扣, 取消 修改 回報失敗給用戶 (重送的指令 直接丟棄)
+func (t *TransactionEgine) rejectStale(productTransactionNotify *model.ProductTransactionNotify) error {
+
+	if t.isDuplicate(productTransactionNotify) {
+		return nil
+	}
+
+	reason := fmt.Errorf("command out of order userID:%v, seq:%d, lastSeq:%d",
+		productTransactionNotify.UserID, productTransactionNotify.Seq, t.userSeq[productTransactionNotify.UserID])
+	logs.Warnf("%v, cmd:%v", reason, productTransactionNotify.Cmd)
+
+	byteArray, err := json.Marshal(productTransactionNotify.Data)
+	if err != nil {
+		return rabbitmqx.Permanent(err)
+	}
+	switch productTransactionNotify.Cmd {
+	case model.Notify_Cmd_Purchase, model.Notify_Cmd_Sell:
+		var params model.ProductTransactionParams
+		if err = json.Unmarshal(byteArray, &params); err != nil {
+			return rabbitmqx.Permanent(err)
+		}
+		params.RemainCount = params.OperateCount
+		t.rejectOrder(&params, reason)
+	case model.Notify_Cmd_Cancel:
+		var params model.ProductCancelParams
+		if err = json.Unmarshal(byteArray, &params); err != nil {
+			return rabbitmqx.Permanent(err)
+		}
+		t.reportCancelRejected(&params, model.Cancel_Result_NotFound)
+	case model.Notify_Cmd_Amend:
+		var params model.ProductAmendParams
+		if err = json.Unmarshal(byteArray, &params); err != nil {
+			return rabbitmqx.Permanent(err)
+		}
+		t.reportAmendRejected(&params, reason)
+	}
+	return rejected(reason)
+}
+
+// 序號不連續的指令 先保留在記憶體 (依序號排序), 等前面的指令到達後依序套用
+// 保留中的指令 已回覆 mq, 重啟時遺失: 買賣單 db 仍為等待搓合 重啟後從 db 補回訂單簿, 取消 修改 需要用戶重送
+func (t *TransactionEgine) holdCommand(productTransactionNotify *model.ProductTransactionNotify) {
+
+	userID := productTransactionNotify.UserID
+	held := t.heldCommands[userID]
+	for _, command := range held {
+		if command.notify.Seq == productTransactionNotify.Seq {
+			logs.Warnf("重複的保留指令 userID:%v, seq:%d", userID, productTransactionNotify.Seq)
+			return
+		}
+	}
+	held = append(held, &heldCommand{notify: productTransactionNotify, heldAt: t.now()})
+	sort.SliceStable(held, func(i, j int) bool {
+		return held[i].notify.Seq < held[j].notify.Seq
+	})
+	t.heldCommands[userID] = held
+
+	logs.Warnf("指令序號不連續 保留等待 userID:%v, seq:%d, lastSeq:%d, 保留數量:%d",
+		userID, productTransactionNotify.Seq, t.userSeq[userID], len(held))
+}
+
+// 依序套用用戶保留中 已經連續的指令 (呼叫端需持有資料鎖)
+// 暫時的錯誤 (db redis) 放回保留清單 由排程重試
+func (t *TransactionEgine) releaseHeld(userID int64) {
+
+	for len(t.heldCommands[userID]) > 0 {
+		held := t.heldCommands[userID]
+		command := held[0]
+		order := t.checkSeq(command.notify)
+		if order == seqGap {
+			return
+		}
+		t.heldCommands[userID] = held[1:]
+		if len(t.heldCommands[userID]) == 0 {
+			delete(t.heldCommands, userID)
+		}
+
+		var err error
+		if order == seqStale {
+			err = t.rejectStale(command.notify)
+		} else {
+			err = t.applyNotify(command.notify)
+		}
+		if isRetryable(err) {
+			t.heldCommands[userID] = append([]*heldCommand{command}, t.heldCommands[userID]...)
+			return
+		}
+		if err != nil {
+			logs.Warnf("held command fail userID:%v, seq:%d, err:%v", userID, command.notify.Seq, err)
+		}
+	}
+}
+
+// 排程: 等待超過時間的保留指令 跳過缺少的序號後套用, 並重試暫時錯誤的指令
+func (t *TransactionEgine) releaseExpiredHeld(now time.Time) {
+
+	for userID, held := range t.heldCommands {
+		command := held[0]
+		if t.checkSeq(command.notify) == seqGap && now.Sub(command.heldAt) >= SeqHoldTimeout {
+			logs.Warnf("等待指令逾時 跳過缺少的序號 userID:%v, lastSeq:%d, seq:%d",
+				userID, t.userSeq[userID], command.notify.Seq)
+			t.userSeq[userID] = command.notify.Seq - 1
+		}
+		t.releaseHeld(userID)
+	}
+}
@@ -0,0 +1,104 @@
+package src
+
+import (
+	"marketplace_server/internal/common/logs"
+	model_product "marketplace_server/internal/product/model"
+	"sort"
+	"time"
+)
+
+// L2 深度
+// 每次處理完指令 比對訂單簿前 N 檔與上次發佈的快照, 有變動才發佈新快照與增量更新 (序號 +1)
+// 客戶端取得快照後, 依序套用 PrevSeq 等於本地序號的增量更新, 序號不連續時重新取得快照
+
+// 已發佈的深度快照
+type DepthSnapshot = model_product.DepthSnapshot
+
+// 發佈到 redis 的深度檔位數
+func (t *TransactionEgine) depthLevel() int {
+	if t.cfg == nil || t.cfg.Engine.DepthLevel <= 0 {
+		return model_product.DepthDefaultLevel
+	}
+	return t.cfg.Engine.DepthLevel
+}
+
+// 發佈所有有變動的商品深度 (重播日誌時 不寫入 redis)
+func (t *TransactionEgine) publishDepths() {
+	if t.replaying {
+		return
+	}
+
+	// 已發佈過的商品 訂單簿可能已清空, 也要發佈移除的檔位
+	productNames := make(map[string]bool)
+	for productName := range t.OrderBooks {
+		productNames[productName] = true
+	}
+	for productName := range t.depths {
+		productNames[productName] = true
+	}
+	var list []string
+	for productName := range productNames {
+		list = append(list, productName)
+	}
+	sort.Strings(list)
+
+	for _, productName := range list {
+		t.publishDepth(productName)
+	}
+}
+
+// 比對商品深度與上次發佈的快照, 有變動才發佈
+func (t *TransactionEgine) publishDepth(productName string) {
+
+	bids, asks := []*PriceLevel{}, []*PriceLevel{}
+	if book, ok := t.OrderBooks[productName]; ok {
+		bids, asks = book.Depth(t.depthLevel())
+	}
+
+	last, ok := t.depths[productName]
+	if !ok {
+		last = &DepthSnapshot{ProductName: productName}
+	}
+
+	now := t.now().UnixNano() / int64(time.Millisecond)
+	update := &model_product.DepthUpdate{
+		ProductName: productName,
+		Seq:         last.Seq + 1,
+		PrevSeq:     last.Seq,
+		Bids:        model_product.DiffDepthLevels(last.Bids, bids),
+		Asks:        model_product.DiffDepthLevels(last.Asks, asks),
+		UpdateTime:  now,
+	}
+	if len(update.Bids) == 0 && len(update.Asks) == 0 {
+		return
+	}
+	snapshot := &DepthSnapshot{
+		ProductName: productName,
+		Seq:         update.Seq,
+		Bids:        bids,
+		Asks:        asks,
+		UpdateTime:  now,
+	}
+
+	// 寫入失敗 不更新已發佈的快照, 下次變動時 與上次成功發佈的快照比對
+	if err := t.Repos.DepthRepo.RedisSetDepth(snapshot, update); err != nil {
+		logs.Errorf("redisSetDepth fail productName:%v, seq:%d, err:%v", productName, update.Seq, err)
+		return
+	}
+	t.depths[productName] = snapshot
+}
+
+// 從 redis 載入已發佈的深度快照, 重啟後序號接續
+func (t *TransactionEgine) loadDepths() {
+
+	for productName := range t.marketPriceMap {
+		snapshot, err := t.Repos.DepthRepo.RedisGetDepth(productName)
+		if err != nil {
+			logs.Warnf("redisGetDepth fail productName:%v, err:%v", productName, err)
+			continue
+		}
+		if snapshot != nil {
+			t.depths[productName] = snapshot
+		}
+	}
+}
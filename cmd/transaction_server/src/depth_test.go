@@ -0,0 +1,71 @@
+package src
+
+import (
+	model_product "marketplace_server/internal/product/model"
+	Infrastructure_server "marketplace_server/internal/servers/Infrastructure_layer"
+	"marketplace_server/internal/user/model"
+	"testing"
+
+	"github.com/shopspring/decimal"
+)
+
+// 測試用的深度持久層 (記憶體)
+type testDepthRepo struct {
+	snapshot *model_product.DepthSnapshot
+	updates  []*model_product.DepthUpdate
+}
+
+func (r *testDepthRepo) RedisSetDepth(snapshot *model_product.DepthSnapshot, update *model_product.DepthUpdate) error {
+	r.snapshot = snapshot
+	r.updates = append(r.updates, update)
+	return nil
+}
+
+func (r *testDepthRepo) RedisGetDepth(productName string) (*model_product.DepthSnapshot, error) {
+	return r.snapshot, nil
+}
+
+func (r *testDepthRepo) RedisGetDepthUpdates(productName string, fromSeq int64) ([]*model_product.DepthUpdate, error) {
+	return r.updates, nil
+}
+
+func Test_PublishDepth(t *testing.T) {
+	repo := &testDepthRepo{}
+	engine := &TransactionEgine{
+		Repos:      &Infrastructure_server.RepositoriesManager{DepthRepo: repo},
+		OrderBooks: make(map[string]*OrderBook),
+		depths:     make(map[string]*DepthSnapshot),
+	}
+	book := engine.getOrderBook("BTC")
+	book.Add(newTestOrder("b1", model.Purchase, 1, "9", 5, 1))
+	book.Add(newTestOrder("s1", model.Sell, 2, "11", 3, 2))
+
+	engine.publishDepths()
+	if repo.snapshot.Seq != 1 || len(repo.updates) != 1 || len(repo.updates[0].Bids) != 1 || len(repo.updates[0].Asks) != 1 {
+		t.Fatalf("snapshot:%+v, updates:%+v", repo.snapshot, repo.updates)
+	}
+
+	// 沒有變動 不發佈
+	engine.publishDepths()
+	if len(repo.updates) != 1 {
+		t.Fatalf("updates:%d", len(repo.updates))
+	}
+
+	// 同價格加量 只發佈變動的檔位
+	book.Add(newTestOrder("b2", model.Purchase, 3, "9", 2, 3))
+	engine.publishDepths()
+	update := repo.updates[1]
+	if update.Seq != 2 || update.PrevSeq != 1 || len(update.Asks) != 0 ||
+		len(update.Bids) != 1 || update.Bids[0].Count != 7 || update.Bids[0].OrderCount != 2 {
+		t.Fatalf("update:%+v", update)
+	}
+
+	// 賣單移除 檔位數量為 0
+	book.Remove("s1")
+	engine.publishDepths()
+	update = repo.updates[2]
+	if update.Seq != 3 || len(update.Asks) != 1 || update.Asks[0].Count != 0 ||
+		!update.Asks[0].Price.Equal(decimal.NewFromInt(11)) || len(repo.snapshot.Asks) != 0 {
+		t.Fatalf("update:%+v, snapshot:%+v", update, repo.snapshot)
+	}
+}
@@ -0,0 +1,177 @@
+package src
+
+import (
+	"encoding/json"
+	"fmt"
+	model_transaction "marketplace_server/internal/bill/model"
+	"marketplace_server/internal/common/logs"
+	"marketplace_server/internal/common/rabbitmqx"
+	"marketplace_server/internal/user/model"
+
+	"github.com/shopspring/decimal"
+)
+
+// 成交回報 發送端
+type ExecutionReporter interface {
+	Report(report *model.ExecutionReport) error
+}
+
+var _ ExecutionReporter = &MqExecutionReporter{}
+
+// 透過 rabbit mq 發送成交回報 給 marketplace_server
+type MqExecutionReporter struct {
+	mq *rabbitmqx.Service
+}
+
+// 建立成交回報 發送端, 並宣告成交回報交换机
+func NewMqExecutionReporter(mq *rabbitmqx.Service) (*MqExecutionReporter, error) {
+	err := mq.ExchangeDeclare(model.ExecutionReportExchange, ExchangeType, true, false, false, false)
+	if err != nil {
+		return nil, err
+	}
+	return &MqExecutionReporter{mq: mq}, nil
+}
+
+func (r *MqExecutionReporter) Report(report *model.ExecutionReport) error {
+	byteArray, err := json.Marshal(report)
+	if err != nil {
+		return err
+	}
+	return r.mq.PutIntoQueue(model.ExecutionReportExchange, model.BindKeyExecutionReport, byteArray)
+}
+
+// 發送成交回報, 重播日誌時 當初已回報 不再發送
+// 發送失敗的回報 保留在待發送清單, 下一筆回報 或 排程時 依序重送
+func (t *TransactionEgine) report(report *model.ExecutionReport) {
+
+	if t.replaying || t.reporter == nil {
+		return
+	}
+
+	report.TimeStamp = t.now().UnixNano()
+	report.ReportID = newReportID(report)
+	t.pendingReports = append(t.pendingReports, report)
+	t.flushReports()
+}
+
+// 依序發送待發送的成交回報, 失敗就停止 保留順序等下次重送 (呼叫端需持有資料鎖)
+func (t *TransactionEgine) flushReports() {
+
+	if t.reporter == nil {
+		return
+	}
+
+	for len(t.pendingReports) > 0 {
+		report := t.pendingReports[0]
+		if err := t.reporter.Report(report); err != nil {
+			logs.Errorf("report fail pending:%d, report:%+v, err:%v", len(t.pendingReports), report, err)
+			return
+		}
+		t.pendingReports[0] = nil
+		t.pendingReports = t.pendingReports[1:]
+	}
+}
+
+// 回報ID, 成交使用成交單號 (買賣雙方各一筆 加上交易單號), 其他回報使用回報時間
+func newReportID(report *model.ExecutionReport) string {
+	if len(report.TradeID) > 0 {
+		return fmt.Sprintf("%s-%s", report.TradeID, report.TransactionID)
+	}
+	return fmt.Sprintf("%s-%d-%d", report.TransactionID, report.ExecType, report.TimeStamp)
+}
+
+// 依訂單建立回報
+func newOrderReport(execType model.ExecType, order *model.ProductTransactionParams) *model.ExecutionReport {
+	return &model.ExecutionReport{
+		ExecType:      execType,
+		TransactionID: order.TransactionID,
+		TransferMode:  order.TransferMode,
+		ProductName:   order.ProductName,
+		UserID:        order.UserID,
+		Currency:      order.Currency,
+		RemainCount:   order.RemainCount,
+	}
+}
+
+// 回報 訂單已接受
+func (t *TransactionEgine) reportAccepted(order *model.ProductTransactionParams) {
+	t.report(newOrderReport(model.Exec_Accepted, order))
+}
+
+// 回報 訂單被拒絕
+func (t *TransactionEgine) reportRejected(order *model.ProductTransactionParams, reason error) {
+	report := newOrderReport(model.Exec_Rejected, order)
+	report.Reason = reason.Error()
+	t.report(report)
+}
+
+// 回報 成交 (部分成交 或 全部成交)
+func (t *TransactionEgine) reportFill(order *model.ProductTransactionParams, trade *model_transaction.Trade) {
+
+	if trade == nil {
+		return
+	}
+
+	execType := model.Exec_PartialFilled
+	if order.RemainCount <= 0 {
+		execType = model.Exec_Filled
+	}
+	report := newOrderReport(execType, order)
+	report.TradeID = trade.TradeID
+	report.FillPrice = trade.Price
+	report.FillCount = trade.Count
+	report.Fee = trade.SellFee
+	report.SettleCurrency, report.SettleAmount, report.SettleFee = trade.SellCurrency, trade.SellSettleAmount, trade.SellSettleFee
+	if model.TransferMode(order.TransferMode) == model.Purchase {
+		report.Fee = trade.BuyFee
+		report.SettleCurrency, report.SettleAmount, report.SettleFee = trade.BuyCurrency, trade.BuySettleAmount, trade.BuySettleFee
+		report.ReleaseAmount = trade.BuyReleaseAmount
+	}
+	t.report(report)
+}
+
+// 回報 訂單結束 (取消 過期 或 拒絕)
+func (t *TransactionEgine) reportClosed(order *model.ProductTransactionParams, status model_transaction.Transaction_Status, refundAmount decimal.Decimal) {
+
+	execType := model.Exec_Cancelled
+	switch status {
+	case model_transaction.Transaction_Status_Expired:
+		execType = model.Exec_Expired
+	case model_transaction.Transaction_Status_Error:
+		execType = model.Exec_Rejected
+	}
+	report := newOrderReport(execType, order)
+	report.RefundAmount = refundAmount
+	if execType == model.Exec_Cancelled {
+		report.CancelResult = model.Cancel_Result_Cancelled
+	}
+	t.report(report)
+}
+
+// 回報 取消失敗 (已經全部成交 或 找不到訂單), 回報給要求取消的用戶
+func (t *TransactionEgine) reportCancelRejected(params *model.ProductCancelParams, result model.CancelResult) {
+	t.report(&model.ExecutionReport{
+		ExecType:      model.Exec_CancelRejected,
+		TransactionID: params.TransactionID,
+		UserID:        params.UserID,
+		CancelResult:  result,
+	})
+}
+
+// 回報 修改失敗 (找不到訂單 不是自己的 或 指令順序錯誤), 回報給要求修改的用戶
+func (t *TransactionEgine) reportAmendRejected(params *model.ProductAmendParams, reason error) {
+	t.report(&model.ExecutionReport{
+		ExecType:      model.Exec_Rejected,
+		TransactionID: params.TransactionID,
+		UserID:        params.UserID,
+		Reason:        reason.Error(),
+	})
+}
+
+// 回報 自成交防範 (訂單被取消 或 減少數量), 原因為防範模式
+func (t *TransactionEgine) reportSelfTrade(order *model.ProductTransactionParams, mode string, refundAmount decimal.Decimal) {
+	report := newOrderReport(model.Exec_SelfTradePrevented, order)
+	report.Reason = mode
+	report.RefundAmount = refundAmount
+	t.report(report)
+}
@@ -0,0 +1,62 @@
+package src
+
+import (
+	"errors"
+	"marketplace_server/internal/user/model"
+	"testing"
+
+	"github.com/shopspring/decimal"
+)
+
+// 測試用的成交回報 發送端 (記錄發送的回報, fail 時發送失敗)
+type testReporter struct {
+	fail    bool
+	reports []*model.ExecutionReport
+}
+
+func (r *testReporter) Report(report *model.ExecutionReport) error {
+	if r.fail {
+		return errors.New("mq unavailable")
+	}
+	r.reports = append(r.reports, report)
+	return nil
+}
+
+func Test_Report_RetryPending(t *testing.T) {
+	e := newTestEngine(t, nil)
+	reporter := &testReporter{fail: true}
+	e.reporter = reporter
+	e.addUser(1, "10000", 0)
+	e.addUser(2, "0", 5)
+
+	// 發送失敗 保留在待發送清單
+	if err := e.submit(newTestOrder("1-1-1", model.Purchase, 1, "100", 2, 1)); err != nil {
+		t.Fatalf("err:%v", err)
+	}
+	if err := e.submit(newTestOrder("2-1-1", model.Sell, 2, "100", 1, 2)); err != nil {
+		t.Fatalf("err:%v", err)
+	}
+	if len(reporter.reports) != 0 || len(e.pendingReports) != 4 {
+		t.Fatalf("reports:%d, pending:%d", len(reporter.reports), len(e.pendingReports))
+	}
+
+	// 恢復後 依序重送
+	reporter.fail = false
+	e.flushReports()
+	if len(e.pendingReports) != 0 || len(reporter.reports) != 4 {
+		t.Fatalf("reports:%d, pending:%d", len(reporter.reports), len(e.pendingReports))
+	}
+	execTypes := []model.ExecType{model.Exec_Accepted, model.Exec_Accepted, model.Exec_PartialFilled, model.Exec_Filled}
+	ids := make(map[string]bool)
+	for i, report := range reporter.reports {
+		if report.ExecType != execTypes[i] || len(report.ReportID) == 0 || ids[report.ReportID] {
+			t.Fatalf("index:%d, report:%+v", i, report)
+		}
+		ids[report.ReportID] = true
+	}
+
+	// 買單成交 回報此次成交釋放的預扣金額 (2 單位預扣 200, 成交 1)
+	if fill := reporter.reports[2]; fill.TransactionID != "1-1-1" || !fill.ReleaseAmount.Equal(decimal.NewFromInt(100)) {
+		t.Fatalf("report:%+v", fill)
+	}
+}
@@ -0,0 +1,158 @@
+package src
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"marketplace_server/internal/user/model"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// 指令日誌 (write-ahead journal)
+// 每筆被接受的指令 (買 賣 取消 修改) 在套用前先附加寫入檔案, 每行一筆 json
+// 重啟時 載入最新快照 再重播快照之後的指令, 也可以當作引擎收到指令的稽核紀錄
+// 快照成功後 日誌檔輪替 (只保留上一份), 日誌檔只包含最近一次快照之後的指令
+type Journal struct {
+	lock sync.Mutex
+	path string
+	file *os.File
+	seq  int64 // 最後寫入的序號
+}
+
+// 輪替後 上一份日誌檔的副檔名
+const JournalBackupSuffix = ".1"
+
+// 日誌紀錄
+type JournalEntry struct {
+	Seq     int64                           `json:"seq"`     // 序號 (遞增)
+	Time    int64                           `json:"time"`    // 寫入時間 (UnixNano)
+	Command *model.ProductTransactionNotify `json:"command"` // 指令
+}
+
+// 開啟日誌檔 (不存在就建立), 並讀取最後的序號
+// baseSeq 為快照包含到的序號, 輪替後的日誌檔是空的 序號從 baseSeq 接續
+func OpenJournal(path string, baseSeq int64) (*Journal, error) {
+
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, err
+	}
+
+	// 找出最後的序號
+	lastSeq := baseSeq
+	err := ReadJournal(path, baseSeq, func(entry *JournalEntry) error {
+		lastSeq = entry.Seq
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Journal{
+		path: path,
+		file: file,
+		seq:  lastSeq,
+	}, nil
+}
+
+// 附加一筆指令, 寫入時間使用引擎的時鐘 (重播時的時鐘), 寫入磁碟後才回傳序號
+func (j *Journal) Append(command *model.ProductTransactionNotify, now time.Time) (int64, error) {
+	j.lock.Lock()
+	defer j.lock.Unlock()
+
+	entry := &JournalEntry{
+		Seq:     j.seq + 1,
+		Time:    now.UnixNano(),
+		Command: command,
+	}
+	byteArray, err := json.Marshal(entry)
+	if err != nil {
+		return 0, err
+	}
+	byteArray = append(byteArray, '\n')
+
+	if _, err = j.file.Write(byteArray); err != nil {
+		return 0, err
+	}
+	if err = j.file.Sync(); err != nil {
+		return 0, err
+	}
+
+	j.seq = entry.Seq
+	return entry.Seq, nil
+}
+
+// 最後寫入的序號
+func (j *Journal) Seq() int64 {
+	j.lock.Lock()
+	defer j.lock.Unlock()
+	return j.seq
+}
+
+// 輪替日誌檔: 目前的日誌檔改名為上一份 (覆蓋更早的), 再建立新的日誌檔, 序號接續
+// 需在快照寫入成功後呼叫, 快照已包含目前日誌檔的全部指令
+func (j *Journal) Rotate() error {
+	j.lock.Lock()
+	defer j.lock.Unlock()
+
+	if err := j.file.Close(); err != nil {
+		return err
+	}
+	renameErr := os.Rename(j.path, j.path+JournalBackupSuffix)
+
+	// 改名失敗 繼續附加寫入原本的日誌檔
+	file, err := os.OpenFile(j.path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	j.file = file
+	return renameErr
+}
+
+func (j *Journal) Close() error {
+	return j.file.Close()
+}
+
+// 依序讀取日誌中 序號大於 fromSeq 的紀錄
+func ReadJournal(path string, fromSeq int64, fn func(entry *JournalEntry) error) error {
+
+	file, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	reader := bufio.NewReader(file)
+	for {
+		line, err := reader.ReadBytes('\n')
+		if err == io.EOF {
+			// 最後一行沒有換行, 代表寫入到一半就中斷, 忽略
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		entry := &JournalEntry{}
+		if err = json.Unmarshal(line, entry); err != nil {
+			return fmt.Errorf("unmarshal journal fail line:%s, err:%v", string(line), err)
+		}
+		if entry.Seq <= fromSeq {
+			continue
+		}
+		if err = fn(entry); err != nil {
+			return err
+		}
+	}
+}
@@ -0,0 +1,118 @@
+package src
+
+import (
+	model_bill "marketplace_server/internal/bill/model"
+	model_product "marketplace_server/internal/product/model"
+	"marketplace_server/internal/user/model"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/shopspring/decimal"
+)
+
+func Test_JournalRotate(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "engine.journal")
+
+	journal, err := OpenJournal(path, 0)
+	if err != nil {
+		t.Fatalf("err:%v", err)
+	}
+	for i := 0; i < 3; i++ {
+		if _, err = journal.Append(&model.ProductTransactionNotify{Cmd: model.Notify_Cmd_Cancel}, time.Now()); err != nil {
+			t.Fatalf("err:%v", err)
+		}
+	}
+
+	// 輪替後 新的日誌檔是空的, 序號接續
+	if err = journal.Rotate(); err != nil {
+		t.Fatalf("err:%v", err)
+	}
+	if seq, _ := journal.Append(&model.ProductTransactionNotify{Cmd: model.Notify_Cmd_Cancel}, time.Now()); seq != 4 {
+		t.Fatalf("seq:%d", seq)
+	}
+	journal.Close()
+
+	var seqs []int64
+	if err = ReadJournal(path, 0, func(entry *JournalEntry) error {
+		seqs = append(seqs, entry.Seq)
+		return nil
+	}); err != nil || len(seqs) != 1 || seqs[0] != 4 {
+		t.Fatalf("seqs:%v, err:%v", seqs, err)
+	}
+
+	// 重新開啟 從快照的序號接續
+	journal, err = OpenJournal(path, 3)
+	if err != nil || journal.Seq() != 4 {
+		t.Fatalf("seq:%d, err:%v", journal.Seq(), err)
+	}
+	journal.Close()
+
+	// 日誌檔是空的 (剛輪替), 使用快照的序號
+	journal, err = OpenJournal(filepath.Join(t.TempDir(), "empty.journal"), 7)
+	if err != nil || journal.Seq() != 7 {
+		t.Fatalf("seq:%d, err:%v", journal.Seq(), err)
+	}
+	journal.Close()
+}
+
+func Test_Recover_ReconcileWithDB(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "engine.journal")
+	e := newTestEngine(t, nil)
+	journal, err := OpenJournal(path, 0)
+	if err != nil {
+		t.Fatalf("err:%v", err)
+	}
+	e.journal = journal
+	if err = e.products.Save(&model_product.Product{ProductName: "BTC", Currency: "TWD"}); err != nil {
+		t.Fatalf("err:%v", err)
+	}
+	e.addUser(1, "10000", 0)
+	e.addUser(2, "0", 5)
+	e.addUser(3, "0", 5)
+
+	if err = e.submit(newTestOrder("2-1-1", model.Sell, 2, "110", 2, 1)); err != nil {
+		t.Fatalf("err:%v", err)
+	}
+	if err = e.submit(newTestOrder("1-1-1", model.Purchase, 1, "90", 3, 2)); err != nil {
+		t.Fatalf("err:%v", err)
+	}
+	journal.Close()
+	e.journal = nil
+
+	// 日誌之外 db 有變動: 2-1-1 已經結束, 1-1-1 只剩 1, 新的賣單 3-1-1 沒有寫入日誌
+	transaction := e.transaction("2-1-1")
+	transaction.Status = int8(model_bill.Transaction_Status_Cancel)
+	if err = e.transactions.Save(transaction); err != nil {
+		t.Fatalf("err:%v", err)
+	}
+	transaction = e.transaction("1-1-1")
+	transaction.Status, transaction.RemainCount, transaction.FilledCount = int8(model_bill.Transaction_Status_PartialFilled), 1, 2
+	if err = e.transactions.Save(transaction); err != nil {
+		t.Fatalf("err:%v", err)
+	}
+	if err = e.transactions.Save(&model_bill.Transaction{TransactionID: "3-1-1", TransferMode: int(model.Sell), FromUserID: 3,
+		ProductName: "BTC", ProductCount: 1, RemainCount: 1, Price: decimal.NewFromInt(120), Currency: "TWD"}); err != nil {
+		t.Fatalf("err:%v", err)
+	}
+
+	// 重播日誌後 依 db 校正訂單簿
+	replay := newTestEngine(t, nil)
+	replay.Repos = e.Repos
+	if replay.journal, err = OpenJournal(path, 0); err != nil {
+		t.Fatalf("err:%v", err)
+	}
+	defer replay.journal.Close()
+	if recovered, err := replay.recoverFromJournal(nil); err != nil || !recovered {
+		t.Fatalf("recovered:%v, err:%v", recovered, err)
+	}
+	if err = replay.reconcileOrderBooks(); err != nil {
+		t.Fatalf("err:%v", err)
+	}
+	if ids := orderIDs(replay.book().Asks); !equalIDs(ids, []string{"3-1-1"}) {
+		t.Fatalf("asks:%v", ids)
+	}
+	if ids := orderIDs(replay.book().Bids); !equalIDs(ids, []string{"1-1-1"}) || replay.book().Bids[0].RemainCount != 1 {
+		t.Fatalf("bids:%+v", replay.book().Bids)
+	}
+}
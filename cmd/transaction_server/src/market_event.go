@@ -0,0 +1,51 @@
+package src
+
+import (
+	"encoding/json"
+	"fmt"
+	Infrastructure_bill "marketplace_server/internal/bill/Infrastructure_layer"
+	model_transaction "marketplace_server/internal/bill/model"
+	"marketplace_server/internal/common/logs"
+	"marketplace_server/internal/product/Infrastructure_layer"
+	model_product "marketplace_server/internal/product/model"
+)
+
+// 即時行情事件 (redis pub/sub), 由 marketplace_server 的 websocket 轉發給客戶端
+// 發佈失敗不影響搓合, 重播日誌時不發佈
+
+// 發佈事件到頻道
+func (t *TransactionEgine) publishEvent(channel string, event interface{}) {
+	if t.replaying || t.Repos == nil || t.Repos.EventRepo == nil {
+		return
+	}
+
+	byteArray, err := json.Marshal(event)
+	if err != nil {
+		logs.Errorf("marshal fail event:%+v, err:%v", event, err)
+		return
+	}
+	if err = t.Repos.EventRepo.Publish(channel, byteArray); err != nil {
+		logs.Errorf("publish fail channel:%v, err:%v", channel, err)
+	}
+}
+
+// 發佈成交
+func (t *TransactionEgine) publishTrade(trade *model_transaction.Trade) {
+	if trade == nil {
+		return
+	}
+	t.publishEvent(fmt.Sprintf(Infrastructure_bill.Redis_TradeChannel, trade.ProductName), trade.ToEvent())
+}
+
+// 發佈K線更新
+func (t *TransactionEgine) publishCandle(candle *model_product.Candle) {
+	t.publishEvent(fmt.Sprintf(Infrastructure_layer.Redis_CandleChannel, candle.ProductName), candle.ToRedis())
+}
+
+// 發佈市場價格變動
+func (t *TransactionEgine) publishTicker(productName string, marketPriceDetail *model_product.MarketPriceRedis) {
+	t.publishEvent(fmt.Sprintf(Infrastructure_layer.Redis_TickerChannel, productName), &model_product.TickerEvent{
+		ProductName:      productName,
+		MarketPriceRedis: marketPriceDetail,
+	})
+}
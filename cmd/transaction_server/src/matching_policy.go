@@ -0,0 +1,197 @@
+package src
+
+import (
+	"fmt"
+	"marketplace_server/internal/user/model"
+	"sort"
+
+	"github.com/shopspring/decimal"
+)
+
+const (
+	MatchingPolicyFifo    = "fifo"    // 價格優先 時間優先
+	MatchingPolicyProRata = "prorata" // 價格優先 同價格依剩餘數量比例分配
+)
+
+// 成交分配 (一組 買單 賣單 的成交)
+type Fill struct {
+	Purchase *model.ProductTransactionParams // 買單
+	Sell     *model.ProductTransactionParams // 賣單
+	Price    decimal.Decimal                 // 成交價 (賣方價格)
+	Count    int64                           // 成交數量
+}
+
+// 搓合策略: 從訂單簿找出下一批成交, 只計算分配 不修改訂單簿
+type MatchingPolicy interface {
+	Name() string
+	Match(book *OrderBook, marketPrice decimal.Decimal) []*Fill // 沒有可成交的訂單 回傳 nil
+}
+
+var _ MatchingPolicy = &FifoPolicy{}
+var _ MatchingPolicy = &ProRataPolicy{}
+
+// 依名稱建立搓合策略, 空的使用 fifo
+func NewMatchingPolicy(name string) (MatchingPolicy, error) {
+	switch name {
+	case "", MatchingPolicyFifo:
+		return &FifoPolicy{}, nil
+	case MatchingPolicyProRata:
+		return &ProRataPolicy{}, nil
+	}
+	return nil, fmt.Errorf("unknown matching policy:%v", name)
+}
+
+// 價格優先 時間優先: 最佳買單對最佳賣單
+type FifoPolicy struct{}
+
+func (p *FifoPolicy) Name() string {
+	return MatchingPolicyFifo
+}
+
+func (p *FifoPolicy) Match(book *OrderBook, marketPrice decimal.Decimal) []*Fill {
+
+	purchaseData, sellData, sellAmount := findMatch(book, marketPrice)
+	if purchaseData == nil || sellData == nil {
+		return nil
+	}
+
+	// 成交數量取雙方剩餘數量較小者, 剩下的留在訂單簿
+	fillCount := purchaseData.RemainCount
+	if sellData.RemainCount < fillCount {
+		fillCount = sellData.RemainCount
+	}
+	return []*Fill{{
+		Purchase: purchaseData,
+		Sell:     sellData,
+		Price:    sellAmount,
+		Count:    fillCount,
+	}}
+}
+
+// 依 價格優先 時間優先 找出可成交的 買單 與 賣單, 回傳成交價 (賣方價格)
+func findMatch(book *OrderBook, marketPrice decimal.Decimal) (
+	purchaseData *model.ProductTransactionParams, sellData *model.ProductTransactionParams, sellAmount decimal.Decimal) {
+
+	for _, bid := range book.Bids {
+
+		// 取得買方的價格
+		purchaseAmount := bid.GetPrice(marketPrice)
+
+		for _, ask := range book.Asks {
+
+			// 取得賣方想要的價格
+			askAmount := ask.GetPrice(marketPrice)
+
+			// 買方價格 < 賣方價格, 後面的限價賣單只會更貴
+			if purchaseAmount.LessThan(askAmount) {
+				if model.TransferType(ask.TransferType) == model.MarketPrice {
+					continue
+				}
+				break
+			}
+			// 比對 相同用戶 不給予搓則
+			if bid.UserID == ask.UserID {
+				continue
+			}
+
+			return bid, ask, askAmount
+		}
+	}
+
+	return nil, nil, decimal.Zero
+}
+
+// 價格優先 依比例分配: 後進的訂單 (taker) 與對手方同價格的所有掛單 (maker) 成交,
+// 依掛單剩餘數量比例分配, 無條件捨去後剩下的數量 依時間先後補足
+type ProRataPolicy struct{}
+
+func (p *ProRataPolicy) Name() string {
+	return MatchingPolicyProRata
+}
+
+func (p *ProRataPolicy) Match(book *OrderBook, marketPrice decimal.Decimal) []*Fill {
+
+	purchaseData, sellData, _ := findMatch(book, marketPrice)
+	if purchaseData == nil || sellData == nil {
+		return nil
+	}
+
+	// 後進的訂單為 taker, 與 maker 同價格的對手方掛單一起分配
+	taker, maker, makerList := purchaseData, sellData, book.Asks
+	if sellData.TimeStamp > purchaseData.TimeStamp {
+		taker, maker, makerList = sellData, purchaseData, book.Bids
+	}
+	makerPrice := maker.GetPrice(marketPrice)
+
+	var makers []*model.ProductTransactionParams
+	var total int64
+	for _, order := range makerList {
+		if order.UserID == taker.UserID || order.TransferType != maker.TransferType ||
+			!order.GetPrice(marketPrice).Equal(makerPrice) {
+			continue
+		}
+		makers = append(makers, order)
+		total += order.RemainCount
+	}
+
+	quantity := taker.RemainCount
+	if total < quantity {
+		quantity = total
+	}
+
+	allocations := allocateProRata(makers, total, quantity)
+
+	var fills []*Fill
+	for i, order := range makers {
+		if allocations[i] <= 0 {
+			continue
+		}
+		fill := &Fill{Count: allocations[i]}
+		if taker == purchaseData {
+			fill.Purchase, fill.Sell = taker, order
+		} else {
+			fill.Purchase, fill.Sell = order, taker
+		}
+		fill.Price = fill.Sell.GetPrice(marketPrice)
+		fills = append(fills, fill)
+	}
+	return fills
+}
+
+// 依剩餘數量比例分配 quantity (掛單清單已依時間先後排序)
+func allocateProRata(makers []*model.ProductTransactionParams, total, quantity int64) []int64 {
+
+	allocations := make([]int64, len(makers))
+	if total <= 0 || quantity <= 0 {
+		return allocations
+	}
+
+	// 無條件捨去
+	var allocated int64
+	for i, order := range makers {
+		allocations[i] = order.RemainCount * quantity / total
+		allocated += allocations[i]
+	}
+
+	// 剩下的數量 依時間先後補足
+	index := make([]int, len(makers))
+	for i := range index {
+		index[i] = i
+	}
+	sort.SliceStable(index, func(a, b int) bool {
+		return makers[index[a]].TimeStamp < makers[index[b]].TimeStamp
+	})
+	for _, i := range index {
+		if allocated >= quantity {
+			break
+		}
+		add := makers[i].RemainCount - allocations[i]
+		if add > quantity-allocated {
+			add = quantity - allocated
+		}
+		allocations[i] += add
+		allocated += add
+	}
+
+	return allocations
+}
@@ -0,0 +1,102 @@
+package src
+
+import (
+	"marketplace_server/internal/user/model"
+	"testing"
+
+	"github.com/shopspring/decimal"
+)
+
+// 建立測試用的限價單
+func newTestOrder(transactionID string, mode model.TransferMode, userID int64, price string, count, timeStamp int64) *model.ProductTransactionParams {
+	return &model.ProductTransactionParams{
+		TransactionID: transactionID,
+		TransferMode:  int(mode),
+		TransferType:  int(model.LimitPrice),
+		UserID:        userID,
+		Amount:        decimal.RequireFromString(price),
+		OperateCount:  count,
+		RemainCount:   count,
+		TimeStamp:     timeStamp,
+	}
+}
+
+func Test_FifoPolicy(t *testing.T) {
+	book := NewOrderBook("BTC")
+	book.Add(newTestOrder("s1", model.Sell, 1, "10", 5, 1))
+	book.Add(newTestOrder("s2", model.Sell, 2, "9", 5, 2))
+	book.Add(newTestOrder("b1", model.Purchase, 3, "10", 8, 3))
+
+	fills := (&FifoPolicy{}).Match(book, decimal.NewFromInt(10))
+	if len(fills) != 1 {
+		t.Fatalf("fills:%d", len(fills))
+	}
+	// 價格優先: 先與較便宜的 s2 成交, 成交價為賣方價格
+	if fills[0].Sell.TransactionID != "s2" || fills[0].Count != 5 || !fills[0].Price.Equal(decimal.NewFromInt(9)) {
+		t.Fatalf("fill:%+v", fills[0])
+	}
+}
+
+func Test_FifoPolicy_SameUser(t *testing.T) {
+	book := NewOrderBook("BTC")
+	book.Add(newTestOrder("s1", model.Sell, 1, "10", 5, 1))
+	book.Add(newTestOrder("b1", model.Purchase, 1, "10", 5, 2))
+
+	// 相同用戶 不成交
+	if fills := (&FifoPolicy{}).Match(book, decimal.NewFromInt(10)); len(fills) != 0 {
+		t.Fatalf("fills:%d", len(fills))
+	}
+}
+
+func Test_ProRataPolicy(t *testing.T) {
+	book := NewOrderBook("BTC")
+	book.Add(newTestOrder("s1", model.Sell, 1, "10", 30, 1))
+	book.Add(newTestOrder("s2", model.Sell, 2, "10", 10, 2))
+	book.Add(newTestOrder("s3", model.Sell, 3, "11", 50, 3))
+	book.Add(newTestOrder("b1", model.Purchase, 4, "11", 9, 4))
+
+	// 同價格 (10) 的賣單依剩餘數量 30:10 分配 9, 捨去後剩下的依時間先後補足 (s1)
+	fills := (&ProRataPolicy{}).Match(book, decimal.NewFromInt(10))
+	got := map[string]int64{}
+	for _, fill := range fills {
+		if fill.Purchase.TransactionID != "b1" || !fill.Price.Equal(decimal.NewFromInt(10)) {
+			t.Fatalf("fill:%+v", fill)
+		}
+		got[fill.Sell.TransactionID] = fill.Count
+	}
+	if len(got) != 2 || got["s1"] != 7 || got["s2"] != 2 {
+		t.Fatalf("allocations:%v", got)
+	}
+}
+
+func Test_ProRataPolicy_MakerLevelSmaller(t *testing.T) {
+	book := NewOrderBook("BTC")
+	book.Add(newTestOrder("b1", model.Purchase, 1, "10", 2, 1))
+	book.Add(newTestOrder("b2", model.Purchase, 2, "10", 4, 2))
+	book.Add(newTestOrder("s1", model.Sell, 3, "10", 100, 3))
+
+	// 掛單總量小於 taker, 全部成交
+	fills := (&ProRataPolicy{}).Match(book, decimal.NewFromInt(10))
+	var total int64
+	for _, fill := range fills {
+		if fill.Sell.TransactionID != "s1" || fill.Count != fill.Purchase.RemainCount {
+			t.Fatalf("fill:%+v", fill)
+		}
+		total += fill.Count
+	}
+	if total != 6 {
+		t.Fatalf("total:%d", total)
+	}
+}
+
+func Test_NewMatchingPolicy(t *testing.T) {
+	for name, want := range map[string]string{"": MatchingPolicyFifo, "fifo": MatchingPolicyFifo, "prorata": MatchingPolicyProRata} {
+		policy, err := NewMatchingPolicy(name)
+		if err != nil || policy.Name() != want {
+			t.Fatalf("name:%v, policy:%v, err:%v", name, policy, err)
+		}
+	}
+	if _, err := NewMatchingPolicy("unknown"); err == nil {
+		t.Fatal("unknown policy should fail")
+	}
+}
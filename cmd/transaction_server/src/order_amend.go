@@ -0,0 +1,245 @@
+package src
+
+import (
+	"encoding/json"
+	"fmt"
+	domain_bill "marketplace_server/internal/bill/domain_layer"
+	model_transaction "marketplace_server/internal/bill/model"
+	"marketplace_server/internal/common/logs"
+	"marketplace_server/internal/common/rabbitmqx"
+	Infrastructure_server "marketplace_server/internal/servers/Infrastructure_layer"
+	"marketplace_server/internal/user/model"
+
+	"github.com/shopspring/decimal"
+)
+
+// 修改交易 (價格 / 數量)
+// 只減少數量 保留時間優先, 修改價格 或 增加數量 以新的時間重新排隊
+func (t *TransactionEgine) AmendProduct(productTransactionNotify *model.ProductTransactionNotify) error {
+
+	// 解析封包
+	byteArray, err := json.Marshal(productTransactionNotify.Data)
+	if err != nil {
+		return rabbitmqx.Permanent(err)
+	}
+	var productAmendParams model.ProductAmendParams
+	err = json.Unmarshal(byteArray, &productAmendParams)
+	if err != nil {
+		return rabbitmqx.Permanent(err)
+	}
+
+	// 資料檢查
+	if len(productAmendParams.TransactionID) == 0 || productAmendParams.UserID <= 0 {
+		return rabbitmqx.Permanent(fmt.Errorf("error params productAmendParams:%+v", productAmendParams))
+	}
+
+	// 從訂單簿 或 停損單觸發清單 找出訂單, 找不到 或 不是自己的訂單 回報給要求修改的用戶
+	order := t.findOrder(productAmendParams.TransactionID)
+	if order == nil || order.UserID != productAmendParams.UserID {
+		err = fmt.Errorf("order not found productAmendParams:%+v", productAmendParams)
+		if order != nil {
+			err = fmt.Errorf("not order owner productAmendParams:%+v, owner:%v", productAmendParams, order.UserID)
+		}
+		t.reportAmendRejected(&productAmendParams, err)
+		return rejected(err)
+	}
+
+	remainCount := productAmendParams.NewRemainCount(order)
+	priceChanged := productAmendParams.IsPriceChanged(order)
+	currencyMismatch := len(productAmendParams.Currency) > 0 && productAmendParams.Currency != order.Currency
+	if remainCount <= 0 || (priceChanged && (!order.HasLimitPrice() || currencyMismatch)) {
+		err = fmt.Errorf("error params productAmendParams:%+v, order:%+v", productAmendParams, order)
+		t.reportRejected(order, err)
+		return rabbitmqx.Permanent(err)
+	}
+	addCount := remainCount - order.RemainCount
+	if addCount == 0 && !priceChanged {
+		return nil
+	}
+
+	// 寫入 db, 調整 預扣金額 / 凍結數量 的差額, 暫時的錯誤 (db redis) 不回報 讓 mq 重試
+	refundAmount, err := t.amendOrder(order, &productAmendParams, addCount, priceChanged)
+	if err != nil {
+		if IsRejected(err) {
+			t.reportRejected(order, err)
+		}
+		return err
+	}
+
+	// 修改訂單簿
+	book := t.getOrderBook(order.ProductName)
+	if priceChanged || addCount > 0 {
+		// 失去時間優先, 移除後以新的時間重新加入
+		stopBook := t.getStopBook(order.ProductName)
+		if _, ok := book.Remove(order.TransactionID); !ok {
+			stopBook.Remove(order.TransactionID)
+		}
+		if priceChanged {
+			order.Amount = productAmendParams.Amount
+		}
+		order.OperateCount += addCount
+		order.RemainCount = remainCount
+		order.TimeStamp = productAmendParams.TimeStamp
+		if order.IsStop() {
+			stopBook.Add(order)
+		} else {
+			book.Add(order)
+		}
+	} else {
+		// 只減少數量, 保留原本的排隊位置
+		order.OperateCount += addCount
+		order.RemainCount = remainCount
+	}
+	logs.Debugf("修改等待搓合單:%+v", order)
+
+	report := newOrderReport(model.Exec_Amended, order)
+	report.RefundAmount = refundAmount
+	t.report(report)
+
+	// 價格修改後 可能可以成交
+	t.matchBook(order.ProductName, book)
+	return nil
+}
+
+// 修改 db 的交易單, 賣單調整凍結的商品數量, 買單重新計算剩餘數量的預扣金額 並調整差額
+// 回傳退還的預扣金額, 負數代表追加預扣; 重播日誌時 當初已寫入 不再處理
+func (t *TransactionEgine) amendOrder(order *model.ProductTransactionParams, productAmendParams *model.ProductAmendParams,
+	addCount int64, priceChanged bool) (decimal.Decimal, error) {
+
+	if t.replaying {
+		return decimal.Zero, nil
+	}
+
+	transaction, err := t.Repos.TransactionRepo.GetTransactionInfo(order.TransactionID)
+	if err != nil {
+		return decimal.Zero, fmt.Errorf("error getTransactionInfo transactionID:%v, err:%v", order.TransactionID, err)
+	}
+	switch model_transaction.Transaction_Status(transaction.Status) {
+	case model_transaction.Transaction_Status_Wait, model_transaction.Transaction_Status_PartialFilled:
+	default:
+		return decimal.Zero, rejected(fmt.Errorf("transaction already closed transactionID:%v, status:%d", transaction.TransactionID, transaction.Status))
+	}
+
+	// 買單 退還的預扣金額 = 修改前 剩餘數量的預扣金額 - 修改後 剩餘數量的預扣金額
+	price := order.Amount
+	if priceChanged {
+		price = productAmendParams.Amount
+	}
+	productCount := transaction.ProductCount + addCount
+	remainCount := transaction.RemainCount + addCount
+	refundAmount := decimal.Zero
+	holdAmount := decimal.Zero
+	if model.TransferMode(transaction.TransferMode) == model.Purchase {
+		if holdAmount, err = t.amendHoldAmount(transaction, order, price, remainCount); err != nil {
+			return decimal.Zero, err
+		}
+		refundAmount = transaction.RemainHoldAmount().Sub(holdAmount)
+	}
+
+	// 追加預扣 寫入 db 前先檢查並扣除用戶緩存的餘額 (原子操作), db 寫入失敗再退回
+	if refundAmount.IsNegative() {
+		_, err = t.Repos.AuthRepo.UpdateAmount(transaction.FromUserID, func(auth *model.AuthInfo) error {
+			if !auth.Amount.GreaterThan(refundAmount.Neg()) {
+				return rejected(fmt.Errorf("不夠錢買 %s < %s", auth.Amount.String(), refundAmount.Neg().String()))
+			}
+			auth.Amount = auth.Amount.Add(refundAmount)
+			return nil
+		})
+		if IsRejected(err) {
+			return decimal.Zero, err
+		}
+		if err != nil {
+			return decimal.Zero, fmt.Errorf("hold amount fail userID:%v err:%v", transaction.FromUserID, err)
+		}
+	}
+
+	// 修改交易單, 賣單調整凍結的商品數量 (使用 transaction(事務) 失敗就Rollback)
+	err = t.Repos.Transaction(func(uow *Infrastructure_server.UnitOfWork) error {
+
+		// 記錄修改後 剩餘數量的預扣金額, 之後的成交 與 結束 依此 釋放 / 退還
+		if model.TransferMode(transaction.TransferMode) == model.Purchase {
+			transaction.HoldAmount = holdAmount
+		}
+		transaction.ProductCount = productCount
+		transaction.RemainCount = remainCount
+		if priceChanged {
+			transaction.Price = productAmendParams.Amount
+		}
+		if priceChanged || addCount > 0 {
+			// 失去時間優先, 重啟從 db 重建訂單簿時 使用新的排隊時間
+			transaction.QueueTime = productAmendParams.TimeStamp
+		}
+		transaction.UodateAt = t.now()
+		if err := uow.TransactionRepo.Save(transaction); err != nil {
+			return fmt.Errorf("error Save transaction:%+v, err:%v", transaction, err)
+		}
+
+		if model.TransferMode(transaction.TransferMode) != model.Sell || addCount == 0 {
+			return nil
+		}
+		var err error
+		if addCount > 0 {
+			err = uow.BackpackRepo.HoldProduct(transaction.FromUserID, transaction.ProductName, addCount)
+		} else {
+			err = uow.BackpackRepo.ReleaseProduct(transaction.FromUserID, transaction.ProductName, -addCount)
+		}
+		if err != nil {
+			return fmt.Errorf("adjust hold fail transactionID:%v, addCount:%d, err:%v", transaction.TransactionID, addCount, err)
+		}
+		return nil
+	})
+	if err != nil {
+		if refundAmount.IsNegative() {
+			if refundErr := t.addAuthAmount(transaction.FromUserID, refundAmount.Neg()); refundErr != nil {
+				logs.Errorf("refund user cache fail userID:%v, amount:%v, err:%v", transaction.FromUserID, refundAmount.Neg(), refundErr)
+			}
+		}
+		return decimal.Zero, err
+	}
+
+	// 預扣金額減少 退還用戶緩存的預扣金額
+	if refundAmount.IsPositive() {
+		if err = t.addAuthAmount(transaction.FromUserID, refundAmount); err != nil {
+			logs.Errorf("refund user cache fail userID:%v, amount:%v, err:%v", transaction.FromUserID, refundAmount, err)
+		}
+	}
+	logs.Debugf("修改交易單: transactionID:%v, addCount:%d, amount(退款額):%v",
+		transaction.TransactionID, addCount, refundAmount)
+
+	return refundAmount, nil
+}
+
+// 買單修改後 剩餘數量需要的預扣金額 (用戶的幣種)
+// 有委託價格 = (新的委託價格 * 新的剩餘數量 + 最多可能的手續費) * 匯率, 市價單沒有委託價格 維持每單位的預扣金額
+func (t *TransactionEgine) amendHoldAmount(transaction *model_transaction.Transaction, order *model.ProductTransactionParams,
+	price decimal.Decimal, remainCount int64) (decimal.Decimal, error) {
+
+	if !order.HasLimitPrice() {
+		return transaction.HoldAmountOf(remainCount), nil
+	}
+
+	user, err := t.Repos.UserRepo.GetUserInfo(transaction.FromUserID)
+	if err != nil {
+		return decimal.Zero, fmt.Errorf("getUserInfo userID:%v, err:%v", transaction.FromUserID, err)
+	}
+	rate, err := t.Rate.GetRate(transaction.Currency, user.Currency)
+	if err != nil {
+		return decimal.Zero, fmt.Errorf("getRate from:%v, to:%v, err:%v", transaction.Currency, user.Currency, err)
+	}
+	return domain_bill.HoldAmount(t.Fee, transaction.ProductName, user.VipLevel, price, remainCount, rate.Get()), nil
+}
+
+// 從訂單簿 或 停損單觸發清單 找出訂單, 找不到回傳 nil
+func (t *TransactionEgine) findOrder(transactionID string) *model.ProductTransactionParams {
+	for _, book := range t.OrderBooks {
+		if list, index := book.Find(transactionID); list != nil {
+			return (*list)[index]
+		}
+	}
+	for _, stopBook := range t.StopBooks {
+		if index := stopBook.Find(transactionID); index >= 0 {
+			return stopBook.Orders[index]
+		}
+	}
+	return nil
+}
@@ -0,0 +1,216 @@
+package src
+
+import (
+	model_bill "marketplace_server/internal/bill/model"
+	model_product "marketplace_server/internal/product/model"
+	"marketplace_server/internal/user/model"
+	"testing"
+
+	"github.com/shopspring/decimal"
+)
+
+func amendParams(transactionID string, userID int64, price string, count, timeStamp int64) *model.ProductAmendParams {
+	params := &model.ProductAmendParams{TransactionID: transactionID, UserID: userID, OperateCount: count, TimeStamp: timeStamp}
+	if len(price) > 0 {
+		params.Amount = decimal.RequireFromString(price)
+	}
+	return params
+}
+
+func Test_Amend_PriceChangeRecomputesHold(t *testing.T) {
+	e := newTestEngine(t, nil)
+	e.addUser(1, "10000", 0)
+
+	// 限價買單 2 @ 100, 預扣 200
+	if err := e.submit(newTestOrder("1-1-1", model.Purchase, 1, "100", 2, 1)); err != nil {
+		t.Fatalf("err:%v", err)
+	}
+	if !e.authAmount(1).Equal(decimal.NewFromInt(9800)) {
+		t.Fatalf("auth:%v", e.authAmount(1))
+	}
+
+	// 改價 150, 預扣 150*2=300, 追加預扣 100
+	if err := e.notify(model.Notify_Cmd_Amend, 1, amendParams("1-1-1", 1, "150", 0, 2)); err != nil {
+		t.Fatalf("err:%v", err)
+	}
+	if !e.authAmount(1).Equal(decimal.NewFromInt(9700)) {
+		t.Fatalf("auth:%v", e.authAmount(1))
+	}
+	// 下單時的預扣金額不變, 另外記錄剩餘數量的預扣金額
+	if transaction := e.transaction("1-1-1"); !transaction.ProductNeedAmount.Equal(decimal.NewFromInt(200)) ||
+		!transaction.HoldAmount.Equal(decimal.NewFromInt(300)) || !transaction.Price.Equal(decimal.NewFromInt(150)) {
+		t.Fatalf("transaction:%+v", transaction)
+	}
+
+	// 改價 120 並減少數量為 1, 預扣 120, 退還 180
+	if err := e.notify(model.Notify_Cmd_Amend, 1, amendParams("1-1-1", 1, "120", 1, 3)); err != nil {
+		t.Fatalf("err:%v", err)
+	}
+	if !e.authAmount(1).Equal(decimal.NewFromInt(9880)) {
+		t.Fatalf("auth:%v", e.authAmount(1))
+	}
+	order := e.findOrder("1-1-1")
+	if order == nil || order.RemainCount != 1 || !order.Amount.Equal(decimal.NewFromInt(120)) || order.TimeStamp != 3 {
+		t.Fatalf("order:%+v", order)
+	}
+
+	// 取消 退還剩餘的預扣 120
+	if err := e.notify(model.Notify_Cmd_Cancel, 1, &model.ProductCancelParams{TransactionID: "1-1-1", UserID: 1}); err != nil {
+		t.Fatalf("err:%v", err)
+	}
+	if !e.authAmount(1).Equal(decimal.NewFromInt(10000)) {
+		t.Fatalf("auth:%v", e.authAmount(1))
+	}
+}
+
+func Test_Amend_PartialFilledPriceChange(t *testing.T) {
+	e := newTestEngine(t, nil)
+	e.addUser(1, "10000", 0)
+	e.addUser(2, "0", 10)
+
+	// 買單 4 @ 100 成交 1, 剩餘 3 預扣 300
+	if err := e.submit(newTestOrder("1-1-1", model.Purchase, 1, "100", 4, 1)); err != nil {
+		t.Fatalf("err:%v", err)
+	}
+	if err := e.submit(newTestOrder("2-1-1", model.Sell, 2, "100", 1, 2)); err != nil {
+		t.Fatalf("err:%v", err)
+	}
+	if transaction := e.transaction("1-1-1"); transaction.RemainCount != 3 {
+		t.Fatalf("transaction:%+v", transaction)
+	}
+
+	// 改價 110, 剩餘 3 預扣 330, 追加預扣 30
+	before := e.authAmount(1)
+	if err := e.notify(model.Notify_Cmd_Amend, 1, amendParams("1-1-1", 1, "110", 0, 3)); err != nil {
+		t.Fatalf("err:%v", err)
+	}
+	if !before.Sub(e.authAmount(1)).Equal(decimal.NewFromInt(30)) {
+		t.Fatalf("before:%v, after:%v", before, e.authAmount(1))
+	}
+	if transaction := e.transaction("1-1-1"); !transaction.RemainHoldAmount().Equal(decimal.NewFromInt(330)) {
+		t.Fatalf("transaction:%+v", transaction)
+	}
+
+	// 餘額不足的改價 被拒絕, 訂單與預扣不變
+	e.addAuth(1, e.authAmount(1).Neg())
+	if err := e.notify(model.Notify_Cmd_Amend, 1, amendParams("1-1-1", 1, "200", 0, 4)); err == nil {
+		t.Fatalf("amend without balance")
+	}
+	if order := e.findOrder("1-1-1"); !order.Amount.Equal(decimal.NewFromInt(110)) {
+		t.Fatalf("order:%+v", order)
+	}
+	if transaction := e.transaction("1-1-1"); !transaction.RemainHoldAmount().Equal(decimal.NewFromInt(330)) ||
+		transaction.Status != int8(model_bill.Transaction_Status_PartialFilled) {
+		t.Fatalf("transaction:%+v", transaction)
+	}
+}
+
+func Test_Amend_RejectReported(t *testing.T) {
+	e := newTestEngine(t, nil)
+	reporter := &testReporter{}
+	e.reporter = reporter
+	e.addUser(1, "10000", 0)
+	e.addUser(2, "10000", 0)
+
+	if err := e.submit(newTestOrder("1-1-1", model.Purchase, 1, "100", 2, 1)); err != nil {
+		t.Fatalf("err:%v", err)
+	}
+	reporter.reports = nil
+
+	// 找不到訂單 與 不是自己的訂單, 回報給要求修改的用戶
+	if err := e.notify(model.Notify_Cmd_Amend, 2, amendParams("1-1-9", 2, "90", 0, 2)); !IsRejected(err) {
+		t.Fatalf("err:%v", err)
+	}
+	if err := e.notify(model.Notify_Cmd_Amend, 2, amendParams("1-1-1", 2, "90", 0, 3)); !IsRejected(err) {
+		t.Fatalf("err:%v", err)
+	}
+	if len(reporter.reports) != 2 {
+		t.Fatalf("reports:%d", len(reporter.reports))
+	}
+	for i, transactionID := range []string{"1-1-9", "1-1-1"} {
+		report := reporter.reports[i]
+		if report.ExecType != model.Exec_Rejected || report.UserID != 2 || report.TransactionID != transactionID || len(report.Reason) == 0 {
+			t.Fatalf("report:%+v", report)
+		}
+	}
+	if order := e.findOrder("1-1-1"); !order.Amount.Equal(decimal.NewFromInt(100)) {
+		t.Fatalf("order:%+v", order)
+	}
+}
+
+func Test_Amend_HoldReleasedOnFill(t *testing.T) {
+	e := newTestEngine(t, nil)
+	e.addUser(1, "10000", 0)
+	e.addUser(2, "0", 10)
+
+	// 買單 3 @ 100 改價 33.33, 剩餘數量的預扣 99.99
+	if err := e.submit(newTestOrder("1-1-1", model.Purchase, 1, "100", 3, 1)); err != nil {
+		t.Fatalf("err:%v", err)
+	}
+	if err := e.notify(model.Notify_Cmd_Amend, 1, amendParams("1-1-1", 1, "33.33", 0, 2)); err != nil {
+		t.Fatalf("err:%v", err)
+	}
+	if transaction := e.transaction("1-1-1"); !transaction.HoldAmount.Equal(decimal.RequireFromString("99.99")) {
+		t.Fatalf("transaction:%+v", transaction)
+	}
+
+	// 成交 1, 釋放 33.33, 剩餘 66.66
+	if err := e.submit(newTestOrder("2-1-1", model.Sell, 2, "33.33", 1, 3)); err != nil {
+		t.Fatalf("err:%v", err)
+	}
+	trade := e.trades.GetTradeList()[0]
+	if !trade.BuyReleaseAmount.Equal(decimal.RequireFromString("33.33")) {
+		t.Fatalf("trade:%+v", trade)
+	}
+	if transaction := e.transaction("1-1-1"); !transaction.HoldAmount.Equal(decimal.RequireFromString("66.66")) {
+		t.Fatalf("transaction:%+v", transaction)
+	}
+
+	// 取消 退還剩餘的預扣 66.66, 預扣全部釋放
+	before := e.authAmount(1)
+	if err := e.notify(model.Notify_Cmd_Cancel, 1, &model.ProductCancelParams{TransactionID: "1-1-1", UserID: 1}); err != nil {
+		t.Fatalf("err:%v", err)
+	}
+	if refund := e.authAmount(1).Sub(before); !refund.Equal(decimal.RequireFromString("66.66")) {
+		t.Fatalf("refund:%v", refund)
+	}
+	if transaction := e.transaction("1-1-1"); !transaction.HoldAmount.IsZero() {
+		t.Fatalf("transaction:%+v", transaction)
+	}
+}
+
+func Test_Amend_RequeueSurvivesRestart(t *testing.T) {
+	e := newTestEngine(t, nil)
+	if err := e.products.Save(&model_product.Product{ProductName: "BTC", Currency: "TWD"}); err != nil {
+		t.Fatalf("err:%v", err)
+	}
+	e.addUser(1, "0", 10)
+	e.addUser(2, "0", 10)
+
+	if err := e.submit(newTestOrder("1-1-1", model.Sell, 1, "100", 1, 1)); err != nil {
+		t.Fatalf("err:%v", err)
+	}
+	if err := e.submit(newTestOrder("2-1-1", model.Sell, 2, "100", 1, 2)); err != nil {
+		t.Fatalf("err:%v", err)
+	}
+
+	// 增加數量 失去時間優先, 排到 2-1-1 之後
+	if err := e.notify(model.Notify_Cmd_Amend, 1, amendParams("1-1-1", 1, "", 2, 3)); err != nil {
+		t.Fatalf("err:%v", err)
+	}
+	if !equalIDs(orderIDs(e.book().Asks), []string{"2-1-1", "1-1-1"}) {
+		t.Fatalf("asks:%v", orderIDs(e.book().Asks))
+	}
+	if transaction := e.transaction("1-1-1"); transaction.QueueTime != 3 {
+		t.Fatalf("transaction:%+v", transaction)
+	}
+
+	// 重啟從 db 重建訂單簿 維持修改後的排隊順序
+	e.OrderBooks = make(map[string]*OrderBook)
+	if err := e.LoadOrderBooks(); err != nil {
+		t.Fatalf("err:%v", err)
+	}
+	if !equalIDs(orderIDs(e.book().Asks), []string{"2-1-1", "1-1-1"}) {
+		t.Fatalf("asks:%v", orderIDs(e.book().Asks))
+	}
+}
@@ -0,0 +1,193 @@
+package src
+
+import (
+	"marketplace_server/internal/common/utils"
+	model_product "marketplace_server/internal/product/model"
+	"marketplace_server/internal/user/model"
+	"sort"
+
+	"github.com/shopspring/decimal"
+)
+
+// 訂單簿 (每個商品一本)
+// 買單依價格由高到低, 賣單依價格由低到高, 同價格依時間先後 (FIFO)
+// 市價單沒有價格, 一律排在限價單前面
+type OrderBook struct {
+	ProductName string                            // 商品名稱
+	Bids        []*model.ProductTransactionParams // 買單 (價格高 -> 低)
+	Asks        []*model.ProductTransactionParams // 賣單 (價格低 -> 高)
+}
+
+// 建立訂單簿
+func NewOrderBook(productName string) *OrderBook {
+	return &OrderBook{
+		ProductName: productName,
+	}
+}
+
+// 買單 a 是否優先於 b
+func bidBefore(a, b *model.ProductTransactionParams) bool {
+	aMarket := model.TransferType(a.TransferType) == model.MarketPrice
+	bMarket := model.TransferType(b.TransferType) == model.MarketPrice
+	if aMarket != bMarket {
+		return aMarket
+	}
+	if !aMarket && !a.Amount.Equal(b.Amount) {
+		return a.Amount.GreaterThan(b.Amount)
+	}
+	return a.TimeStamp < b.TimeStamp
+}
+
+// 賣單 a 是否優先於 b
+func askBefore(a, b *model.ProductTransactionParams) bool {
+	aMarket := model.TransferType(a.TransferType) == model.MarketPrice
+	bMarket := model.TransferType(b.TransferType) == model.MarketPrice
+	if aMarket != bMarket {
+		return aMarket
+	}
+	if !aMarket && !a.Amount.Equal(b.Amount) {
+		return a.Amount.LessThan(b.Amount)
+	}
+	return a.TimeStamp < b.TimeStamp
+}
+
+// 依優先順序插入 (二分搜尋)
+func insertOrder(list []*model.ProductTransactionParams, order *model.ProductTransactionParams,
+	before func(a, b *model.ProductTransactionParams) bool) []*model.ProductTransactionParams {
+
+	index := sort.Search(len(list), func(i int) bool {
+		return before(order, list[i])
+	})
+	list = append(list, nil)
+	copy(list[index+1:], list[index:])
+	list[index] = order
+	return list
+}
+
+// 加入訂單
+func (b *OrderBook) Add(order *model.ProductTransactionParams) {
+	switch model.TransferMode(order.TransferMode) {
+	case model.Purchase:
+		b.Bids = insertOrder(b.Bids, order, bidBefore)
+	case model.Sell:
+		b.Asks = insertOrder(b.Asks, order, askBefore)
+	}
+}
+
+// 搜尋訂單 回傳所在的清單與索引
+func (b *OrderBook) Find(transactionID string) (*[]*model.ProductTransactionParams, int) {
+	for i, data := range b.Bids {
+		if data.TransactionID == transactionID {
+			return &b.Bids, i
+		}
+	}
+	for i, data := range b.Asks {
+		if data.TransactionID == transactionID {
+			return &b.Asks, i
+		}
+	}
+	return nil, -1
+}
+
+// 移除訂單
+func (b *OrderBook) Remove(transactionID string) (*model.ProductTransactionParams, bool) {
+	list, index := b.Find(transactionID)
+	if list == nil {
+		return nil, false
+	}
+	order := (*list)[index]
+	utils.SliceHelper(list).Remove(index)
+	return order, true
+}
+
+// 訂單目前可立即成交的數量 (對手方價格可成交 且不是同一用戶), 全部成交或取消 (FOK) 使用
+// stopAtSelf: 依 價格優先 時間優先 遇到同一用戶可成交的訂單就停止計算 (自成交防範會取消或減少新進的訂單)
+func (b *OrderBook) FillableCount(order *model.ProductTransactionParams, marketPrice decimal.Decimal, stopAtSelf bool) int64 {
+
+	price := order.GetPrice(marketPrice)
+	var count int64
+	switch model.TransferMode(order.TransferMode) {
+	case model.Purchase:
+		for _, ask := range b.Asks {
+			if price.LessThan(ask.GetPrice(marketPrice)) {
+				continue
+			}
+			if ask.UserID == order.UserID {
+				if stopAtSelf {
+					break
+				}
+				continue
+			}
+			count += ask.RemainCount
+		}
+	case model.Sell:
+		for _, bid := range b.Bids {
+			if bid.GetPrice(marketPrice).LessThan(price) {
+				continue
+			}
+			if bid.UserID == order.UserID {
+				if stopAtSelf {
+					break
+				}
+				continue
+			}
+			count += bid.RemainCount
+		}
+	}
+	return count
+}
+
+// 最佳買單
+func (b *OrderBook) BestBid() *model.ProductTransactionParams {
+	if len(b.Bids) == 0 {
+		return nil
+	}
+	return b.Bids[0]
+}
+
+// 最佳賣單
+func (b *OrderBook) BestAsk() *model.ProductTransactionParams {
+	if len(b.Asks) == 0 {
+		return nil
+	}
+	return b.Asks[0]
+}
+
+// 訂單數量
+func (b *OrderBook) Len() int {
+	return len(b.Bids) + len(b.Asks)
+}
+
+// 價格檔位 (L2 深度), 與發佈到 redis 的深度使用相同格式
+type PriceLevel = model_product.DepthLevel
+
+// 依價格彙總 前 level 檔的買賣深度 (level <= 0 不限制), 市價單沒有價格 不列入
+func (b *OrderBook) Depth(level int) (bids, asks []*PriceLevel) {
+	return depthLevels(b.Bids, level), depthLevels(b.Asks, level)
+}
+
+// 依價格彙總 (清單已依優先順序排序, 同價格相鄰)
+func depthLevels(list []*model.ProductTransactionParams, level int) []*PriceLevel {
+
+	levels := []*PriceLevel{}
+	for _, order := range list {
+		if model.TransferType(order.TransferType) == model.MarketPrice {
+			continue
+		}
+		last := len(levels) - 1
+		if last >= 0 && levels[last].Price.Equal(order.Amount) {
+			levels[last].Count += order.RemainCount
+			levels[last].OrderCount++
+			continue
+		}
+		if level > 0 && len(levels) >= level {
+			break
+		}
+		levels = append(levels, &PriceLevel{
+			Price:      order.Amount,
+			Count:      order.RemainCount,
+			OrderCount: 1,
+		})
+	}
+	return levels
+}
@@ -0,0 +1,149 @@
+package src
+
+import (
+	"fmt"
+	model_transaction "marketplace_server/internal/bill/model"
+	"marketplace_server/internal/common/logs"
+	"marketplace_server/internal/user/model"
+)
+
+// 重啟時從 db 重建訂單簿 (依原本的時間優先順序)
+func (t *TransactionEgine) LoadOrderBooks() error {
+
+	t.DataLock.Lock()
+	defer t.DataLock.Unlock()
+
+	// 取得商品清單
+	productList, err := t.Repos.ProductRepo.GetProductList()
+	if err != nil {
+		return fmt.Errorf("getProductList fail err:%v", err)
+	}
+
+	count := 0
+	for _, product := range productList {
+
+		// 取得等待搓合的訂單
+		transactionList, err := t.Repos.TransactionRepo.GetWaitTransactionListByProduct(product.ProductName)
+		if err != nil {
+			return fmt.Errorf("getWaitTransactionListByProduct fail productName:%v, err:%v", product.ProductName, err)
+		}
+
+		for _, transaction := range transactionList {
+			if t.restoreOrder(transaction, product.Currency) {
+				count++
+			}
+		}
+	}
+
+	logs.Debugf("從db重建訂單簿 訂單數量:%d, 商品數量:%d", count, len(t.OrderBooks))
+	return nil
+}
+
+// 重播日誌後 依 db 校正訂單簿
+// 重播時不寫入 db 假設當初的成交都成功, 當初成交失敗的訂單 訂單簿會與 db 不一致
+// db 已經結束的訂單 從訂單簿移除, 剩餘數量以 db 為準, db 等待搓合 但不在訂單簿的訂單 放回訂單簿
+func (t *TransactionEgine) reconcileOrderBooks() error {
+
+	t.DataLock.Lock()
+	defer t.DataLock.Unlock()
+
+	productList, err := t.Repos.ProductRepo.GetProductList()
+	if err != nil {
+		return fmt.Errorf("getProductList fail err:%v", err)
+	}
+
+	count := 0
+	for _, product := range productList {
+
+		transactionList, err := t.Repos.TransactionRepo.GetWaitTransactionListByProduct(product.ProductName)
+		if err != nil {
+			return fmt.Errorf("getWaitTransactionListByProduct fail productName:%v, err:%v", product.ProductName, err)
+		}
+		waits := make(map[string]*model_transaction.Transaction, len(transactionList))
+		for _, transaction := range transactionList {
+			waits[transaction.TransactionID] = transaction
+		}
+
+		book := t.getOrderBook(product.ProductName)
+		stopBook := t.getStopBook(product.ProductName)
+		orders := append(append(append([]*model.ProductTransactionParams{}, book.Bids...), book.Asks...), stopBook.Orders...)
+		for _, order := range orders {
+			transaction, ok := waits[order.TransactionID]
+			if !ok {
+				logs.Warnf("移除 db 已經結束的訂單:%+v", order)
+				if _, ok = book.Remove(order.TransactionID); !ok {
+					stopBook.Remove(order.TransactionID)
+				}
+				count++
+				continue
+			}
+			delete(waits, order.TransactionID)
+			if remainCount := NewOrderFromTransaction(transaction).RemainCount; order.RemainCount != remainCount {
+				logs.Warnf("校正訂單剩餘數量 transactionID:%v, remainCount:%d -> %d", order.TransactionID, order.RemainCount, remainCount)
+				order.RemainCount = remainCount
+				count++
+			}
+		}
+
+		// 依 db 的順序 放回不在訂單簿的訂單
+		for _, transaction := range transactionList {
+			if _, ok := waits[transaction.TransactionID]; ok && t.restoreOrder(transaction, product.Currency) {
+				logs.Warnf("放回不在訂單簿的訂單 transactionID:%v", transaction.TransactionID)
+				count++
+			}
+		}
+	}
+
+	logs.Debugf("依db校正訂單簿 校正數量:%d", count)
+	return nil
+}
+
+// db 等待搓合的交易單 放回訂單簿, 尚未觸發的停損單 放回觸發清單 (呼叫端需持有資料鎖)
+// 無法換算成報價幣種的訂單 拒絕, 停機期間已到期的訂單 結束, 都會退還 預扣金額 / 凍結數量
+func (t *TransactionEgine) restoreOrder(transaction *model_transaction.Transaction, currency string) bool {
+	order := NewOrderFromTransaction(transaction)
+	if err := t.normalizeOrder(order, currency); err != nil {
+		t.rejectOrder(order, fmt.Errorf("normalizeOrder fail transactionID:%v, err:%v", order.TransactionID, err))
+		return false
+	}
+	if t.rejectExpired(order) {
+		return false
+	}
+	if order.IsStop() {
+		t.getStopBook(transaction.ProductName).Add(order)
+	} else {
+		t.getOrderBook(transaction.ProductName).Add(order)
+	}
+	return true
+}
+
+// db 交易單 轉成 訂單簿的訂單
+func NewOrderFromTransaction(transaction *model_transaction.Transaction) *model.ProductTransactionParams {
+
+	remainCount := transaction.RemainCount
+	if remainCount <= 0 && transaction.FilledCount == 0 {
+		// 舊資料沒有剩餘數量
+		remainCount = transaction.ProductCount
+	}
+	queueTime := transaction.QueueTime
+	if queueTime <= 0 {
+		// 舊資料沒有排隊時間
+		queueTime = transaction.CreatedAt.UnixNano()
+	}
+
+	return &model.ProductTransactionParams{
+		TransferMode:  transaction.TransferMode,
+		TransferType:  transaction.TransferType,
+		TransactionID: transaction.TransactionID,
+		ProductName:   transaction.ProductName,
+		UserID:        transaction.FromUserID,
+		Currency:      transaction.Currency,
+		Amount:        transaction.Price,
+		TriggerPrice:  transaction.TriggerPrice,
+		TimeInForce:   transaction.TimeInForce,
+		ExpireTime:    transaction.ExpireTime,
+		OperateCount:  transaction.ProductCount,
+		RemainCount:   remainCount,
+		TimeStamp:     queueTime,
+	}
+}
@@ -0,0 +1,77 @@
+package src
+
+import (
+	model_bill "marketplace_server/internal/bill/model"
+	model_product "marketplace_server/internal/product/model"
+	"marketplace_server/internal/user/model"
+	"testing"
+	"time"
+
+	"github.com/shopspring/decimal"
+)
+
+// 清空訂單簿後 從 db 重建 (模擬重啟)
+func (e *testEngine) restart() {
+	e.OrderBooks = make(map[string]*OrderBook)
+	e.StopBooks = make(map[string]*StopBook)
+	if err := e.LoadOrderBooks(); err != nil {
+		e.t.Fatalf("err:%v", err)
+	}
+}
+
+func Test_LoadOrderBooks_ExpiredGTD(t *testing.T) {
+	e := newTestEngine(t, nil)
+	if err := e.products.Save(&model_product.Product{ProductName: "BTC", Currency: "TWD"}); err != nil {
+		t.Fatalf("err:%v", err)
+	}
+	e.addUser(1, "10000", 0)
+
+	order := newTestOrder("1-1-1", model.Purchase, 1, "90", 2, 1)
+	order.TimeInForce = int(model.GTD)
+	order.ExpireTime = e.clock.now.Add(10 * time.Minute).Unix()
+	if err := e.submit(order); err != nil {
+		t.Fatalf("err:%v", err)
+	}
+
+	// 停機期間到期, 重啟時結束 並退還預扣
+	e.clock.now = e.clock.now.Add(time.Hour)
+	e.restart()
+	if len(e.book().Bids) != 0 {
+		t.Fatalf("bids:%+v", e.book().Bids)
+	}
+	if transaction := e.transaction("1-1-1"); transaction.Status != int8(model_bill.Transaction_Status_Expired) {
+		t.Fatalf("transaction:%+v", transaction)
+	}
+	if !e.authAmount(1).Equal(decimal.NewFromInt(10000)) {
+		t.Fatalf("auth:%v", e.authAmount(1))
+	}
+}
+
+func Test_LoadOrderBooks_NormalizeFail(t *testing.T) {
+	e := newTestEngine(t, nil)
+	if err := e.products.Save(&model_product.Product{ProductName: "BTC", Currency: "TWD"}); err != nil {
+		t.Fatalf("err:%v", err)
+	}
+	e.addUser(1, "0", 10)
+
+	if err := e.submit(newTestOrder("1-1-1", model.Sell, 1, "100", 2, 1)); err != nil {
+		t.Fatalf("err:%v", err)
+	}
+
+	// 無法換算成報價幣種的訂單 重啟時拒絕 並解除凍結的商品
+	transaction := e.transaction("1-1-1")
+	transaction.Currency = "XXX"
+	if err := e.transactions.Save(transaction); err != nil {
+		t.Fatalf("err:%v", err)
+	}
+	e.restart()
+	if len(e.book().Asks) != 0 {
+		t.Fatalf("asks:%+v", e.book().Asks)
+	}
+	if transaction = e.transaction("1-1-1"); transaction.Status != int8(model_bill.Transaction_Status_Error) {
+		t.Fatalf("transaction:%+v", transaction)
+	}
+	if backpack := e.backpack(1); backpack.ProductCount != 10 || backpack.HoldCount != 0 {
+		t.Fatalf("backpack:%+v", backpack)
+	}
+}
@@ -0,0 +1,104 @@
+package src
+
+import (
+	"marketplace_server/internal/user/model"
+	"testing"
+
+	"github.com/shopspring/decimal"
+)
+
+// 清單內的交易單號 (依排列順序)
+func orderIDs(list []*model.ProductTransactionParams) []string {
+	ids := make([]string, 0, len(list))
+	for _, order := range list {
+		ids = append(ids, order.TransactionID)
+	}
+	return ids
+}
+
+func equalIDs(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func Test_OrderBook_PriceTimePriority(t *testing.T) {
+	book := NewOrderBook("BTC")
+
+	// 買單 價格高 -> 低, 同價格 時間先 -> 後, 市價單排最前面
+	book.Add(newTestOrder("b1", model.Purchase, 1, "10", 1, 3))
+	book.Add(newTestOrder("b2", model.Purchase, 1, "11", 1, 4))
+	book.Add(newTestOrder("b3", model.Purchase, 1, "10", 1, 1))
+	market := newTestOrder("b4", model.Purchase, 1, "0", 1, 5)
+	market.TransferType = int(model.MarketPrice)
+	book.Add(market)
+
+	// 賣單 價格低 -> 高, 同價格 時間先 -> 後
+	book.Add(newTestOrder("s1", model.Sell, 2, "13", 1, 1))
+	book.Add(newTestOrder("s2", model.Sell, 2, "12", 1, 3))
+	book.Add(newTestOrder("s3", model.Sell, 2, "12", 1, 2))
+
+	if ids := orderIDs(book.Bids); !equalIDs(ids, []string{"b4", "b2", "b3", "b1"}) {
+		t.Fatalf("bids:%v", ids)
+	}
+	if ids := orderIDs(book.Asks); !equalIDs(ids, []string{"s3", "s2", "s1"}) {
+		t.Fatalf("asks:%v", ids)
+	}
+	if book.BestBid().TransactionID != "b4" || book.BestAsk().TransactionID != "s3" || book.Len() != 7 {
+		t.Fatalf("bestBid:%v, bestAsk:%v, len:%d", book.BestBid(), book.BestAsk(), book.Len())
+	}
+
+	// 移除後 其他訂單的順序不變
+	if _, ok := book.Remove("b2"); !ok {
+		t.Fatalf("remove b2 fail")
+	}
+	if _, ok := book.Remove("b2"); ok {
+		t.Fatalf("remove b2 twice")
+	}
+	if ids := orderIDs(book.Bids); !equalIDs(ids, []string{"b4", "b3", "b1"}) {
+		t.Fatalf("bids:%v", ids)
+	}
+}
+
+func Test_Match_PriceTimePriority(t *testing.T) {
+	e := newTestEngine(t, nil)
+	e.addUser(1, "10000", 0)
+	e.addUser(2, "0", 5)
+	e.addUser(3, "0", 5)
+	e.addUser(4, "0", 5)
+
+	// 同價格 先進來的先成交, 價格較好的 優先於時間
+	for _, order := range []*model.ProductTransactionParams{
+		newTestOrder("2-1-1", model.Sell, 2, "101", 1, 1),
+		newTestOrder("3-1-1", model.Sell, 3, "101", 1, 2),
+		newTestOrder("4-1-1", model.Sell, 4, "100", 1, 3),
+	} {
+		if err := e.submit(order); err != nil {
+			t.Fatalf("err:%v", err)
+		}
+	}
+	if err := e.submit(newTestOrder("1-1-1", model.Purchase, 1, "101", 2, 4)); err != nil {
+		t.Fatalf("err:%v", err)
+	}
+
+	// 使用賣方 (掛單方) 的價格成交
+	trades := e.trades.GetTradeList()
+	if len(trades) != 2 {
+		t.Fatalf("trades:%d", len(trades))
+	}
+	if trades[0].SellTransactionID != "4-1-1" || !trades[0].Price.Equal(decimal.NewFromInt(100)) {
+		t.Fatalf("trade:%+v", trades[0])
+	}
+	if trades[1].SellTransactionID != "2-1-1" || !trades[1].Price.Equal(decimal.NewFromInt(101)) {
+		t.Fatalf("trade:%+v", trades[1])
+	}
+	if ids := orderIDs(e.book().Asks); !equalIDs(ids, []string{"3-1-1"}) || len(e.book().Bids) != 0 {
+		t.Fatalf("asks:%v, bids:%+v", ids, e.book().Bids)
+	}
+}
@@ -0,0 +1,117 @@
+package src
+
+import (
+	model_bill "marketplace_server/internal/bill/model"
+	"marketplace_server/internal/user/model"
+	"testing"
+
+	"github.com/shopspring/decimal"
+)
+
+func Test_Cancel_SellOrder(t *testing.T) {
+	e := newTestEngine(t, nil)
+	e.addUser(1, "10000", 0)
+	e.addUser(2, "0", 5)
+
+	// 買單 賣單 各一筆, 取消賣單 只刪除賣單
+	if err := e.submit(newTestOrder("1-1-1", model.Purchase, 1, "90", 1, 1)); err != nil {
+		t.Fatalf("err:%v", err)
+	}
+	if err := e.submit(newTestOrder("2-1-1", model.Sell, 2, "110", 3, 2)); err != nil {
+		t.Fatalf("err:%v", err)
+	}
+
+	// 不是自己的訂單 拒絕取消
+	if err := e.notify(model.Notify_Cmd_Cancel, 1, &model.ProductCancelParams{TransactionID: "2-1-1", UserID: 1}); err == nil {
+		t.Fatalf("cancel other user's order should fail")
+	}
+	if e.book().Len() != 2 {
+		t.Fatalf("book:%d", e.book().Len())
+	}
+
+	if err := e.notify(model.Notify_Cmd_Cancel, 2, &model.ProductCancelParams{TransactionID: "2-1-1", UserID: 2}); err != nil {
+		t.Fatalf("err:%v", err)
+	}
+	if ids := orderIDs(e.book().Bids); !equalIDs(ids, []string{"1-1-1"}) || len(e.book().Asks) != 0 {
+		t.Fatalf("bids:%v, asks:%+v", ids, e.book().Asks)
+	}
+
+	// 歸還凍結的商品
+	if transaction := e.transaction("2-1-1"); transaction.Status != int8(model_bill.Transaction_Status_Cancel) {
+		t.Fatalf("transaction:%+v", transaction)
+	}
+	if e.backpack(2).ProductCount != 5 {
+		t.Fatalf("backpack:%+v", e.backpack(2))
+	}
+}
+
+func Test_Cancel_AlreadyFilled(t *testing.T) {
+	e := newTestEngine(t, nil)
+	e.addUser(1, "10000", 0)
+	e.addUser(2, "0", 1)
+
+	if err := e.submit(newTestOrder("2-1-1", model.Sell, 2, "100", 1, 1)); err != nil {
+		t.Fatalf("err:%v", err)
+	}
+	if err := e.submit(newTestOrder("1-1-1", model.Purchase, 1, "100", 1, 2)); err != nil {
+		t.Fatalf("err:%v", err)
+	}
+
+	// 已全部成交 拒絕取消, 成交結果不變
+	if err := e.notify(model.Notify_Cmd_Cancel, 1, &model.ProductCancelParams{TransactionID: "1-1-1", UserID: 1}); err == nil {
+		t.Fatalf("cancel filled order should fail")
+	}
+	if transaction := e.transaction("1-1-1"); transaction.Status != int8(model_bill.Transaction_Status_Finish) {
+		t.Fatalf("transaction:%+v", transaction)
+	}
+	if !e.userAmount(1).Equal(decimal.NewFromInt(9900)) || e.backpack(1).ProductCount != 1 {
+		t.Fatalf("amount:%v, backpack:%+v", e.userAmount(1), e.backpack(1))
+	}
+}
+
+func Test_CancelAll(t *testing.T) {
+	e := newTestEngine(t, nil)
+	e.addUser(1, "10000", 5)
+	e.addUser(2, "10000", 5)
+
+	// 用戶 1: 買單 賣單 停損單, 用戶 2: 買單
+	for _, order := range []*model.ProductTransactionParams{
+		newTestOrder("1-1-1", model.Purchase, 1, "90", 1, 1),
+		newTestOrder("1-1-2", model.Sell, 1, "110", 2, 2),
+		newTestStopOrder("1-1-3", model.Sell, model.StopMarket, 1, "80", "0", 1, 3),
+		newTestOrder("2-1-1", model.Purchase, 2, "95", 1, 4),
+	} {
+		if err := e.submit(order); err != nil {
+			t.Fatalf("err:%v", err)
+		}
+	}
+
+	// 取消用戶 1 的全部訂單 (包含停損單), 退還預扣 歸還凍結的商品
+	if err := e.notify(model.Notify_Cmd_CancelAll, 1, &model.ProductCancelAllParams{UserID: 1, Operator: 1}); err != nil {
+		t.Fatalf("err:%v", err)
+	}
+	if ids := orderIDs(e.book().Bids); !equalIDs(ids, []string{"2-1-1"}) || len(e.book().Asks) != 0 || e.getStopBook("BTC").Len() != 0 {
+		t.Fatalf("bids:%v, asks:%+v, stops:%d", ids, e.book().Asks, e.getStopBook("BTC").Len())
+	}
+	for _, transactionID := range []string{"1-1-1", "1-1-2", "1-1-3"} {
+		if transaction := e.transaction(transactionID); transaction.Status != int8(model_bill.Transaction_Status_Cancel) {
+			t.Fatalf("transaction:%+v", transaction)
+		}
+	}
+	if !e.authAmount(1).Equal(decimal.NewFromInt(10000)) || e.backpack(1).ProductCount != 5 {
+		t.Fatalf("auth:%v, backpack:%+v", e.authAmount(1), e.backpack(1))
+	}
+
+	// 取消商品的全部訂單 (管理員)
+	if err := e.notify(model.Notify_Cmd_CancelAll, 99, &model.ProductCancelAllParams{ProductName: "BTC", Operator: 99}); err != nil {
+		t.Fatalf("err:%v", err)
+	}
+	if e.book().Len() != 0 || e.transaction("2-1-1").Status != int8(model_bill.Transaction_Status_Cancel) {
+		t.Fatalf("book:%d, transaction:%+v", e.book().Len(), e.transaction("2-1-1"))
+	}
+
+	// 不能同時取消 所有用戶 所有商品
+	if err := e.notify(model.Notify_Cmd_CancelAll, 99, &model.ProductCancelAllParams{Operator: 99}); err == nil {
+		t.Fatalf("cancel all users and products should fail")
+	}
+}
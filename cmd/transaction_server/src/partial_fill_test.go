@@ -0,0 +1,103 @@
+package src
+
+import (
+	model_bill "marketplace_server/internal/bill/model"
+	"marketplace_server/internal/user/model"
+	"testing"
+
+	"github.com/shopspring/decimal"
+)
+
+func Test_PartialFill(t *testing.T) {
+	e := newTestEngine(t, nil)
+	e.addUser(1, "10000", 0)
+	e.addUser(2, "0", 5)
+	e.addUser(3, "0", 5)
+
+	// 買 5 @ 100, 預扣 500
+	if err := e.submit(newTestOrder("1-1-1", model.Purchase, 1, "100", 5, 1)); err != nil {
+		t.Fatalf("err:%v", err)
+	}
+	if !e.authAmount(1).Equal(decimal.NewFromInt(9500)) {
+		t.Fatalf("auth:%v", e.authAmount(1))
+	}
+
+	// 賣 2 + 賣 1 成交, 買單剩餘 2 留在訂單簿
+	if err := e.submit(newTestOrder("2-1-1", model.Sell, 2, "100", 2, 2)); err != nil {
+		t.Fatalf("err:%v", err)
+	}
+	if err := e.submit(newTestOrder("3-1-1", model.Sell, 3, "100", 1, 3)); err != nil {
+		t.Fatalf("err:%v", err)
+	}
+	transaction := e.transaction("1-1-1")
+	if transaction.Status != int8(model_bill.Transaction_Status_PartialFilled) || transaction.FilledCount != 3 ||
+		transaction.RemainCount != 2 || !transaction.AvgPrice.Equal(decimal.NewFromInt(100)) {
+		t.Fatalf("transaction:%+v", transaction)
+	}
+	if e.transaction("2-1-1").Status != int8(model_bill.Transaction_Status_Finish) ||
+		e.transaction("3-1-1").Status != int8(model_bill.Transaction_Status_Finish) {
+		t.Fatalf("sell transaction not finished")
+	}
+	if len(e.book().Bids) != 1 || e.book().Bids[0].RemainCount != 2 || len(e.book().Asks) != 0 {
+		t.Fatalf("bids:%+v, asks:%+v", e.book().Bids, e.book().Asks)
+	}
+
+	// db 只異動成交的部分
+	if !e.userAmount(1).Equal(decimal.NewFromInt(9700)) || e.backpack(1).ProductCount != 3 {
+		t.Fatalf("amount:%v, backpack:%+v", e.userAmount(1), e.backpack(1))
+	}
+	if !e.userAmount(2).Equal(decimal.NewFromInt(200)) || e.backpack(2).ProductCount != 3 {
+		t.Fatalf("amount:%v, backpack:%+v", e.userAmount(2), e.backpack(2))
+	}
+
+	// 取消剩餘的 2, 只退還未成交部分的預扣 200
+	if err := e.notify(model.Notify_Cmd_Cancel, 1, &model.ProductCancelParams{TransactionID: "1-1-1", UserID: 1}); err != nil {
+		t.Fatalf("err:%v", err)
+	}
+	if !e.authAmount(1).Equal(decimal.NewFromInt(9700)) {
+		t.Fatalf("auth:%v", e.authAmount(1))
+	}
+	if transaction = e.transaction("1-1-1"); transaction.Status != int8(model_bill.Transaction_Status_Cancel) || transaction.FilledCount != 3 {
+		t.Fatalf("transaction:%+v", transaction)
+	}
+}
+
+func Test_Settle_StaleOrder(t *testing.T) {
+	e := newTestEngine(t, nil)
+	e.addUser(1, "10000", 0)
+	e.addUser(2, "0", 5)
+	e.addUser(3, "0", 5)
+
+	// 賣單 2-1-1 在 db 已經結束, 賣單 3-1-1 在 db 只剩 1
+	if err := e.submit(newTestOrder("2-1-1", model.Sell, 2, "100", 2, 1)); err != nil {
+		t.Fatalf("err:%v", err)
+	}
+	if err := e.submit(newTestOrder("3-1-1", model.Sell, 3, "100", 2, 2)); err != nil {
+		t.Fatalf("err:%v", err)
+	}
+	transaction := e.transaction("2-1-1")
+	transaction.Status = int8(model_bill.Transaction_Status_Cancel)
+	if err := e.transactions.Save(transaction); err != nil {
+		t.Fatalf("err:%v", err)
+	}
+	transaction = e.transaction("3-1-1")
+	transaction.RemainCount = 1
+	if err := e.transactions.Save(transaction); err != nil {
+		t.Fatalf("err:%v", err)
+	}
+
+	// 不與已結束的訂單成交, 依 db 校正後 只成交 1
+	if err := e.submit(newTestOrder("1-1-1", model.Purchase, 1, "100", 3, 3)); err != nil {
+		t.Fatalf("err:%v", err)
+	}
+	trades := e.trades.GetTradeList()
+	if len(trades) != 1 || trades[0].SellTransactionID != "3-1-1" || trades[0].Count != 1 {
+		t.Fatalf("trades:%+v", trades)
+	}
+	if e.transaction("2-1-1").Status != int8(model_bill.Transaction_Status_Cancel) || e.backpack(2).HoldCount != 2 {
+		t.Fatalf("transaction:%+v, backpack:%+v", e.transaction("2-1-1"), e.backpack(2))
+	}
+	if len(e.book().Asks) != 0 || len(e.book().Bids) != 1 || e.book().Bids[0].RemainCount != 2 {
+		t.Fatalf("bids:%+v, asks:%+v", e.book().Bids, e.book().Asks)
+	}
+}
@@ -0,0 +1,86 @@
+package src
+
+import (
+	"fmt"
+	model_transaction "marketplace_server/internal/bill/model"
+	"marketplace_server/internal/common/logs"
+	domain_user "marketplace_server/internal/user/domain_layer"
+	"marketplace_server/internal/user/model"
+
+	"github.com/shopspring/decimal"
+)
+
+// 報價幣種
+// 每個商品的訂單簿使用上架時的幣種報價, marketplace_server 受理訂單時 已將委託價格換算成報價幣種,
+// 訂單簿內的價格比較 與 成交價 都是報價幣種, 結算時才換算成買賣雙方各自的幣種
+
+// 用戶幣種的結算金額
+type settleAmount struct {
+	Currency string          // 用戶的幣種
+	Amount   decimal.Decimal // 成交金額
+	Fee      decimal.Decimal // 手續費
+}
+
+// 取得商品的報價幣種, 取不到 (舊商品) 回傳空字串
+func (t *TransactionEgine) quoteCurrency(productName string) string {
+	marketPriceDetail, err := t.getMarketPrice(productName)
+	if err != nil {
+		return ""
+	}
+	return marketPriceDetail.Currency
+}
+
+// 檢查訂單是否使用商品的報價幣種
+func (t *TransactionEgine) checkQuoteCurrency(order *model.ProductTransactionParams) error {
+	quote := t.quoteCurrency(order.ProductName)
+	if len(quote) > 0 && order.Currency != quote {
+		return fmt.Errorf("currency mismatch transactionID:%v, currency:%v, quote currency:%v",
+			order.TransactionID, order.Currency, quote)
+	}
+	return nil
+}
+
+// 將訂單的 委託價格 觸發價格 換算成報價幣種 (從 db 重建訂單簿時, 受理時尚未換算的舊訂單)
+func (t *TransactionEgine) normalizeOrder(order *model.ProductTransactionParams, quote string) (err error) {
+	if len(quote) == 0 || order.Currency == quote {
+		return nil
+	}
+	if order.Amount, err = domain_user.Exchange(t.Rate, order.Amount, order.Currency, quote); err != nil {
+		return err
+	}
+	if order.TriggerPrice, err = domain_user.Exchange(t.Rate, order.TriggerPrice, order.Currency, quote); err != nil {
+		return err
+	}
+	order.Currency = quote
+	return nil
+}
+
+// 拒絕訂單, 結束 db 的交易單 並退還 預扣金額 / 凍結數量
+func (t *TransactionEgine) rejectOrder(order *model.ProductTransactionParams, reason error) {
+	logs.Errorf("拒絕訂單:%+v, reason:%v", order, reason)
+	if err := t.closeOrder(order, model_transaction.Transaction_Status_Error); err != nil {
+		logs.Errorf("closeOrder fail transactionID:%v, err:%v", order.TransactionID, err)
+	}
+}
+
+// 報價幣種的 成交金額 與 手續費 換算成用戶的幣種
+func (t *TransactionEgine) exchangeSettle(fillAmount, fee decimal.Decimal, quote, currency string) (*settleAmount, error) {
+
+	amount, err := domain_user.Exchange(t.Rate, fillAmount, quote, currency)
+	if err != nil {
+		return nil, err
+	}
+	fee, err = domain_user.Exchange(t.Rate, fee, quote, currency)
+	if err != nil {
+		return nil, err
+	}
+	if len(currency) == 0 {
+		currency = quote
+	}
+
+	return &settleAmount{
+		Currency: currency,
+		Amount:   amount,
+		Fee:      fee,
+	}, nil
+}
@@ -0,0 +1,133 @@
+package src
+
+import (
+	"fmt"
+	model_transaction "marketplace_server/internal/bill/model"
+	"marketplace_server/internal/common/logs"
+	"marketplace_server/internal/user/model"
+
+	"github.com/shopspring/decimal"
+)
+
+const (
+	SelfTradeNone         = "none"          // 略過 不成交 (訂單留在訂單簿)
+	SelfTradeCancelNewest = "cancel_newest" // 取消新進的訂單
+	SelfTradeCancelOldest = "cancel_oldest" // 取消先進的訂單
+	SelfTradeCancelBoth   = "cancel_both"   // 兩筆都取消
+	SelfTradeDecrement    = "decrement"     // 雙方減少可成交的數量, 數量歸零的訂單取消
+)
+
+// 檢查自成交防範模式, 空的使用 none
+func NewSelfTradeMode(mode string) (string, error) {
+	switch mode {
+	case "":
+		return SelfTradeNone, nil
+	case SelfTradeNone, SelfTradeCancelNewest, SelfTradeCancelOldest, SelfTradeCancelBoth, SelfTradeDecrement:
+		return mode, nil
+	}
+	return "", fmt.Errorf("unknown self trade prevention mode:%v", mode)
+}
+
+// 取得商品的自成交防範模式, 沒有設定使用預設
+func (t *TransactionEgine) getSelfTradeMode(productName string) string {
+	if mode, ok := t.selfTrades[productName]; ok {
+		return mode
+	}
+	return t.selfTrade
+}
+
+// 依 價格優先 時間優先 找出第一組可成交的 買單 與 賣單 (不排除相同用戶)
+func findCross(book *OrderBook, marketPrice decimal.Decimal) (purchaseData, sellData *model.ProductTransactionParams) {
+	for _, bid := range book.Bids {
+		purchaseAmount := bid.GetPrice(marketPrice)
+		for _, ask := range book.Asks {
+			if purchaseAmount.LessThan(ask.GetPrice(marketPrice)) {
+				if model.TransferType(ask.TransferType) == model.MarketPrice {
+					continue
+				}
+				break
+			}
+			return bid, ask
+		}
+	}
+	return nil, nil
+}
+
+// 自成交防範: 最優先可成交的一組 買單 賣單 為同一用戶時 依模式處理, 回傳是否有處理 (訂單簿已異動)
+// db 寫入失敗的訂單留在訂單簿, 都失敗時回傳 false (搓合策略不會讓同一用戶成交)
+func (t *TransactionEgine) preventSelfTrade(productName string, book *OrderBook, marketPrice decimal.Decimal) bool {
+
+	mode := t.getSelfTradeMode(productName)
+	if mode == SelfTradeNone {
+		return false
+	}
+
+	purchaseData, sellData := findCross(book, marketPrice)
+	if purchaseData == nil || sellData == nil || purchaseData.UserID != sellData.UserID {
+		return false
+	}
+	logs.Debugf("自成交防範 productName:%v, mode:%v, 買:%v, 賣:%v",
+		productName, mode, purchaseData.TransactionID, sellData.TransactionID)
+
+	// 後進的訂單為新單
+	newest, oldest := purchaseData, sellData
+	if sellData.TimeStamp > purchaseData.TimeStamp {
+		newest, oldest = sellData, purchaseData
+	}
+
+	changed := false
+	switch mode {
+	case SelfTradeCancelNewest:
+		changed = t.cancelSelfTrade(book, newest, mode)
+	case SelfTradeCancelOldest:
+		changed = t.cancelSelfTrade(book, oldest, mode)
+	case SelfTradeCancelBoth:
+		changed = t.cancelSelfTrade(book, newest, mode)
+		changed = t.cancelSelfTrade(book, oldest, mode) || changed
+	case SelfTradeDecrement:
+		count := purchaseData.RemainCount
+		if sellData.RemainCount < count {
+			count = sellData.RemainCount
+		}
+		changed = t.decrementSelfTrade(book, purchaseData, count, mode)
+		changed = t.decrementSelfTrade(book, sellData, count, mode) || changed
+	}
+	return changed
+}
+
+// 自成交防範 取消訂單 (從訂單簿移除, 結束交易單並退款), 回傳是否有取消
+// 與 cancelOrder 相同 db 寫入失敗時 訂單留在訂單簿
+func (t *TransactionEgine) cancelSelfTrade(book *OrderBook, order *model.ProductTransactionParams, mode string) bool {
+
+	refundAmount, _, err := t.closeTransaction(order, model_transaction.Transaction_Status_Cancel)
+	if err != nil {
+		logs.Errorf("closeTransaction fail transactionID:%v, err:%v", order.TransactionID, err)
+		return false
+	}
+	book.Remove(order.TransactionID)
+	order.RemainCount = 0
+	t.reportSelfTrade(order, mode, refundAmount)
+	return true
+}
+
+// 自成交防範 減少訂單數量, 數量歸零的訂單取消, 回傳是否有處理 (db 寫入失敗時 訂單不變)
+func (t *TransactionEgine) decrementSelfTrade(book *OrderBook, order *model.ProductTransactionParams, count int64, mode string) bool {
+
+	if order.RemainCount <= count {
+		return t.cancelSelfTrade(book, order, mode)
+	}
+
+	// 只減少數量 保留原本的排隊位置, 與修改訂單相同 調整 db 與 預扣金額 / 凍結數量
+	refundAmount, err := t.amendOrder(order, &model.ProductAmendParams{
+		TransactionID: order.TransactionID,
+		UserID:        order.UserID,
+	}, -count, false)
+	if err != nil {
+		logs.Errorf("amendOrder fail transactionID:%v, count:%d, err:%v", order.TransactionID, count, err)
+		return false
+	}
+	order.OperateCount -= count
+	order.RemainCount -= count
+	t.reportSelfTrade(order, mode, refundAmount)
+	return true
+}
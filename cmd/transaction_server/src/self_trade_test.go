@@ -0,0 +1,180 @@
+package src
+
+import (
+	"marketplace_server/config"
+	model_bill "marketplace_server/internal/bill/model"
+	"marketplace_server/internal/common/logs"
+	"marketplace_server/internal/user/model"
+	"os"
+	"testing"
+
+	"github.com/shopspring/decimal"
+)
+
+func TestMain(m *testing.M) {
+	logs.Init(config.Log{Env: "prd"})
+	os.Exit(m.Run())
+}
+
+// 建立測試用的引擎 (重播模式 不寫入 db 與回報)
+func newSelfTradeEngine(mode string) *TransactionEgine {
+	return &TransactionEgine{
+		replaying:  true,
+		selfTrade:  mode,
+		selfTrades: make(map[string]string),
+	}
+}
+
+func Test_NewSelfTradeMode(t *testing.T) {
+	if mode, err := NewSelfTradeMode(""); err != nil || mode != SelfTradeNone {
+		t.Fatalf("mode:%v, err:%v", mode, err)
+	}
+	if _, err := NewSelfTradeMode("unknown"); err == nil {
+		t.Fatalf("unknown mode should fail")
+	}
+}
+
+func Test_PreventSelfTrade_None(t *testing.T) {
+	book := NewOrderBook("BTC")
+	book.Add(newTestOrder("s1", model.Sell, 1, "10", 5, 1))
+	book.Add(newTestOrder("b1", model.Purchase, 1, "10", 5, 2))
+
+	// 略過 不處理, 訂單留在訂單簿
+	if newSelfTradeEngine(SelfTradeNone).preventSelfTrade("BTC", book, decimal.NewFromInt(10)) {
+		t.Fatalf("none should not prevent")
+	}
+	if len(book.Bids) != 1 || len(book.Asks) != 1 {
+		t.Fatalf("bids:%d, asks:%d", len(book.Bids), len(book.Asks))
+	}
+}
+
+func Test_PreventSelfTrade_Cancel(t *testing.T) {
+	cases := []struct {
+		mode       string
+		bids, asks int
+	}{
+		{SelfTradeCancelNewest, 0, 1},
+		{SelfTradeCancelOldest, 1, 0},
+		{SelfTradeCancelBoth, 0, 0},
+	}
+	for _, c := range cases {
+		book := NewOrderBook("BTC")
+		book.Add(newTestOrder("s1", model.Sell, 1, "10", 5, 1))
+		book.Add(newTestOrder("b1", model.Purchase, 1, "10", 5, 2))
+
+		if !newSelfTradeEngine(c.mode).preventSelfTrade("BTC", book, decimal.NewFromInt(10)) {
+			t.Fatalf("mode:%v should prevent", c.mode)
+		}
+		if len(book.Bids) != c.bids || len(book.Asks) != c.asks {
+			t.Fatalf("mode:%v, bids:%d, asks:%d", c.mode, len(book.Bids), len(book.Asks))
+		}
+	}
+}
+
+func Test_PreventSelfTrade_Decrement(t *testing.T) {
+	book := NewOrderBook("BTC")
+	book.Add(newTestOrder("s1", model.Sell, 1, "10", 3, 1))
+	book.Add(newTestOrder("b1", model.Purchase, 1, "10", 5, 2))
+
+	// 雙方減少 3, 賣單歸零取消, 買單剩 2
+	if !newSelfTradeEngine(SelfTradeDecrement).preventSelfTrade("BTC", book, decimal.NewFromInt(10)) {
+		t.Fatalf("decrement should prevent")
+	}
+	if len(book.Asks) != 0 || len(book.Bids) != 1 || book.Bids[0].RemainCount != 2 {
+		t.Fatalf("bids:%+v, asks:%+v", book.Bids, book.Asks)
+	}
+}
+
+func Test_PreventSelfTrade_OtherUser(t *testing.T) {
+	book := NewOrderBook("BTC")
+	book.Add(newTestOrder("s1", model.Sell, 2, "10", 5, 1))
+	book.Add(newTestOrder("b1", model.Purchase, 1, "10", 5, 2))
+
+	// 不同用戶 正常成交
+	if newSelfTradeEngine(SelfTradeCancelBoth).preventSelfTrade("BTC", book, decimal.NewFromInt(10)) {
+		t.Fatalf("other user should not prevent")
+	}
+}
+
+// 設定預設自成交防範模式的測試引擎
+func newSelfTradeTestEngine(t *testing.T, mode string) *testEngine {
+	return newTestEngine(t, &config.Config{ConfigBase: &config.ConfigBase{
+		Engine: config.Engine{SelfTradePrevention: mode},
+	}})
+}
+
+// 賣單簿: 用戶 2 賣 1 @ 99, 用戶 1 賣 1 @ 100, 用戶 2 賣 2 @ 101; 用戶 1 送出 FOK 買 3 @ 101
+func submitSelfTradeFOK(e *testEngine) *model.ProductTransactionParams {
+	e.addUser(1, "10000", 1)
+	e.addUser(2, "0", 3)
+	for _, order := range []*model.ProductTransactionParams{
+		newTestOrder("2-1-1", model.Sell, 2, "99", 1, 1),
+		newTestOrder("1-1-1", model.Sell, 1, "100", 1, 2),
+		newTestOrder("2-1-2", model.Sell, 2, "101", 2, 3),
+	} {
+		if err := e.submit(order); err != nil {
+			e.t.Fatalf("err:%v", err)
+		}
+	}
+
+	fok := newTestOrder("1-1-2", model.Purchase, 1, "101", 3, 4)
+	fok.TimeInForce = int(model.FOK)
+	if err := e.submit(fok); err != nil {
+		e.t.Fatalf("err:%v", err)
+	}
+	return fok
+}
+
+func Test_FOK_SelfTradeCancelNewest(t *testing.T) {
+	e := newSelfTradeTestEngine(t, SelfTradeCancelNewest)
+	submitSelfTradeFOK(e)
+
+	// 成交到用戶 1 的賣單時 FOK 會被取消, 只有排在前面的 1 可成交 不足 3, 整筆取消 不能部分成交
+	if trades := e.trades.GetTradeList(); len(trades) != 0 {
+		t.Fatalf("trades:%+v", trades)
+	}
+	if transaction := e.transaction("1-1-2"); transaction.Status != int8(model_bill.Transaction_Status_Expired) || transaction.FilledCount != 0 {
+		t.Fatalf("transaction:%+v", transaction)
+	}
+	if len(e.book().Asks) != 3 || len(e.book().Bids) != 0 {
+		t.Fatalf("bids:%+v, asks:%+v", e.book().Bids, e.book().Asks)
+	}
+	if !e.authAmount(1).Equal(decimal.NewFromInt(10000)) {
+		t.Fatalf("auth:%v", e.authAmount(1))
+	}
+}
+
+func Test_FOK_SelfTradeCancelOldest(t *testing.T) {
+	e := newSelfTradeTestEngine(t, SelfTradeCancelOldest)
+	submitSelfTradeFOK(e)
+
+	// 用戶 1 的賣單被取消, FOK 與用戶 2 的賣單全部成交
+	if transaction := e.transaction("1-1-2"); transaction.Status != int8(model_bill.Transaction_Status_Finish) || transaction.FilledCount != 3 {
+		t.Fatalf("transaction:%+v", transaction)
+	}
+	if transaction := e.transaction("1-1-1"); transaction.Status != int8(model_bill.Transaction_Status_Cancel) {
+		t.Fatalf("transaction:%+v", transaction)
+	}
+	if len(e.book().Asks) != 0 || len(e.book().Bids) != 0 {
+		t.Fatalf("bids:%+v, asks:%+v", e.book().Bids, e.book().Asks)
+	}
+	if backpack := e.backpack(1); backpack.ProductCount != 4 {
+		t.Fatalf("backpack:%+v", backpack)
+	}
+}
+
+func Test_CancelSelfTrade_CloseFail(t *testing.T) {
+	e := newSelfTradeTestEngine(t, SelfTradeCancelOldest)
+	e.addUser(1, "10000", 0)
+
+	// 沒有 db 交易單的賣單, 取消時 closeTransaction 失敗
+	e.book().Add(newTestOrder("1-1-1", model.Sell, 1, "100", 1, 1))
+	if err := e.submit(newTestOrder("1-1-2", model.Purchase, 1, "100", 1, 2)); err != nil {
+		t.Fatalf("err:%v", err)
+	}
+
+	// 與 cancelOrder 相同 訂單留在訂單簿
+	if len(e.book().Asks) != 1 || e.book().Asks[0].RemainCount != 1 || len(e.book().Bids) != 1 {
+		t.Fatalf("bids:%+v, asks:%+v", e.book().Bids, e.book().Asks)
+	}
+}
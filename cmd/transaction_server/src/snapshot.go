@@ -0,0 +1,194 @@
+package src
+
+import (
+	"encoding/json"
+	"fmt"
+	"marketplace_server/internal/common/logs"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// 訂單簿快照
+type Snapshot struct {
+	Seq          int64                    `json:"seq"`           // 快照包含到的日誌序號
+	Time         int64                    `json:"time"`          // 快照時間 (UnixNano)
+	MarketPrice  map[string]string        `json:"market_price"`  // 市場最新價格
+	OrderBooks   map[string]*OrderBook    `json:"order_books"`   // 訂單簿
+	StopBooks    map[string]*StopBook     `json:"stop_books"`    // 停損單觸發清單
+	UserSeq      map[int64]int64          `json:"user_seq"`      // 用戶已處理的指令序號
+	Paused       map[string]bool          `json:"paused"`        // 暫停搓合的商品
+	Auctions     map[string]int64         `json:"auctions"`      // 集合競價中的商品
+	Halts        map[string]int64         `json:"halts"`         // 熔斷暫停交易的商品
+	PriceWindows map[string][]*PricePoint `json:"price_windows"` // 熔斷時間窗內的成交價
+}
+
+// 讀取快照, 檔案不存在回傳 nil
+func LoadSnapshot(path string) (*Snapshot, error) {
+
+	byteArray, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	snapshot := &Snapshot{}
+	if err = json.Unmarshal(byteArray, snapshot); err != nil {
+		return nil, err
+	}
+	return snapshot, nil
+}
+
+// 寫入快照 (先寫暫存檔再改名, 避免寫到一半中斷)
+func SaveSnapshot(path string, snapshot *Snapshot) error {
+
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+
+	byteArray, err := json.Marshal(snapshot)
+	if err != nil {
+		return err
+	}
+
+	tmpPath := path + ".tmp"
+	if err = os.WriteFile(tmpPath, byteArray, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmpPath, path)
+}
+
+// 產生目前訂單簿的快照 (呼叫端需持有資料鎖)
+func (t *TransactionEgine) takeSnapshot() error {
+
+	if t.journal == nil || len(t.cfg.Engine.SnapshotPath) == 0 {
+		return nil
+	}
+
+	snapshot := &Snapshot{
+		Seq:          t.journal.Seq(),
+		Time:         t.now().UnixNano(),
+		MarketPrice:  t.marketPriceMap,
+		OrderBooks:   t.OrderBooks,
+		StopBooks:    t.StopBooks,
+		UserSeq:      t.userSeq,
+		Paused:       t.paused,
+		Auctions:     t.auctions,
+		Halts:        t.halts,
+		PriceWindows: t.priceWindows,
+	}
+	if err := SaveSnapshot(t.cfg.Engine.SnapshotPath, snapshot); err != nil {
+		return err
+	}
+	logs.Debugf("寫入快照 seq:%d, 商品數量:%d", snapshot.Seq, len(snapshot.OrderBooks))
+
+	// 快照已包含全部的指令, 輪替日誌檔 (重啟只需重播之後的指令)
+	if err := t.journal.Rotate(); err != nil {
+		return fmt.Errorf("rotate journal fail seq:%d, err:%v", snapshot.Seq, err)
+	}
+	return nil
+}
+
+// 產生快照
+func (t *TransactionEgine) TakeSnapshot() error {
+	t.DataLock.Lock()
+	defer t.DataLock.Unlock()
+
+	return t.takeSnapshot()
+}
+
+// 定時產生快照
+func (t *TransactionEgine) RunSnapshot() {
+
+	// 沒有啟用指令日誌 就不需要快照
+	if t.journal == nil {
+		return
+	}
+
+	interval, err := time.ParseDuration(t.cfg.Engine.SnapshotInterval)
+	if err != nil || interval <= 0 {
+		logs.Warnf("snapshotInterval:%v 不啟用定時快照, err:%v", t.cfg.Engine.SnapshotInterval, err)
+		return
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for range ticker.C {
+		if err := t.TakeSnapshot(); err != nil {
+			logs.Errorf("takeSnapshot fail err:%v", err)
+		}
+	}
+}
+
+// 從快照與日誌還原訂單簿, 沒有快照也沒有日誌回傳 false
+func (t *TransactionEgine) recoverFromJournal(snapshot *Snapshot) (bool, error) {
+
+	t.DataLock.Lock()
+	defer t.DataLock.Unlock()
+
+	if snapshot == nil && t.journal.Seq() == 0 {
+		return false, nil
+	}
+
+	var fromSeq int64
+	if snapshot != nil {
+		fromSeq = snapshot.Seq
+		t.restoreSnapshot(snapshot)
+	}
+
+	// 重播快照之後的指令, 只還原訂單簿, 不再寫入 db 與 redis (當初套用時已寫入)
+	// 時鐘使用日誌的寫入時間, 熔斷結束時間等 與當初套用時相同
+	count := 0
+	clock := t.clock
+	t.replaying = true
+	defer func() {
+		t.replaying = false
+		t.clock = clock
+	}()
+	err := ReadJournal(t.journal.path, fromSeq, func(entry *JournalEntry) error {
+		t.clock = FixedClock(time.Unix(0, entry.Time))
+		t.acceptSeq(entry.Command)
+		if err := t.Dispatch(entry.Command); err != nil {
+			logs.Warnf("replay dispatch fail seq:%d, err:%v", entry.Seq, err)
+		}
+		count++
+		return nil
+	})
+	if err != nil {
+		return false, err
+	}
+
+	logs.Debugf("從快照與日誌還原訂單簿 快照seq:%d, 重播指令數量:%d, 商品數量:%d",
+		fromSeq, count, len(t.OrderBooks))
+	return true, nil
+}
+
+// 從快照還原 訂單簿 市場價格 與 交易狀態 (呼叫端需持有資料鎖)
+func (t *TransactionEgine) restoreSnapshot(snapshot *Snapshot) {
+	for productName, marketPriceJson := range snapshot.MarketPrice {
+		t.marketPriceMap[productName] = marketPriceJson
+	}
+	for productName, book := range snapshot.OrderBooks {
+		t.OrderBooks[productName] = book
+	}
+	for productName, stopBook := range snapshot.StopBooks {
+		t.StopBooks[productName] = stopBook
+	}
+	for userID, seq := range snapshot.UserSeq {
+		t.userSeq[userID] = seq
+	}
+	for productName, paused := range snapshot.Paused {
+		t.paused[productName] = paused
+	}
+	for productName, auctionEnd := range snapshot.Auctions {
+		t.auctions[productName] = auctionEnd
+	}
+	for productName, haltEnd := range snapshot.Halts {
+		t.halts[productName] = haltEnd
+	}
+	for productName, window := range snapshot.PriceWindows {
+		t.priceWindows[productName] = window
+	}
+}
@@ -0,0 +1,71 @@
+package src
+
+import (
+	"marketplace_server/internal/common/utils"
+	"marketplace_server/internal/user/model"
+
+	"github.com/shopspring/decimal"
+)
+
+// 停損單觸發清單 (每個商品一本)
+// 停損單不進訂單簿, 等商品最新成交價觸及觸發價格後, 才轉成市價單或限價單進入訂單簿
+type StopBook struct {
+	ProductName string                            // 商品名稱
+	Orders      []*model.ProductTransactionParams // 等待觸發的停損單 (時間先 -> 後)
+}
+
+// 建立停損單觸發清單
+func NewStopBook(productName string) *StopBook {
+	return &StopBook{
+		ProductName: productName,
+	}
+}
+
+// 加入停損單
+func (b *StopBook) Add(order *model.ProductTransactionParams) {
+	b.Orders = insertOrder(b.Orders, order, func(a, b *model.ProductTransactionParams) bool {
+		return a.TimeStamp < b.TimeStamp
+	})
+}
+
+// 搜尋停損單 回傳索引, 找不到回傳 -1
+func (b *StopBook) Find(transactionID string) int {
+	for i, order := range b.Orders {
+		if order.TransactionID == transactionID {
+			return i
+		}
+	}
+	return -1
+}
+
+// 移除停損單
+func (b *StopBook) Remove(transactionID string) (*model.ProductTransactionParams, bool) {
+	index := b.Find(transactionID)
+	if index < 0 {
+		return nil, false
+	}
+	order := b.Orders[index]
+	utils.SliceHelper(&b.Orders).Remove(index)
+	return order, true
+}
+
+// 依最新成交價 取出已觸發的停損單 (依時間先後)
+func (b *StopBook) Trigger(lastPrice decimal.Decimal) []*model.ProductTransactionParams {
+
+	var triggered []*model.ProductTransactionParams
+	remain := b.Orders[:0]
+	for _, order := range b.Orders {
+		if order.IsTriggered(lastPrice) {
+			triggered = append(triggered, order)
+		} else {
+			remain = append(remain, order)
+		}
+	}
+	b.Orders = remain
+	return triggered
+}
+
+// 停損單數量
+func (b *StopBook) Len() int {
+	return len(b.Orders)
+}
@@ -0,0 +1,84 @@
+package src
+
+import (
+	"marketplace_server/internal/user/model"
+	"testing"
+
+	"github.com/shopspring/decimal"
+)
+
+// 停損單 (觸發價格 trigger, 停損限價單的委託價格 price)
+func newTestStopOrder(transactionID string, mode model.TransferMode, transferType model.TransferType, userID int64,
+	trigger, price string, count, timeStamp int64) *model.ProductTransactionParams {
+	order := newTestOrder(transactionID, mode, userID, price, count, timeStamp)
+	order.TransferType = int(transferType)
+	order.TriggerPrice = decimal.RequireFromString(trigger)
+	return order
+}
+
+func Test_StopBook_Trigger(t *testing.T) {
+	stopBook := NewStopBook("BTC")
+	stopBook.Add(newTestStopOrder("b1", model.Purchase, model.StopMarket, 1, "110", "0", 1, 2))
+	stopBook.Add(newTestStopOrder("s1", model.Sell, model.StopLimit, 2, "90", "89", 1, 3))
+	stopBook.Add(newTestStopOrder("b2", model.Purchase, model.StopLimit, 3, "105", "106", 1, 1))
+
+	// 最新成交價 100 都不觸發
+	if triggered := stopBook.Trigger(decimal.NewFromInt(100)); len(triggered) != 0 || stopBook.Len() != 3 {
+		t.Fatalf("triggered:%v, len:%d", orderIDs(triggered), stopBook.Len())
+	}
+
+	// 買單 最新成交價 >= 觸發價格, 依時間先後
+	if triggered := stopBook.Trigger(decimal.NewFromInt(110)); !equalIDs(orderIDs(triggered), []string{"b2", "b1"}) {
+		t.Fatalf("triggered:%v", orderIDs(triggered))
+	}
+
+	// 賣單 最新成交價 <= 觸發價格
+	if triggered := stopBook.Trigger(decimal.NewFromInt(90)); !equalIDs(orderIDs(triggered), []string{"s1"}) || stopBook.Len() != 0 {
+		t.Fatalf("triggered:%v, len:%d", orderIDs(triggered), stopBook.Len())
+	}
+}
+
+func Test_StopOrder_TriggeredByTrade(t *testing.T) {
+	e := newTestEngine(t, nil)
+	e.addUser(1, "0", 0)
+	e.addUser(2, "0", 1)
+	e.addUser(3, "10000", 0)
+	e.addUser(4, "0", 1)
+	e.addUser(5, "10000", 0)
+	e.addUser(6, "10000", 0)
+
+	// 買單 1 @ 94 掛單, 停損限價賣單 觸發 95 委託 94, 停損市價買單 觸發 200
+	if err := e.submit(newTestOrder("3-1-1", model.Purchase, 3, "94", 1, 1)); err != nil {
+		t.Fatalf("err:%v", err)
+	}
+	if err := e.submit(newTestStopOrder("2-1-1", model.Sell, model.StopLimit, 2, "95", "94", 1, 2)); err != nil {
+		t.Fatalf("err:%v", err)
+	}
+	if err := e.submit(newTestStopOrder("6-1-1", model.Purchase, model.StopMarket, 6, "200", "0", 1, 3)); err != nil {
+		t.Fatalf("err:%v", err)
+	}
+	if e.getStopBook("BTC").Len() != 2 || len(e.book().Asks) != 0 {
+		t.Fatalf("stops:%d, asks:%+v", e.getStopBook("BTC").Len(), e.book().Asks)
+	}
+
+	// 成交價 95 觸發停損賣單, 轉成限價單 與買單 94 成交
+	if err := e.submit(newTestOrder("4-1-1", model.Sell, 4, "95", 1, 4)); err != nil {
+		t.Fatalf("err:%v", err)
+	}
+	if err := e.submit(newTestOrder("5-1-1", model.Purchase, 5, "95", 1, 5)); err != nil {
+		t.Fatalf("err:%v", err)
+	}
+	trades := e.trades.GetTradeList()
+	if len(trades) != 2 || trades[1].SellTransactionID != "2-1-1" || trades[1].BuyTransactionID != "3-1-1" ||
+		!trades[1].Price.Equal(decimal.NewFromInt(94)) {
+		t.Fatalf("trades:%+v", trades)
+	}
+
+	// 觸發後 db 的交易種類改為限價單, 沒觸發的停損單留在清單
+	if transaction := e.transaction("2-1-1"); transaction.TransferType != int(model.LimitPrice) {
+		t.Fatalf("transaction:%+v", transaction)
+	}
+	if stopBook := e.getStopBook("BTC"); stopBook.Len() != 1 || stopBook.Orders[0].TransactionID != "6-1-1" {
+		t.Fatalf("stops:%v", orderIDs(stopBook.Orders))
+	}
+}
@@ -0,0 +1,173 @@
+package src
+
+import (
+	model_bill "marketplace_server/internal/bill/model"
+	"marketplace_server/internal/user/model"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/shopspring/decimal"
+)
+
+func Test_IOC_RemainExpired(t *testing.T) {
+	e := newTestEngine(t, nil)
+	e.addUser(1, "10000", 0)
+	e.addUser(2, "0", 2)
+
+	// 賣 2 掛單, IOC 買 5 成交 2, 剩餘的 3 取消 退還預扣
+	if err := e.submit(newTestOrder("2-1-1", model.Sell, 2, "100", 2, 1)); err != nil {
+		t.Fatalf("err:%v", err)
+	}
+	order := newTestOrder("1-1-1", model.Purchase, 1, "100", 5, 2)
+	order.TimeInForce = int(model.IOC)
+	if err := e.submit(order); err != nil {
+		t.Fatalf("err:%v", err)
+	}
+
+	transaction := e.transaction("1-1-1")
+	if transaction.Status != int8(model_bill.Transaction_Status_Expired) || transaction.FilledCount != 2 {
+		t.Fatalf("transaction:%+v", transaction)
+	}
+	if len(e.book().Bids) != 0 || len(e.book().Asks) != 0 {
+		t.Fatalf("bids:%+v, asks:%+v", e.book().Bids, e.book().Asks)
+	}
+	if !e.authAmount(1).Equal(decimal.NewFromInt(9800)) || !e.userAmount(1).Equal(decimal.NewFromInt(9800)) {
+		t.Fatalf("auth:%v, amount:%v", e.authAmount(1), e.userAmount(1))
+	}
+}
+
+func Test_FOK_FillOrKill(t *testing.T) {
+	e := newTestEngine(t, nil)
+	e.addUser(1, "10000", 0)
+	e.addUser(2, "0", 3)
+
+	// 賣 3 掛單, FOK 買 5 無法全部成交 整筆取消 不成交
+	if err := e.submit(newTestOrder("2-1-1", model.Sell, 2, "100", 3, 1)); err != nil {
+		t.Fatalf("err:%v", err)
+	}
+	order := newTestOrder("1-1-1", model.Purchase, 1, "100", 5, 2)
+	order.TimeInForce = int(model.FOK)
+	if err := e.submit(order); err != nil {
+		t.Fatalf("err:%v", err)
+	}
+	if transaction := e.transaction("1-1-1"); transaction.Status != int8(model_bill.Transaction_Status_Expired) || transaction.FilledCount != 0 {
+		t.Fatalf("transaction:%+v", transaction)
+	}
+	if len(e.trades.GetTradeList()) != 0 || !e.authAmount(1).Equal(decimal.NewFromInt(10000)) {
+		t.Fatalf("trades:%d, auth:%v", len(e.trades.GetTradeList()), e.authAmount(1))
+	}
+	if ids := orderIDs(e.book().Asks); !equalIDs(ids, []string{"2-1-1"}) || len(e.book().Bids) != 0 {
+		t.Fatalf("asks:%v, bids:%+v", ids, e.book().Bids)
+	}
+
+	// FOK 買 3 可以全部成交
+	order = newTestOrder("1-1-2", model.Purchase, 1, "100", 3, 3)
+	order.TimeInForce = int(model.FOK)
+	if err := e.submit(order); err != nil {
+		t.Fatalf("err:%v", err)
+	}
+	if transaction := e.transaction("1-1-2"); transaction.Status != int8(model_bill.Transaction_Status_Finish) || transaction.FilledCount != 3 {
+		t.Fatalf("transaction:%+v", transaction)
+	}
+	if e.book().Len() != 0 || e.backpack(1).ProductCount != 3 {
+		t.Fatalf("book:%d, backpack:%+v", e.book().Len(), e.backpack(1))
+	}
+}
+
+func Test_GTD_Expired(t *testing.T) {
+	e := newTestEngine(t, nil)
+	e.addUser(1, "10000", 0)
+	e.addUser(2, "0", 1)
+
+	// GTD 買單 10 分鐘後到期, GTD 停損賣單 同時到期
+	expireTime := e.clock.now.Add(10 * time.Minute).Unix()
+	order := newTestOrder("1-1-1", model.Purchase, 1, "90", 2, 1)
+	order.TimeInForce = int(model.GTD)
+	order.ExpireTime = expireTime
+	if err := e.submit(order); err != nil {
+		t.Fatalf("err:%v", err)
+	}
+	stop := newTestStopOrder("2-1-1", model.Sell, model.StopMarket, 2, "80", "0", 1, 2)
+	stop.TimeInForce = int(model.GTD)
+	stop.ExpireTime = expireTime
+	if err := e.submit(stop); err != nil {
+		t.Fatalf("err:%v", err)
+	}
+
+	// 還沒到期
+	e.clock.now = e.clock.now.Add(9 * time.Minute)
+	e.expireOrders(e.now())
+	if len(e.book().Bids) != 1 || e.getStopBook("BTC").Len() != 1 {
+		t.Fatalf("bids:%+v, stops:%d", e.book().Bids, e.getStopBook("BTC").Len())
+	}
+
+	// 到期 取消並退還預扣
+	e.clock.now = e.clock.now.Add(time.Minute)
+	e.expireOrders(e.now())
+	if len(e.book().Bids) != 0 || e.getStopBook("BTC").Len() != 0 {
+		t.Fatalf("bids:%+v, stops:%d", e.book().Bids, e.getStopBook("BTC").Len())
+	}
+	for _, transactionID := range []string{"1-1-1", "2-1-1"} {
+		if transaction := e.transaction(transactionID); transaction.Status != int8(model_bill.Transaction_Status_Expired) {
+			t.Fatalf("transaction:%+v", transaction)
+		}
+	}
+	if !e.authAmount(1).Equal(decimal.NewFromInt(10000)) || e.backpack(2).ProductCount != 1 {
+		t.Fatalf("auth:%v, backpack:%+v", e.authAmount(1), e.backpack(2))
+	}
+}
+
+func Test_GTD_ExpireJournaled(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "engine.journal")
+	e := newTestEngine(t, nil)
+	journal, err := OpenJournal(path, 0)
+	if err != nil {
+		t.Fatalf("err:%v", err)
+	}
+	e.journal = journal
+	e.addUser(1, "10000", 0)
+
+	order := newTestOrder("1-1-1", model.Purchase, 1, "90", 2, 1)
+	order.TimeInForce = int(model.GTD)
+	order.ExpireTime = e.clock.now.Add(10 * time.Minute).Unix()
+	if err = e.submit(order); err != nil {
+		t.Fatalf("err:%v", err)
+	}
+
+	// 到期 寫入指令日誌
+	e.clock.now = e.clock.now.Add(10 * time.Minute)
+	e.expireOrders(e.now())
+	if len(e.book().Bids) != 0 || e.transaction("1-1-1").Status != int8(model_bill.Transaction_Status_Expired) {
+		t.Fatalf("bids:%+v, transaction:%+v", e.book().Bids, e.transaction("1-1-1"))
+	}
+	journal.Close()
+	e.journal = nil
+
+	// 重播日誌 到期的訂單不會再回到訂單簿
+	replay := newTestEngine(t, nil)
+	if replay.journal, err = OpenJournal(path, 0); err != nil {
+		t.Fatalf("err:%v", err)
+	}
+	defer replay.journal.Close()
+	if recovered, err := replay.recoverFromJournal(nil); err != nil || !recovered {
+		t.Fatalf("recovered:%v, err:%v", recovered, err)
+	}
+	if replay.book().Len() != 0 {
+		t.Fatalf("bids:%+v", replay.book().Bids)
+	}
+
+	// 已經到期的新訂單 不進入訂單簿 直接退還預扣
+	order = newTestOrder("1-1-2", model.Purchase, 1, "90", 1, 2)
+	order.TimeInForce = int(model.GTD)
+	order.ExpireTime = e.clock.now.Unix()
+	if err = e.submit(order); err != nil {
+		t.Fatalf("err:%v", err)
+	}
+	if e.book().Len() != 0 || e.transaction("1-1-2").Status != int8(model_bill.Transaction_Status_Expired) {
+		t.Fatalf("book:%d, transaction:%+v", e.book().Len(), e.transaction("1-1-2"))
+	}
+	if !e.authAmount(1).Equal(decimal.NewFromInt(10000)) {
+		t.Fatalf("auth:%v", e.authAmount(1))
+	}
+}
@@ -2,19 +2,20 @@ package src
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"marketplace_server/config"
 
-	model_backpack "marketplace_server/internal/backpack/model"
+	domain_bill "marketplace_server/internal/bill/domain_layer"
 	model_transaction "marketplace_server/internal/bill/model"
 	"marketplace_server/internal/common/logs"
 	"marketplace_server/internal/common/rabbitmqx"
-	"marketplace_server/internal/common/utils"
 	"marketplace_server/internal/product/Infrastructure_layer"
 	model_product "marketplace_server/internal/product/model"
 
 	Infrastructure_server "marketplace_server/internal/servers/Infrastructure_layer"
 
+	domain_user "marketplace_server/internal/user/domain_layer"
 	"marketplace_server/internal/user/model"
 	"runtime/debug"
 	"sync"
@@ -23,6 +24,10 @@ import (
 	"github.com/shopspring/decimal"
 )
 
+const (
+	CronInterval = time.Second * 30 // 定時任務間隔
+)
+
 const (
 	ExchangeType           = "direct"
 	TransactionExchange    = "transaction_exchange"        // 通知交换机
@@ -36,11 +41,32 @@ type TransactionEgine struct {
 
 	Repos *Infrastructure_server.RepositoriesManager // 持久層管理
 
-	PurchaseProductList []*model.ProductTransactionParams // 購買等候清單 會選slice 是因為 元素越小優先越高, 可重複快速搜尋
-	SellProductList     []*model.ProductTransactionParams // 販賣等候清單
-	marketPriceMap      map[string]string                 // 市場最新價格 key=商品名稱 value={"product_count":1000,"currency":"TWD","amount":"10"}
-	SysRate             decimal.Decimal                   // 系統抽成
-	Consumer            *rabbitmqx.Consumer               // mq
+	OrderBooks     map[string]*OrderBook     // 訂單簿 key=商品名稱
+	StopBooks      map[string]*StopBook      // 停損單觸發清單 key=商品名稱
+	marketPriceMap map[string]string         // 市場最新價格 key=商品名稱 value={"product_count":1000,"currency":"TWD","amount":"10"}
+	Fee            domain_bill.FeeService    // 手續費
+	Rate           domain_user.RateService   // 匯率 (成交時 報價幣種換算成用戶的幣種)
+	defaultPolicy  MatchingPolicy            // 預設搓合策略
+	policies       map[string]MatchingPolicy // 商品的搓合策略 key=商品名稱
+	selfTrade      string                    // 預設自成交防範模式
+	selfTrades     map[string]string         // 商品的自成交防範模式 key=商品名稱
+	breaker        *Breaker                  // 預設熔斷設定 (未啟用為 nil)
+	breakers       map[string]*Breaker       // 商品的熔斷設定 key=商品名稱
+	priceWindows   map[string][]*PricePoint  // 熔斷時間窗內的成交價 key=商品名稱
+	halts          map[string]int64          // 熔斷暫停交易的商品 key=商品名稱 value=結束時間 unix 秒
+	candles        map[string]CandleSet      // 目前的K線 key=商品名稱, 週期
+	depths         map[string]*DepthSnapshot // 已發佈的深度快照 key=商品名稱
+	Consumer       *rabbitmqx.Consumer       // mq
+	journal        *Journal                  // 指令日誌 (未啟用為 nil)
+	reporter       ExecutionReporter         // 成交回報 (未啟用 mq 為 nil)
+	pendingReports []*model.ExecutionReport  // 發送失敗 等待重送的成交回報 (依序)
+	replaying      bool                      // 是否正在重播日誌 (重播時不寫入 db 與 redis)
+	userSeq        map[int64]int64           // 用戶已處理的指令序號 key=用戶ID
+	heldCommands   map[int64][]*heldCommand  // 序號不連續 等待前面指令的指令 key=用戶ID
+	paused         map[string]bool           // 暫停搓合的商品 key=商品名稱
+	auctions       map[string]int64          // 集合競價中的商品 key=商品名稱 value=結束時間 unix 秒
+	stats          EngineStats               // 引擎統計
+	clock          Clock                     // 時鐘 (回放工具使用模擬時鐘)
 }
 
 // 建立交易引擎
@@ -51,14 +77,31 @@ func NewTransactionEgine(cfg *config.Config) *TransactionEgine {
 	repos.Automigrate()
 
 	// 綁定交易搓合物件
-	transactionEgine := &TransactionEgine{
-		cfg:            cfg,
-		Repos:          repos,                     // 持久層
-		marketPriceMap: make(map[string]string),   // 市場價格
-		SysRate:        decimal.NewFromFloat(1.0), // 系統抽成, 目前沒抽
+	transactionEgine, err := newTransactionEgine(cfg, repos, SystemClock{})
+	if err != nil {
+		logs.Fatalf("newTransactionEgine fail err:%v", err)
 	}
 
-	logs.Debugf("RFC3339 start time:%v", time.Now().Format(time.RFC3339))
+	// 成交回報 (使用 rabbit mq 發送給 marketplace_server)
+	if mq := rabbitmqx.GetMq(); mq != nil {
+		reporter, err := NewMqExecutionReporter(mq)
+		if err != nil {
+			logs.Errorf("newMqExecutionReporter fail err:%v", err)
+		} else {
+			transactionEgine.reporter = reporter
+		}
+	} else {
+		logs.Warnf("rabbitmq 未啟用 不發送成交回報")
+	}
+
+	// 重建訂單簿, 完成後才開始消費 rabbit mq
+	if err = transactionEgine.recover(); err != nil {
+		logs.Fatalf("recover order books fail err:%v", err)
+	}
+	transactionEgine.syncAuctions(transactionEgine.now())
+	transactionEgine.syncHalts(transactionEgine.now())
+	transactionEgine.expireOrders(transactionEgine.now())
+	transactionEgine.publishDepths()
 
 	// 監聽 rabbit mq
 	transactionEgine.consumeNotifyTransaction(cfg.RabbitMq.Host,
@@ -71,15 +114,123 @@ func NewTransactionEgine(cfg *config.Config) *TransactionEgine {
 	return transactionEgine
 }
 
+// 建立回放用的交易引擎 (回放工具使用), 不連線 mq 不寫入指令日誌 也不發送成交回報
+// 使用指定的持久層 與 時鐘, 有快照時 從快照還原訂單簿, 之後的指令由呼叫端送入 NotifyTransaction
+func NewReplayEgine(cfg *config.Config, repos *Infrastructure_server.RepositoriesManager, clock Clock, snapshot *Snapshot) (*TransactionEgine, error) {
+
+	transactionEgine, err := newTransactionEgine(cfg, repos, clock)
+	if err != nil {
+		return nil, err
+	}
+
+	if snapshot != nil {
+		transactionEgine.restoreSnapshot(snapshot)
+	}
+	transactionEgine.syncAuctions(clock.Now())
+	transactionEgine.syncHalts(clock.Now())
+	transactionEgine.expireOrders(clock.Now())
+	transactionEgine.publishDepths()
+	return transactionEgine, nil
+}
+
+// 綁定交易搓合物件, 載入 手續費 搓合策略 市場最新價格 目前的K線 與 已發佈的深度快照
+func newTransactionEgine(cfg *config.Config, repos *Infrastructure_server.RepositoriesManager, clock Clock) (*TransactionEgine, error) {
+
+	transactionEgine := &TransactionEgine{
+		cfg:            cfg,
+		Repos:          repos,                          // 持久層
+		OrderBooks:     make(map[string]*OrderBook),    // 訂單簿
+		StopBooks:      make(map[string]*StopBook),     // 停損單觸發清單
+		userSeq:        make(map[int64]int64),          // 用戶已處理的指令序號
+		heldCommands:   make(map[int64][]*heldCommand), // 等待前面指令的指令
+		paused:         make(map[string]bool),          // 暫停搓合的商品
+		auctions:       make(map[string]int64),         // 集合競價中的商品
+		priceWindows:   make(map[string][]*PricePoint), // 熔斷時間窗內的成交價
+		halts:          make(map[string]int64),         // 熔斷暫停交易的商品
+		candles:        make(map[string]CandleSet),     // 目前的K線
+		stats:          EngineStats{StartTime: clock.Now()},
+		clock:          clock,                           // 時鐘
+		Rate:           domain_user.NewRateService(),    // 匯率
+		depths:         make(map[string]*DepthSnapshot), // 已發佈的深度快照
+		marketPriceMap: make(map[string]string),         // 市場價格
+	}
+
+	logs.Debugf("RFC3339 start time:%v", clock.Now().Format(time.RFC3339))
+
+	// 手續費
+	fee, err := domain_bill.NewFeeService(cfg.Fee)
+	if err != nil {
+		return nil, fmt.Errorf("newFeeService fail err:%v", err)
+	}
+	transactionEgine.Fee = fee
+
+	// 搓合策略
+	if err = transactionEgine.loadPolicies(cfg.Engine); err != nil {
+		return nil, fmt.Errorf("loadPolicies fail err:%v", err)
+	}
+
+	// 載入市場最新價格
+	dataMap, err := repos.ProductRepo.RedisGetMarketPrice(Infrastructure_layer.Redis_MarketPrice)
+	if err != nil {
+		logs.Warnf("redisGetMarketPrice fail err:%v", err)
+	} else {
+		transactionEgine.marketPriceMap = dataMap
+	}
+
+	// 載入目前的K線 與 已發佈的深度快照
+	transactionEgine.loadCandles(clock.Now())
+	transactionEgine.loadDepths()
+	return transactionEgine, nil
+}
+
+// 重建訂單簿, 有啟用指令日誌時 從快照與日誌還原, 否則從 db 重建
+func (t *TransactionEgine) recover() (err error) {
+
+	if len(t.cfg.Engine.JournalPath) == 0 {
+		return t.LoadOrderBooks()
+	}
+
+	// 日誌檔輪替後 序號從快照接續
+	snapshot, err := LoadSnapshot(t.cfg.Engine.SnapshotPath)
+	if err != nil {
+		return err
+	}
+	var baseSeq int64
+	if snapshot != nil {
+		baseSeq = snapshot.Seq
+	}
+	t.journal, err = OpenJournal(t.cfg.Engine.JournalPath, baseSeq)
+	if err != nil {
+		return err
+	}
+
+	recovered, err := t.recoverFromJournal(snapshot)
+	if err != nil {
+		return err
+	}
+	if recovered {
+		return t.reconcileOrderBooks()
+	}
+
+	// 第一次啟用日誌, 從 db 重建後 寫入第一份快照當作起點
+	if err = t.LoadOrderBooks(); err != nil {
+		return err
+	}
+	return t.TakeSnapshot()
+}
+
 // 建立 交易通知 的 消費端
 func (t *TransactionEgine) consumeNotifyTransaction(_host, _port, _user, _password string, _connectionNum, _channelNum int, tag string) (consumer *rabbitmqx.Consumer) {
 
 	uri := "amqp://" + _user + ":" + _password + "@" + _host + ":" + _port + "/"
 
+	// 依序處理 避免同一個訂單的 買 與 取消 順序顛倒, 失敗超過次數送到死信佇列
 	consumer = rabbitmqx.NewConsumer(uri, ExchangeType,
 		TransactionExchange, BindKeyPurchaseProduct,
 		BindKeyPurchaseProduct, tag, false,
-		true, t.NotifyTransaction)
+		true, t.NotifyTransaction).
+		SetOrdered(true).
+		SetMaxRetry(t.cfg.Engine.MaxRetry, rabbitmqx.DefaultRetryInterval)
 	if err := consumer.Start(); err != nil {
 		logs.Errorf("RabbitInit error,err = " + err.Error())
 		return nil
@@ -88,6 +239,7 @@ func (t *TransactionEgine) consumeNotifyTransaction(_host, _port, _user, _passwo
 	return consumer
 }
 
+// 定時任務 (與搓合分開, 搓合在收到訂單時就立即執行)
 func (t *TransactionEgine) Run() {
 
 	defer func() {
@@ -97,11 +249,11 @@ func (t *TransactionEgine) Run() {
 		}
 	}()
 
-	logs.Debugf("啟動搓合監聽goroutine")
-	for {
-
-		t.Cron()                     // 搓合
-		time.Sleep(time.Second * 30) // 30秒搓合一次
+	logs.Debugf("啟動定時任務goroutine")
+	ticker := time.NewTicker(CronInterval)
+	defer ticker.Stop()
+	for range ticker.C {
+		t.Cron()
 	}
 }
 
@@ -112,204 +264,546 @@ func (t *TransactionEgine) Cron() {
 	t.DataLock.Lock()
 	defer t.DataLock.Unlock()
 
-	// 沒有資料就不用搓合
-	if len(t.PurchaseProductList) == 0 {
+	// 同步新上架商品的市場價格 (已存在的商品以引擎成交價為準)
+	dataMap, err := t.Repos.ProductRepo.RedisGetMarketPrice(Infrastructure_layer.Redis_MarketPrice)
+	if err != nil {
+		logs.Warnf("redisGetMarketPrice fail err:%v", err)
 		return
 	}
-	if len(t.SellProductList) == 0 {
-		return
+	for productName, marketPriceJson := range dataMap {
+		if _, ok := t.marketPriceMap[productName]; !ok {
+			t.marketPriceMap[productName] = marketPriceJson
+		}
 	}
 
-	// 撈取市場最新價格 (取得redis緩存)
-	dataMap, err := t.Repos.ProductRepo.RedisGetMarketPrice(Infrastructure_layer.Redis_MarketPrice)
+	// 新上架商品的集合競價 開始 / 結束
+	t.syncAuctions(t.now())
+
+	// 結束已到時間的熔斷
+	t.syncHalts(t.now())
+
+	// 取消已到期的訂單 (GTD)
+	t.expireOrders(t.now())
+
+	// 套用等待逾時的保留指令
+	t.releaseExpiredHeld(t.now())
+
+	// 重送發送失敗的成交回報
+	t.flushReports()
+
+	// 寫入週期已結束的K線
+	t.flushCandles(t.now())
+
+	// 發佈訂單簿變動後的深度
+	t.publishDepths()
+}
+
+// 商品是否停止連續搓合 (暫停 集合競價中 或 熔斷)
+func (t *TransactionEgine) isHalted(productName string) bool {
+	if t.paused[productName] {
+		return true
+	}
+	if _, ok := t.auctions[productName]; ok {
+		return true
+	}
+	_, ok := t.halts[productName]
+	return ok
+}
+
+// 取消已到期的訂單 (GTD), 包含還沒觸發的停損單 (寫入指令日誌 重啟後可重播)
+func (t *TransactionEgine) expireOrders(now time.Time) {
+
+	var expired []*model.ProductTransactionParams
+	for _, book := range t.OrderBooks {
+		for _, list := range [][]*model.ProductTransactionParams{book.Bids, book.Asks} {
+			for _, order := range list {
+				if order.IsExpired(now) {
+					expired = append(expired, order)
+				}
+			}
+		}
+	}
+	for _, stopBook := range t.StopBooks {
+		for _, order := range stopBook.Orders {
+			if order.IsExpired(now) {
+				expired = append(expired, order)
+			}
+		}
+	}
+
+	for _, order := range expired {
+		err := t.applyCommand(model.Notify_Cmd_Expire, &model.ProductExpireParams{TransactionID: order.TransactionID})
+		if err != nil {
+			logs.Errorf("expire fail transactionID:%v, err:%v", order.TransactionID, err)
+		}
+	}
+}
+
+// 訂單到期, 從訂單簿 (或停損單觸發清單) 移除 並退還預扣
+func (t *TransactionEgine) ExpireProduct(productTransactionNotify *model.ProductTransactionNotify) error {
+
+	// 解析封包
+	byteArray, err := json.Marshal(productTransactionNotify.Data)
 	if err != nil {
-		return
+		return rabbitmqx.Permanent(err)
+	}
+	var productExpireParams model.ProductExpireParams
+	err = json.Unmarshal(byteArray, &productExpireParams)
+	if err != nil {
+		return rabbitmqx.Permanent(err)
 	}
-	t.marketPriceMap = dataMap
-	logs.Debugf("marketPriceMap:%+v", t.marketPriceMap)
 
-	// 搜尋優先配對搓合的 購買清單
-	for i, purchaseData := range t.PurchaseProductList {
+	order := t.findOrder(productExpireParams.TransactionID)
+	if order == nil {
+		return rejected(fmt.Errorf("order not found productExpireParams:%+v", productExpireParams))
+	}
+	if _, ok := t.getOrderBook(order.ProductName).Remove(order.TransactionID); !ok {
+		t.getStopBook(order.ProductName).Remove(order.TransactionID)
+	}
+	logs.Debugf("訂單到期:%+v", order)
+	return t.closeOrder(order, model_transaction.Transaction_Status_Expired)
+}
 
-		// 取得要配對的商品的市場價格
-		productName := purchaseData.ProductName
-		marketPriceJson, ok := t.marketPriceMap[productName]
+// 新訂單已經到期 (排隊期間 或 重播時 到期), 不進入訂單簿 直接結束並退還預扣
+func (t *TransactionEgine) rejectExpired(order *model.ProductTransactionParams) bool {
+	if !order.IsExpired(t.now()) {
+		return false
+	}
+	logs.Warnf("訂單已到期:%+v", order)
+	if err := t.closeOrder(order, model_transaction.Transaction_Status_Expired); err != nil {
+		logs.Errorf("closeOrder fail transactionID:%v, err:%v", order.TransactionID, err)
+	}
+	return true
+}
+
+// 取得商品的市場價格 (記憶體快取, 沒有才去 redis 撈取)
+func (t *TransactionEgine) getMarketPrice(productName string) (*model_product.MarketPriceRedis, error) {
+
+	marketPriceJson, ok := t.marketPriceMap[productName]
+	if !ok {
+		// 可能是新上架的商品, 重新撈取市場最新價格 (取得redis緩存)
+		dataMap, err := t.Repos.ProductRepo.RedisGetMarketPrice(Infrastructure_layer.Redis_MarketPrice)
+		if err != nil {
+			return nil, err
+		}
+		t.marketPriceMap = dataMap
+		marketPriceJson, ok = t.marketPriceMap[productName]
 		if !ok {
-			logs.Warnf("快取不存在的產品 productName:%v, marketPriceMap:%+v",
-				productName, t.marketPriceMap)
+			return nil, fmt.Errorf("快取不存在的產品 productName:%v", productName)
+		}
+	}
+
+	// 取得市場價格物件
+	return model_product.NewMarketPriceRedis(marketPriceJson)
+}
+
+// 搓合單一商品的訂單簿, 成交後觸發的停損單進入訂單簿 再繼續搓合
+// 暫停搓合 或 集合競價中的商品 訂單只進入訂單簿
+func (t *TransactionEgine) matchBook(productName string, book *OrderBook) {
+	for !t.isHalted(productName) {
+		t.matchOrders(productName, book)
+		if !t.triggerStops(productName, book) {
+			break
+		}
+	}
+
+	// 搓合後 IOC FOK 訂單剩餘的數量 不留在訂單簿
+	t.cancelImmediateOrders(book)
+}
+
+// 訂單進入訂單簿, 全部成交或取消 (FOK) 的訂單 無法全部成交時直接取消
+// 自成交防範會取消或減少新單時 (取消舊單以外的模式), 只計算排在同一用戶訂單之前的數量
+func (t *TransactionEgine) addToBook(book *OrderBook, order *model.ProductTransactionParams) {
+
+	if model.TimeInForce(order.TimeInForce) == model.FOK {
+		mode := t.getSelfTradeMode(book.ProductName)
+		stopAtSelf := mode != SelfTradeNone && mode != SelfTradeCancelOldest
+		marketPriceDetail, err := t.getMarketPrice(book.ProductName)
+		if err != nil || book.FillableCount(order, marketPriceDetail.Amount, stopAtSelf) < order.RemainCount {
+			logs.Debugf("無法全部成交 取消訂單:%+v", order)
+			if err := t.closeOrder(order, model_transaction.Transaction_Status_Expired); err != nil {
+				logs.Errorf("closeOrder fail transactionID:%v, err:%v", order.TransactionID, err)
+			}
+			return
+		}
+	}
+
+	book.Add(order)
+}
+
+// 取消訂單簿內 IOC FOK 訂單剩餘的數量
+func (t *TransactionEgine) cancelImmediateOrders(book *OrderBook) {
+
+	var immediate []*model.ProductTransactionParams
+	for _, list := range [][]*model.ProductTransactionParams{book.Bids, book.Asks} {
+		for _, order := range list {
+			if order.IsImmediate() {
+				immediate = append(immediate, order)
+			}
+		}
+	}
+
+	for _, order := range immediate {
+		book.Remove(order.TransactionID)
+		logs.Debugf("取消未成交的部分:%+v", order)
+		if err := t.closeOrder(order, model_transaction.Transaction_Status_Expired); err != nil {
+			logs.Errorf("closeOrder fail transactionID:%v, err:%v", order.TransactionID, err)
+		}
+	}
+}
+
+// 依最新成交價 觸發停損單並放入訂單簿, 回傳是否有觸發
+func (t *TransactionEgine) triggerStops(productName string, book *OrderBook) bool {
+
+	stopBook, ok := t.StopBooks[productName]
+	if !ok || stopBook.Len() == 0 {
+		return false
+	}
+
+	marketPriceDetail, err := t.getMarketPrice(productName)
+	if err != nil {
+		logs.Warnf("getMarketPrice fail productName:%v, err:%v", productName, err)
+		return false
+	}
+
+	triggered := stopBook.Trigger(marketPriceDetail.Amount)
+	for _, order := range triggered {
+		order.Activate()
+		t.addToBook(book, order)
+		logs.Debugf("觸發停損單 最新成交價:%v, 觸發價格:%v, 詳細資料:%+v",
+			marketPriceDetail.Amount.String(), order.TriggerPrice.String(), order)
+
+		// 更新 db 的交易種類, 重啟時才會放進訂單簿
+		if t.replaying {
 			continue
 		}
-		// 取得市場價格物件
-		marketPriceDetail, err := model_product.NewMarketPriceRedis(marketPriceJson)
+		transaction, err := t.Repos.TransactionRepo.GetTransactionInfo(order.TransactionID)
 		if err != nil {
-			logs.Warnf("marketPriceJson:%v, err:%v", marketPriceJson, err)
+			logs.Errorf("getTransactionInfo fail transactionID:%v, err:%v", order.TransactionID, err)
 			continue
 		}
+		transaction.TransferType = order.TransferType
+		if err = t.Repos.TransactionRepo.Save(transaction); err != nil {
+			logs.Errorf("transactionRepo save fail transactionID:%v, err:%v", order.TransactionID, err)
+		}
+	}
 
-		// 取得買方的價格
-		purchaseAmount := purchaseData.GetPrice(marketPriceDetail.Amount)
+	return len(triggered) > 0
+}
 
-		logs.Debugf("i:%d, amount(買的價格):%s, marketPriceDetail(市場價格):%+v",
-			i, purchaseAmount.String(), marketPriceDetail)
+// 搓合訂單簿, 依商品的搓合策略分配成交
+func (t *TransactionEgine) matchOrders(productName string, book *OrderBook) {
 
-		// 搜尋優先配對搓合的 販賣清單
-		for j, sellData := range t.SellProductList {
+	policy := t.getPolicy(productName)
+match:
+	for len(book.Bids) > 0 && len(book.Asks) > 0 {
 
-			// 比對產品是否相同
-			if purchaseData.ProductName != sellData.ProductName {
-				continue
+		// 取得要配對的商品的市場價格
+		marketPriceDetail, err := t.getMarketPrice(productName)
+		if err != nil {
+			logs.Warnf("getMarketPrice fail productName:%v, err:%v", productName, err)
+			return
+		}
+
+		// 最優先可成交的 買單 賣單 為同一用戶時, 依自成交防範模式處理後 重新搓合
+		if t.preventSelfTrade(productName, book, marketPriceDetail.Amount) {
+			continue
+		}
+
+		// 依搓合策略 找出這一批成交
+		fills := policy.Match(book, marketPriceDetail.Amount)
+		if len(fills) == 0 {
+			return
+		}
+
+		for _, fill := range fills {
+			purchaseData, sellData := fill.Purchase, fill.Sell
+			logs.Debugf(" #### 配對成功 ProductName:%s, policy:%s, 買:%v >= 賣:%v, 成交數量:%d",
+				productName, policy.Name(), purchaseData.GetPrice(marketPriceDetail.Amount).String(), fill.Price.String(), fill.Count)
+
+			// 成交價變動過大 觸發熔斷, 這一批剩下的成交都不執行
+			if t.tripBreaker(productName, fill, marketPriceDetail) {
+				return
 			}
-			// 比對 相同用戶 不給予搓則
-			if purchaseData.UserID == sellData.UserID {
-				continue
+
+			// 寫進db (使用 transaction(事務) 失敗就Rollback), 重播日誌時 當初已寫入 只還原訂單簿
+			var trade *model_transaction.Trade
+			if !t.replaying {
+				err = t.Repos.Transaction(func(uow *Infrastructure_server.UnitOfWork) (err error) {
+					trade, err = t.settle(uow, purchaseData, sellData, fill.Price, fill.Count)
+					return
+				})
+				if err != nil {
+					logs.Errorf("settle fail purchase:%v, sell:%v, err:%v",
+						purchaseData.TransactionID, sellData.TransactionID, err)
+
+					// 訂單簿與 db 不一致, 依 db 校正後 重新搓合
+					var stale *staleOrderError
+					if errors.As(err, &stale) {
+						t.syncStaleOrder(book, stale)
+						continue match
+					}
+					return
+				}
+			}
+			purchaseData.RemainCount -= fill.Count
+			sellData.RemainCount -= fill.Count
+			t.stats.Fills++
+			t.recordPrice(productName, fill)
+			t.addCandle(trade)
+			t.publishTrade(trade)
+
+			// db 已 Commit, 才更新市場最新價格 例如 t.marketPriceMap["BTC"] = 賣方價格 元成交
+			marketPriceDetail.Amount = fill.Price
+			t.setMarketPrice(productName, marketPriceDetail)
+
+			// 回報成交 給 marketplace_server
+			t.reportFill(purchaseData, trade)
+			t.reportFill(sellData, trade)
+
+			// 刪除 已全部成交的搓合單
+			if purchaseData.RemainCount <= 0 {
+				logs.Debugf("刪除配對搓合單 買:%+v", purchaseData)
+				book.Remove(purchaseData.TransactionID)
 			}
+			if sellData.RemainCount <= 0 {
+				logs.Debugf("刪除配對搓合單 賣:%+v", sellData)
+				book.Remove(sellData.TransactionID)
+			}
+		}
+	}
+}
 
-			// 是否配對flag
-			isMatch := false
+// 更新市場最新價格 (記憶體快取 與 redis)
+func (t *TransactionEgine) setMarketPrice(productName string, marketPriceDetail *model_product.MarketPriceRedis) {
 
-			// 取得賣方想要的價格
-			sellAmount := sellData.GetPrice(marketPriceDetail.Amount)
+	marketPriceRedisStr, err := marketPriceDetail.ToJson()
+	if err != nil {
+		logs.Errorf("to json fail data:%+v, err:%v", marketPriceDetail, err)
+		return
+	}
+	t.marketPriceMap[productName] = marketPriceRedisStr
 
-			logs.Debugf("j:%d, amount(賣的價格):%s, marketPriceDetail(市場價格):%+v",
-				j, sellAmount.String(), marketPriceDetail)
+	// 重播日誌時 不寫入 redis
+	if t.replaying {
+		return
+	}
+	err = t.Repos.ProductRepo.RedisSetMarketPrice(Infrastructure_layer.Redis_MarketPrice,
+		map[string]string{productName: marketPriceRedisStr})
+	if err != nil {
+		logs.Errorf("redisSetMarketPrice fail productName:%v, err:%v", productName, err)
+	}
+	t.publishTicker(productName, marketPriceDetail)
+}
 
-			// 如果 買方價格 >= 賣方
-			isMatch = purchaseAmount.GreaterThanOrEqual(sellAmount)
-			logs.Debugf("配對開始 isMatch:%v ProductName:%s, 買:%v >= 賣:%v",
-				isMatch, purchaseData.ProductName, purchaseAmount.String(), sellAmount.String())
+// 結算成交的 買單 與 賣單 (使用賣方的價格當作成交價, 成交數量 fillCount)
+// 買賣雙方依 掛單方(maker) / 吃單方(taker) 各自支付手續費, 手續費存入平台收入帳戶
+// 全部的寫入都透過同一個交易單元, 由呼叫端決定 Commit 或 Rollback, 回傳成交紀錄
+// db 的交易單 與訂單簿不一致 (已經結束 或 剩餘數量不足), 不能成交
+type staleOrderError struct {
+	TransactionID string
+	Status        int8  // db 的交易狀態
+	Open          bool  // db 的交易單 是否還在等待搓合
+	RemainCount   int64 // db 的剩餘數量
+}
 
-			if isMatch {
+func (e *staleOrderError) Error() string {
+	return fmt.Sprintf("stale order transactionID:%v, status:%d, remainCount:%d", e.TransactionID, e.Status, e.RemainCount)
+}
 
-				// 配對成功
-				logs.Debugf(" #### 配對成功 買:%v >= 賣:%v",
-					purchaseData.Amount.String(), sellAmount.String())
+// 檢查 db 的交易單 還能成交 fillCount (等待搓合 且剩餘數量足夠)
+func checkFillable(transaction *model_transaction.Transaction, fillCount int64) error {
+	if transaction.IsOpen() && transaction.RemainCount >= fillCount {
+		return nil
+	}
+	return &staleOrderError{
+		TransactionID: transaction.TransactionID,
+		Status:        transaction.Status,
+		Open:          transaction.IsOpen(),
+		RemainCount:   transaction.RemainCount,
+	}
+}
 
-				// 寫進db (使用 transaction(事務) 失敗就Rollback)
+// 依 db 校正訂單簿的訂單, db 已經結束的訂單 從訂單簿移除, 否則剩餘數量以 db 為準
+func (t *TransactionEgine) syncStaleOrder(book *OrderBook, stale *staleOrderError) {
+	list, index := book.Find(stale.TransactionID)
+	if list == nil {
+		return
+	}
+	order := (*list)[index]
+	if stale.Open && stale.RemainCount > 0 {
+		logs.Warnf("校正訂單剩餘數量 transactionID:%v, remainCount:%d -> %d", order.TransactionID, order.RemainCount, stale.RemainCount)
+		order.RemainCount = stale.RemainCount
+		return
+	}
+	logs.Warnf("移除 db 已經結束的訂單:%+v, status:%d", order, stale.Status)
+	book.Remove(order.TransactionID)
+}
 
-				// 寫入買方背包內
-				purchaseBackpack, err := t.Repos.BackpackRepo.GetBackpackByUserId(purchaseData.UserID, purchaseData.ProductName)
-				if err != nil {
+func (t *TransactionEgine) settle(uow *Infrastructure_server.UnitOfWork, purchaseData, sellData *model.ProductTransactionParams, sellAmount decimal.Decimal, fillCount int64) (*model_transaction.Trade, error) {
 
-					if err.Error() != "record not found" {
-						logs.Errorf("BackpackRepo save fail transactionID:%v, err:%v", purchaseData.TransactionID, err)
-						continue
-					}
+	// db 的交易單需還能成交, 與訂單簿不一致時 不成交 (由呼叫端依 db 校正訂單簿)
+	sellTransaction, err := uow.TransactionRepo.GetTransactionInfo(sellData.TransactionID)
+	if err != nil {
+		return nil, fmt.Errorf("getTransactionInfo transactionID:%v, err:%v", sellData.TransactionID, err)
+	}
+	if err = checkFillable(sellTransaction, fillCount); err != nil {
+		return nil, err
+	}
+	purchaseTransaction, err := uow.TransactionRepo.GetTransactionInfo(purchaseData.TransactionID)
+	if err != nil {
+		return nil, fmt.Errorf("getTransactionInfo fail transactionID:%v, err:%v", purchaseData.TransactionID, err)
+	}
+	if err = checkFillable(purchaseTransaction, fillCount); err != nil {
+		return nil, err
+	}
 
-					// 背包是空的 建立新產品
-					backpackObj := &model_backpack.Backpack{
-						UserID:       purchaseData.UserID, // 買方用戶ID
-						ProductName:  purchaseData.ProductName,
-						ProductCount: purchaseData.OperateCount,
-						CreatedAt:    time.Now(), // 創建時間
-						UodateAt:     time.Now(), // 更新時間
-					}
-					err = t.Repos.BackpackRepo.Save(backpackObj)
-					if err != nil {
-						logs.Warnf("backpackRepo save fail transactionID:%v, err:%v", purchaseData.TransactionID, err)
-						continue
-					}
+	// 成交總金額 = 成交價 * 成交數量
+	fillAmount := sellAmount.Mul(decimal.NewFromInt(fillCount))
 
-				} else {
-					// 原本的商品數量 + 新購買的商品數量
-					purchaseBackpack.UodateAt = time.Now()
-					purchaseBackpack.ProductCount += purchaseData.OperateCount
-					err = t.Repos.BackpackRepo.Save(purchaseBackpack)
-					if err != nil {
-						logs.Warnf("backpackRepo save fail transactionID:%v, err:%v", purchaseData.TransactionID, err)
-						continue
-					}
-				}
+	// 寫入買方背包內 (背包是空的 建立新產品), 條件更新 不覆蓋 marketplace_server 同時的凍結
+	err = uow.BackpackRepo.AddProduct(purchaseData.UserID, purchaseData.ProductName, fillCount)
+	if err != nil {
+		return nil, fmt.Errorf("addProduct fail transactionID:%v, err:%v", purchaseData.TransactionID, err)
+	}
 
-				// 扣除賣方商品的數量
+	// 扣除賣方凍結的商品數量 (掛賣單時已凍結)
+	err = uow.BackpackRepo.DebitHoldProduct(sellData.UserID, sellData.ProductName, fillCount)
+	if err != nil {
+		return nil, fmt.Errorf("debitHold fail transactionID:%v, fillCount:%d, err:%v", sellData.TransactionID, fillCount, err)
+	}
 
-				// 使用賣方的價格當作成交價, 更新賣家交易單
-				sellTransaction, err := t.Repos.TransactionRepo.GetTransactionInfo(sellData.TransactionID)
-				if err != nil {
-					logs.Warnf("getTransactionInfo transactionID:%v, err:%v", sellData.TransactionID, err)
-					continue
-				}
-				sellTransaction.Amount = sellAmount                                        // 更新交易價格
-				sellTransaction.UodateAt = time.Now()                                      // 更新交易完成時間
-				sellTransaction.ToUserID = purchaseData.UserID                             // 買家的id
-				sellTransaction.Status = int8(model_transaction.Transaction_Status_Finish) // 交易完成狀態
-				err = t.Repos.TransactionRepo.Save(sellTransaction)
-				if err != nil {
-					logs.Warnf("transactionInfo save 賣 fail transactionID:%v, err:%v, sellTransaction:%+v",
-						sellData.TransactionID, err, sellTransaction)
-					continue
-				}
+	// 使用賣方的價格當作成交價, 更新賣家交易單
+	sellTransaction.Fill(fillCount, sellAmount, purchaseData.UserID) // 買家的id
+	err = uow.TransactionRepo.Save(sellTransaction)
+	if err != nil {
+		return nil, fmt.Errorf("transactionInfo save 賣 fail transactionID:%v, err:%v", sellData.TransactionID, err)
+	}
 
-				// 使用賣方的價格當作成交價, 更新買家交易單
-				purchaseTransaction, err := t.Repos.TransactionRepo.GetTransactionInfo(purchaseData.TransactionID)
-				if err != nil {
-					logs.Warnf("getTransactionInfo fail transactionID:%v, err:%v", purchaseData.TransactionID, err)
-					continue
-				}
-				purchaseTransaction.Amount = sellAmount                                        // 更新交易價格
-				purchaseTransaction.UodateAt = time.Now()                                      // 更新交易完成時間
-				purchaseTransaction.ToUserID = sellData.UserID                                 // 賣家的id
-				purchaseTransaction.Status = int8(model_transaction.Transaction_Status_Finish) // 交易完成狀態
-				err = t.Repos.TransactionRepo.Save(purchaseTransaction)
-				if err != nil {
-					logs.Warnf("transactionInfo save fail transactionID:%v, err:%v, purchaseTransaction:%+v",
-						purchaseData.TransactionID, err, purchaseTransaction)
-					continue
-				}
+	// 使用賣方的價格當作成交價, 更新買家交易單 (成交前 計算此次成交釋放的預扣金額)
+	buyReleaseAmount := purchaseTransaction.ReleaseHold(fillCount)
+	purchaseTransaction.Fill(fillCount, sellAmount, sellData.UserID) // 賣家的id
+	err = uow.TransactionRepo.Save(purchaseTransaction)
+	if err != nil {
+		return nil, fmt.Errorf("transactionInfo save fail transactionID:%v, err:%v", purchaseData.TransactionID, err)
+	}
 
-				// 更新買家用戶金額 = 買家目前金額 - 賣家金額
-				purchaseUser, err := t.Repos.UserRepo.GetUserInfo(purchaseData.UserID)
-				if err != nil {
-					logs.Warnf("getTransactionInfo transactionID:%v, err:%v", purchaseData.UserID, err)
-					continue
-				}
-				purchaseUser.Amount = purchaseUser.Amount.Sub(sellAmount)
-				_, err = t.Repos.UserRepo.Save(purchaseUser)
-				if err != nil {
-					logs.Warnf("getTransactionInfo transactionID:%v, err:%v", purchaseData.UserID, err)
-					continue
-				}
+	// 先進入訂單簿的一方為掛單方 (maker), 後進來的為吃單方 (taker)
+	purchaseIsMaker := purchaseData.TimeStamp < sellData.TimeStamp
 
-				// 更新賣家用戶的金額 = 賣家用戶的金額 + (販賣 * 系統抽成)
-				sellUser, err := t.Repos.UserRepo.GetUserInfo(sellData.UserID)
-				if err != nil {
-					logs.Warnf("getTransactionInfo transactionID:%v, err:%v", sellData.UserID, err)
-					continue
-				}
-				sellUser.Amount = sellUser.Amount.Add(sellAmount.Mul(t.SysRate))
-				_, err = t.Repos.UserRepo.Save(sellUser)
-				if err != nil {
-					logs.Warnf("getTransactionInfo transactionID:%v, err:%v", sellData.UserID, err)
-					continue
-				}
+	// 取得 買家 賣家, 依 vip 等級計算手續費
+	purchaseUser, err := uow.UserRepo.GetUserInfo(purchaseData.UserID)
+	if err != nil {
+		return nil, fmt.Errorf("getUserInfo userID:%v, err:%v", purchaseData.UserID, err)
+	}
+	sellUser, err := uow.UserRepo.GetUserInfo(sellData.UserID)
+	if err != nil {
+		return nil, fmt.Errorf("getUserInfo userID:%v, err:%v", sellData.UserID, err)
+	}
+	buyFee := t.Fee.CalcFee(purchaseData.ProductName, purchaseUser.VipLevel, purchaseIsMaker, fillAmount)
+	sellFee := t.Fee.CalcFee(sellData.ProductName, sellUser.VipLevel, !purchaseIsMaker, fillAmount)
 
-				// 更新回redis, 市場最新價格 例如 t.marketPriceMap["BTC"] = 賣方價格 元成交
-				marketPriceDetail.Amount = sellAmount
-				marketPriceRedisStr, err := marketPriceDetail.ToJson()
-				if err != nil {
-					logs.Errorf("to json fail data:%+v, err:%v", marketPriceDetail, err)
-					continue
-				}
-				t.marketPriceMap[sellData.ProductName] = marketPriceRedisStr
-				err = t.Repos.ProductRepo.RedisSetMarketPrice(Infrastructure_layer.Redis_MarketPrice, t.marketPriceMap)
-				if err != nil {
-					return
-				}
+	// 成交金額 手續費 為商品的報價幣種, 換算成買賣雙方各自的幣種再結算
+	buySettle, err := t.exchangeSettle(fillAmount, buyFee, sellData.Currency, purchaseUser.Currency)
+	if err != nil {
+		return nil, fmt.Errorf("exchange fail userID:%v, err:%v", purchaseData.UserID, err)
+	}
+	sellSettle, err := t.exchangeSettle(fillAmount, sellFee, sellData.Currency, sellUser.Currency)
+	if err != nil {
+		return nil, fmt.Errorf("exchange fail userID:%v, err:%v", sellData.UserID, err)
+	}
 
-				// 寄送mq 給 marketplace_server
+	// 更新買家用戶金額 = 買家目前金額 - 成交總金額 - 買方手續費 (以 db 目前的金額條件更新)
+	_, err = uow.UserRepo.UpdateAmount(purchaseUser, buySettle.Amount.Add(buySettle.Fee).Neg())
+	if err != nil {
+		return nil, fmt.Errorf("userRepo updateAmount userID:%v, err:%v", purchaseData.UserID, err)
+	}
 
-				// 刪除 配對搓合的購買清單
-				logs.Debugf("刪除配對搓合單 買:%+v", purchaseData)
-				logs.Debugf("刪除配對搓合單 賣:%+v", sellData)
-				utils.SliceHelper(&t.PurchaseProductList).Remove(i)
-				utils.SliceHelper(&t.SellProductList).Remove(j)
-			}
+	// 更新賣家用戶的金額 = 賣家用戶的金額 + 成交總金額 - 賣方手續費
+	_, err = uow.UserRepo.UpdateAmount(sellUser, sellSettle.Amount.Sub(sellSettle.Fee))
+	if err != nil {
+		return nil, fmt.Errorf("userRepo updateAmount userID:%v, err:%v", sellData.UserID, err)
+	}
+
+	// 手續費存入平台收入帳戶
+	totalFee := buyFee.Add(sellFee)
+	if totalFee.IsPositive() {
+		platformUser, err := uow.UserRepo.GetUserInfo(t.Fee.PlatformUserID())
+		if err != nil {
+			return nil, fmt.Errorf("getUserInfo platform userID:%v, err:%v", t.Fee.PlatformUserID(), err)
 		}
+		platformFee, err := domain_user.Exchange(t.Rate, totalFee, sellData.Currency, platformUser.Currency)
+		if err != nil {
+			return nil, fmt.Errorf("exchange fail platform userID:%v, err:%v", t.Fee.PlatformUserID(), err)
+		}
+		_, err = uow.UserRepo.UpdateAmount(platformUser, platformFee)
+		if err != nil {
+			return nil, fmt.Errorf("userRepo updateAmount platform userID:%v, err:%v", t.Fee.PlatformUserID(), err)
+		}
+	}
+
+	// 寫入成交紀錄
+	takerMode := model.Purchase
+	if purchaseIsMaker {
+		takerMode = model.Sell
+	}
+	trade, err := t.newTrade(uow, purchaseData, sellData, sellAmount, fillCount, buyFee, sellFee, takerMode)
+	if err != nil {
+		return nil, err
+	}
+	trade.BuyCurrency, trade.BuySettleAmount, trade.BuySettleFee = buySettle.Currency, buySettle.Amount, buySettle.Fee
+	trade.SellCurrency, trade.SellSettleAmount, trade.SellSettleFee = sellSettle.Currency, sellSettle.Amount, sellSettle.Fee
+	trade.BuyReleaseAmount = buyReleaseAmount
+	err = uow.TradeRepo.Save(trade)
+	if err != nil {
+		return nil, fmt.Errorf("tradeRepo save fail tradeID:%v, err:%v", trade.TradeID, err)
+	}
+
+	return trade, nil
+}
+
+// 建立成交紀錄, 成交單號格式為 ProductName + 流水id
+func (t *TransactionEgine) newTrade(uow *Infrastructure_server.UnitOfWork, purchaseData, sellData *model.ProductTransactionParams,
+	sellAmount decimal.Decimal, fillCount int64, buyFee, sellFee decimal.Decimal, takerMode model.TransferMode) (*model_transaction.Trade, error) {
 
+	id, err := uow.TradeRepo.GetLastInsterId()
+	if err != nil && err.Error() != "record not found" {
+		return nil, fmt.Errorf("getLastInsterId fail err:%v", err)
 	}
+	id++
+
+	return &model_transaction.Trade{
+		TradeID:           fmt.Sprintf("%s-%012d", sellData.ProductName, id),
+		ProductName:       sellData.ProductName,
+		BuyTransactionID:  purchaseData.TransactionID,
+		SellTransactionID: sellData.TransactionID,
+		BuyUserID:         purchaseData.UserID,
+		SellUserID:        sellData.UserID,
+		Price:             sellAmount,
+		Count:             fillCount,
+		Amount:            sellAmount.Mul(decimal.NewFromInt(fillCount)),
+		BuyFee:            buyFee,
+		SellFee:           sellFee,
+		TakerMode:         int(takerMode),
+		Currency:          sellData.Currency,
+		ExecutedAt:        t.now(),
+	}, nil
 }
 
 // 收到交易通知
+// 業務上拒絕的指令 已回報給用戶, 回覆 mq 不重試; 無法解析 資料錯誤 由 mq 送到死信佇列; db redis 等暫時的錯誤 讓 mq 重試
 func (t *TransactionEgine) NotifyTransaction(message []byte) error {
+	err := t.HandleCommand(message)
+	if IsRejected(err) {
+		logs.Warnf("command rejected err:%v, message:%v", err, string(message[:]))
+		return nil
+	}
+	return err
+}
+
+// 處理一筆指令, 依用戶的指令序號 依序套用
+// 回傳的錯誤: 無法解析 資料錯誤 為 rabbitmqx.Permanent, 業務上拒絕為 RejectedError, 其餘可重試
+func (t *TransactionEgine) HandleCommand(message []byte) error {
 
 	logs.Debugf("msg:%s", string(message[:]))
 
@@ -318,20 +812,20 @@ func (t *TransactionEgine) NotifyTransaction(message []byte) error {
 	err := json.Unmarshal(message, &dataMap)
 	if err != nil {
 		logs.Errorf("unmarshal err, err:%v, message:%v", err, string(message[:]))
-		return nil
+		return rabbitmqx.Permanent(err)
 	}
 
 	dataMapTmp, err := json.Marshal(dataMap)
 	if err != nil {
 		logs.Errorf("marshal err, err:%v, message:%v", err, string(message[:]))
-		return nil
+		return rabbitmqx.Permanent(err)
 	}
 	// map to obj
 	productTransactionNotify := &model.ProductTransactionNotify{}
 	err = json.Unmarshal(dataMapTmp, productTransactionNotify)
 	if err != nil {
 		logs.Errorf("unmarshal err, err:%v, message:%v", err, string(message[:]))
-		return nil
+		return rabbitmqx.Permanent(err)
 	}
 
 	logs.Debugf("productTransactionNotify:%+v", productTransactionNotify)
@@ -340,16 +834,98 @@ func (t *TransactionEgine) NotifyTransaction(message []byte) error {
 	t.DataLock.Lock()
 	defer t.DataLock.Unlock()
 
+	// 順序顛倒的指令 拒絕, 序號不連續的指令 保留到前面的指令到達
+	switch t.checkSeq(productTransactionNotify) {
+	case seqStale:
+		return t.rejectStale(productTransactionNotify)
+	case seqGap:
+		t.holdCommand(productTransactionNotify)
+		return nil
+	}
+
+	err = t.applyNotify(productTransactionNotify)
+	if !isRetryable(err) {
+		// 後面的指令 可能在等待這個指令
+		t.releaseHeld(productTransactionNotify.UserID)
+	}
+	return err
+}
+
+// 依序套用已排序的指令 (例如 回放日誌), 不檢查指令序號
+func (t *TransactionEgine) ReplayCommand(productTransactionNotify *model.ProductTransactionNotify) error {
+	t.DataLock.Lock()
+	defer t.DataLock.Unlock()
+
+	return t.applyNotify(productTransactionNotify)
+}
+
+// 套用一筆指令 (呼叫端需持有資料鎖)
+// 暫時的錯誤 不記錄指令序號, mq 重試 (或 保留清單重試) 時 後面的指令會繼續等待
+func (t *TransactionEgine) applyNotify(productTransactionNotify *model.ProductTransactionNotify) error {
+
+	// 丟棄重複的指令 (序號仍要記錄, 用戶重複送出的取消 也會有新的序號)
+	if t.isDuplicate(productTransactionNotify) {
+		t.acceptSeq(productTransactionNotify)
+		return nil
+	}
+
+	// 新上架的商品 先確認是否在集合競價
+	t.prepareNewProduct(productTransactionNotify)
+
+	// 套用前 先寫入指令日誌, 失敗讓 mq 重送
+	if err := t.appendJournal(productTransactionNotify); err != nil {
+		return err
+	}
+
 	// 封包分派
-	err = t.Dispatch(productTransactionNotify)
+	t.stats.Commands++
+	err := t.Dispatch(productTransactionNotify)
 	if err != nil {
 		logs.Errorf("dispatch fail productTransactionNotify:%+v, err:%v",
 			productTransactionNotify, err)
 	}
+	if !isRetryable(err) {
+		t.acceptSeq(productTransactionNotify)
+	}
+
+	// 發佈訂單簿變動後的深度
+	t.publishDepths()
+	return err
+}
+
+// 寫入指令日誌 (未啟用 或 不需要寫入的指令 直接回傳)
+func (t *TransactionEgine) appendJournal(productTransactionNotify *model.ProductTransactionNotify) error {
+
+	if t.journal == nil || !t.isJournalCmd(productTransactionNotify.Cmd) {
+		return nil
+	}
 
+	seq, err := t.journal.Append(productTransactionNotify, t.now())
+	if err != nil {
+		logs.Errorf("journal append fail productTransactionNotify:%+v, err:%v",
+			productTransactionNotify, err)
+		return err
+	}
+	logs.Debugf("寫入指令日誌 seq:%d", seq)
 	return nil
 }
 
+// 執行引擎產生的指令 (管理員 排程), 寫入指令日誌 重啟後可重播 (呼叫端需持有資料鎖)
+func (t *TransactionEgine) applyCommand(cmd model.Notify_Cmd, data interface{}) error {
+
+	productTransactionNotify := &model.ProductTransactionNotify{
+		Cmd:  cmd,
+		Data: data,
+	}
+	if err := t.appendJournal(productTransactionNotify); err != nil {
+		return err
+	}
+	t.stats.Commands++
+	err := t.Dispatch(productTransactionNotify)
+	t.publishDepths()
+	return err
+}
+
 // 封包分派
 func (t *TransactionEgine) Dispatch(productTransactionNotify *model.ProductTransactionNotify) (err error) {
 
@@ -366,37 +942,83 @@ func (t *TransactionEgine) Dispatch(productTransactionNotify *model.ProductTrans
 		err = t.SellProduct(productTransactionNotify) // 儲存到販賣清單
 	case model.Notify_Cmd_Cancel:
 		err = t.CancelProduct(productTransactionNotify)
+	case model.Notify_Cmd_Amend:
+		err = t.AmendProduct(productTransactionNotify)
+	case model.Notify_Cmd_CancelAll:
+		err = t.CancelAllProduct(productTransactionNotify)
+	case model.Notify_Cmd_Pause, model.Notify_Cmd_Resume:
+		err = t.PauseProduct(productTransactionNotify)
+	case model.Notify_Cmd_AuctionStart, model.Notify_Cmd_AuctionEnd:
+		err = t.AuctionProduct(productTransactionNotify)
+	case model.Notify_Cmd_HaltEnd:
+		err = t.HaltProduct(productTransactionNotify)
+	case model.Notify_Cmd_Expire:
+		err = t.ExpireProduct(productTransactionNotify)
 	default:
-		logs.Warnf("unkonw cmd:%v", productTransactionNotify.Cmd)
+		err = rabbitmqx.Permanent(fmt.Errorf("unkonw cmd:%v", productTransactionNotify.Cmd))
 	}
 
 	return
 }
 
+// 需要寫入指令日誌的指令
+func (t *TransactionEgine) isJournalCmd(cmd model.Notify_Cmd) bool {
+	switch cmd {
+	case model.Notify_Cmd_Purchase, model.Notify_Cmd_Sell, model.Notify_Cmd_Cancel, model.Notify_Cmd_Amend, model.Notify_Cmd_CancelAll,
+		model.Notify_Cmd_Pause, model.Notify_Cmd_Resume,
+		model.Notify_Cmd_AuctionStart, model.Notify_Cmd_AuctionEnd, model.Notify_Cmd_HaltEnd, model.Notify_Cmd_Expire:
+		return true
+	}
+	return false
+}
+
 // 交易 買
 func (t *TransactionEgine) PurchaseProduct(productTransactionNotify *model.ProductTransactionNotify) error {
 
 	byteArray, err := json.Marshal(productTransactionNotify.Data)
 	if err != nil {
-		return err
+		return rabbitmqx.Permanent(err)
 	}
 	// 解析封包
 	var productPurchaseParams model.ProductTransactionParams
 	err = json.Unmarshal(byteArray, &productPurchaseParams)
 	if err != nil {
-		return err
+		return rabbitmqx.Permanent(err)
 	}
 
 	// 模式檢查, 這邊只處理 買
 	if model.TransferMode(productPurchaseParams.TransferMode) != model.Purchase {
-		return fmt.Errorf("error transaction_mode:%d", productPurchaseParams.TransferMode)
+		err = fmt.Errorf("error transaction_mode:%d", productPurchaseParams.TransferMode)
+		t.reportRejected(&productPurchaseParams, err)
+		return rabbitmqx.Permanent(err)
 	}
 
-	// 寫入 買結構
-	t.PurchaseProductList = append(t.PurchaseProductList, &productPurchaseParams)
+	// 新訂單 尚未成交
+	productPurchaseParams.RemainCount = productPurchaseParams.OperateCount
+
+	// 訂單需使用商品的報價幣種 (marketplace_server 受理時已換算)
+	if err = t.checkQuoteCurrency(&productPurchaseParams); err != nil {
+		t.rejectOrder(&productPurchaseParams, err)
+		return rejected(err)
+	}
+	if t.rejectExpired(&productPurchaseParams) {
+		return nil
+	}
+	t.reportAccepted(&productPurchaseParams)
+
+	// 寫入 商品的訂單簿 (買), 停損單先放入觸發清單
+	book := t.getOrderBook(productPurchaseParams.ProductName)
+	if productPurchaseParams.IsStop() {
+		t.getStopBook(productPurchaseParams.ProductName).Add(&productPurchaseParams)
+	} else {
+		t.addToBook(book, &productPurchaseParams)
+	}
 
 	logs.Debugf("等待購買清單:%d, 價格:%s 新進詳細資料:%+v",
-		len(t.PurchaseProductList), productPurchaseParams.Amount.String(), productPurchaseParams)
+		len(book.Bids), productPurchaseParams.Amount.String(), productPurchaseParams)
+
+	// 新訂單進來 立即搓合
+	t.matchBook(productPurchaseParams.ProductName, book)
 	return nil
 }
 
@@ -406,128 +1028,326 @@ func (t *TransactionEgine) SellProduct(productTransactionNotify *model.ProductTr
 	// 解析封包 interface to byteArray
 	byteArray, err := json.Marshal(productTransactionNotify.Data)
 	if err != nil {
-		return err
+		return rabbitmqx.Permanent(err)
 	}
 	// 解析封包 byteArray to obj
 	var productPurchaseParams model.ProductTransactionParams
 	err = json.Unmarshal(byteArray, &productPurchaseParams)
 	if err != nil {
-		return err
+		return rabbitmqx.Permanent(err)
 	}
 
 	// 模式檢查, 這邊只處理 賣
 	if model.TransferMode(productPurchaseParams.TransferMode) != model.Sell {
-		return fmt.Errorf("error transaction_mode:%d", productPurchaseParams.TransferMode)
+		err = fmt.Errorf("error transaction_mode:%d", productPurchaseParams.TransferMode)
+		t.reportRejected(&productPurchaseParams, err)
+		return rabbitmqx.Permanent(err)
 	}
 
-	// 寫入 賣結構
-	t.SellProductList = append(t.SellProductList, &productPurchaseParams)
+	// 新訂單 尚未成交
+	productPurchaseParams.RemainCount = productPurchaseParams.OperateCount
+
+	// 訂單需使用商品的報價幣種 (marketplace_server 受理時已換算)
+	if err = t.checkQuoteCurrency(&productPurchaseParams); err != nil {
+		t.rejectOrder(&productPurchaseParams, err)
+		return rejected(err)
+	}
+	if t.rejectExpired(&productPurchaseParams) {
+		return nil
+	}
+	t.reportAccepted(&productPurchaseParams)
+
+	// 寫入 商品的訂單簿 (賣), 停損單先放入觸發清單
+	book := t.getOrderBook(productPurchaseParams.ProductName)
+	if productPurchaseParams.IsStop() {
+		t.getStopBook(productPurchaseParams.ProductName).Add(&productPurchaseParams)
+	} else {
+		t.addToBook(book, &productPurchaseParams)
+	}
 
 	logs.Debugf("等待販賣清單:%d, 價格:%s 新進詳細資料:%+v",
-		len(t.SellProductList), productPurchaseParams.Amount.String(), productPurchaseParams)
+		len(book.Asks), productPurchaseParams.Amount.String(), productPurchaseParams)
+
+	// 新訂單進來 立即搓合
+	t.matchBook(productPurchaseParams.ProductName, book)
 	return nil
 }
 
-// 取消交易
+// 取消交易, 只能取消自己的訂單
+// 取消失敗 (已經全部成交 或 找不到訂單) 以成交回報通知用戶
 func (t *TransactionEgine) CancelProduct(productTransactionNotify *model.ProductTransactionNotify) error {
 
 	// 解析封包
 	byteArray, err := json.Marshal(productTransactionNotify.Data)
 	if err != nil {
-		return err
+		return rabbitmqx.Permanent(err)
 	}
 	// 解析封包
 	var productCancelParams model.ProductCancelParams
 	err = json.Unmarshal(byteArray, &productCancelParams)
 	if err != nil {
-		return err
+		return rabbitmqx.Permanent(err)
 	}
 
 	// 資料檢查
-	if len(productCancelParams.TransactionID) == 0 || productCancelParams.UserID < 0 {
-		return fmt.Errorf("error params productCancelParams:%+v", productCancelParams)
+	if len(productCancelParams.TransactionID) == 0 || productCancelParams.UserID <= 0 {
+		return rabbitmqx.Permanent(fmt.Errorf("error params productCancelParams:%+v", productCancelParams))
 	}
 
-	// todo 抓 user
+	// 從訂單簿 或 停損單觸發清單 找出訂單 (買單 賣單 都會搜尋)
+	data := t.findOrder(productCancelParams.TransactionID)
+	if data == nil || data.UserID != productCancelParams.UserID {
+		// 重播日誌時 當初已回報
+		if t.replaying {
+			return nil
+		}
+		result := model.Cancel_Result_NotFound
+		if data == nil {
+			// 不在訂單簿內, db 已經完成 代表已經全部成交
+			transaction, err := t.Repos.TransactionRepo.GetTransactionInfo(productCancelParams.TransactionID)
+			if err == nil && transaction.FromUserID == productCancelParams.UserID &&
+				transaction.Status == int8(model_transaction.Transaction_Status_Finish) {
+				result = model.Cancel_Result_AlreadyFilled
+			}
+		}
+		t.reportCancelRejected(&productCancelParams, result)
+		return rejected(fmt.Errorf("cancel fail productCancelParams:%+v, result:%d", productCancelParams, result))
+	}
+
+	return t.cancelOrder(data)
+}
+
+// 取消全部交易 (指定用戶 / 指定商品)
+func (t *TransactionEgine) CancelAllProduct(productTransactionNotify *model.ProductTransactionNotify) error {
 
-	// 讀取原本訂單
-	transaction, err := t.Repos.TransactionRepo.GetTransactionInfo(productCancelParams.TransactionID)
+	// 解析封包
+	byteArray, err := json.Marshal(productTransactionNotify.Data)
 	if err != nil {
-		return fmt.Errorf("error getTransactionInfo  productCancelParams:%+v", productCancelParams)
+		return rabbitmqx.Permanent(err)
 	}
-	if transaction.Status == int8(model_transaction.Transaction_Status_Finish) {
-		// 已經完成的訂單無法取消
-		return fmt.Errorf("error transaction is finish productCancelParams:%+v",
-			productCancelParams)
+	var productCancelAllParams model.ProductCancelAllParams
+	err = json.Unmarshal(byteArray, &productCancelAllParams)
+	if err != nil {
+		return rabbitmqx.Permanent(err)
 	}
 
-	// 取得搜尋的交易清單
-	var searchList []*model.ProductTransactionParams
-	switch model.TransferMode(transaction.TransferMode) {
-	case model.Purchase:
-		searchList = t.PurchaseProductList // 等待搓合清單 買
-	case model.Sell:
-		searchList = t.SellProductList // 等待搓合清單 賣
+	// 資料檢查, 不能同時 所有用戶 所有商品
+	if productCancelAllParams.UserID < 0 ||
+		(productCancelAllParams.UserID == 0 && len(productCancelAllParams.ProductName) == 0) {
+		return rabbitmqx.Permanent(fmt.Errorf("error params productCancelAllParams:%+v", productCancelAllParams))
 	}
 
-	// 搜尋要取消的清單
-	for i, data := range searchList {
+	// 先收集 再逐筆取消 (取消會修改訂單簿)
+	var orders []*model.ProductTransactionParams
+	for _, book := range t.OrderBooks {
+		for _, data := range append(append([]*model.ProductTransactionParams{}, book.Bids...), book.Asks...) {
+			if productCancelAllParams.Match(data) {
+				orders = append(orders, data)
+			}
+		}
+	}
+	for _, stopBook := range t.StopBooks {
+		for _, data := range stopBook.Orders {
+			if productCancelAllParams.Match(data) {
+				orders = append(orders, data)
+			}
+		}
+	}
 
-		if data.TransactionID == productCancelParams.TransactionID {
-			// 找到想取消的清單
+	count := 0
+	var cancelErr error
+	for _, data := range orders {
+		if err = t.cancelOrder(data); err != nil {
+			logs.Errorf("cancelOrder fail transactionID:%v, err:%v", data.TransactionID, err)
+			cancelErr = err
+			continue
+		}
+		count++
+	}
 
-			// 刪除等待搓合單
-			logs.Debugf("刪除等待搓合單:%+v", data)
-			utils.SliceHelper(&t.PurchaseProductList).Remove(i)
+	logs.Debugf("取消全部交易 productCancelAllParams:%+v, 取消數量:%d/%d", productCancelAllParams, count, len(orders))
+	if cancelErr != nil {
+		// 取消失敗的訂單 仍在訂單簿內, 重試時只會取消剩下的訂單
+		return fmt.Errorf("cancel all fail productCancelAllParams:%+v, cancelled:%d/%d, err:%v",
+			productCancelAllParams, count, len(orders), cancelErr)
+	}
+	return nil
+}
+
+// 從訂單簿 (或停損單觸發清單) 刪除訂單, 設定取消狀態 歸還凍結的商品 並退款
+func (t *TransactionEgine) cancelOrder(data *model.ProductTransactionParams) error {
+
+	book := t.getOrderBook(data.ProductName)
+	stopBook := t.getStopBook(data.ProductName)
+	if _, ok := book.Remove(data.TransactionID); !ok {
+		stopBook.Remove(data.TransactionID)
+	}
+	logs.Debugf("刪除等待搓合單:%+v", data)
+
+	if err := t.closeOrder(data, model_transaction.Transaction_Status_Cancel); err != nil {
+		// 寫入失敗 放回訂單簿
+		if data.IsStop() {
+			stopBook.Add(data)
+		} else {
+			book.Add(data)
+		}
+		return err
+	}
+
+	return nil
+}
+
+// 結束未成交的訂單 (取消 過期 拒絕), 設定狀態 賣單歸還凍結的商品數量, 買單退還預扣的金額
+// 訂單需已從訂單簿移除, 重播日誌時 當初已寫入 db 與退款 不再處理
+func (t *TransactionEgine) closeOrder(data *model.ProductTransactionParams, status model_transaction.Transaction_Status) error {
+
+	refundAmount, closed, err := t.closeTransaction(data, status)
+	if err != nil || !closed {
+		return err
+	}
+
+	// 回報 給 marketplace_server
+	t.reportClosed(data, status, refundAmount)
+	return nil
+}
 
-			// 設定取消狀態 （todo:搬移到迴圈內)
-			transaction.Status = int8(model_transaction.Transaction_Status_Cancel)
-			err = t.Repos.TransactionRepo.Save(transaction)
+// 結束 db 的交易單 並退款, 回傳退還的預扣金額 與 是否有結束 (重播日誌 或 已經結束的訂單 不處理)
+func (t *TransactionEgine) closeTransaction(data *model.ProductTransactionParams, status model_transaction.Transaction_Status) (decimal.Decimal, bool, error) {
+
+	if t.replaying {
+		return decimal.Zero, false, nil
+	}
+
+	transaction, err := t.Repos.TransactionRepo.GetTransactionInfo(data.TransactionID)
+	if err != nil {
+		return decimal.Zero, false, fmt.Errorf("error getTransactionInfo transactionID:%v, err:%v", data.TransactionID, err)
+	}
+	switch model_transaction.Transaction_Status(transaction.Status) {
+	case model_transaction.Transaction_Status_Wait, model_transaction.Transaction_Status_PartialFilled:
+	default:
+		// 已經結束的訂單 不重複退款
+		logs.Warnf("transaction already closed transactionID:%v, status:%d", transaction.TransactionID, transaction.Status)
+		return decimal.Zero, false, nil
+	}
+
+	// 買單 退還剩餘數量的預扣金額
+	refundAmount := transaction.RemainHoldAmount()
+
+	// 設定狀態, 賣單歸還凍結的商品數量 (使用 transaction(事務) 失敗就Rollback)
+	err = t.Repos.Transaction(func(uow *Infrastructure_server.UnitOfWork) error {
+
+		transaction.Status = int8(status)
+		transaction.HoldAmount = decimal.Zero
+		transaction.UodateAt = t.now()
+		if err := uow.TransactionRepo.Save(transaction); err != nil {
+			return fmt.Errorf("error Save transaction:%+v, err:%v", transaction, err)
+		}
+
+		if model.TransferMode(transaction.TransferMode) != model.Sell {
+			return nil
+		}
+		if err := uow.BackpackRepo.ReleaseProduct(transaction.FromUserID, transaction.ProductName, data.RemainCount); err != nil {
+			return fmt.Errorf("release fail transactionID:%v, remainCount:%d, err:%v", transaction.TransactionID, data.RemainCount, err)
+		}
+		return nil
+	})
+	if err != nil {
+		return decimal.Zero, false, err
+	}
+
+	// 處理退款事宜 (用戶緩存) 因為沒完成搓合, 所以db金額數據不用異動
+	if !refundAmount.IsZero() {
+		// db 已經結束訂單, 緩存寫入失敗 只記錄 (緩存過期 重新登入時 會以 db 金額重建)
+		if err = t.addAuthAmount(transaction.FromUserID, refundAmount); err != nil {
+			logs.Errorf("refund user cache fail userID:%v, transactionID:%v, amount:%v, err:%v",
+				transaction.FromUserID, transaction.TransactionID, refundAmount, err)
+		}
+	}
+	logs.Debugf("處理退款事宜: userId:%v, transactionID:%v, amount(退款額):%v",
+		data.UserID, data.TransactionID, refundAmount)
+
+	return refundAmount, true, nil
+}
+
+// 調整用戶緩存的餘額 (原子操作, marketplace_server 也會同時調整)
+func (t *TransactionEgine) addAuthAmount(userID int64, amount decimal.Decimal) error {
+	_, err := t.Repos.AuthRepo.UpdateAmount(userID, func(auth *model.AuthInfo) error {
+		auth.Amount = auth.Amount.Add(amount)
+		return nil
+	})
+	return err
+}
+
+// 載入搓合策略 自成交防範模式 與 熔斷設定 (預設 與 商品的設定)
+func (t *TransactionEgine) loadPolicies(cfg config.Engine) (err error) {
+
+	if t.defaultPolicy, err = NewMatchingPolicy(cfg.MatchingPolicy); err != nil {
+		return err
+	}
+	if t.selfTrade, err = NewSelfTradeMode(cfg.SelfTradePrevention); err != nil {
+		return err
+	}
+
+	if t.breaker, err = NewBreaker(cfg.CircuitBreaker); err != nil {
+		return err
+	}
+
+	t.policies = make(map[string]MatchingPolicy)
+	t.selfTrades = make(map[string]string)
+	t.breakers = make(map[string]*Breaker)
+	for _, product := range cfg.Products {
+		if len(product.MatchingPolicy) > 0 {
+			policy, err := NewMatchingPolicy(product.MatchingPolicy)
 			if err != nil {
-				return fmt.Errorf("error Save transaction:%+v", transaction)
+				return fmt.Errorf("productName:%v, err:%v", product.ProductName, err)
 			}
-
-			// 處理退款事宜 (取得用戶緩存) 因為沒完成搓合, 所以db金額數據不用異動
-			auth, err := t.Repos.AuthRepo.GetAuthUser(transaction.FromUserID)
+			t.policies[product.ProductName] = policy
+		}
+		if len(product.SelfTradePrevention) > 0 {
+			mode, err := NewSelfTradeMode(product.SelfTradePrevention)
 			if err != nil {
-				errMsg := fmt.Errorf("get redis fail  userID:%v err:%v", transaction.FromUserID, err)
-				return errMsg
+				return fmt.Errorf("productName:%v, err:%v", product.ProductName, err)
 			}
-			//auth.Amount = auth.Amount.Add(data.Amount)
-			auth.Amount = auth.Amount.Add(transaction.ProductNeedAmount) // 購買商品當初預扣的錢
-			if _, err = t.Repos.AuthRepo.Set(auth); err != nil {
-				logs.Errorf("update user cache err:%v", err)
-				return err
+			t.selfTrades[product.ProductName] = mode
+		}
+		if product.CircuitBreaker != nil {
+			breaker, err := NewBreaker(*product.CircuitBreaker)
+			if err != nil {
+				return fmt.Errorf("productName:%v, err:%v", product.ProductName, err)
 			}
-			logs.Debugf("處理退款事宜: userId:%v, transactionID:%v, amount(退款額):%v, amount(現金):%v",
-				data.UserID, data.TransactionID, data.Amount, auth.Amount)
-			break
+			t.breakers[product.ProductName] = breaker
 		}
 	}
 
-	// // 搜尋要取消的清單
-	// for i, data := range t.PurchaseProductList {
-
-	// 	if data.TransactionID == productCancelParams.TransactionID {
-	// 		// 找到想取消的清單
-
-	// 		// 刪除等待搓合單
-	// 		utils.SliceHelper(&t.PurchaseProductList).Remove(i)
-	// 		logs.Debugf("刪除等待搓合單:%+v", data)
-	// 		break
-	// 	}
-	// }
-	// for i, data := range t.SellProductList {
+	logs.Debugf("搓合策略 預設:%s, 商品數量:%d, 自成交防範 預設:%s, 商品數量:%d",
+		t.defaultPolicy.Name(), len(t.policies), t.selfTrade, len(t.selfTrades))
+	return nil
+}
 
-	// 	if data.TransactionID == productCancelParams.TransactionID {
-	// 		// 找到想取消的清單
+// 取得商品的搓合策略, 沒有設定使用預設策略
+func (t *TransactionEgine) getPolicy(productName string) MatchingPolicy {
+	if policy, ok := t.policies[productName]; ok {
+		return policy
+	}
+	return t.defaultPolicy
+}
 
-	// 		// 刪除等待搓合單
-	// 		utils.SliceHelper(&t.SellProductList).Remove(i)
-	// 		logs.Debugf("刪除等待搓合單:%+v", data)
-	// 		break
-	// 	}
-	// }
+// 取得商品的訂單簿, 不存在就建立
+func (t *TransactionEgine) getOrderBook(productName string) *OrderBook {
+	book, ok := t.OrderBooks[productName]
+	if !ok {
+		book = NewOrderBook(productName)
+		t.OrderBooks[productName] = book
+	}
+	return book
+}
 
-	return nil
+// 取得商品的停損單觸發清單, 不存在就建立
+func (t *TransactionEgine) getStopBook(productName string) *StopBook {
+	stopBook, ok := t.StopBooks[productName]
+	if !ok {
+		stopBook = NewStopBook(productName)
+		t.StopBooks[productName] = stopBook
+	}
+	return stopBook
 }
@@ -0,0 +1,275 @@
+package src
+
+import (
+	"encoding/json"
+	"fmt"
+	"marketplace_server/config"
+	Infrastructure_backpack "marketplace_server/internal/backpack/Infrastructure_layer"
+	model_backpack "marketplace_server/internal/backpack/model"
+	Infrastructure_bill "marketplace_server/internal/bill/Infrastructure_layer"
+	domain_bill "marketplace_server/internal/bill/domain_layer"
+	model_bill "marketplace_server/internal/bill/model"
+	Infrastructure_product "marketplace_server/internal/product/Infrastructure_layer"
+	model_product "marketplace_server/internal/product/model"
+	Infrastructure_server "marketplace_server/internal/servers/Infrastructure_layer"
+	Infrastructure_user "marketplace_server/internal/user/Infrastructure_layer"
+	"marketplace_server/internal/user/model"
+	"testing"
+	"time"
+
+	"github.com/shopspring/decimal"
+)
+
+// 測試用的時鐘 (手動推進)
+type testClock struct {
+	now time.Time
+}
+
+func (c *testClock) Now() time.Time {
+	return c.now
+}
+
+// 測試用的交易引擎 (記憶體的持久層, 測試用的時鐘)
+type testEngine struct {
+	*TransactionEgine
+	t            *testing.T
+	clock        *testClock
+	users        *Infrastructure_user.MemoryUserRepo
+	auths        *Infrastructure_user.MemoryAuthRepo
+	transactions *Infrastructure_bill.MemoryTransactionRepo
+	trades       *Infrastructure_bill.MemoryTradeRepo
+	backpacks    *Infrastructure_backpack.MemoryBackpackRepo
+	products     *Infrastructure_product.MemoryProductRepo
+	seqs         map[int64]int64 // 用戶的指令序號 key=用戶ID
+}
+
+// 建立測試用的交易引擎, 商品 BTC 報價幣種 TWD 市場價格 100
+func newTestEngine(t *testing.T, cfg *config.Config) *testEngine {
+
+	if cfg == nil {
+		cfg = &config.Config{ConfigBase: &config.ConfigBase{}}
+	}
+	e := &testEngine{
+		t:            t,
+		clock:        &testClock{now: time.Unix(1700000000, 0)},
+		users:        Infrastructure_user.NewMemoryUserRepo(),
+		auths:        Infrastructure_user.NewMemoryAuthRepo(),
+		transactions: Infrastructure_bill.NewMemoryTransactionRepo(),
+		trades:       Infrastructure_bill.NewMemoryTradeRepo(),
+		backpacks:    Infrastructure_backpack.NewMemoryBackpackRepo(),
+		products:     Infrastructure_product.NewMemoryProductRepo(),
+		seqs:         make(map[int64]int64),
+	}
+	e.setMarketPrice(&model_product.MarketPriceRedis{ProductCount: 1000, Currency: "TWD", Amount: decimal.NewFromInt(100)})
+
+	repos := &Infrastructure_server.RepositoriesManager{
+		AuthRepo:        e.auths,
+		UserRepo:        e.users,
+		TransactionRepo: e.transactions,
+		TradeRepo:       e.trades,
+		ProductRepo:     e.products,
+		CandleRepo:      Infrastructure_product.NewMemoryCandleRepo(),
+		DepthRepo:       Infrastructure_product.NewMemoryDepthRepo(),
+		BackpackRepo:    e.backpacks,
+	}
+	engine, err := newTransactionEgine(cfg, repos, e.clock)
+	if err != nil {
+		t.Fatalf("newTransactionEgine err:%v", err)
+	}
+	e.TransactionEgine = engine
+	return e
+}
+
+// 寫入商品 BTC 的市場價格 (新上架商品)
+func (e *testEngine) setMarketPrice(marketPriceDetail *model_product.MarketPriceRedis) {
+	marketPriceJson, err := marketPriceDetail.ToJson()
+	if err != nil {
+		e.t.Fatalf("err:%v", err)
+	}
+	err = e.products.RedisSetMarketPrice(Infrastructure_product.Redis_MarketPrice, map[string]string{"BTC": marketPriceJson})
+	if err != nil {
+		e.t.Fatalf("err:%v", err)
+	}
+}
+
+// 建立用戶 (TWD 餘額 amount, 持有 BTC count)
+func (e *testEngine) addUser(userID int64, amount string, count int64) {
+	user := &model.User{UserID: userID, Currency: "TWD", Amount: decimal.RequireFromString(amount)}
+	if _, err := e.users.Save(user); err != nil {
+		e.t.Fatalf("err:%v", err)
+	}
+	if _, err := e.auths.Set(&model.AuthInfo{UserID: userID, Currency: "TWD", Amount: user.Amount}); err != nil {
+		e.t.Fatalf("err:%v", err)
+	}
+	if count > 0 {
+		if err := e.backpacks.Save(&model_backpack.Backpack{UserID: userID, ProductName: "BTC", ProductCount: count}); err != nil {
+			e.t.Fatalf("err:%v", err)
+		}
+	}
+}
+
+// 依 marketplace_server 受理的方式下單 再送入引擎
+// 寫入等待搓合的交易單, 賣單凍結商品數量, 買單依委託價格 (市價單依市場價格) 預扣金額 (含最多可能的手續費)
+func (e *testEngine) submit(order *model.ProductTransactionParams) error {
+
+	if len(order.ProductName) == 0 {
+		order.ProductName = "BTC"
+	}
+	if len(order.Currency) == 0 {
+		order.Currency = "TWD"
+	}
+	if order.TimeStamp == 0 {
+		order.TimeStamp = e.clock.now.UnixNano()
+	}
+
+	productNeedAmount := decimal.Zero
+	switch model.TransferMode(order.TransferMode) {
+	case model.Purchase:
+		marketPriceDetail, err := e.getMarketPrice(order.ProductName)
+		if err != nil {
+			e.t.Fatalf("err:%v", err)
+		}
+		productNeedAmount = domain_bill.HoldAmount(e.Fee, order.ProductName, 0, order.HoldPrice(marketPriceDetail.Amount), order.OperateCount, decimal.NewFromInt(1))
+		e.addAuth(order.UserID, productNeedAmount.Neg())
+	case model.Sell:
+		if err := e.backpacks.HoldProduct(order.UserID, order.ProductName, order.OperateCount); err != nil {
+			e.t.Fatalf("err:%v", err)
+		}
+	}
+
+	err := e.transactions.Save(&model_bill.Transaction{
+		TransactionID:     order.TransactionID,
+		TransferMode:      order.TransferMode,
+		TransferType:      order.TransferType,
+		FromUserID:        order.UserID,
+		ProductName:       order.ProductName,
+		ProductCount:      order.OperateCount,
+		Price:             order.Amount,
+		TriggerPrice:      order.TriggerPrice,
+		TimeInForce:       order.TimeInForce,
+		ExpireTime:        order.ExpireTime,
+		RemainCount:       order.OperateCount,
+		ProductNeedAmount: productNeedAmount,
+		HoldAmount:        productNeedAmount,
+		Currency:          order.Currency,
+		QueueTime:         order.TimeStamp,
+		Status:            int8(model_bill.Transaction_Status_Wait),
+	})
+	if err != nil {
+		e.t.Fatalf("err:%v", err)
+	}
+
+	cmd := model.Notify_Cmd_Purchase
+	if model.TransferMode(order.TransferMode) == model.Sell {
+		cmd = model.Notify_Cmd_Sell
+	}
+	return e.notify(cmd, order.UserID, order)
+}
+
+// 送入指令 (每個指令使用用戶的下一個序號)
+func (e *testEngine) notify(cmd model.Notify_Cmd, userID int64, data interface{}) error {
+	e.seqs[userID]++
+	return e.notifySeq(cmd, userID, e.seqs[userID], data)
+}
+
+// 送入指令 (指定序號)
+func (e *testEngine) notifySeq(cmd model.Notify_Cmd, userID, seq int64, data interface{}) error {
+	byteArray, err := json.Marshal(&model.ProductTransactionNotify{
+		Cmd:    cmd,
+		UserID: userID,
+		Seq:    seq,
+		Data:   data,
+	})
+	if err != nil {
+		e.t.Fatalf("err:%v", err)
+	}
+	return e.HandleCommand(byteArray)
+}
+
+// 調整用戶緩存的餘額
+func (e *testEngine) addAuth(userID int64, amount decimal.Decimal) {
+	if err := e.addAuthAmount(userID, amount); err != nil {
+		e.t.Fatalf("err:%v", err)
+	}
+}
+
+// 用戶緩存的餘額
+func (e *testEngine) authAmount(userID int64) decimal.Decimal {
+	auth, err := e.auths.GetAuthUser(userID)
+	if err != nil {
+		e.t.Fatalf("err:%v", err)
+	}
+	return auth.Amount
+}
+
+// 用戶 db 的餘額
+func (e *testEngine) userAmount(userID int64) decimal.Decimal {
+	user, err := e.users.GetUserInfo(userID)
+	if err != nil {
+		e.t.Fatalf("err:%v", err)
+	}
+	return user.Amount
+}
+
+// 用戶的 BTC 背包
+func (e *testEngine) backpack(userID int64) *model_backpack.Backpack {
+	backpack, err := e.backpacks.GetBackpackByUserId(userID, "BTC")
+	if err != nil {
+		e.t.Fatalf("err:%v", err)
+	}
+	return backpack
+}
+
+// 交易單
+func (e *testEngine) transaction(transactionID string) *model_bill.Transaction {
+	transaction, err := e.transactions.GetTransactionInfo(transactionID)
+	if err != nil {
+		e.t.Fatalf("err:%v", err)
+	}
+	return transaction
+}
+
+// BTC 訂單簿
+func (e *testEngine) book() *OrderBook {
+	return e.getOrderBook("BTC")
+}
+
+func Test_MemoryUnitOfWork_Rollback(t *testing.T) {
+	e := newTestEngine(t, nil)
+	e.addUser(1, "100", 5)
+
+	// fn 回傳錯誤 或 panic, 記憶體的持久層 還原到執行前的資料
+	for _, fail := range []func() error{
+		func() error { return fmt.Errorf("fail") },
+		func() error { panic("fail") },
+	} {
+		err := e.Repos.Transaction(func(uow *Infrastructure_server.UnitOfWork) error {
+			user, _ := uow.UserRepo.GetUserInfo(1)
+			user.Amount = decimal.Zero
+			if _, err := uow.UserRepo.Save(user); err != nil {
+				return err
+			}
+			backpack, _ := uow.BackpackRepo.GetBackpackByUserId(1, "BTC")
+			backpack.ProductCount = 0
+			if err := uow.BackpackRepo.Save(backpack); err != nil {
+				return err
+			}
+			if err := uow.TransactionRepo.Save(&model_bill.Transaction{TransactionID: "1-1-1"}); err != nil {
+				return err
+			}
+			if err := uow.TradeRepo.Save(&model_bill.Trade{}); err != nil {
+				return err
+			}
+			return fail()
+		})
+		if err == nil {
+			t.Fatalf("transaction should fail")
+		}
+		if !e.userAmount(1).Equal(decimal.NewFromInt(100)) || e.backpack(1).ProductCount != 5 {
+			t.Fatalf("amount:%v, backpack:%+v", e.userAmount(1), e.backpack(1))
+		}
+		if _, err = e.transactions.GetTransactionInfo("1-1-1"); err == nil || len(e.trades.GetTradeList()) != 0 {
+			t.Fatalf("transaction or trade not rolled back")
+		}
+	}
+}
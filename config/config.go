@@ -7,6 +7,9 @@ type ConfigBase struct {
 	Redis    Redis    `yaml:"redis"`
 	RabbitMq RabbitMq `yaml:"rabbitmq"`
 	Log      Log      `yaml:"log"`
+	Engine   Engine   `yaml:"engine"`
+	Fee      Fee      `yaml:"fee"`
+	Admin    Admin    `yaml:"admin"`
 }
 type Web struct {
 	Mode string `yaml:"mode"`
@@ -53,8 +56,64 @@ type Log struct {
 	MaxBackups int    `yaml:"max_backups"`
 }
 
+// 搓合引擎 配置 (transaction_server)
+type Engine struct {
+	JournalPath         string          `yaml:"journalPath"`         // 指令日誌檔路徑 (空的就不啟用, 快照後輪替)
+	SnapshotPath        string          `yaml:"snapshotPath"`        // 訂單簿快照檔路徑
+	SnapshotInterval    string          `yaml:"snapshotInterval"`    // 快照間隔 例如 5m
+	MaxRetry            int             `yaml:"maxRetry"`            // 指令處理失敗的重試次數, 超過送到死信佇列 (0 = 不限次數)
+	MatchingPolicy      string          `yaml:"matchingPolicy"`      // 預設搓合策略 fifo | prorata (不填 = fifo)
+	SelfTradePrevention string          `yaml:"selfTradePrevention"` // 預設自成交防範模式 none | cancel_newest | cancel_oldest | cancel_both | decrement (不填 = none)
+	CircuitBreaker      CircuitBreaker  `yaml:"circuitBreaker"`      // 預設熔斷設定
+	DepthLevel          int             `yaml:"depthLevel"`          // 發佈到 redis 的深度檔位數 (0 = 預設 20)
+	Products            []EngineProduct `yaml:"products"`            // 商品的搓合設定 (覆蓋預設)
+}
+
+// 商品的搓合設定
+type EngineProduct struct {
+	ProductName         string          `yaml:"productName"`         // 商品名稱
+	MatchingPolicy      string          `yaml:"matchingPolicy"`      // 搓合策略 fifo | prorata
+	SelfTradePrevention string          `yaml:"selfTradePrevention"` // 自成交防範模式 (不填 = 使用預設)
+	CircuitBreaker      *CircuitBreaker `yaml:"circuitBreaker"`      // 熔斷設定 (不填 = 使用預設)
+}
+
+// 熔斷: 成交價在時間窗內變動超過門檻 暫停交易
+type CircuitBreaker struct {
+	Percent string `yaml:"percent"` // 成交價變動超過 % 觸發熔斷 (不填或 0 = 不啟用)
+	Window  string `yaml:"window"`  // 時間窗 例如 5m
+	Halt    string `yaml:"halt"`    // 暫停交易的時間 例如 10m
+}
+
+// 手續費 配置 (transaction_server 收取, marketplace_server 買單預扣 需使用相同設定)
+type Fee struct {
+	PlatformUserID int64        `yaml:"platformUserID"` // 平台收入帳戶的用戶ID (不填就不收手續費)
+	MakerRate      string       `yaml:"makerRate"`      // 掛單方 (maker) 費率 例如 0.001
+	TakerRate      string       `yaml:"takerRate"`      // 吃單方 (taker) 費率 例如 0.002
+	Products       []ProductFee `yaml:"products"`       // 商品費率 (覆蓋預設費率)
+	VipLevels      []VipFee     `yaml:"vipLevels"`      // vip 等級費率折扣
+}
+
+// 商品費率
+type ProductFee struct {
+	ProductName string `yaml:"productName"` // 商品名稱
+	MakerRate   string `yaml:"makerRate"`   // 掛單方費率
+	TakerRate   string `yaml:"takerRate"`   // 吃單方費率
+}
+
+// vip 等級費率折扣
+type VipFee struct {
+	Level    int    `yaml:"level"`    // vip 等級
+	Discount string `yaml:"discount"` // 費率乘上折扣 例如 0.8
+}
+
 // Config 将配置文件的参数解析,比如解析时间为 time.Ticker
 // type Config struct {
 // 	*ConfigBase
 // 	AuthExpireTime time.Duration
 // }
+
+// 管理員 配置
+type Admin struct {
+	UserIDs []int64 `yaml:"userIDs"` // 管理員的用戶ID (marketplace_server 管理員 api)
+	Token   string  `yaml:"token"`   // 管理員憑證 (transaction_server 管理員 api, 空的就不開放)
+}
@@ -4,6 +4,7 @@ import (
 	"log"
 	"os"
 	"strconv"
+	"strings"
 	"time"
 )
 
@@ -38,6 +39,19 @@ func NewEnvConfig() *Config {
 		log.Fatalf("max_backups max_backups:%v, err=%v", os.Getenv("log_max_backups"), err)
 		return nil
 	}
+	// 沒設定就不限重試次數
+	maxRetry, _ := strconv.Atoi(os.Getenv("engine_maxRetry"))
+	// 沒設定就使用預設的深度檔位數
+	depthLevel, _ := strconv.Atoi(os.Getenv("engine_depthLevel"))
+	// 沒設定就不收手續費
+	platformUserID, _ := strconv.ParseInt(os.Getenv("fee_platformUserID"), 10, 64)
+	// 管理員的用戶ID 以逗號分隔
+	var adminUserIDs []int64
+	for _, s := range strings.Split(os.Getenv("admin_userIDs"), ",") {
+		if userID, err := strconv.ParseInt(strings.TrimSpace(s), 10, 64); err == nil {
+			adminUserIDs = append(adminUserIDs, userID)
+		}
+	}
 
 	baseConf := &ConfigBase{
 		Web: Web{
@@ -80,6 +94,29 @@ func NewEnvConfig() *Config {
 			MaxAge:     max_age,
 			MaxBackups: max_backups,
 		},
+		Engine: Engine{
+			JournalPath:         os.Getenv("engine_journalPath"),
+			SnapshotPath:        os.Getenv("engine_snapshotPath"),
+			SnapshotInterval:    os.Getenv("engine_snapshotInterval"),
+			MaxRetry:            maxRetry,
+			MatchingPolicy:      os.Getenv("engine_matchingPolicy"),
+			SelfTradePrevention: os.Getenv("engine_selfTradePrevention"),
+			CircuitBreaker: CircuitBreaker{
+				Percent: os.Getenv("engine_breakerPercent"),
+				Window:  os.Getenv("engine_breakerWindow"),
+				Halt:    os.Getenv("engine_breakerHalt"),
+			},
+			DepthLevel: depthLevel,
+		},
+		Fee: Fee{
+			PlatformUserID: platformUserID,
+			MakerRate:      os.Getenv("fee_makerRate"),
+			TakerRate:      os.Getenv("fee_takerRate"),
+		},
+		Admin: Admin{
+			UserIDs: adminUserIDs,
+			Token:   os.Getenv("admin_token"),
+		},
 	}
 
 	// AuthExpireTime 解析为 time.Duration
@@ -0,0 +1,157 @@
+package Infrastructure_layer
+
+import (
+	"marketplace_server/internal/backpack/model"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/jinzhu/gorm"
+)
+
+// 記憶體的背包持久層 (回放工具 測試使用, 不需要 mysql)
+// 讀取 與 寫入 都複製一份, 與 db 相同 修改取出的物件不影響已儲存的資料
+type MemoryBackpackRepo struct {
+	lock   sync.RWMutex
+	lastID int64
+	list   map[int64]*model.Backpack // key=背包ID
+}
+
+var _ BackpackRepo = &MemoryBackpackRepo{}
+
+func NewMemoryBackpackRepo() *MemoryBackpackRepo {
+	return &MemoryBackpackRepo{list: make(map[int64]*model.Backpack)}
+}
+
+func (r *MemoryBackpackRepo) Save(backpack *model.Backpack) error {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	if backpack.BackpackID == 0 {
+		r.lastID++
+		backpack.BackpackID = r.lastID
+	}
+	data := *backpack
+	r.list[backpack.BackpackID] = &data
+	return nil
+}
+
+// 凍結商品數量
+func (r *MemoryBackpackRepo) HoldProduct(userId int64, productName string, count int64) error {
+	return r.updateCount(userId, productName, model.Error_ProductNotEnough, func(backpack *model.Backpack) error {
+		return backpack.Hold(count)
+	})
+}
+
+// 解除凍結 歸還可用數量
+func (r *MemoryBackpackRepo) ReleaseProduct(userId int64, productName string, count int64) error {
+	return r.updateCount(userId, productName, model.Error_HoldNotEnough, func(backpack *model.Backpack) error {
+		return backpack.Release(count)
+	})
+}
+
+// 賣單成交 扣除凍結數量
+func (r *MemoryBackpackRepo) DebitHoldProduct(userId int64, productName string, count int64) error {
+	return r.updateCount(userId, productName, model.Error_HoldNotEnough, func(backpack *model.Backpack) error {
+		return backpack.DebitHold(count)
+	})
+}
+
+// 買單成交 增加可用數量, 沒有背包就建立
+func (r *MemoryBackpackRepo) AddProduct(userId int64, productName string, count int64) error {
+	err := r.updateCount(userId, productName, gorm.ErrRecordNotFound, func(backpack *model.Backpack) error {
+		backpack.ProductCount += count
+		backpack.UodateAt = time.Now()
+		return nil
+	})
+	if err != gorm.ErrRecordNotFound {
+		return err
+	}
+	return r.Save(&model.Backpack{
+		UserID:       userId,
+		ProductName:  productName,
+		ProductCount: count,
+		CreatedAt:    time.Now(),
+		UodateAt:     time.Now(),
+	})
+}
+
+// 在鎖內 讀取 修改 寫回 (與 db 的條件更新相同 不會覆蓋同時的修改), 找不到背包 回傳 notFound
+func (r *MemoryBackpackRepo) updateCount(userId int64, productName string, notFound error, update func(backpack *model.Backpack) error) error {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	for backpackID, backpack := range r.list {
+		if backpack.UserID != userId || backpack.ProductName != productName {
+			continue
+		}
+		data := *backpack
+		if err := update(&data); err != nil {
+			return err
+		}
+		r.list[backpackID] = &data
+		return nil
+	}
+	return notFound
+}
+
+// 記錄目前的資料, 回傳還原到此時的函式 (事務 Rollback 使用)
+// 儲存的物件都是複製的 不會被修改, 只需複製清單
+func (r *MemoryBackpackRepo) Savepoint() func() {
+	r.lock.RLock()
+	lastID := r.lastID
+	list := make(map[int64]*model.Backpack, len(r.list))
+	for backpackID, backpack := range r.list {
+		list[backpackID] = backpack
+	}
+	r.lock.RUnlock()
+
+	return func() {
+		r.lock.Lock()
+		defer r.lock.Unlock()
+		r.lastID = lastID
+		r.list = list
+	}
+}
+
+// 取得背包內持有商品資訊
+func (r *MemoryBackpackRepo) GetBackpackById(backpackId int64) (*model.Backpack, error) {
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+
+	backpack, ok := r.list[backpackId]
+	if !ok {
+		return nil, gorm.ErrRecordNotFound
+	}
+	data := *backpack
+	return &data, nil
+}
+
+// 取得背包內持有商品資訊
+func (r *MemoryBackpackRepo) GetBackpackByUserId(userId int64, productName string) (*model.Backpack, error) {
+	list, err := r.FindAll(userId)
+	if err != nil {
+		return nil, err
+	}
+	for _, backpack := range list {
+		if backpack.ProductName == productName {
+			return backpack, nil
+		}
+	}
+	return nil, gorm.ErrRecordNotFound
+}
+
+// 取得用戶全部的背包, 依背包ID排列
+func (r *MemoryBackpackRepo) FindAll(userId int64) (list []*model.Backpack, err error) {
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+
+	for _, backpack := range r.list {
+		if backpack.UserID == userId {
+			data := *backpack
+			list = append(list, &data)
+		}
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].BackpackID < list[j].BackpackID })
+	return list, nil
+}
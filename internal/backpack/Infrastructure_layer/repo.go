@@ -3,16 +3,22 @@ package Infrastructure_layer
 import (
 	"marketplace_server/internal/backpack/model"
 	"marketplace_server/internal/common/logs"
+	"time"
 
 	"github.com/jinzhu/gorm"
 )
 
 // 用戶背包
+// 商品數量的異動 (凍結 解除凍結 成交) 使用條件更新, 不讀出整筆再寫回 避免同時修改互相覆蓋
 type BackpackRepo interface {
 	Save(backpack *model.Backpack) error
 	GetBackpackById(backpackId int64) (*model.Backpack, error)
 	GetBackpackByUserId(userId int64, productName string) (*model.Backpack, error)
 	FindAll(userId int64) (list []*model.Backpack, err error)
+	HoldProduct(userId int64, productName string, count int64) error      // 凍結商品數量 (可用數量足夠才更新)
+	ReleaseProduct(userId int64, productName string, count int64) error   // 解除凍結 歸還可用數量 (凍結數量足夠才更新)
+	DebitHoldProduct(userId int64, productName string, count int64) error // 賣單成交 扣除凍結數量 (凍結數量足夠才更新)
+	AddProduct(userId int64, productName string, count int64) error       // 買單成交 增加可用數量 (沒有背包就建立)
 }
 
 type MysqlBackpackRepo struct {
@@ -52,6 +58,66 @@ func (r *MysqlBackpackRepo) GetBackpackByUserId(userId int64, productName string
 	return backpackPO.ToDomain()
 }
 
+// 凍結商品數量
+func (r *MysqlBackpackRepo) HoldProduct(userId int64, productName string, count int64) error {
+	return r.updateCount(userId, productName, "product_count >= ?", count, model.Error_ProductNotEnough, map[string]interface{}{
+		"product_count": gorm.Expr("product_count - ?", count),
+		"hold_count":    gorm.Expr("hold_count + ?", count),
+	})
+}
+
+// 解除凍結 歸還可用數量
+func (r *MysqlBackpackRepo) ReleaseProduct(userId int64, productName string, count int64) error {
+	return r.updateCount(userId, productName, "hold_count >= ?", count, model.Error_HoldNotEnough, map[string]interface{}{
+		"product_count": gorm.Expr("product_count + ?", count),
+		"hold_count":    gorm.Expr("hold_count - ?", count),
+	})
+}
+
+// 賣單成交 扣除凍結數量
+func (r *MysqlBackpackRepo) DebitHoldProduct(userId int64, productName string, count int64) error {
+	return r.updateCount(userId, productName, "hold_count >= ?", count, model.Error_HoldNotEnough, map[string]interface{}{
+		"hold_count": gorm.Expr("hold_count - ?", count),
+	})
+}
+
+// 買單成交 增加可用數量, 沒有背包就建立
+func (r *MysqlBackpackRepo) AddProduct(userId int64, productName string, count int64) error {
+	err := r.updateCount(userId, productName, "", count, gorm.ErrRecordNotFound, map[string]interface{}{
+		"product_count": gorm.Expr("product_count + ?", count),
+	})
+	if err != gorm.ErrRecordNotFound {
+		return err
+	}
+	return r.Save(&model.Backpack{
+		UserID:       userId,
+		ProductName:  productName,
+		ProductCount: count,
+		CreatedAt:    time.Now(),
+		UodateAt:     time.Now(),
+	})
+}
+
+// 以條件更新商品數量 (條件使用 db 目前的數量 參數為 count), 沒有更新任何資料 回傳 notEnough
+func (r *MysqlBackpackRepo) updateCount(userId int64, productName, condition string, count int64, notEnough error, fields map[string]interface{}) error {
+	if count <= 0 {
+		return notEnough
+	}
+	fields["uodate_at"] = time.Now()
+	db := r.db.Model(&model.Backpack_PO{}).Where("user_id = ? AND product_name = ?", userId, productName)
+	if len(condition) > 0 {
+		db = db.Where(condition, count)
+	}
+	result := db.Updates(fields)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return notEnough
+	}
+	return nil
+}
+
 func (r *MysqlBackpackRepo) FindAll(userId int64) (list []*model.Backpack, err error) {
 	var poList []model.Backpack_PO
 	var db = r.db
@@ -8,7 +8,8 @@ type Backpack struct {
 	BackpackID   int64     // 背包ID
 	UserID       int64     // 持有人
 	ProductName  string    // 產品名稱
-	ProductCount int64     // 產品數量
+	ProductCount int64     // 產品數量 (可用)
+	HoldCount    int64     // 凍結數量 (掛賣單中)
 	CreatedAt    time.Time // 創建時間
 	UodateAt     time.Time // 更新時間
 }
@@ -19,7 +20,40 @@ func (b *Backpack) ToPO() *Backpack_PO {
 		UserID:       b.UserID,
 		ProductName:  b.ProductName,
 		ProductCount: b.ProductCount,
+		HoldCount:    b.HoldCount,
 		CreatedAt:    b.CreatedAt,
 		UodateAt:     b.UodateAt,
 	}
 }
+
+// 掛賣單 凍結商品數量
+func (b *Backpack) Hold(count int64) error {
+	if count <= 0 || b.ProductCount < count {
+		return Error_ProductNotEnough
+	}
+	b.ProductCount -= count
+	b.HoldCount += count
+	b.UodateAt = time.Now()
+	return nil
+}
+
+// 取消賣單 解除凍結 歸還可用數量
+func (b *Backpack) Release(count int64) error {
+	if count <= 0 || b.HoldCount < count {
+		return Error_HoldNotEnough
+	}
+	b.HoldCount -= count
+	b.ProductCount += count
+	b.UodateAt = time.Now()
+	return nil
+}
+
+// 賣單成交 扣除凍結數量
+func (b *Backpack) DebitHold(count int64) error {
+	if count <= 0 || b.HoldCount < count {
+		return Error_HoldNotEnough
+	}
+	b.HoldCount -= count
+	b.UodateAt = time.Now()
+	return nil
+}
@@ -9,6 +9,8 @@ var (
 	Error_UserIDIsEmpty     = errors.New("user_id is empty")
 	Error_BackpackIDIsEmpty = errors.New("backpack_id is empty")
 	Error_ConvertFailed     = errors.New("convert failed")
+	Error_ProductNotEnough  = errors.New("商品數量不足")
+	Error_HoldNotEnough     = errors.New("凍結數量不足")
 )
 
 type Backpack_PO struct {
@@ -16,6 +18,7 @@ type Backpack_PO struct {
 	UserID       int64     `gorm:"column:user_id; comment:'用戶ID'" `
 	ProductName  string    `gorm:"size:256;not null; comment:'產品名稱'" json:"product_name"`
 	ProductCount int64     `gorm:"type:bigint(20);comment:'產品數量'" json:"product_count"`
+	HoldCount    int64     `gorm:"type:bigint(20);default:0;comment:'凍結數量'" json:"hold_count"`
 	CreatedAt    time.Time `gorm:"autoCreateTime;comment:'創建時間'" json:"created_at"`
 	UodateAt     time.Time `gorm:"autoUpdateTime;comment:'更新時間'" json:"update_at"`
 }
@@ -38,6 +41,7 @@ func (b *Backpack_PO) ToDomain() (*Backpack, error) {
 		UserID:       b.UserID,
 		ProductName:  b.ProductName,
 		ProductCount: b.ProductCount,
+		HoldCount:    b.HoldCount,
 		CreatedAt:    b.CreatedAt,
 		UodateAt:     b.UodateAt,
 	}
@@ -0,0 +1,198 @@
+package Infrastructure_layer
+
+import (
+	"marketplace_server/internal/bill/model"
+	"sort"
+	"sync"
+
+	"github.com/jinzhu/gorm"
+)
+
+// 記憶體的交易持久層 (回放工具 測試使用, 不需要 mysql)
+// 讀取 與 寫入 都複製一份, 與 db 相同 修改取出的物件不影響已儲存的資料
+type MemoryTransactionRepo struct {
+	lock   sync.RWMutex
+	lastID int64
+	list   map[string]*model.Transaction // key=交易單號
+}
+
+var _ TransactionRepo = &MemoryTransactionRepo{}
+
+func NewMemoryTransactionRepo() *MemoryTransactionRepo {
+	return &MemoryTransactionRepo{list: make(map[string]*model.Transaction)}
+}
+
+func (r *MemoryTransactionRepo) Save(transaction *model.Transaction) error {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	if transaction.ID == 0 {
+		if old, ok := r.list[transaction.TransactionID]; ok {
+			transaction.ID = old.ID
+		} else {
+			r.lastID++
+			transaction.ID = r.lastID
+		}
+	}
+	data := *transaction
+	r.list[transaction.TransactionID] = &data
+	return nil
+}
+
+// 取得交易資訊
+func (r *MemoryTransactionRepo) GetTransactionInfo(transactionId string) (*model.Transaction, error) {
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+
+	transaction, ok := r.list[transactionId]
+	if !ok {
+		return nil, gorm.ErrRecordNotFound
+	}
+	data := *transaction
+	return &data, nil
+}
+
+func (r *MemoryTransactionRepo) GetLastInsterId() (int64, error) {
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+
+	if r.lastID == 0 {
+		return 0, gorm.ErrRecordNotFound
+	}
+	return r.lastID, nil
+}
+
+// 取得商品等待搓合的訂單 (未完成 或 部分成交), 依建立順序排列
+func (r *MemoryTransactionRepo) GetWaitTransactionListByProduct(productName string) ([]*model.Transaction, error) {
+	var list []*model.Transaction
+	for _, transaction := range r.GetTransactionList() {
+		switch model.Transaction_Status(transaction.Status) {
+		case model.Transaction_Status_Wait, model.Transaction_Status_PartialFilled:
+		default:
+			continue
+		}
+		if transaction.ProductName == productName {
+			list = append(list, transaction)
+		}
+	}
+	return list, nil
+}
+
+// 記錄目前的資料, 回傳還原到此時的函式 (事務 Rollback 使用)
+// 儲存的物件都是複製的 不會被修改, 只需複製清單
+func (r *MemoryTransactionRepo) Savepoint() func() {
+	r.lock.RLock()
+	lastID := r.lastID
+	list := make(map[string]*model.Transaction, len(r.list))
+	for transactionID, transaction := range r.list {
+		list[transactionID] = transaction
+	}
+	r.lock.RUnlock()
+
+	return func() {
+		r.lock.Lock()
+		defer r.lock.Unlock()
+		r.lastID = lastID
+		r.list = list
+	}
+}
+
+// 取得全部的交易, 依建立順序排列
+func (r *MemoryTransactionRepo) GetTransactionList() []*model.Transaction {
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+
+	list := make([]*model.Transaction, 0, len(r.list))
+	for _, transaction := range r.list {
+		data := *transaction
+		list = append(list, &data)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].ID < list[j].ID })
+	return list
+}
+
+// 記憶體的成交紀錄持久層
+type MemoryTradeRepo struct {
+	lock sync.RWMutex
+	list []*model.Trade // 依成交順序
+}
+
+var _ TradeRepo = &MemoryTradeRepo{}
+
+func NewMemoryTradeRepo() *MemoryTradeRepo {
+	return &MemoryTradeRepo{}
+}
+
+func (r *MemoryTradeRepo) Save(trade *model.Trade) error {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	if trade.ID > 0 && trade.ID <= int64(len(r.list)) {
+		data := *trade
+		r.list[trade.ID-1] = &data
+		return nil
+	}
+	trade.ID = int64(len(r.list)) + 1
+	data := *trade
+	r.list = append(r.list, &data)
+	return nil
+}
+
+func (r *MemoryTradeRepo) GetLastInsterId() (int64, error) {
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+
+	if len(r.list) == 0 {
+		return 0, gorm.ErrRecordNotFound
+	}
+	return int64(len(r.list)), nil
+}
+
+// 取得訂單的成交紀錄 (買方或賣方), 依成交順序排列
+func (r *MemoryTradeRepo) GetTradeListByTransaction(transactionId string) ([]*model.Trade, error) {
+	var list []*model.Trade
+	for _, trade := range r.GetTradeList() {
+		if trade.BuyTransactionID == transactionId || trade.SellTransactionID == transactionId {
+			list = append(list, trade)
+		}
+	}
+	return list, nil
+}
+
+// 取得商品最近的成交紀錄, 依成交時間由新到舊
+func (r *MemoryTradeRepo) GetTradeListByProduct(productName string, limit int) ([]*model.Trade, error) {
+	var list []*model.Trade
+	trades := r.GetTradeList()
+	for i := len(trades) - 1; i >= 0 && (limit <= 0 || len(list) < limit); i-- {
+		if trades[i].ProductName == productName {
+			list = append(list, trades[i])
+		}
+	}
+	return list, nil
+}
+
+// 記錄目前的資料, 回傳還原到此時的函式 (事務 Rollback 使用)
+func (r *MemoryTradeRepo) Savepoint() func() {
+	r.lock.RLock()
+	list := append([]*model.Trade(nil), r.list...)
+	r.lock.RUnlock()
+
+	return func() {
+		r.lock.Lock()
+		defer r.lock.Unlock()
+		r.list = list
+	}
+}
+
+// 取得全部的成交紀錄, 依成交順序排列
+func (r *MemoryTradeRepo) GetTradeList() []*model.Trade {
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+
+	list := make([]*model.Trade, 0, len(r.list))
+	for _, trade := range r.list {
+		data := *trade
+		list = append(list, &data)
+	}
+	return list
+}
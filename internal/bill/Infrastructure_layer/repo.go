@@ -10,6 +10,7 @@ type TransactionRepo interface {
 	Save(transaction *model.Transaction) error
 	GetTransactionInfo(transactionId string) (*model.Transaction, error)
 	GetLastInsterId() (int64, error)
+	GetWaitTransactionListByProduct(productName string) ([]*model.Transaction, error) // 取得等待搓合的訂單
 }
 
 type MysqlTransactionRepo struct {
@@ -47,3 +48,26 @@ func (r *MysqlTransactionRepo) GetLastInsterId() (int64, error) {
 
 	return transactionPO.ID, nil
 }
+
+// 取得商品等待搓合的訂單 (未完成 或 部分成交), 依建立順序排列
+func (r *MysqlTransactionRepo) GetWaitTransactionListByProduct(productName string) ([]*model.Transaction, error) {
+	var poList []model.Transaction_PO
+	var db = r.db
+
+	status := []int8{int8(model.Transaction_Status_Wait), int8(model.Transaction_Status_PartialFilled)}
+	if err := db.Where("product_name = ? AND status IN (?)", productName, status).Order("id").Find(&poList).Error; err != nil {
+		return nil, err
+	}
+
+	// 轉成領域物件
+	var list []*model.Transaction
+	for _, data := range poList {
+		domainObj, err := data.ToDomain()
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, domainObj)
+	}
+
+	return list, nil
+}
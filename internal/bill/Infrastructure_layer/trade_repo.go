@@ -0,0 +1,84 @@
+package Infrastructure_layer
+
+import (
+	"marketplace_server/internal/bill/model"
+
+	"github.com/jinzhu/gorm"
+)
+
+const (
+	Redis_TradeChannel = "product:trade:channel:%s" // redis 商品的成交頻道 (pub/sub) %s=商品名稱
+)
+
+type TradeRepo interface {
+	Save(trade *model.Trade) error
+	GetLastInsterId() (int64, error)
+	GetTradeListByTransaction(transactionId string) ([]*model.Trade, error)      // 取得訂單的成交紀錄
+	GetTradeListByProduct(productName string, limit int) ([]*model.Trade, error) // 取得商品最近的成交紀錄
+}
+
+type MysqlTradeRepo struct {
+	db *gorm.DB
+}
+
+func NewMysqlTradeRepo(db *gorm.DB) *MysqlTradeRepo {
+	return &MysqlTradeRepo{db: db}
+}
+
+func (r *MysqlTradeRepo) Save(trade *model.Trade) error {
+	tradePO := trade.ToPO()
+	if err := r.db.Save(tradePO).Error; err != nil {
+		return err
+	}
+	trade.ID = tradePO.ID
+	return nil
+}
+
+func (r *MysqlTradeRepo) GetLastInsterId() (int64, error) {
+	var tradePO model.Trade_PO
+	var db = r.db
+
+	if err := db.Last(&tradePO).Error; err != nil {
+		return 0, err
+	}
+
+	return tradePO.ID, nil
+}
+
+// 取得訂單的成交紀錄 (買方或賣方), 依成交順序排列
+func (r *MysqlTradeRepo) GetTradeListByTransaction(transactionId string) ([]*model.Trade, error) {
+	var poList []model.Trade_PO
+	var db = r.db
+
+	if err := db.Where("buy_transaction_id = ? OR sell_transaction_id = ?", transactionId, transactionId).
+		Order("id").Find(&poList).Error; err != nil {
+		return nil, err
+	}
+
+	return toTradeList(poList)
+}
+
+// 取得商品最近的成交紀錄, 依成交時間由新到舊
+func (r *MysqlTradeRepo) GetTradeListByProduct(productName string, limit int) ([]*model.Trade, error) {
+	var poList []model.Trade_PO
+	var db = r.db
+
+	if err := db.Where("product_name = ?", productName).Order("id desc").Limit(limit).Find(&poList).Error; err != nil {
+		return nil, err
+	}
+
+	return toTradeList(poList)
+}
+
+// 轉成領域物件
+func toTradeList(poList []model.Trade_PO) ([]*model.Trade, error) {
+	var list []*model.Trade
+	for _, data := range poList {
+		domainObj, err := data.ToDomain()
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, domainObj)
+	}
+	return list, nil
+}
@@ -0,0 +1,137 @@
+package domain_layer
+
+import (
+	"fmt"
+	"marketplace_server/config"
+	"marketplace_server/internal/common/logs"
+
+	"github.com/shopspring/decimal"
+)
+
+// 手續費小數位數 (與 db 金額欄位一致)
+const FeePrecision = 2
+
+type FeeService interface {
+	PlatformUserID() int64                                                                          // 平台收入帳戶的用戶ID
+	CalcFee(productName string, vipLevel int, isMaker bool, amount decimal.Decimal) decimal.Decimal // 計算成交金額的手續費
+	MaxFee(productName string, vipLevel int, amount decimal.Decimal) decimal.Decimal                // 成交金額最多可能的手續費 (買單預扣)
+}
+
+var _ FeeService = &FeeServiceImpl{}
+
+// 費率
+type feeRate struct {
+	maker decimal.Decimal // 掛單方費率
+	taker decimal.Decimal // 吃單方費率
+}
+
+// 手續費 (maker / taker 費率, 商品費率覆蓋預設, vip 等級折扣)
+type FeeServiceImpl struct {
+	platformUserID int64
+	defaultRate    feeRate
+	productRates   map[string]feeRate      // key=商品名稱
+	vipDiscounts   map[int]decimal.Decimal // key=vip 等級
+}
+
+func NewFeeService(cfg config.Fee) (*FeeServiceImpl, error) {
+
+	f := &FeeServiceImpl{
+		platformUserID: cfg.PlatformUserID,
+		productRates:   make(map[string]feeRate),
+		vipDiscounts:   make(map[int]decimal.Decimal),
+	}
+
+	// 沒有平台收入帳戶 就不收手續費, 否則收到的手續費無處可去
+	if cfg.PlatformUserID <= 0 {
+		logs.Warnf("platformUserID:%d 不收手續費", cfg.PlatformUserID)
+		return f, nil
+	}
+
+	var err error
+	if f.defaultRate, err = parseFeeRate(cfg.MakerRate, cfg.TakerRate); err != nil {
+		return nil, err
+	}
+	for _, product := range cfg.Products {
+		rate, err := parseFeeRate(product.MakerRate, product.TakerRate)
+		if err != nil {
+			return nil, fmt.Errorf("productName:%v, err:%v", product.ProductName, err)
+		}
+		f.productRates[product.ProductName] = rate
+	}
+	for _, vip := range cfg.VipLevels {
+		discount, err := decimal.NewFromString(vip.Discount)
+		if err != nil || discount.IsNegative() {
+			return nil, fmt.Errorf("error vip discount level:%d, discount:%v", vip.Level, vip.Discount)
+		}
+		f.vipDiscounts[vip.Level] = discount
+	}
+
+	return f, nil
+}
+
+// 解析費率, 沒填當作 0
+func parseFeeRate(makerRate, takerRate string) (rate feeRate, err error) {
+
+	parse := func(s string) (decimal.Decimal, error) {
+		if len(s) == 0 {
+			return decimal.Zero, nil
+		}
+		d, err := decimal.NewFromString(s)
+		if err != nil || d.IsNegative() {
+			return decimal.Zero, fmt.Errorf("error fee rate:%v", s)
+		}
+		return d, nil
+	}
+
+	if rate.maker, err = parse(makerRate); err != nil {
+		return
+	}
+	rate.taker, err = parse(takerRate)
+	return
+}
+
+func (f *FeeServiceImpl) PlatformUserID() int64 {
+	return f.platformUserID
+}
+
+// 計算手續費 = 成交金額 * 費率 (商品費率優先) * vip 折扣
+func (f *FeeServiceImpl) CalcFee(productName string, vipLevel int, isMaker bool, amount decimal.Decimal) decimal.Decimal {
+
+	rate, ok := f.productRates[productName]
+	if !ok {
+		rate = f.defaultRate
+	}
+
+	fee := amount.Mul(rate.taker)
+	if isMaker {
+		fee = amount.Mul(rate.maker)
+	}
+	if discount, ok := f.vipDiscounts[vipLevel]; ok {
+		fee = fee.Mul(discount)
+	}
+
+	return fee.Round(FeePrecision)
+}
+
+// 最多可能的手續費 = 成交金額 * 掛單方 吃單方 較高的費率 * vip 折扣, 無條件進位
+// 下單時還不知道會是 maker 或 taker, 買單預扣時使用
+func (f *FeeServiceImpl) MaxFee(productName string, vipLevel int, amount decimal.Decimal) decimal.Decimal {
+
+	rate, ok := f.productRates[productName]
+	if !ok {
+		rate = f.defaultRate
+	}
+
+	fee := amount.Mul(decimal.Max(rate.maker, rate.taker))
+	if discount, ok := f.vipDiscounts[vipLevel]; ok {
+		fee = fee.Mul(discount)
+	}
+
+	return fee.RoundCeil(FeePrecision)
+}
+
+// 買單需要預扣的金額 = (價格 * 數量 + 最多可能的手續費) * 匯率 (報價幣種 -> 用戶的幣種)
+func HoldAmount(fee FeeService, productName string, vipLevel int, price decimal.Decimal, count int64, rate decimal.Decimal) decimal.Decimal {
+	amount := price.Mul(decimal.NewFromInt(count))
+	return amount.Add(fee.MaxFee(productName, vipLevel, amount)).Mul(rate)
+}
@@ -0,0 +1,60 @@
+package model
+
+import (
+	"time"
+
+	"github.com/shopspring/decimal"
+)
+
+// 成交紀錄 (一筆訂單部分成交時 會有多筆成交紀錄)
+type Trade struct {
+	ID                int64           // 流水編號
+	TradeID           string          // 成交單號
+	ProductName       string          // 產品名稱
+	BuyTransactionID  string          // 買方交易單號
+	SellTransactionID string          // 賣方交易單號
+	BuyUserID         int64           // 買方用戶ID
+	SellUserID        int64           // 賣方用戶ID
+	Price             decimal.Decimal // 成交價
+	Count             int64           // 成交數量
+	Amount            decimal.Decimal // 成交金額 = 成交價 * 成交數量
+	BuyFee            decimal.Decimal // 買方手續費
+	SellFee           decimal.Decimal // 賣方手續費
+	TakerMode         int             // 吃單方 0:買 1:賣 (另一方為掛單方)
+	Currency          string          // 貨幣 (商品的報價幣種)
+	BuyCurrency       string          // 買方結算幣種 (買方用戶的幣種)
+	BuySettleAmount   decimal.Decimal // 買方結算的成交金額 (買方幣種)
+	BuySettleFee      decimal.Decimal // 買方結算的手續費 (買方幣種)
+	BuyReleaseAmount  decimal.Decimal // 買方此次成交釋放的預扣金額 (買方幣種)
+	SellCurrency      string          // 賣方結算幣種 (賣方用戶的幣種)
+	SellSettleAmount  decimal.Decimal // 賣方結算的成交金額 (賣方幣種)
+	SellSettleFee     decimal.Decimal // 賣方結算的手續費 (賣方幣種)
+	ExecutedAt        time.Time       // 成交時間
+}
+
+func (b *Trade) ToPO() *Trade_PO {
+	return &Trade_PO{
+		ID:                b.ID,
+		TradeID:           b.TradeID,
+		ProductName:       b.ProductName,
+		BuyTransactionID:  b.BuyTransactionID,
+		SellTransactionID: b.SellTransactionID,
+		BuyUserID:         b.BuyUserID,
+		SellUserID:        b.SellUserID,
+		Price:             b.Price,
+		Count:             b.Count,
+		Amount:            b.Amount,
+		BuyFee:            b.BuyFee,
+		SellFee:           b.SellFee,
+		TakerMode:         b.TakerMode,
+		Currency:          b.Currency,
+		BuyCurrency:       b.BuyCurrency,
+		BuySettleAmount:   b.BuySettleAmount,
+		BuySettleFee:      b.BuySettleFee,
+		BuyReleaseAmount:  b.BuyReleaseAmount,
+		SellCurrency:      b.SellCurrency,
+		SellSettleAmount:  b.SellSettleAmount,
+		SellSettleFee:     b.SellSettleFee,
+		ExecutedAt:        b.ExecutedAt,
+	}
+}
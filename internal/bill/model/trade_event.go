@@ -0,0 +1,30 @@
+package model
+
+import (
+	"github.com/shopspring/decimal"
+)
+
+// 公開的成交事件 (搓合引擎成交後發佈, 不包含買賣雙方的資料)
+type TradeEvent struct {
+	TradeID     string          `json:"trade_id"`     // 成交單號
+	ProductName string          `json:"product_name"` // 產品名稱
+	Price       decimal.Decimal `json:"price"`        // 成交價
+	Count       int64           `json:"count"`        // 成交數量
+	Amount      decimal.Decimal `json:"amount"`       // 成交金額
+	TakerMode   int             `json:"taker_mode"`   // 吃單方 0:買 1:賣
+	Currency    string          `json:"currency"`     // 貨幣 (商品的報價幣種)
+	ExecutedAt  int64           `json:"executed_at"`  // 成交時間 unix 毫秒
+}
+
+func (b *Trade) ToEvent() *TradeEvent {
+	return &TradeEvent{
+		TradeID:     b.TradeID,
+		ProductName: b.ProductName,
+		Price:       b.Price,
+		Count:       b.Count,
+		Amount:      b.Amount,
+		TakerMode:   b.TakerMode,
+		Currency:    b.Currency,
+		ExecutedAt:  b.ExecutedAt.UnixNano() / 1e6,
+	}
+}
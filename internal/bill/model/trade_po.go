@@ -0,0 +1,75 @@
+package model
+
+import (
+	"errors"
+	"time"
+
+	"github.com/shopspring/decimal"
+)
+
+var (
+	Error_TradeIDIsEmpty = errors.New("trade_id is empty")
+)
+
+type Trade_PO struct {
+	ID                int64           `gorm:"primary_key;auto_increment;comment:'流水號 主鍵'" json:"id"`
+	TradeID           string          `gorm:"unique;not null; uniqueIndex; comment:'成交單號'" json:"trade_id"`
+	ProductName       string          `gorm:"size:256;not null; index; comment:'產品名稱'" json:"product_name"`
+	BuyTransactionID  string          `gorm:"size:64;not null; index; comment:'買方交易單號'" json:"buy_transaction_id"`
+	SellTransactionID string          `gorm:"size:64;not null; index; comment:'賣方交易單號'" json:"sell_transaction_id"`
+	BuyUserID         int64           `gorm:"column:buy_user_id; comment:'買方用戶ID'" json:"buy_user_id"`
+	SellUserID        int64           `gorm:"column:sell_user_id; comment:'賣方用戶ID'" json:"sell_user_id"`
+	Price             decimal.Decimal `gorm:"type:decimal(20,2);default:0; comment:'成交價'" json:"price"`
+	Count             int64           `gorm:"type:bigint(20);default:0; comment:'成交數量'" json:"count"`
+	Amount            decimal.Decimal `gorm:"type:decimal(20,2);default:0; comment:'成交金額'" json:"amount"`
+	BuyFee            decimal.Decimal `gorm:"type:decimal(20,2);default:0; comment:'買方手續費'" json:"buy_fee"`
+	SellFee           decimal.Decimal `gorm:"type:decimal(20,2);default:0; comment:'賣方手續費'" json:"sell_fee"`
+	TakerMode         int             `gorm:"type:int(12);default:0; comment:'吃單方 0:買 1:賣'" json:"taker_mode"`
+	Currency          string          `gorm:"size:32;not null; comment:'幣種'" json:"currency"`
+	BuyCurrency       string          `gorm:"size:32; comment:'買方結算幣種'" json:"buy_currency"`
+	BuySettleAmount   decimal.Decimal `gorm:"type:decimal(20,2);default:0; comment:'買方結算的成交金額'" json:"buy_settle_amount"`
+	BuySettleFee      decimal.Decimal `gorm:"type:decimal(20,2);default:0; comment:'買方結算的手續費'" json:"buy_settle_fee"`
+	BuyReleaseAmount  decimal.Decimal `gorm:"type:decimal(20,2);default:0; comment:'買方釋放的預扣金額'" json:"buy_release_amount"`
+	SellCurrency      string          `gorm:"size:32; comment:'賣方結算幣種'" json:"sell_currency"`
+	SellSettleAmount  decimal.Decimal `gorm:"type:decimal(20,2);default:0; comment:'賣方結算的成交金額'" json:"sell_settle_amount"`
+	SellSettleFee     decimal.Decimal `gorm:"type:decimal(20,2);default:0; comment:'賣方結算的手續費'" json:"sell_settle_fee"`
+	ExecutedAt        time.Time       `gorm:"comment:'成交時間'" json:"executed_at"`
+}
+
+func (Trade_PO) TableName() string {
+	return "trade"
+}
+
+func (t *Trade_PO) ToDomain() (*Trade, error) {
+
+	if len(t.TradeID) == 0 {
+		return nil, Error_TradeIDIsEmpty
+	}
+
+	trade := &Trade{
+		ID:                t.ID,
+		TradeID:           t.TradeID,
+		ProductName:       t.ProductName,
+		BuyTransactionID:  t.BuyTransactionID,
+		SellTransactionID: t.SellTransactionID,
+		BuyUserID:         t.BuyUserID,
+		SellUserID:        t.SellUserID,
+		Price:             t.Price,
+		Count:             t.Count,
+		Amount:            t.Amount,
+		BuyFee:            t.BuyFee,
+		SellFee:           t.SellFee,
+		TakerMode:         t.TakerMode,
+		Currency:          t.Currency,
+		BuyCurrency:       t.BuyCurrency,
+		BuySettleAmount:   t.BuySettleAmount,
+		BuySettleFee:      t.BuySettleFee,
+		BuyReleaseAmount:  t.BuyReleaseAmount,
+		SellCurrency:      t.SellCurrency,
+		SellSettleAmount:  t.SellSettleAmount,
+		SellSettleFee:     t.SellSettleFee,
+		ExecutedAt:        t.ExecutedAt,
+	}
+
+	return trade, nil
+}
@@ -16,18 +16,27 @@ var (
 type Transaction_PO struct {
 	ID                int64           `gorm:"primary_key;auto_increment;comment:'流水號 主鍵'" json:"id"`
 	TransferMode      int             `gorm:"type:int(12);comment:'交易模式 0:買 1:賣'" json:"transfer_mode"`
-	TransferType      int             `gorm:"type:int(12);comment:'交易種類 0:限價 1:市價'" json:"transaction_type"`
+	TransferType      int             `gorm:"type:int(12);comment:'交易種類 0:限價 1:市價 2:停損市價 3:停損限價'" json:"transaction_type"`
 	TransactionID     string          `gorm:"unique;not null; uniqueIndex; comment:'交易訂單'" json:"transaction_id"`
 	FromUserID        int64           `gorm:"column:from_user_id; comment:'來源用戶ID'" `
 	ToUserID          int64           `gorm:"column:to_user_id; comment:'目的用戶ID'" `
 	ProductName       string          `gorm:"size:256;not null; comment:'產品名稱'" json:"product_name"`
 	ProductCount      int64           `gorm:"type:bigint(20);default:0; comment:'產品數量'" json:"product_count"`
+	Price             decimal.Decimal `gorm:"type:decimal(20,2);default:0; comment:'委託價格'" json:"price"`
+	TriggerPrice      decimal.Decimal `gorm:"type:decimal(20,2);default:0; comment:'觸發價格'" json:"trigger_price"`
+	TimeInForce       int             `gorm:"type:int(12);default:0; comment:'有效期限 0:GTC 1:IOC 2:FOK 3:GTD'" json:"time_in_force"`
+	ExpireTime        int64           `gorm:"type:bigint(20);default:0; comment:'到期時間 unix 秒'" json:"expire_time"`
+	FilledCount       int64           `gorm:"type:bigint(20);default:0; comment:'已成交數量'" json:"filled_count"`
+	RemainCount       int64           `gorm:"type:bigint(20);default:0; comment:'剩餘數量'" json:"remain_count"`
+	AvgPrice          decimal.Decimal `gorm:"type:decimal(20,2);default:0; comment:'平均成交價'" json:"avg_price"`
 	ProductNeedAmount decimal.Decimal `gorm:"type:decimal(20,2);default:0; comment:'商品需要的預扣金額'" json:"product_need_amount"`
+	HoldAmount        decimal.Decimal `gorm:"type:decimal(20,2);default:0; comment:'剩餘數量的預扣金額'" json:"hold_amount"`
 	Amount            decimal.Decimal `gorm:"type:decimal(20,2);default:0; comment:'實際交易金額'" json:"amount"`
 	Currency          string          `gorm:"size:32;not null; comment:'幣種'" json:"currency"`
+	QueueTime         int64           `gorm:"type:bigint(20);default:0; comment:'排隊時間 UnixNano'" json:"queue_time"`
 	CreatedAt         time.Time       `gorm:"autoCreateTime;comment:'創建時間'" json:"created_at"`
 	UodateAt          time.Time       `gorm:"autoUpdateTime;comment:'更新時間'" json:"update_at"`
-	Status            int8            `gorm:"type:tinyint(1);default:0;comment:'交易狀態 0:未完成 1:已完成 2:取消 3:錯誤'" json:"status"`
+	Status            int8            `gorm:"type:tinyint(1);default:0;comment:'交易狀態 0:未完成 1:已完成 2:取消 3:錯誤 4:部分成交 5:過期'" json:"status"`
 }
 
 func (Transaction_PO) TableName() string {
@@ -45,14 +54,25 @@ func (t *Transaction_PO) ToDomain() (*Transaction, error) {
 
 	user := &Transaction{
 		ID:                t.ID,
+		TransferMode:      t.TransferMode,
+		TransferType:      t.TransferType,
 		TransactionID:     t.TransactionID,
 		FromUserID:        t.FromUserID,
 		ToUserID:          t.ToUserID,
 		ProductName:       t.ProductName,
 		ProductCount:      t.ProductCount,
+		Price:             t.Price,
+		TriggerPrice:      t.TriggerPrice,
+		TimeInForce:       t.TimeInForce,
+		ExpireTime:        t.ExpireTime,
+		FilledCount:       t.FilledCount,
+		RemainCount:       t.RemainCount,
+		AvgPrice:          t.AvgPrice,
 		ProductNeedAmount: t.ProductNeedAmount,
+		HoldAmount:        t.HoldAmount,
 		Amount:            t.Amount,
 		Currency:          t.Currency,
+		QueueTime:         t.QueueTime,
 		CreatedAt:         t.CreatedAt,
 		UodateAt:          t.UodateAt,
 		Status:            t.Status,
@@ -8,29 +8,107 @@ import (
 
 type Transaction_Status int8
 
+const HoldAmountPrecision = 2 // 預扣金額的小數位數 (與 db 的 decimal(20,2) 相同)
+
 const (
-	Transaction_Status_Wait   Transaction_Status = iota // 0:未完成
-	Transaction_Status_Finish                           // 1:已完成
-	Transaction_Status_Cancel                           // 2:取消
-	Transaction_Status_Error                            // 3:錯誤
+	Transaction_Status_Wait          Transaction_Status = iota // 0:未完成
+	Transaction_Status_Finish                                  // 1:已完成
+	Transaction_Status_Cancel                                  // 2:取消
+	Transaction_Status_Error                                   // 3:錯誤
+	Transaction_Status_PartialFilled                           // 4:部分成交
+	Transaction_Status_Expired                                 // 5:過期 (IOC FOK 未成交的部分 或 GTD 到期)
 )
 
 // 交易清單
 type Transaction struct {
 	ID                int64           // 流水編號
 	TransferMode      int             // 交易模式 0:買 1:賣
-	TransferType      int             // 交易種類 0:限價 1:市價
+	TransferType      int             // 交易種類 0:限價 1:市價 2:停損市價 3:停損限價
 	TransactionID     string          // 交易單號
 	FromUserID        int64           // 發起人的用戶ID
 	ToUserID          int64           // 交易對象的用戶ID
 	ProductName       string          // 產品名稱
 	ProductCount      int64           // 產品數量
-	ProductNeedAmount decimal.Decimal // 商品需要的預扣金額
-	Amount            decimal.Decimal // 成交實際金額
+	Price             decimal.Decimal // 委託價格 (限價單參考)
+	TriggerPrice      decimal.Decimal // 觸發價格 (停損單參考)
+	TimeInForce       int             // 有效期限 0:GTC 1:IOC 2:FOK 3:GTD
+	ExpireTime        int64           // 到期時間 unix 秒 (GTD 參考)
+	FilledCount       int64           // 已成交數量
+	RemainCount       int64           // 剩餘數量
+	AvgPrice          decimal.Decimal // 平均成交價
+	ProductNeedAmount decimal.Decimal // 商品需要的預扣金額 (下單時)
+	HoldAmount        decimal.Decimal // 剩餘數量的預扣金額 (買單, 成交 修改 結束時更新)
+	Amount            decimal.Decimal // 成交實際金額 (累計)
 	Currency          string          // 貨幣
+	QueueTime         int64           // 排隊時間 UnixNano (時間優先順序, 修改價格 或 增加數量 時更新)
 	CreatedAt         time.Time       // 創建時間
 	UodateAt          time.Time       // 更新時間
-	Status            int8            // 交易狀態 0:未完成 1:已完成 2:取消 3:錯誤
+	Status            int8            // 交易狀態 0:未完成 1:已完成 2:取消 3:錯誤 4:部分成交 5:過期
+}
+
+// 成交 (可部分成交), 更新成交數量 剩餘數量 平均成交價
+func (b *Transaction) Fill(count int64, price decimal.Decimal, toUserID int64) {
+
+	fillAmount := price.Mul(decimal.NewFromInt(count))
+	b.Amount = b.Amount.Add(fillAmount)
+	b.FilledCount += count
+	b.RemainCount -= count
+	b.AvgPrice = b.Amount.Div(decimal.NewFromInt(b.FilledCount))
+	b.ToUserID = toUserID
+	b.UodateAt = time.Now()
+
+	if b.RemainCount <= 0 {
+		b.RemainCount = 0
+		b.Status = int8(Transaction_Status_Finish)
+	} else {
+		b.Status = int8(Transaction_Status_PartialFilled)
+	}
+}
+
+// 是否等待搓合 (未完成 或 部分成交)
+func (b *Transaction) IsOpen() bool {
+	switch Transaction_Status(b.Status) {
+	case Transaction_Status_Wait, Transaction_Status_PartialFilled:
+		return true
+	}
+	return false
+}
+
+// 剩餘數量的預扣金額, 沒有記錄的舊資料 = 預扣金額 * 剩餘數量 / 產品數量
+func (b *Transaction) RemainHoldAmount() decimal.Decimal {
+	if b.RemainCount <= 0 {
+		return decimal.Zero
+	}
+	if !b.HoldAmount.IsZero() || b.ProductCount <= 0 {
+		return b.HoldAmount
+	}
+	return b.ProductNeedAmount.Mul(decimal.NewFromInt(b.RemainCount)).Div(decimal.NewFromInt(b.ProductCount)).Round(HoldAmountPrecision)
+}
+
+// count 數量的預扣金額 = 剩餘數量的預扣金額 * count / 剩餘數量 (依每單位的預扣金額)
+func (b *Transaction) HoldAmountOf(count int64) decimal.Decimal {
+	if b.RemainCount <= 0 {
+		return decimal.Zero
+	}
+	if count == b.RemainCount {
+		return b.RemainHoldAmount()
+	}
+	return b.RemainHoldAmount().Mul(decimal.NewFromInt(count)).Div(decimal.NewFromInt(b.RemainCount)).Round(HoldAmountPrecision)
+}
+
+// 成交 count 釋放的預扣金額, 全部成交時 釋放全部剩餘的預扣金額 (成交前呼叫)
+func (b *Transaction) ReleaseHoldAmount(count int64) decimal.Decimal {
+	if count >= b.RemainCount {
+		return b.RemainHoldAmount()
+	}
+	return b.HoldAmountOf(count)
+}
+
+// 成交 count 釋放預扣金額, 更新剩餘數量的預扣金額 並回傳釋放的金額 (成交前呼叫)
+func (b *Transaction) ReleaseHold(count int64) decimal.Decimal {
+	release := b.ReleaseHoldAmount(count)
+	b.HoldAmount = b.RemainHoldAmount().Sub(release)
+	return release
 }
 
 func (b *Transaction) ToPO() *Transaction_PO {
@@ -43,9 +121,18 @@ func (b *Transaction) ToPO() *Transaction_PO {
 		ToUserID:          b.ToUserID,
 		ProductName:       b.ProductName,
 		ProductCount:      b.ProductCount,
+		Price:             b.Price,
+		TriggerPrice:      b.TriggerPrice,
+		TimeInForce:       b.TimeInForce,
+		ExpireTime:        b.ExpireTime,
+		FilledCount:       b.FilledCount,
+		RemainCount:       b.RemainCount,
+		AvgPrice:          b.AvgPrice,
 		ProductNeedAmount: b.ProductNeedAmount,
+		HoldAmount:        b.HoldAmount,
 		Amount:            b.Amount,
 		Currency:          b.Currency,
+		QueueTime:         b.QueueTime,
 		CreatedAt:         b.CreatedAt,
 		UodateAt:          b.UodateAt,
 		Status:            b.Status,
@@ -1,8 +1,11 @@
 package rabbitmqx
 
 import (
+	"errors"
+	"fmt"
 	"log"
 	"marketplace_server/internal/common/logs"
+	"runtime/debug"
 	"time"
 
 	"github.com/streadway/amqp"
@@ -23,6 +26,45 @@ type Consumer struct {
 	autoDelete    bool
 	durable       bool
 	handler       func([]byte) error
+	ordered       bool          // 依序處理 (不開 goroutine)
+	maxRetry      int           // 處理失敗的重試次數, 超過就送到死信佇列 (0 = 重新入队 不限次數)
+	retryInterval time.Duration // 重試間隔
+	publish       publishFunc   // 發送到佇列 (送到死信佇列使用, nil 使用 channel)
+}
+
+// 發送訊息到指定的佇列
+type publishFunc func(queue string, msg amqp.Publishing) error
+
+const (
+	DeadLetterQueueSuffix = ".dlq"          // 死信佇列名稱 = 佇列名稱 + 後綴
+	DefaultRetryInterval  = time.Second * 1 // 預設重試間隔
+)
+
+// 不需要重試的錯誤 (例如 無法解析的訊息), 有死信佇列時 直接送到死信佇列
+type PermanentError struct {
+	Err error
+}
+
+func (e *PermanentError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *PermanentError) Unwrap() error {
+	return e.Err
+}
+
+// 包裝成不需要重試的錯誤
+func Permanent(err error) error {
+	if err == nil {
+		return nil
+	}
+	return &PermanentError{Err: err}
+}
+
+// 是否為不需要重試的錯誤
+func IsPermanent(err error) bool {
+	var permanent *PermanentError
+	return errors.As(err, &permanent)
 }
 
 func NewConsumer(uri, exchangeType, exchange, queue, routingKey, consumerTag string, autoDelete, durable bool, handler func([]byte) error) *Consumer {
@@ -42,6 +84,27 @@ func NewConsumer(uri, exchangeType, exchange, queue, routingKey, consumerTag str
 	return c
 }
 
+// 依序處理訊息 (同一個佇列 依收到的順序 一次處理一筆)
+func (c *Consumer) SetOrdered(ordered bool) *Consumer {
+	c.ordered = ordered
+	return c
+}
+
+// 處理失敗時 原地重試 maxRetry 次, 仍失敗就送到死信佇列 (queue + ".dlq"), 避免毒訊息無限重送
+func (c *Consumer) SetMaxRetry(maxRetry int, retryInterval time.Duration) *Consumer {
+	c.maxRetry = maxRetry
+	c.retryInterval = retryInterval
+	if c.retryInterval <= 0 {
+		c.retryInterval = DefaultRetryInterval
+	}
+	return c
+}
+
+// 死信佇列名稱
+func (c *Consumer) DeadLetterQueue() string {
+	return c.queue + DeadLetterQueueSuffix
+}
+
 func (c *Consumer) Start() error {
 	if err := c.Run(); err != nil {
 		return err
@@ -108,6 +171,22 @@ func (c *Consumer) Run() error {
 		return err
 	}
 
+	// declare dead letter queue
+	if c.maxRetry > 0 {
+		if _, err = c.channel.QueueDeclare(
+			c.DeadLetterQueue(), // name
+			true,                // durable
+			false,               // delete when usused
+			false,               // exclusive
+			false,               // no-wait
+			nil,                 // arguments
+		); err != nil {
+			_ = c.channel.Close()
+			_ = c.conn.Close()
+			return err
+		}
+	}
+
 	log.Printf("routingKey:%v, exchange:%v", c.routingKey, c.exchange)
 	// bind queue to exchagne by key
 	if err = c.channel.QueueBind(
@@ -203,13 +282,82 @@ func (c *Consumer) ReConnect() {
 
 func (c *Consumer) Handle(delivery <-chan amqp.Delivery) {
 	for d := range delivery {
-		go func(delivery amqp.Delivery) {
-			if err := c.handler(delivery.Body); err == nil {
-				_ = delivery.Ack(false)
-			} else {
-				// 重新入队，否则未确认的消息会持续占用内存
-				_ = delivery.Reject(true)
+		if c.ordered {
+			c.process(d)
+			continue
+		}
+		go c.process(d)
+	}
+}
+
+// 處理一筆訊息, 不需要重試的錯誤 直接送到死信佇列
+func (c *Consumer) process(delivery amqp.Delivery) {
+
+	for retry := 0; ; retry++ {
+		err := c.handle(delivery.Body)
+		if err == nil {
+			_ = delivery.Ack(false)
+			return
+		}
+
+		if c.maxRetry <= 0 {
+			if IsPermanent(err) {
+				// 沒有死信佇列, 重新入队也不會成功 記錄後丟棄
+				logs.Errorf("rabbitmq consumer - 丟棄無法處理的訊息 err:%v, body:%s", err, string(delivery.Body))
+				_ = delivery.Reject(false)
+				return
 			}
-		}(d)
+			// 重新入队，否则未确认的消息会持续占用内存
+			_ = delivery.Reject(true)
+			return
+		}
+
+		if IsPermanent(err) || retry >= c.maxRetry {
+			logs.Errorf("rabbitmq consumer - retry:%d 送到死信佇列:%v, err:%v, body:%s",
+				retry, c.DeadLetterQueue(), err, string(delivery.Body))
+			c.deadLetter(delivery)
+			return
+		}
+
+		logs.Warnf("rabbitmq consumer - handle fail retry:%d, err:%v", retry, err)
+		time.Sleep(c.retryInterval)
 	}
 }
+
+// 執行 handler, panic 轉成錯誤 (依序處理時 一筆訊息 panic 不能中斷消費)
+func (c *Consumer) handle(body []byte) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("handler panic:%v, stack:%s", r, string(debug.Stack()))
+		}
+	}()
+	return c.handler(body)
+}
+
+// 送到死信佇列, 成功才 ack, 失敗就重新入队
+func (c *Consumer) deadLetter(delivery amqp.Delivery) {
+	err := c.publishTo(c.DeadLetterQueue(), amqp.Publishing{
+		ContentType:  delivery.ContentType,
+		DeliveryMode: amqp.Persistent,
+		Body:         delivery.Body,
+	})
+	if err != nil {
+		log.Println("rabbitmq consumer - dead letter publish failed: ", err)
+		_ = delivery.Reject(true)
+		return
+	}
+	_ = delivery.Ack(false)
+}
+
+// 透過預設交换机 發送到指定的佇列
+func (c *Consumer) publishTo(queue string, msg amqp.Publishing) error {
+	if c.publish != nil {
+		return c.publish(queue, msg)
+	}
+	return c.channel.Publish(
+		"",    // default exchange
+		queue, // routing key = queue name
+		false,
+		false,
+		msg)
+}
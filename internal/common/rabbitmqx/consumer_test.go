@@ -0,0 +1,142 @@
+package rabbitmqx
+
+import (
+	"errors"
+	"marketplace_server/config"
+	"marketplace_server/internal/common/logs"
+	"os"
+	"testing"
+
+	"github.com/streadway/amqp"
+)
+
+func TestMain(m *testing.M) {
+	logs.Init(config.Log{Env: "prd"})
+	os.Exit(m.Run())
+}
+
+// 測試用的 ack 記錄
+type testAcknowledger struct {
+	acks    int
+	rejects []bool // 每次 reject 是否重新入队
+}
+
+func (a *testAcknowledger) Ack(tag uint64, multiple bool) error {
+	a.acks++
+	return nil
+}
+
+func (a *testAcknowledger) Nack(tag uint64, multiple bool, requeue bool) error {
+	a.rejects = append(a.rejects, requeue)
+	return nil
+}
+
+func (a *testAcknowledger) Reject(tag uint64, requeue bool) error {
+	a.rejects = append(a.rejects, requeue)
+	return nil
+}
+
+// 建立測試用的 consumer, handler 依序回傳 results (超過的次數回傳最後一個), 記錄送到死信佇列的訊息
+func newTestConsumer(maxRetry int, results ...error) (*Consumer, *int, *[]string) {
+	calls := 0
+	var deadLetters []string
+	c := NewConsumer("", "direct", "exchange", "queue", "key", "tag", false, true, func([]byte) error {
+		err := results[len(results)-1]
+		if calls < len(results) {
+			err = results[calls]
+		}
+		calls++
+		return err
+	})
+	if maxRetry > 0 {
+		c.SetMaxRetry(maxRetry, 1)
+	}
+	c.publish = func(queue string, msg amqp.Publishing) error {
+		deadLetters = append(deadLetters, queue+":"+string(msg.Body))
+		return nil
+	}
+	return c, &calls, &deadLetters
+}
+
+func Test_Consumer_RetryThenAck(t *testing.T) {
+	c, calls, deadLetters := newTestConsumer(3, errors.New("db down"), nil)
+	ack := &testAcknowledger{}
+
+	c.process(amqp.Delivery{Acknowledger: ack, Body: []byte("msg")})
+	if *calls != 2 || ack.acks != 1 || len(ack.rejects) != 0 || len(*deadLetters) != 0 {
+		t.Fatalf("calls:%d, ack:%+v, deadLetters:%v", *calls, ack, *deadLetters)
+	}
+}
+
+func Test_Consumer_RetryExhausted(t *testing.T) {
+	c, calls, deadLetters := newTestConsumer(2, errors.New("db down"))
+	ack := &testAcknowledger{}
+
+	// 重試 maxRetry 次後 送到死信佇列 並 ack
+	c.process(amqp.Delivery{Acknowledger: ack, Body: []byte("msg")})
+	if *calls != 3 || ack.acks != 1 || len(*deadLetters) != 1 || (*deadLetters)[0] != "queue.dlq:msg" {
+		t.Fatalf("calls:%d, ack:%+v, deadLetters:%v", *calls, ack, *deadLetters)
+	}
+}
+
+func Test_Consumer_PermanentDeadLetter(t *testing.T) {
+	c, calls, deadLetters := newTestConsumer(3, Permanent(errors.New("bad message")))
+	ack := &testAcknowledger{}
+
+	// 不需要重試的錯誤 直接送到死信佇列
+	c.process(amqp.Delivery{Acknowledger: ack, Body: []byte("{")})
+	if *calls != 1 || ack.acks != 1 || len(*deadLetters) != 1 {
+		t.Fatalf("calls:%d, ack:%+v, deadLetters:%v", *calls, ack, *deadLetters)
+	}
+}
+
+func Test_Consumer_DeadLetterPublishFail(t *testing.T) {
+	c, _, _ := newTestConsumer(1, Permanent(errors.New("bad message")))
+	c.publish = func(queue string, msg amqp.Publishing) error {
+		return errors.New("channel closed")
+	}
+	ack := &testAcknowledger{}
+
+	// 送到死信佇列失敗 重新入队, 不能遺失訊息
+	c.process(amqp.Delivery{Acknowledger: ack, Body: []byte("{")})
+	if ack.acks != 0 || len(ack.rejects) != 1 || !ack.rejects[0] {
+		t.Fatalf("ack:%+v", ack)
+	}
+}
+
+func Test_Consumer_NoDeadLetterQueue(t *testing.T) {
+	c, calls, _ := newTestConsumer(0, errors.New("db down"))
+	ack := &testAcknowledger{}
+
+	// 沒有死信佇列 暫時的錯誤 重新入队
+	c.process(amqp.Delivery{Acknowledger: ack, Body: []byte("msg")})
+	if *calls != 1 || len(ack.rejects) != 1 || !ack.rejects[0] {
+		t.Fatalf("calls:%d, ack:%+v", *calls, ack)
+	}
+
+	// 不需要重試的錯誤 丟棄
+	c, _, _ = newTestConsumer(0, Permanent(errors.New("bad message")))
+	ack = &testAcknowledger{}
+	c.process(amqp.Delivery{Acknowledger: ack, Body: []byte("{")})
+	if len(ack.rejects) != 1 || ack.rejects[0] {
+		t.Fatalf("ack:%+v", ack)
+	}
+}
+
+func Test_Consumer_HandlerPanic(t *testing.T) {
+	c := NewConsumer("", "direct", "exchange", "queue", "key", "tag", false, true, func([]byte) error {
+		panic("nil pointer")
+	}).SetMaxRetry(1, 1)
+	var deadLetters int
+	c.publish = func(queue string, msg amqp.Publishing) error {
+		deadLetters++
+		return nil
+	}
+	ack := &testAcknowledger{}
+
+	// panic 轉成錯誤 重試後送到死信佇列, 不中斷消費
+	c.process(amqp.Delivery{Acknowledger: ack, Body: []byte("msg")})
+	if ack.acks != 1 || deadLetters != 1 {
+		t.Fatalf("ack:%+v, deadLetters:%d", ack, deadLetters)
+	}
+}
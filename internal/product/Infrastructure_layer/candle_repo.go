@@ -0,0 +1,128 @@
+package Infrastructure_layer
+
+import (
+	"context"
+	"fmt"
+	"marketplace_server/internal/product/model"
+
+	"github.com/jinzhu/gorm"
+	redis "github.com/redis/go-redis/v9"
+)
+
+const (
+	Redis_Candle        = "product:candle:%s"         // rediskey 商品目前的K線 %s=商品名稱, field=週期
+	Redis_CandleChannel = "product:candle:channel:%s" // redis 商品的K線更新頻道 (pub/sub) %s=商品名稱
+)
+
+// 持久層 K線
+type CandleRepo interface {
+	Save(candle *model.Candle) error                                                         // 寫入已結束的K線 (相同週期與開始時間 覆蓋)
+	GetCandleList(params *model.CandleParams) ([]*model.Candle, error)                       // 取得已結束的K線, 依時間由舊到新
+	RedisSetCandle(candle *model.Candle) error                                               // 設定目前的K線
+	RedisGetCandle(productName string, interval model.CandleInterval) (*model.Candle, error) // 取得目前的K線, 沒有回傳 nil
+	RedisGetCandles(productName string) ([]*model.Candle, error)                             // 取得商品所有週期目前的K線
+}
+
+var _ CandleRepo = &CandleRepoManager{}
+
+type CandleRepoManager struct {
+	db          *gorm.DB      // 資料庫
+	redisClient *redis.Client // redis
+}
+
+func NewCandleRepoManager(db *gorm.DB, redisDb *redis.Client) *CandleRepoManager {
+	return &CandleRepoManager{db: db, redisClient: redisDb}
+}
+
+// 寫入已結束的K線 db, 重複寫入時以最新資料覆蓋
+func (r *CandleRepoManager) Save(candle *model.Candle) error {
+	candlePO := candle.ToPO()
+	candlePO.ID = 0
+	err := r.db.Where(model.Candle_PO{
+		ProductName: candlePO.ProductName,
+		Interval:    candlePO.Interval,
+		OpenTime:    candlePO.OpenTime,
+	}).Assign(candlePO).FirstOrCreate(&model.Candle_PO{}).Error
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+// 取得已結束的K線 db, 依時間由舊到新 (超過 limit 取最新的幾根)
+func (r *CandleRepoManager) GetCandleList(params *model.CandleParams) ([]*model.Candle, error) {
+	var poList []model.Candle_PO
+
+	db := r.db.Where("product_name = ? AND `interval` = ?", params.ProductName, string(params.Interval))
+	if params.Start > 0 {
+		db = db.Where("open_time >= ?", params.Start)
+	}
+	if params.End > 0 {
+		db = db.Where("open_time <= ?", params.End)
+	}
+	if params.Limit > 0 {
+		db = db.Limit(params.Limit)
+	}
+	if err := db.Order("open_time desc").Find(&poList).Error; err != nil {
+		return nil, err
+	}
+
+	list := make([]*model.Candle, 0, len(poList))
+	for i := len(poList) - 1; i >= 0; i-- {
+		list = append(list, poList[i].ToDomain())
+	}
+	return list, nil
+}
+
+// 設定目前的K線 redis
+func (r *CandleRepoManager) RedisSetCandle(candle *model.Candle) error {
+
+	jsonStr, err := candle.ToRedis().ToJson()
+	if err != nil {
+		return err
+	}
+
+	return r.redisClient.HSet(context.TODO(), fmt.Sprintf(Redis_Candle, candle.ProductName),
+		string(candle.Interval), jsonStr).Err()
+}
+
+// 取得目前的K線 redis, 沒有回傳 nil
+func (r *CandleRepoManager) RedisGetCandle(productName string, interval model.CandleInterval) (*model.Candle, error) {
+
+	jsonStr, err := r.redisClient.HGet(context.TODO(), fmt.Sprintf(Redis_Candle, productName), string(interval)).Result()
+	if err == redis.Nil {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	candleRedis, err := model.NewCandleRedis(jsonStr)
+	if err != nil {
+		return nil, err
+	}
+	return candleRedis.ToDomain(), nil
+}
+
+// 取得商品所有週期目前的K線 redis
+func (r *CandleRepoManager) RedisGetCandles(productName string) ([]*model.Candle, error) {
+
+	data, err := r.redisClient.HGetAll(context.TODO(), fmt.Sprintf(Redis_Candle, productName)).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	var list []*model.Candle
+	for _, interval := range model.CandleIntervals {
+		jsonStr, ok := data[string(interval)]
+		if !ok {
+			continue
+		}
+		candleRedis, err := model.NewCandleRedis(jsonStr)
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, candleRedis.ToDomain())
+	}
+	return list, nil
+}
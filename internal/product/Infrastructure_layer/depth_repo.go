@@ -0,0 +1,92 @@
+package Infrastructure_layer
+
+import (
+	"context"
+	"fmt"
+	"marketplace_server/internal/product/model"
+
+	redis "github.com/redis/go-redis/v9"
+)
+
+const (
+	Redis_Depth        = "product:depth:%s"         // rediskey 商品的深度快照 %s=商品名稱
+	Redis_DepthUpdates = "product:depth:updates:%s" // rediskey 商品最近的深度增量更新 (list) %s=商品名稱
+	Redis_DepthChannel = "product:depth:channel:%s" // redis 商品的深度增量更新頻道 (pub/sub) %s=商品名稱
+
+	DepthUpdateKeep = 1000 // 保留最近的增量更新筆數
+)
+
+// 持久層 深度
+type DepthRepo interface {
+	RedisSetDepth(snapshot *model.DepthSnapshot, update *model.DepthUpdate) error         // 寫入快照 與 增量更新, 並發佈到頻道
+	RedisGetDepth(productName string) (*model.DepthSnapshot, error)                       // 取得快照, 沒有回傳 nil
+	RedisGetDepthUpdates(productName string, fromSeq int64) ([]*model.DepthUpdate, error) // 取得序號大於 fromSeq 的增量更新
+}
+
+var _ DepthRepo = &RedisDepthRepo{}
+
+type RedisDepthRepo struct {
+	c *redis.Client
+}
+
+func NewRedisDepthRepo(c *redis.Client) *RedisDepthRepo {
+	return &RedisDepthRepo{c: c}
+}
+
+// 寫入快照 與 增量更新 (同一個 redis 交易), 並發佈到頻道
+func (r *RedisDepthRepo) RedisSetDepth(snapshot *model.DepthSnapshot, update *model.DepthUpdate) error {
+
+	snapshotStr, err := snapshot.ToJson()
+	if err != nil {
+		return err
+	}
+	updateStr, err := update.ToJson()
+	if err != nil {
+		return err
+	}
+
+	updatesKey := fmt.Sprintf(Redis_DepthUpdates, snapshot.ProductName)
+	_, err = r.c.TxPipelined(context.TODO(), func(pipe redis.Pipeliner) error {
+		pipe.Set(context.TODO(), fmt.Sprintf(Redis_Depth, snapshot.ProductName), snapshotStr, 0)
+		pipe.RPush(context.TODO(), updatesKey, updateStr)
+		pipe.LTrim(context.TODO(), updatesKey, -DepthUpdateKeep, -1)
+		pipe.Publish(context.TODO(), fmt.Sprintf(Redis_DepthChannel, snapshot.ProductName), updateStr)
+		return nil
+	})
+	return err
+}
+
+// 取得快照, 沒有回傳 nil
+func (r *RedisDepthRepo) RedisGetDepth(productName string) (*model.DepthSnapshot, error) {
+
+	jsonStr, err := r.c.Get(context.TODO(), fmt.Sprintf(Redis_Depth, productName)).Result()
+	if err == redis.Nil {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return model.NewDepthSnapshot(jsonStr)
+}
+
+// 取得序號大於 fromSeq 的增量更新 (依序號由舊到新)
+func (r *RedisDepthRepo) RedisGetDepthUpdates(productName string, fromSeq int64) ([]*model.DepthUpdate, error) {
+
+	list, err := r.c.LRange(context.TODO(), fmt.Sprintf(Redis_DepthUpdates, productName), 0, -1).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	updates := []*model.DepthUpdate{}
+	for _, jsonStr := range list {
+		update, err := model.NewDepthUpdate(jsonStr)
+		if err != nil {
+			return nil, err
+		}
+		if update.Seq > fromSeq {
+			updates = append(updates, update)
+		}
+	}
+	return updates, nil
+}
@@ -0,0 +1,227 @@
+package Infrastructure_layer
+
+import (
+	"marketplace_server/internal/product/model"
+	"sort"
+	"sync"
+
+	"github.com/jinzhu/gorm"
+)
+
+// 記憶體的產品持久層 與 市場價格 (回放工具 測試使用, 不需要 mysql redis)
+type MemoryProductRepo struct {
+	lock         sync.RWMutex
+	list         []*model.Product             // 依上架順序
+	marketPrices map[string]map[string]string // 市場價格 key=redis key, 商品名稱
+}
+
+var _ ProductRepo = &MemoryProductRepo{}
+
+func NewMemoryProductRepo() *MemoryProductRepo {
+	return &MemoryProductRepo{marketPrices: make(map[string]map[string]string)}
+}
+
+func (p *MemoryProductRepo) Save(product *model.Product) error {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	data := *product
+	data.ProductID = int64(len(p.list)) + 1
+	p.list = append(p.list, &data)
+	return nil
+}
+
+// 取得商品清單
+func (p *MemoryProductRepo) GetProductList() ([]*model.Product, error) {
+	p.lock.RLock()
+	defer p.lock.RUnlock()
+
+	list := make([]*model.Product, 0, len(p.list))
+	for _, product := range p.list {
+		data := *product
+		list = append(list, &data)
+	}
+	return list, nil
+}
+
+func (p *MemoryProductRepo) GetProductLastInsterId() (int64, error) {
+	p.lock.RLock()
+	defer p.lock.RUnlock()
+
+	if len(p.list) == 0 {
+		return 0, gorm.ErrRecordNotFound
+	}
+	return int64(len(p.list)), nil
+}
+
+// 取得商品價格
+func (p *MemoryProductRepo) RedisGetMarketPrice(key string) (data map[string]string, err error) {
+	p.lock.RLock()
+	defer p.lock.RUnlock()
+
+	data = make(map[string]string)
+	for productName, marketPriceJson := range p.marketPrices[key] {
+		data[productName] = marketPriceJson
+	}
+	return data, nil
+}
+
+// 設定商品價格
+func (p *MemoryProductRepo) RedisSetMarketPrice(key string, data map[string]string) (err error) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	if _, ok := p.marketPrices[key]; !ok {
+		p.marketPrices[key] = make(map[string]string)
+	}
+	for productName, marketPriceJson := range data {
+		p.marketPrices[key][productName] = marketPriceJson
+	}
+	return nil
+}
+
+// 記憶體的K線持久層
+type MemoryCandleRepo struct {
+	lock    sync.RWMutex
+	saved   map[string][]*model.Candle                        // 已結束的K線 key=商品名稱, 依開始時間排列
+	current map[string]map[model.CandleInterval]*model.Candle // 目前的K線 key=商品名稱, 週期
+}
+
+var _ CandleRepo = &MemoryCandleRepo{}
+
+func NewMemoryCandleRepo() *MemoryCandleRepo {
+	return &MemoryCandleRepo{
+		saved:   make(map[string][]*model.Candle),
+		current: make(map[string]map[model.CandleInterval]*model.Candle),
+	}
+}
+
+// 寫入已結束的K線, 重複寫入時以最新資料覆蓋
+func (r *MemoryCandleRepo) Save(candle *model.Candle) error {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	data := *candle
+	list := r.saved[candle.ProductName]
+	for i, old := range list {
+		if old.Interval == candle.Interval && old.OpenTime == candle.OpenTime {
+			list[i] = &data
+			return nil
+		}
+	}
+	list = append(list, &data)
+	sort.SliceStable(list, func(i, j int) bool { return list[i].OpenTime < list[j].OpenTime })
+	r.saved[candle.ProductName] = list
+	return nil
+}
+
+// 取得已結束的K線, 依時間由舊到新 (超過 limit 取最新的幾根)
+func (r *MemoryCandleRepo) GetCandleList(params *model.CandleParams) ([]*model.Candle, error) {
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+
+	list := []*model.Candle{}
+	for _, candle := range r.saved[params.ProductName] {
+		if candle.Interval != params.Interval ||
+			(params.Start > 0 && candle.OpenTime < params.Start) ||
+			(params.End > 0 && candle.OpenTime > params.End) {
+			continue
+		}
+		data := *candle
+		list = append(list, &data)
+	}
+	if params.Limit > 0 && len(list) > params.Limit {
+		list = list[len(list)-params.Limit:]
+	}
+	return list, nil
+}
+
+// 設定目前的K線
+func (r *MemoryCandleRepo) RedisSetCandle(candle *model.Candle) error {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	if _, ok := r.current[candle.ProductName]; !ok {
+		r.current[candle.ProductName] = make(map[model.CandleInterval]*model.Candle)
+	}
+	data := *candle
+	r.current[candle.ProductName][candle.Interval] = &data
+	return nil
+}
+
+// 取得目前的K線, 沒有回傳 nil
+func (r *MemoryCandleRepo) RedisGetCandle(productName string, interval model.CandleInterval) (*model.Candle, error) {
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+
+	candle, ok := r.current[productName][interval]
+	if !ok {
+		return nil, nil
+	}
+	data := *candle
+	return &data, nil
+}
+
+// 取得商品所有週期目前的K線
+func (r *MemoryCandleRepo) RedisGetCandles(productName string) ([]*model.Candle, error) {
+	var list []*model.Candle
+	for _, interval := range model.CandleIntervals {
+		candle, _ := r.RedisGetCandle(productName, interval)
+		if candle != nil {
+			list = append(list, candle)
+		}
+	}
+	return list, nil
+}
+
+// 記憶體的深度 (快照 與 最近的增量更新)
+type MemoryDepthRepo struct {
+	lock      sync.RWMutex
+	snapshots map[string]*model.DepthSnapshot // key=商品名稱
+	updates   map[string][]*model.DepthUpdate // key=商品名稱, 保留最近 DepthUpdateKeep 筆
+}
+
+var _ DepthRepo = &MemoryDepthRepo{}
+
+func NewMemoryDepthRepo() *MemoryDepthRepo {
+	return &MemoryDepthRepo{
+		snapshots: make(map[string]*model.DepthSnapshot),
+		updates:   make(map[string][]*model.DepthUpdate),
+	}
+}
+
+// 寫入快照 與 增量更新
+func (r *MemoryDepthRepo) RedisSetDepth(snapshot *model.DepthSnapshot, update *model.DepthUpdate) error {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	r.snapshots[snapshot.ProductName] = snapshot
+	updates := append(r.updates[snapshot.ProductName], update)
+	if len(updates) > DepthUpdateKeep {
+		updates = updates[len(updates)-DepthUpdateKeep:]
+	}
+	r.updates[snapshot.ProductName] = updates
+	return nil
+}
+
+// 取得快照, 沒有回傳 nil
+func (r *MemoryDepthRepo) RedisGetDepth(productName string) (*model.DepthSnapshot, error) {
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+
+	return r.snapshots[productName], nil
+}
+
+// 取得序號大於 fromSeq 的增量更新 (依序號由舊到新)
+func (r *MemoryDepthRepo) RedisGetDepthUpdates(productName string, fromSeq int64) ([]*model.DepthUpdate, error) {
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+
+	updates := []*model.DepthUpdate{}
+	for _, update := range r.updates[productName] {
+		if update.Seq > fromSeq {
+			updates = append(updates, update)
+		}
+	}
+	return updates, nil
+}
@@ -11,7 +11,8 @@ import (
 )
 
 const (
-	Redis_MarketPrice = "product:market_price" // rediskey 商品市場價格
+	Redis_MarketPrice   = "product:market_price"      // rediskey 商品市場價格
+	Redis_TickerChannel = "product:ticker:channel:%s" // redis 商品的市場價格變動頻道 (pub/sub) %s=商品名稱
 )
 
 // 持久層 產品
@@ -6,30 +6,39 @@ import (
 	"marketplace_server/internal/common/logs"
 	"marketplace_server/internal/product/Infrastructure_layer"
 	"marketplace_server/internal/product/model"
-	"time"
 )
 
 var (
 	Error_VerifyFailed         = errors.New("验证失败")
 	Error_ProductAlreadyExists = errors.New("商品已存在")
 	Error_RedisFail            = errors.New("取得redis失敗")
+	Error_DbFail               = errors.New("取得資料庫失敗")
+	Error_DepthNotFound        = errors.New("深度不存在")
+	Error_DepthSeqGap          = errors.New("深度序號不連續 請重新取得快照")
 )
 
 // [Application 層]
 type ProductAppInterface interface {
 	CreateProduct(product *model.ProductCreateParams) error                                                   // 建立商品
 	GetMarketPrice(marketPrice *model.MarketPriceParams) ([]*model.S2C_MarketPrice, map[string]string, error) // 取得市場價格
+	GetCandles(params *model.CandleParams) (*model.S2C_Candles, error)                                        // 取得K線
+	GetDepth(params *model.DepthParams) (*model.DepthSnapshot, error)                                         // 取得深度快照
+	GetDepthUpdates(params *model.DepthUpdateParams) ([]*model.DepthUpdate, error)                            // 取得快照之後的深度增量更新
 }
 
 var _ ProductAppInterface = &ProductApp{}
 
 type ProductApp struct {
 	ProductRepo Infrastructure_layer.ProductRepo
+	CandleRepo  Infrastructure_layer.CandleRepo
+	DepthRepo   Infrastructure_layer.DepthRepo
 }
 
-func NewProductApp(productRepo Infrastructure_layer.ProductRepo) *ProductApp {
+func NewProductApp(productRepo Infrastructure_layer.ProductRepo, candleRepo Infrastructure_layer.CandleRepo, depthRepo Infrastructure_layer.DepthRepo) *ProductApp {
 	return &ProductApp{
 		ProductRepo: productRepo,
+		CandleRepo:  candleRepo,
+		DepthRepo:   depthRepo,
 	}
 }
 
@@ -41,7 +50,24 @@ func (a *ProductApp) CreateProduct(product *model.ProductCreateParams) error {
 		return Error_ProductAlreadyExists
 	}
 
-	return a.ProductRepo.Save(params)
+	if err = a.ProductRepo.Save(params); err != nil {
+		return err
+	}
+
+	// 上架的市場價格 使用初始價格, 有集合競價時 由搓合引擎在競價結束後以成交價更新
+	marketPriceRedisStr, err := params.ToMarketPriceRedis().ToJson()
+	if err != nil {
+		return err
+	}
+	err = a.ProductRepo.RedisSetMarketPrice(Infrastructure_layer.Redis_MarketPrice,
+		map[string]string{params.ProductName: marketPriceRedisStr})
+	if err != nil {
+		logs.Errorf("redisSetMarketPrice fail productName:%v, err:%v", params.ProductName, err)
+		return Error_RedisFail
+	}
+
+	logs.Debugf("商品上架 product:%+v", params)
+	return nil
 }
 
 // 取得市場價格
@@ -66,13 +92,8 @@ func (a *ProductApp) GetMarketPrice(marketPrice *model.MarketPriceParams) ([]*mo
 		var marketPriceMap = make(map[string]string)
 		for _, data := range productList {
 
-			marketPriceRedis := model.MarketPriceRedis{
-				ProductCount: data.ProductCount,   // 商名數量
-				Currency:     data.Currency,       // 幣值
-				Amount:       data.BaseAmount,     // 使用初始價格 當 市場價格
-				UpdateTime:   time.Now().String(), // 更新時間
-			}
-			marketPriceRedisStr, err := marketPriceRedis.ToJson()
+			// 使用初始價格 當 市場價格
+			marketPriceRedisStr, err := data.ToMarketPriceRedis().ToJson()
 			if err != nil {
 				logs.Errorf("to json fail data:%+v, err:%v", data, err)
 				continue
@@ -112,10 +133,96 @@ func (a *ProductApp) GetMarketPrice(marketPrice *model.MarketPriceParams) ([]*mo
 			ProductCount: marketPriceRedis.ProductCount,
 			Currency:     data.Currency,
 			BaseAmount:   data.BaseAmount,
-			NowAmount:    marketPriceRedis.Amount, // 目前價格
+			NowAmount:    marketPriceRedis.Amount,     // 目前價格
+			AuctionEnd:   marketPriceRedis.AuctionEnd, // 集合競價結束時間
+			PriceBand:    marketPriceRedis.PriceBand,  // 價格限制 %
+			HaltEnd:      marketPriceRedis.HaltEnd,    // 熔斷暫停交易的結束時間
 		}
 		s2cList = append(s2cList, s2c)
 	}
 
 	return s2cList, dataMap, nil
 }
+
+// 取得K線: db 已結束的K線 + redis 目前的K線 (搓合引擎尚未寫入 db)
+func (a *ProductApp) GetCandles(params *model.CandleParams) (*model.S2C_Candles, error) {
+
+	candleList, err := a.CandleRepo.GetCandleList(params)
+	if err != nil {
+		logs.Errorf("getCandleList fail params:%+v, err:%v", params, err)
+		return nil, Error_DbFail
+	}
+
+	current, err := a.CandleRepo.RedisGetCandle(params.ProductName, params.Interval)
+	if err != nil {
+		logs.Warnf("redisGetCandle fail params:%+v, err:%v", params, err)
+		return nil, Error_RedisFail
+	}
+
+	// 目前的K線 在查詢範圍內 才加入, 已寫入 db 的同一根 以 redis 為準
+	if current != nil && current.OpenTime >= params.Start && (params.End == 0 || current.OpenTime <= params.End) {
+		if n := len(candleList); n > 0 && candleList[n-1].OpenTime == current.OpenTime {
+			candleList = candleList[:n-1]
+		}
+		if n := len(candleList); n == 0 || candleList[n-1].OpenTime < current.OpenTime {
+			candleList = append(candleList, current)
+		}
+		if params.Limit > 0 && len(candleList) > params.Limit {
+			candleList = candleList[len(candleList)-params.Limit:]
+		}
+	}
+
+	s2c := &model.S2C_Candles{
+		ProductName: params.ProductName,
+		Interval:    string(params.Interval),
+		Candles:     make([]*model.S2C_Candle, 0, len(candleList)),
+	}
+	for _, candle := range candleList {
+		s2c.Candles = append(s2c.Candles, candle.ToS2C())
+	}
+	return s2c, nil
+}
+
+// 取得深度快照 (搓合引擎發佈到 redis), 只回傳前 level 檔
+func (a *ProductApp) GetDepth(params *model.DepthParams) (*model.DepthSnapshot, error) {
+
+	snapshot, err := a.DepthRepo.RedisGetDepth(params.ProductName)
+	if err != nil {
+		logs.Warnf("redisGetDepth fail params:%+v, err:%v", params, err)
+		return nil, Error_RedisFail
+	}
+	if snapshot == nil {
+		return nil, Error_DepthNotFound
+	}
+
+	snapshot.Truncate(params.Level)
+	return snapshot, nil
+}
+
+// 取得快照之後的深度增量更新, 已超過保留筆數 無法接續時 回傳序號不連續
+func (a *ProductApp) GetDepthUpdates(params *model.DepthUpdateParams) ([]*model.DepthUpdate, error) {
+
+	updates, err := a.DepthRepo.RedisGetDepthUpdates(params.ProductName, params.FromSeq)
+	if err != nil {
+		logs.Warnf("redisGetDepthUpdates fail params:%+v, err:%v", params, err)
+		return nil, Error_RedisFail
+	}
+
+	if len(updates) > 0 {
+		if updates[0].PrevSeq != params.FromSeq {
+			return nil, Error_DepthSeqGap
+		}
+		return updates, nil
+	}
+
+	// 沒有更新: 序號與目前快照不同 代表已不存在的序號
+	snapshot, err := a.DepthRepo.RedisGetDepth(params.ProductName)
+	if err != nil {
+		logs.Warnf("redisGetDepth fail params:%+v, err:%v", params, err)
+		return nil, Error_RedisFail
+	}
+	if snapshot != nil && snapshot.Seq != params.FromSeq {
+		return nil, Error_DepthSeqGap
+	}
+	return updates, nil
+}
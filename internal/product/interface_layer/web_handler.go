@@ -134,3 +134,139 @@ func (u *ProductHandler) GetMarketPrice(c *gin.Context) {
 
 	response.Ok(c, marketPriceList)
 }
+
+// PingExample godoc
+// @Summary 取得K線
+// @Description get OHLCV candles of a product (1m, 5m, 1h, 1d)
+// @Schemes
+// @Tags product
+// @Produce json
+// @Param	product_name	query	string	true	"商品名稱"
+// @Param	interval		query	string	true	"週期 1m | 5m | 1h | 1d"
+// @Param	start			query	int		false	"開始時間 unix 秒"
+// @Param	end				query	int		false	"結束時間 unix 秒"
+// @Param	limit			query	int		false	"最多筆數 (預設 500)"
+// @Success 	200 	{object} 	model.S2C_Candles
+// @Failure     500		{object}	response.HTTPError
+// @Failure     400		{object}	response.HTTPError
+// @Router /v1/candles [get]
+func (u *ProductHandler) GetCandles(c *gin.Context) {
+
+	var err error
+	req := &model.C2S_Candles{}
+
+	// 解析参数
+	if err = c.ShouldBindQuery(req); err != nil {
+		response.Err(c, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	// 转化为领域对象 + 参数验证
+	candleParams, err := req.ToDomain()
+	if err != nil {
+		logs.Errorf("[GetCandles] failed, err: %+v", err)
+		response.Err(c, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	// 呼叫應用層 取得K線
+	candles, err := u.ProductApp.GetCandles(candleParams)
+	if err != nil {
+		response.Err(c, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	response.Ok(c, candles)
+}
+
+// PingExample godoc
+// @Summary 取得深度快照
+// @Description get aggregated price levels of a product, published by the matching engine
+// @Schemes
+// @Tags product
+// @Produce json
+// @Param	product_name	query	string	true	"商品名稱"
+// @Param	level			query	int		false	"檔位數 (不填 = 全部已發佈的檔位)"
+// @Success 	200 	{object} 	model.DepthSnapshot
+// @Failure     500		{object}	response.HTTPError
+// @Failure     404		{object}	response.HTTPError
+// @Failure     400		{object}	response.HTTPError
+// @Router /v1/depth [get]
+func (u *ProductHandler) GetDepth(c *gin.Context) {
+
+	var err error
+	req := &model.C2S_Depth{}
+
+	// 解析参数
+	if err = c.ShouldBindQuery(req); err != nil {
+		response.Err(c, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	// 转化为领域对象 + 参数验证
+	depthParams, err := req.ToDomain()
+	if err != nil {
+		logs.Errorf("[GetDepth] failed, err: %+v", err)
+		response.Err(c, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	// 呼叫應用層 取得深度快照
+	snapshot, err := u.ProductApp.GetDepth(depthParams)
+	if err == application_product.Error_DepthNotFound {
+		response.Err(c, http.StatusNotFound, err.Error())
+		return
+	}
+	if err != nil {
+		response.Err(c, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	response.Ok(c, snapshot)
+}
+
+// PingExample godoc
+// @Summary 取得深度增量更新
+// @Description get sequence-numbered depth diffs after from_seq, 409 when the client must take a new snapshot
+// @Schemes
+// @Tags product
+// @Produce json
+// @Param	product_name	query	string	true	"商品名稱"
+// @Param	from_seq		query	int		true	"本地快照的序號"
+// @Success 	200 	{array} 	model.DepthUpdate
+// @Failure     500		{object}	response.HTTPError
+// @Failure     409		{object}	response.HTTPError
+// @Failure     400		{object}	response.HTTPError
+// @Router /v1/depth_updates [get]
+func (u *ProductHandler) GetDepthUpdates(c *gin.Context) {
+
+	var err error
+	req := &model.C2S_DepthUpdates{}
+
+	// 解析参数
+	if err = c.ShouldBindQuery(req); err != nil {
+		response.Err(c, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	// 转化为领域对象 + 参数验证
+	updateParams, err := req.ToDomain()
+	if err != nil {
+		logs.Errorf("[GetDepthUpdates] failed, err: %+v", err)
+		response.Err(c, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	// 呼叫應用層 取得增量更新, 序號不連續時 客戶端需重新取得快照
+	updates, err := u.ProductApp.GetDepthUpdates(updateParams)
+	if err == application_product.Error_DepthSeqGap {
+		response.Err(c, http.StatusConflict, err.Error())
+		return
+	}
+	if err != nil {
+		response.Err(c, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	response.Ok(c, updates)
+}
@@ -0,0 +1,87 @@
+package model
+
+import (
+	"github.com/shopspring/decimal"
+)
+
+const (
+	CandleDefaultLimit = 500  // 預設回傳的K線數量
+	CandleMaxLimit     = 1500 // 最多回傳的K線數量
+)
+
+// C2S_Candles 取得K線
+type C2S_Candles struct {
+	ProductName string `form:"product_name"` // 商品名稱
+	Interval    string `form:"interval"`     // 週期 1m | 5m | 1h | 1d
+	Start       int64  `form:"start"`        // 開始時間 unix 秒 (可選)
+	End         int64  `form:"end"`          // 結束時間 unix 秒 (可選)
+	Limit       int    `form:"limit"`        // 最多筆數 (可選, 預設 500)
+}
+
+func (c *C2S_Candles) ToDomain() (*CandleParams, error) {
+
+	// 驗證參數
+	if err := c.Verify(); err != nil {
+		return nil, err
+	}
+
+	limit := c.Limit
+	if limit == 0 {
+		limit = CandleDefaultLimit
+	}
+
+	// 將用戶參數轉換為領域對象
+	return &CandleParams{
+		ProductName: c.ProductName,
+		Interval:    CandleInterval(c.Interval),
+		Start:       c.Start,
+		End:         c.End,
+		Limit:       limit,
+	}, nil
+}
+
+// 驗證
+func (c *C2S_Candles) Verify() error {
+	if len(c.ProductName) == 0 || !CandleInterval(c.Interval).IsValid() {
+		return Error_VerifyFailed
+	}
+	if c.Start < 0 || c.End < 0 || (c.End > 0 && c.End < c.Start) {
+		return Error_VerifyFailed
+	}
+	if c.Limit < 0 || c.Limit > CandleMaxLimit {
+		return Error_VerifyFailed
+	}
+	return nil
+}
+
+// K線 的回應
+type S2C_Candle struct {
+	OpenTime   int64           `json:"open_time"`   // 開始時間 unix 秒
+	Open       decimal.Decimal `json:"open"`        // 開盤價
+	High       decimal.Decimal `json:"high"`        // 最高價
+	Low        decimal.Decimal `json:"low"`         // 最低價
+	Close      decimal.Decimal `json:"close"`       // 收盤價
+	Volume     int64           `json:"volume"`      // 成交數量
+	Amount     decimal.Decimal `json:"amount"`      // 成交金額
+	TradeCount int64           `json:"trade_count"` // 成交筆數
+}
+
+// 取得K線 的回應
+type S2C_Candles struct {
+	ProductName string        `json:"product_name"` // 商品名稱
+	Interval    string        `json:"interval"`     // 週期
+	Candles     []*S2C_Candle `json:"candles"`      // K線 (依時間由舊到新)
+}
+
+func (c *Candle) ToS2C() *S2C_Candle {
+	return &S2C_Candle{
+		OpenTime:   c.OpenTime,
+		Open:       c.Open,
+		High:       c.High,
+		Low:        c.Low,
+		Close:      c.Close,
+		Volume:     c.Volume,
+		Amount:     c.Amount,
+		TradeCount: c.TradeCount,
+	}
+}
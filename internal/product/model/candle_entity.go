@@ -0,0 +1,183 @@
+package model
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/shopspring/decimal"
+)
+
+// K線週期
+type CandleInterval string
+
+const (
+	Candle_1m CandleInterval = "1m" // 1 分鐘
+	Candle_5m CandleInterval = "5m" // 5 分鐘
+	Candle_1h CandleInterval = "1h" // 1 小時
+	Candle_1d CandleInterval = "1d" // 1 天 (UTC)
+)
+
+// 成交時 需要彙整的K線週期
+var CandleIntervals = []CandleInterval{Candle_1m, Candle_5m, Candle_1h, Candle_1d}
+
+// 週期長度, 不支援的週期回傳 0
+func (i CandleInterval) Duration() time.Duration {
+	switch i {
+	case Candle_1m:
+		return time.Minute
+	case Candle_5m:
+		return 5 * time.Minute
+	case Candle_1h:
+		return time.Hour
+	case Candle_1d:
+		return 24 * time.Hour
+	}
+	return 0
+}
+
+// 是否為支援的週期
+func (i CandleInterval) IsValid() bool {
+	return i.Duration() > 0
+}
+
+// 時間所屬K線的開始時間 unix 秒
+func (i CandleInterval) OpenTime(t time.Time) int64 {
+	return t.Truncate(i.Duration()).Unix()
+}
+
+// K線 (OHLCV)
+type Candle struct {
+	ID          int64           // 流水編號
+	ProductName string          // 產品名稱
+	Interval    CandleInterval  // 週期
+	OpenTime    int64           // 開始時間 unix 秒
+	Open        decimal.Decimal // 開盤價
+	High        decimal.Decimal // 最高價
+	Low         decimal.Decimal // 最低價
+	Close       decimal.Decimal // 收盤價
+	Volume      int64           // 成交數量
+	Amount      decimal.Decimal // 成交金額
+	TradeCount  int64           // 成交筆數
+}
+
+// 以第一筆成交 建立K線
+func NewCandle(productName string, interval CandleInterval, executedAt time.Time, price decimal.Decimal) *Candle {
+	return &Candle{
+		ProductName: productName,
+		Interval:    interval,
+		OpenTime:    interval.OpenTime(executedAt),
+		Open:        price,
+		High:        price,
+		Low:         price,
+		Close:       price,
+	}
+}
+
+// 成交時間 是否在這根K線的週期內
+func (c *Candle) Contains(executedAt time.Time) bool {
+	return c.Interval.OpenTime(executedAt) == c.OpenTime
+}
+
+// 這根K線的週期 是否已經結束
+func (c *Candle) IsClosed(now time.Time) bool {
+	return now.Unix() >= c.OpenTime+int64(c.Interval.Duration().Seconds())
+}
+
+// 加入一筆成交
+func (c *Candle) Add(price decimal.Decimal, count int64, amount decimal.Decimal) {
+	if price.GreaterThan(c.High) {
+		c.High = price
+	}
+	if price.LessThan(c.Low) {
+		c.Low = price
+	}
+	c.Close = price
+	c.Volume += count
+	c.Amount = c.Amount.Add(amount)
+	c.TradeCount++
+}
+
+func (c *Candle) ToPO() *Candle_PO {
+	return &Candle_PO{
+		ID:          c.ID,
+		ProductName: c.ProductName,
+		Interval:    string(c.Interval),
+		OpenTime:    c.OpenTime,
+		Open:        c.Open,
+		High:        c.High,
+		Low:         c.Low,
+		Close:       c.Close,
+		Volume:      c.Volume,
+		Amount:      c.Amount,
+		TradeCount:  c.TradeCount,
+	}
+}
+
+func (c *Candle) ToRedis() *CandleRedis {
+	return &CandleRedis{
+		ProductName: c.ProductName,
+		Interval:    string(c.Interval),
+		OpenTime:    c.OpenTime,
+		Open:        c.Open,
+		High:        c.High,
+		Low:         c.Low,
+		Close:       c.Close,
+		Volume:      c.Volume,
+		Amount:      c.Amount,
+		TradeCount:  c.TradeCount,
+	}
+}
+
+// redis 目前的K線
+type CandleRedis struct {
+	ProductName string          `json:"product_name"` // 商品名稱
+	Interval    string          `json:"interval"`     // 週期
+	OpenTime    int64           `json:"open_time"`    // 開始時間 unix 秒
+	Open        decimal.Decimal `json:"open"`         // 開盤價
+	High        decimal.Decimal `json:"high"`         // 最高價
+	Low         decimal.Decimal `json:"low"`          // 最低價
+	Close       decimal.Decimal `json:"close"`        // 收盤價
+	Volume      int64           `json:"volume"`       // 成交數量
+	Amount      decimal.Decimal `json:"amount"`       // 成交金額
+	TradeCount  int64           `json:"trade_count"`  // 成交筆數
+}
+
+func NewCandleRedis(jsonStr string) (*CandleRedis, error) {
+	var candleRedis CandleRedis
+	if err := json.Unmarshal([]byte(jsonStr), &candleRedis); err != nil {
+		return nil, err
+	}
+	return &candleRedis, nil
+}
+
+func (c *CandleRedis) ToJson() (string, error) {
+	byteArray, err := json.Marshal(c)
+	if err != nil {
+		return "", err
+	}
+	return string(byteArray), nil
+}
+
+func (c *CandleRedis) ToDomain() *Candle {
+	return &Candle{
+		ProductName: c.ProductName,
+		Interval:    CandleInterval(c.Interval),
+		OpenTime:    c.OpenTime,
+		Open:        c.Open,
+		High:        c.High,
+		Low:         c.Low,
+		Close:       c.Close,
+		Volume:      c.Volume,
+		Amount:      c.Amount,
+		TradeCount:  c.TradeCount,
+	}
+}
+
+// 查詢K線
+type CandleParams struct {
+	ProductName string         // 商品名稱
+	Interval    CandleInterval // 週期
+	Start       int64          // 開始時間 unix 秒 (0 = 不限制)
+	End         int64          // 結束時間 unix 秒 (0 = 不限制)
+	Limit       int            // 最多筆數 (最新的幾根)
+}
@@ -0,0 +1,40 @@
+package model
+
+import (
+	"github.com/shopspring/decimal"
+)
+
+type Candle_PO struct {
+	ID          int64           `gorm:"primary_key;auto_increment;comment:'流水號 主鍵'" json:"id"`
+	ProductName string          `gorm:"size:256;not null; unique_index:idx_candle; comment:'產品名稱'" json:"product_name"`
+	Interval    string          `gorm:"size:8;not null; unique_index:idx_candle; comment:'週期'" json:"interval"`
+	OpenTime    int64           `gorm:"type:bigint(20);not null; unique_index:idx_candle; comment:'開始時間'" json:"open_time"`
+	Open        decimal.Decimal `gorm:"type:decimal(20,2);default:0; comment:'開盤價'" json:"open"`
+	High        decimal.Decimal `gorm:"type:decimal(20,2);default:0; comment:'最高價'" json:"high"`
+	Low         decimal.Decimal `gorm:"type:decimal(20,2);default:0; comment:'最低價'" json:"low"`
+	Close       decimal.Decimal `gorm:"type:decimal(20,2);default:0; comment:'收盤價'" json:"close"`
+	Volume      int64           `gorm:"type:bigint(20);default:0; comment:'成交數量'" json:"volume"`
+	Amount      decimal.Decimal `gorm:"type:decimal(20,2);default:0; comment:'成交金額'" json:"amount"`
+	TradeCount  int64           `gorm:"type:bigint(20);default:0; comment:'成交筆數'" json:"trade_count"`
+}
+
+func (Candle_PO) TableName() string {
+	return "candle"
+}
+
+// 持久層轉網域層
+func (p *Candle_PO) ToDomain() *Candle {
+	return &Candle{
+		ID:          p.ID,
+		ProductName: p.ProductName,
+		Interval:    CandleInterval(p.Interval),
+		OpenTime:    p.OpenTime,
+		Open:        p.Open,
+		High:        p.High,
+		Low:         p.Low,
+		Close:       p.Close,
+		Volume:      p.Volume,
+		Amount:      p.Amount,
+		TradeCount:  p.TradeCount,
+	}
+}
@@ -0,0 +1,41 @@
+package model
+
+// C2S_Depth 取得深度快照
+type C2S_Depth struct {
+	ProductName string `form:"product_name"` // 商品名稱
+	Level       int    `form:"level"`        // 檔位數 (可選, 不填 = 全部已發佈的檔位)
+}
+
+func (c *C2S_Depth) ToDomain() (*DepthParams, error) {
+
+	// 驗證參數
+	if len(c.ProductName) == 0 || c.Level < 0 {
+		return nil, Error_VerifyFailed
+	}
+
+	// 將用戶參數轉換為領域對象
+	return &DepthParams{
+		ProductName: c.ProductName,
+		Level:       c.Level,
+	}, nil
+}
+
+// C2S_DepthUpdates 取得快照之後的增量更新
+type C2S_DepthUpdates struct {
+	ProductName string `form:"product_name"` // 商品名稱
+	FromSeq     int64  `form:"from_seq"`     // 本地快照的序號, 回傳序號大於 from_seq 的更新
+}
+
+func (c *C2S_DepthUpdates) ToDomain() (*DepthUpdateParams, error) {
+
+	// 驗證參數
+	if len(c.ProductName) == 0 || c.FromSeq < 0 {
+		return nil, Error_VerifyFailed
+	}
+
+	// 將用戶參數轉換為領域對象
+	return &DepthUpdateParams{
+		ProductName: c.ProductName,
+		FromSeq:     c.FromSeq,
+	}, nil
+}
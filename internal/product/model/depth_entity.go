@@ -0,0 +1,124 @@
+package model
+
+import (
+	"encoding/json"
+
+	"github.com/shopspring/decimal"
+)
+
+const (
+	DepthDefaultLevel = 20 // 預設發佈的深度檔位數
+)
+
+// 價格檔位 (L2 深度)
+type DepthLevel struct {
+	Price      decimal.Decimal `json:"price"`       // 價格
+	Count      int64           `json:"count"`       // 剩餘數量合計 (增量更新 0 = 移除這個檔位)
+	OrderCount int             `json:"order_count"` // 訂單筆數
+}
+
+// 檔位是否相同
+func (l *DepthLevel) Equal(other *DepthLevel) bool {
+	return l.Price.Equal(other.Price) && l.Count == other.Count && l.OrderCount == other.OrderCount
+}
+
+// redis 商品的深度快照 (搓合引擎 每次訂單簿變動後發佈)
+type DepthSnapshot struct {
+	ProductName string        `json:"product_name"` // 商品名稱
+	Seq         int64         `json:"seq"`          // 序號 (每次變動 +1)
+	Bids        []*DepthLevel `json:"bids"`         // 買方檔位 (價格高 -> 低)
+	Asks        []*DepthLevel `json:"asks"`         // 賣方檔位 (價格低 -> 高)
+	UpdateTime  int64         `json:"update_time"`  // 更新時間 unix 毫秒
+}
+
+func NewDepthSnapshot(jsonStr string) (*DepthSnapshot, error) {
+	var depthSnapshot DepthSnapshot
+	if err := json.Unmarshal([]byte(jsonStr), &depthSnapshot); err != nil {
+		return nil, err
+	}
+	return &depthSnapshot, nil
+}
+
+func (d *DepthSnapshot) ToJson() (string, error) {
+	byteArray, err := json.Marshal(d)
+	if err != nil {
+		return "", err
+	}
+	return string(byteArray), nil
+}
+
+// 只保留前 level 檔 (level <= 0 不限制)
+func (d *DepthSnapshot) Truncate(level int) {
+	if level <= 0 {
+		return
+	}
+	if len(d.Bids) > level {
+		d.Bids = d.Bids[:level]
+	}
+	if len(d.Asks) > level {
+		d.Asks = d.Asks[:level]
+	}
+}
+
+// 深度的增量更新: 依序號套用到快照 (PrevSeq 需等於本地快照的 Seq, 否則重新取得快照)
+// 只列出有變動的檔位, Count = 0 代表移除
+type DepthUpdate struct {
+	ProductName string        `json:"product_name"` // 商品名稱
+	Seq         int64         `json:"seq"`          // 序號
+	PrevSeq     int64         `json:"prev_seq"`     // 前一個序號
+	Bids        []*DepthLevel `json:"bids"`         // 變動的買方檔位
+	Asks        []*DepthLevel `json:"asks"`         // 變動的賣方檔位
+	UpdateTime  int64         `json:"update_time"`  // 更新時間 unix 毫秒
+}
+
+func NewDepthUpdate(jsonStr string) (*DepthUpdate, error) {
+	var depthUpdate DepthUpdate
+	if err := json.Unmarshal([]byte(jsonStr), &depthUpdate); err != nil {
+		return nil, err
+	}
+	return &depthUpdate, nil
+}
+
+func (d *DepthUpdate) ToJson() (string, error) {
+	byteArray, err := json.Marshal(d)
+	if err != nil {
+		return "", err
+	}
+	return string(byteArray), nil
+}
+
+// 比對新舊檔位 回傳有變動的檔位 (移除的檔位 Count = 0)
+func DiffDepthLevels(old, new []*DepthLevel) []*DepthLevel {
+
+	oldMap := make(map[string]*DepthLevel, len(old))
+	for _, level := range old {
+		oldMap[level.Price.String()] = level
+	}
+
+	diff := []*DepthLevel{}
+	for _, level := range new {
+		key := level.Price.String()
+		if oldLevel, ok := oldMap[key]; !ok || !oldLevel.Equal(level) {
+			diff = append(diff, level)
+		}
+		delete(oldMap, key)
+	}
+	for _, level := range old {
+		if _, ok := oldMap[level.Price.String()]; ok {
+			diff = append(diff, &DepthLevel{Price: level.Price})
+		}
+	}
+	return diff
+}
+
+// 查詢深度
+type DepthParams struct {
+	ProductName string // 商品名稱
+	Level       int    // 檔位數 (0 = 全部已發佈的檔位)
+}
+
+// 查詢深度的增量更新
+type DepthUpdateParams struct {
+	ProductName string // 商品名稱
+	FromSeq     int64  // 回傳序號大於 FromSeq 的更新
+}
@@ -11,6 +11,8 @@ type C2S_ProductCreate struct {
 	ProductCount int64           `json:"product_count"` // 上架的商品數量
 	Currency     string          `json:"currency"`      // 上架的基本幣值
 	BaseAmount   decimal.Decimal `json:"base_amount"`   // 上架基本價格
+	AuctionTime  int64           `json:"auction_time"`  // 集合競價時間 (秒), 0 = 上架後直接連續搓合; 由搓合引擎每 30 秒的定時任務結束, 最多延後 30 秒
+	PriceBand    decimal.Decimal `json:"price_band"`    // 委託價格與最新成交價的最大差距 % (0 = 不限制)
 }
 
 func (c *C2S_ProductCreate) ToDomain() (*ProductCreateParams, error) {
@@ -26,6 +28,8 @@ func (c *C2S_ProductCreate) ToDomain() (*ProductCreateParams, error) {
 		ProductCount: c.ProductCount,
 		Currency:     c.Currency,
 		BaseAmount:   c.BaseAmount,
+		AuctionTime:  c.AuctionTime,
+		PriceBand:    c.PriceBand,
 	}, nil
 }
 
@@ -43,6 +47,14 @@ func (c *C2S_ProductCreate) Verify() error {
 	if !c.BaseAmount.GreaterThan(decimal.Zero) {
 		return Error_VerifyFailed
 	}
+	// 集合競價時間 < 0
+	if c.AuctionTime < 0 {
+		return Error_VerifyFailed
+	}
+	// 價格限制 < 0
+	if c.PriceBand.IsNegative() {
+		return Error_VerifyFailed
+	}
 
 	return nil
 }
@@ -125,4 +137,7 @@ type S2C_MarketPrice struct {
 	Currency     string          `json:"currency"`      // 幣種
 	BaseAmount   decimal.Decimal `json:"base_amount"`   // 基本上市價格
 	NowAmount    decimal.Decimal `json:"now_amount"`    // 目前價格
+	AuctionEnd   int64           `json:"auction_end"`   // 集合競價結束時間 unix 秒 (0 = 連續搓合中)
+	PriceBand    decimal.Decimal `json:"price_band"`    // 委託價格與最新成交價的最大差距 % (0 = 不限制)
+	HaltEnd      int64           `json:"halt_end"`      // 熔斷暫停交易的結束時間 unix 秒 (0 = 交易中)
 }
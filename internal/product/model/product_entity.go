@@ -3,6 +3,7 @@ package model
 import (
 	"encoding/json"
 	"errors"
+	"time"
 
 	"github.com/shopspring/decimal"
 )
@@ -18,6 +19,8 @@ type Product struct {
 	ProductCount int64           // 上架的商品數量
 	BaseAmount   decimal.Decimal // 上架初始金額
 	Currency     string          // 貨幣
+	AuctionEnd   int64           // 集合競價結束時間 unix 秒 (0 = 不集合競價)
+	PriceBand    decimal.Decimal // 委託價格與最新成交價的最大差距 % (0 = 不限制)
 }
 
 func (b *Product) ToPO() *Product_PO {
@@ -27,6 +30,20 @@ func (b *Product) ToPO() *Product_PO {
 		ProductCount: b.ProductCount,
 		BaseAmount:   b.BaseAmount,
 		Currency:     b.Currency,
+		AuctionEnd:   b.AuctionEnd,
+		PriceBand:    b.PriceBand,
+	}
+}
+
+// 上架時的市場價格 (使用初始價格, 集合競價結束後以成交價為準)
+func (b *Product) ToMarketPriceRedis() *MarketPriceRedis {
+	return &MarketPriceRedis{
+		ProductCount: b.ProductCount,
+		Currency:     b.Currency,
+		Amount:       b.BaseAmount,
+		AuctionEnd:   b.AuctionEnd,
+		PriceBand:    b.PriceBand,
+		UpdateTime:   time.Now().String(),
 	}
 }
 
@@ -35,18 +52,25 @@ type ProductCreateParams struct {
 	ProductCount int64           `json:"product_count"` // 上架的商品數量
 	Currency     string          `json:"currency"`      // 幣種
 	BaseAmount   decimal.Decimal `json:"base_amount"`   // 基本價格
+	AuctionTime  int64           `json:"auction_time"`  // 集合競價時間 (秒)
+	PriceBand    decimal.Decimal `json:"price_band"`    // 價格限制 %
 }
 
 func (c *ProductCreateParams) ToDomain() (*Product, error) {
 
 	// todo 驗證用戶參數
 
-	return &Product{
+	product := &Product{
 		ProductName:  c.ProductName,
 		ProductCount: c.ProductCount,
 		Currency:     c.Currency,
 		BaseAmount:   c.BaseAmount,
-	}, nil
+		PriceBand:    c.PriceBand,
+	}
+	if c.AuctionTime > 0 {
+		product.AuctionEnd = time.Now().Unix() + c.AuctionTime
+	}
+	return product, nil
 }
 
 // 市場價格
@@ -82,6 +106,9 @@ type MarketPriceRedis struct {
 	ProductCount int64           `json:"product_count"` // 上架的商品數量
 	Currency     string          `json:"currency"`      // 幣種
 	Amount       decimal.Decimal `json:"amount"`        // 基本價格
+	AuctionEnd   int64           `json:"auction_end"`   // 集合競價結束時間 unix 秒 (0 = 連續搓合中)
+	PriceBand    decimal.Decimal `json:"price_band"`    // 委託價格與最新成交價的最大差距 % (0 = 不限制)
+	HaltEnd      int64           `json:"halt_end"`      // 熔斷暫停交易的結束時間 unix 秒 (0 = 交易中)
 	UpdateTime   string          `json:"update_time"`   // 更新時間
 }
 
@@ -100,6 +127,15 @@ func NewMarketPriceRedis(jsonStr string) (*MarketPriceRedis, error) {
 	return &marketPriceRedis, nil
 }
 
+// 委託價格是否在價格限制內 (沒有限制 或 還沒有市場價格 都視為在範圍內)
+func (c *MarketPriceRedis) InBand(price decimal.Decimal) bool {
+	if !c.PriceBand.IsPositive() || !c.Amount.IsPositive() {
+		return true
+	}
+	limit := c.Amount.Mul(c.PriceBand).Div(decimal.NewFromInt(100))
+	return price.Sub(c.Amount).Abs().LessThanOrEqual(limit)
+}
+
 func (c *MarketPriceRedis) ToJson() (string, error) {
 
 	byteArray, err := json.Marshal(c)
@@ -109,3 +145,9 @@ func (c *MarketPriceRedis) ToJson() (string, error) {
 
 	return string(byteArray[:]), nil
 }
+
+// 即時行情 (搓合引擎 市場價格變動時發佈)
+type TickerEvent struct {
+	ProductName string `json:"product_name"` // 商品名稱
+	*MarketPriceRedis
+}
@@ -8,6 +8,8 @@ type Product_PO struct {
 	ProductCount int64           `gorm:"type:bigint(20);comment:'產品數量'" json:"product_count"`
 	BaseAmount   decimal.Decimal `gorm:"type:decimal(20,2); comment:'上架初始金額'" json:"base_amount"`
 	Currency     string          `gorm:"size:32;not null; comment:'幣種'" json:"currency"`
+	AuctionEnd   int64           `gorm:"type:bigint(20);default:0;comment:'集合競價結束時間'" json:"auction_end"`
+	PriceBand    decimal.Decimal `gorm:"type:decimal(10,2);default:0; comment:'價格限制 %'" json:"price_band"`
 }
 
 func (Product_PO) TableName() string {
@@ -23,6 +25,8 @@ func (p *Product_PO) ToDomain() *Product {
 		ProductName:  p.ProductName,
 		BaseAmount:   p.BaseAmount,
 		Currency:     p.Currency,
+		AuctionEnd:   p.AuctionEnd,
+		PriceBand:    p.PriceBand,
 	}
 
 }
@@ -0,0 +1,15 @@
+package Infrastructure_layer
+
+import (
+	"marketplace_server/internal/common/redis"
+
+	goredis "github.com/redis/go-redis/v9"
+)
+
+// 即時事件 (redis pub/sub): 搓合引擎發佈 成交 深度 K線 行情, marketplace_server 發佈用戶通知
+type EventRepo interface {
+	Publish(channel string, message interface{}) error // 發佈到頻道
+	Subscribe(channel ...string) *goredis.PubSub       // 訂閱頻道 (可再動態增減)
+}
+
+var _ EventRepo = &redis.Redis{}
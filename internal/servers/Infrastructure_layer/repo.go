@@ -34,11 +34,18 @@ import (
 // 持久化管理物件
 type RepositoriesManager struct {
 	AuthRepo        Infrastructure_user.AuthInterface    // 驗證
+	NotifyRepo      Infrastructure_user.NotifyRepo       // 用戶推播通知
+	SeqRepo         Infrastructure_user.SeqRepo          // 用戶指令序號
 	UserRepo        Infrastructure_user.UserRepo         // 用戶
 	TransactionRepo Infrastructure_bill.TransactionRepo  // 交易
+	TradeRepo       Infrastructure_bill.TradeRepo        // 成交紀錄
 	ProductRepo     Infrastructure_product.ProductRepo   // 產品持久層
+	CandleRepo      Infrastructure_product.CandleRepo    // K線持久層
+	DepthRepo       Infrastructure_product.DepthRepo     // 深度 (redis)
+	EventRepo       EventRepo                            // 即時事件 (redis pub/sub)
 	BackpackRepo    Infrastructure_backpack.BackpackRepo // 背包持久層
 	db              *gorm.DB
+	redisClient     *redis.Redis
 }
 
 // 建立持久化管理物件
@@ -92,6 +99,7 @@ func NewRepositories(cfg *config.Config) *RepositoriesManager {
 	}
 
 	transactionRepo := Infrastructure_bill.NewMysqlTransactionRepo(db)
+	tradeRepo := Infrastructure_bill.NewMysqlTradeRepo(db)
 	protuctRepo := Infrastructure_product.NewProductRepoManager(db, redisClient.GetClient())
 	// user 和 產品
 	userRepo := Infrastructure_user.NewMysqlUserRepo(db, redisClient.GetClient())
@@ -109,16 +117,26 @@ func NewRepositories(cfg *config.Config) *RepositoriesManager {
 
 	return &RepositoriesManager{
 		AuthRepo:        authRepo,
+		NotifyRepo:      Infrastructure_user.NewRedisNotifyRepo(redisClient.GetClient()),
+		SeqRepo:         Infrastructure_user.NewRedisSeqRepo(redisClient.GetClient()),
 		UserRepo:        userRepo,
 		TransactionRepo: transactionRepo,
+		TradeRepo:       tradeRepo,
 		ProductRepo:     protuctRepo,
+		CandleRepo:      Infrastructure_product.NewCandleRepoManager(db, redisClient.GetClient()),
+		DepthRepo:       Infrastructure_product.NewRedisDepthRepo(redisClient.GetClient()),
+		EventRepo:       redisClient,
 		BackpackRepo:    backpackRepo,
 		db:              db,
+		redisClient:     redisClient,
 	}
 }
 
 // closes the  database connection
 func (s *RepositoriesManager) Close() error {
+	if s.db == nil {
+		return nil
+	}
 	return s.db.Close()
 }
 
@@ -130,6 +148,8 @@ func (s *RepositoriesManager) GetDB() *gorm.DB {
 func (s *RepositoriesManager) Automigrate() error {
 	return s.db.AutoMigrate(&model_user.UserPO{},
 		&model_transaction.Transaction_PO{},
+		&model_transaction.Trade_PO{},
 		&model_product.Product_PO{},
+		&model_product.Candle_PO{},
 		&model_backpack.Backpack_PO{}).Error
 }
@@ -0,0 +1,90 @@
+package Infrastructure_layer
+
+import (
+	"fmt"
+
+	Infrastructure_backpack "marketplace_server/internal/backpack/Infrastructure_layer"
+	Infrastructure_bill "marketplace_server/internal/bill/Infrastructure_layer"
+	Infrastructure_user "marketplace_server/internal/user/Infrastructure_layer"
+)
+
+// 交易單元 (unit of work)
+// 內部的持久層共用同一個 db 事務, 全部成功才 Commit, 任一失敗就 Rollback
+type UnitOfWork struct {
+	UserRepo        Infrastructure_user.UserRepo         // 用戶
+	TransactionRepo Infrastructure_bill.TransactionRepo  // 交易
+	TradeRepo       Infrastructure_bill.TradeRepo        // 成交紀錄
+	BackpackRepo    Infrastructure_backpack.BackpackRepo // 背包持久層
+}
+
+// 可還原的持久層 (記憶體的持久層), Savepoint 記錄目前的資料 並回傳還原的函式
+type savepointer interface {
+	Savepoint() func()
+}
+
+// 在同一個 db 事務(transaction)內執行 fn, fn 回傳錯誤或 panic 就 Rollback
+// 沒有 db (記憶體的持久層) 時 直接使用管理物件的持久層執行 fn, 失敗時還原到執行前的資料
+func (s *RepositoriesManager) Transaction(fn func(uow *UnitOfWork) error) (err error) {
+
+	if s.db == nil {
+		return s.memoryTransaction(fn)
+	}
+
+	tx := s.db.Begin()
+	if tx.Error != nil {
+		return tx.Error
+	}
+
+	defer func() {
+		if r := recover(); r != nil {
+			tx.Rollback()
+			err = fmt.Errorf("transaction panic:%v", r)
+		}
+	}()
+
+	// 建立綁定在此事務上的持久層
+	uow := &UnitOfWork{
+		UserRepo:        Infrastructure_user.NewMysqlUserRepo(tx, s.redisClient.GetClient()),
+		TransactionRepo: Infrastructure_bill.NewMysqlTransactionRepo(tx),
+		TradeRepo:       Infrastructure_bill.NewMysqlTradeRepo(tx),
+		BackpackRepo:    Infrastructure_backpack.NewMysqlBackpackRepo(tx),
+	}
+
+	if err = fn(uow); err != nil {
+		if rollbackErr := tx.Rollback().Error; rollbackErr != nil {
+			return fmt.Errorf("rollback fail err:%v, rollbackErr:%v", err, rollbackErr)
+		}
+		return err
+	}
+
+	return tx.Commit().Error
+}
+
+// 記憶體的持久層 執行 fn, fn 回傳錯誤或 panic 就還原全部持久層的資料 (與 db 事務的 Rollback 相同)
+func (s *RepositoriesManager) memoryTransaction(fn func(uow *UnitOfWork) error) (err error) {
+
+	var restores []func()
+	for _, repo := range []interface{}{s.UserRepo, s.TransactionRepo, s.TradeRepo, s.BackpackRepo} {
+		if repo, ok := repo.(savepointer); ok {
+			restores = append(restores, repo.Savepoint())
+		}
+	}
+
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("transaction panic:%v", r)
+		}
+		if err != nil {
+			for _, restore := range restores {
+				restore()
+			}
+		}
+	}()
+
+	return fn(&UnitOfWork{
+		UserRepo:        s.UserRepo,
+		TransactionRepo: s.TransactionRepo,
+		TradeRepo:       s.TradeRepo,
+		BackpackRepo:    s.BackpackRepo,
+	})
+}
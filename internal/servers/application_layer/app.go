@@ -1,6 +1,9 @@
 package application_layer
 
 import (
+	"marketplace_server/config"
+	domain_bill "marketplace_server/internal/bill/domain_layer"
+	"marketplace_server/internal/common/logs"
 	application_product "marketplace_server/internal/product/application_layer"
 	Infrastructure_server "marketplace_server/internal/servers/Infrastructure_layer"
 	"marketplace_server/internal/user/application_layer"
@@ -11,16 +14,24 @@ import (
 type Apps struct {
 	UserApp    application_user.UserAppInterface       // 用戶應用層
 	ProductAPP application_product.ProductAppInterface // 產品應用層
+	Events     Infrastructure_server.EventRepo         // 即時事件 (websocket 轉發)
 }
 
-func NewApps(repos *Infrastructure_server.RepositoriesManager) *Apps {
+func NewApps(cfg *config.Config, repos *Infrastructure_server.RepositoriesManager) *Apps {
 
 	//  取得產品APP層
-	productAPP := application_product.NewProductApp(repos.ProductRepo)
+	productAPP := application_product.NewProductApp(repos.ProductRepo, repos.CandleRepo, repos.DepthRepo)
+
+	// 手續費 (買單預扣最多可能的手續費)
+	feeService, err := domain_bill.NewFeeService(cfg.Fee)
+	if err != nil {
+		logs.Fatalf("newFeeService fail err:%v", err)
+	}
 
 	// 綁定應用層物件, 並回傳
 	return &Apps{
-		UserApp:    application_layer.NewUserApp(repos.UserRepo, repos.AuthRepo, repos.TransactionRepo, productAPP),
+		UserApp:    application_layer.NewUserApp(repos.UserRepo, repos.AuthRepo, repos.NotifyRepo, repos.SeqRepo, repos.TransactionRepo, repos.BackpackRepo, productAPP, feeService),
 		ProductAPP: productAPP,
+		Events:     repos.EventRepo,
 	}
 }
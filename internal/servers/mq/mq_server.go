@@ -0,0 +1,80 @@
+package mq
+
+import (
+	"encoding/json"
+	"marketplace_server/config"
+	"marketplace_server/internal/common/logs"
+	"marketplace_server/internal/common/rabbitmqx"
+	"marketplace_server/internal/common/servers"
+	application_server "marketplace_server/internal/servers/application_layer"
+	"marketplace_server/internal/user/model"
+)
+
+const (
+	Version = "成交回報-1.0.0"
+
+	QueueExecutionReport = "execution_report_queue" // 成交回報佇列
+	MaxRetry             = 5                        // 處理失敗的重試次數, 超過送到死信佇列
+)
+
+// 接收 transaction_server 的成交回報
+type MqServer struct {
+	cfg      *config.Config
+	consumer *rabbitmqx.Consumer
+	Apps     *application_server.Apps
+}
+
+func (s *MqServer) GetVersion() string {
+	return Version
+}
+
+func (s *MqServer) GetSystemInfo() string {
+	return ""
+}
+
+func (s *MqServer) AsyncStart() {
+
+	uri := "amqp://" + s.cfg.RabbitMq.User + ":" + s.cfg.RabbitMq.Password + "@" +
+		s.cfg.RabbitMq.Host + ":" + s.cfg.RabbitMq.Port + "/"
+
+	s.consumer = rabbitmqx.NewConsumer(uri, "direct",
+		model.ExecutionReportExchange, QueueExecutionReport,
+		model.BindKeyExecutionReport, "marketplace_server", false,
+		true, s.handle).
+		SetOrdered(true). // 同一用戶的回報依序調整緩存餘額
+		SetMaxRetry(MaxRetry, rabbitmqx.DefaultRetryInterval)
+	if err := s.consumer.Start(); err != nil {
+		logs.Errorf("[服务启动] [mq] 服务异常 err:%v", err)
+		s.consumer = nil
+		return
+	}
+	logs.Debugf("[服务启动] [mq] exchange:%s, queue:%s", model.ExecutionReportExchange, QueueExecutionReport)
+}
+
+func (s *MqServer) Stop() {
+	logs.Debugf("[服务关闭] [mq] 关闭服务")
+	if s.consumer != nil {
+		s.consumer.Stop()
+	}
+}
+
+// 收到成交回報
+func (s *MqServer) handle(message []byte) error {
+
+	report := &model.ExecutionReport{}
+	if err := json.Unmarshal(message, report); err != nil {
+		// 格式錯誤 重送也不會成功
+		logs.Errorf("unmarshal err, err:%v, message:%v", err, string(message))
+		return nil
+	}
+	logs.Debugf("executionReport:%+v", report)
+
+	return s.Apps.UserApp.HandleExecutionReport(report)
+}
+
+func NewMqServer(cfg *config.Config, apps *application_server.Apps) servers.ServerInterface {
+	return &MqServer{
+		cfg:  cfg,
+		Apps: apps,
+	}
+}
@@ -8,14 +8,18 @@ import (
 func WithRouter(s *WebServer) {
 	// 新建 handler 呼叫 interface層
 	userHandler := interface_user.NewUserHandler(s.Apps.UserApp, s.Apps.ProductAPP)
-	authMiddleware := interface_user.NewAuthMiddleware(s.Apps.UserApp)
+	authMiddleware := interface_user.NewAuthMiddleware(s.Apps.UserApp, s.cfg.Admin.UserIDs)
 	productHandler := interface_product.NewProducHandler(s.Apps.ProductAPP)
+	s.wsGateway = NewWsGateway(s.Apps)
 
 	// 路由
 	auth := s.Engin.Group("/auth")
 	auth.POST("/login", userHandler.Login)       // 用戶登入 token ttl=expireTime(2hour)
 	auth.POST("/register", userHandler.Register) // 用戶註冊
 
+	// websocket 即時推播 (token 可放在 header 或 query)
+	s.Engin.GET("/v1/ws", authMiddleware.AuthWebSocket, s.wsGateway.Serve)
+
 	// api
 	api := s.Engin.Group("/v1")
 
@@ -25,7 +29,17 @@ func WithRouter(s *WebServer) {
 	// 路由
 	api.GET("/user_info", userHandler.UserInfo)                      // 取得用戶資料
 	api.GET("/get_market_price", productHandler.GetMarketPrice)      // 取得市場價格
+	api.GET("/candles", productHandler.GetCandles)                   // 取得K線 (1m / 5m / 1h / 1d)
+	api.GET("/depth", productHandler.GetDepth)                       // 取得深度快照
+	api.GET("/depth_updates", productHandler.GetDepthUpdates)        // 取得深度快照之後的增量更新
 	api.POST("/create_product", productHandler.CreateProduct)        // 商品上架
 	api.POST("/transaction_product", userHandler.TransactionProduct) // 買商品 / 賣商品
 	api.POST("/cancel_product", userHandler.CancelProduct)           // 取消交易
+	api.POST("/amend_product", userHandler.AmendProduct)             // 修改交易 (價格 / 數量)
+	api.POST("/cancel_all_product", userHandler.CancelAllProduct)    // 取消自己全部的交易 (可指定商品)
+
+	// 管理員 api
+	admin := api.Group("/admin")
+	admin.Use(authMiddleware.Admin)
+	admin.POST("/cancel_all_product", userHandler.AdminCancelAllProduct) // 取消指定用戶 / 指定商品 全部的交易
 }
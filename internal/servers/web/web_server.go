@@ -29,9 +29,11 @@ const (
 )
 
 type WebServer struct {
+	cfg        *config.Config
 	httpServer *http.Server
 	Engin      *gin.Engine
 	Apps       *application_server.Apps
+	wsGateway  *WsGateway
 }
 
 func (s *WebServer) GetVersion() string {
@@ -59,6 +61,7 @@ func (s *WebServer) Stop() {
 	if err := s.httpServer.Shutdown(ctx); err != nil {
 		logs.Fatalf("[服务关闭] [web] 关闭服务异常: %s", zap.Error(err))
 	}
+	s.wsGateway.Close()
 }
 
 func NewWebServer(cfg *config.Config, apps *application_server.Apps) servers.ServerInterface {
@@ -83,6 +86,7 @@ func NewWebServer(cfg *config.Config, apps *application_server.Apps) servers.Ser
 	}
 
 	server := &WebServer{
+		cfg:        cfg,
 		httpServer: httpServer,
 		Engin:      e,
 		Apps:       apps,
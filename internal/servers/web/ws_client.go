@@ -0,0 +1,349 @@
+package web
+
+import (
+	"encoding/json"
+	"errors"
+	"marketplace_server/internal/common/logs"
+	application_product "marketplace_server/internal/product/application_layer"
+	model_product "marketplace_server/internal/product/model"
+	model_user "marketplace_server/internal/user/model"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/net/websocket"
+)
+
+const (
+	WsOp_Subscribe   = "subscribe"   // 訂閱頻道
+	WsOp_Unsubscribe = "unsubscribe" // 取消訂閱頻道
+	WsOp_Ping        = "ping"        // 保持連線
+	WsOp_Pong        = "pong"        // ping 的回應
+	WsOp_Error       = "error"       // 錯誤
+
+	WsType_Snapshot = "snapshot" // 訂閱時的目前資料
+	WsType_Update   = "update"   // 即時更新
+
+	wsSendBuffer      = 256              // 待送出的訊息數量, 超過代表客戶端太慢 中斷連線
+	wsReadTimeout     = 60 * time.Second // 超過時間沒有收到客戶端訊息 (包含 ping) 中斷連線
+	wsWriteTimeout    = 10 * time.Second // 送出訊息的逾時
+	wsMaxMessageSize  = 4096             // 客戶端訊息的最大長度
+	wsMaxSubscription = 50               // 每個連線最多訂閱的頻道數量
+)
+
+var (
+	errTooManySubscriptions = errors.New("too many subscriptions")
+	errSubscribeFail        = errors.New("subscribe fail")
+)
+
+// 客戶端 -> 伺服器
+type C2S_WsRequest struct {
+	Op       string   `json:"op"`       // subscribe | unsubscribe | ping
+	Channels []string `json:"channels"` // 頻道 trades:<商品> depth:<商品> candles:<商品> ticker:<商品> orders balance
+}
+
+// 伺服器 -> 客戶端
+type S2C_WsMessage struct {
+	Op       string      `json:"op,omitempty"`       // 回應的操作 subscribe | unsubscribe | pong | error
+	Channels []string    `json:"channels,omitempty"` // 目前訂閱的頻道 (回應訂閱 取消訂閱)
+	Channel  string      `json:"channel,omitempty"`  // 事件的頻道
+	Type     string      `json:"type,omitempty"`     // 事件種類 snapshot | update
+	Data     interface{} `json:"data,omitempty"`     // 事件內容
+	Error    string      `json:"error,omitempty"`    // 錯誤訊息
+}
+
+// websocket 連線
+type WsClient struct {
+	gateway   *WsGateway
+	conn      *websocket.Conn
+	userID    int64
+	send      chan []byte
+	done      chan struct{}
+	closeOnce sync.Once
+	lock      sync.Mutex
+	channels  map[string]string // 訂閱的頻道 key=頻道 value=redis 頻道
+	depthSeq  map[string]int64  // 已送出的深度序號 key=頻道, 序號較舊的增量更新不送出
+}
+
+func newWsClient(gateway *WsGateway, conn *websocket.Conn, userID int64) *WsClient {
+	conn.MaxPayloadBytes = wsMaxMessageSize
+	return &WsClient{
+		gateway:  gateway,
+		conn:     conn,
+		userID:   userID,
+		send:     make(chan []byte, wsSendBuffer),
+		done:     make(chan struct{}),
+		channels: make(map[string]string),
+		depthSeq: make(map[string]int64),
+	}
+}
+
+// 處理連線, 直到斷線
+func (c *WsClient) run() {
+	logs.Debugf("[websocket] 連線 userID:%v", c.userID)
+	defer c.Close()
+
+	go c.writeLoop()
+
+	for {
+		c.conn.SetReadDeadline(time.Now().Add(wsReadTimeout))
+		var message string
+		if err := websocket.Message.Receive(c.conn, &message); err != nil {
+			logs.Debugf("[websocket] 斷線 userID:%v, err:%v", c.userID, err)
+			return
+		}
+
+		req := &C2S_WsRequest{}
+		if err := json.Unmarshal([]byte(message), req); err != nil {
+			c.enqueue(&S2C_WsMessage{Op: WsOp_Error, Error: "invalid message"})
+			continue
+		}
+		c.handle(req)
+	}
+}
+
+// 處理客戶端的請求
+func (c *WsClient) handle(req *C2S_WsRequest) {
+
+	switch req.Op {
+	case WsOp_Ping:
+		c.enqueue(&S2C_WsMessage{Op: WsOp_Pong})
+		return
+	case WsOp_Subscribe, WsOp_Unsubscribe:
+	default:
+		c.enqueue(&S2C_WsMessage{Op: WsOp_Error, Error: "unknown op:" + req.Op})
+		return
+	}
+
+	var errs []string
+	for _, channel := range req.Channels {
+		var err error
+		if req.Op == WsOp_Subscribe {
+			err = c.subscribe(channel)
+		} else {
+			c.unsubscribe(channel)
+		}
+		if err != nil {
+			errs = append(errs, err.Error())
+		}
+	}
+
+	resp := &S2C_WsMessage{Op: req.Op, Channels: c.subscribed()}
+	if len(errs) > 0 {
+		resp.Error = strings.Join(errs, "; ")
+	}
+	c.enqueue(resp)
+}
+
+// 訂閱頻道, 深度 市場價格 餘額 先送出目前的資料
+// 持有連線的鎖 直到快照送出, 期間收到的事件 等快照送出後才處理
+func (c *WsClient) subscribe(channel string) error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if _, ok := c.channels[channel]; ok {
+		return nil
+	}
+	if len(c.channels) >= wsMaxSubscription {
+		return errTooManySubscriptions
+	}
+	redisChannel, err := redisChannel(channel, c.userID)
+	if err != nil {
+		return err
+	}
+	if err = c.gateway.subscribe(c, redisChannel); err != nil {
+		logs.Errorf("[websocket] subscribe fail channel:%v, err:%v", redisChannel, err)
+		return errSubscribeFail
+	}
+
+	snapshot, err := c.snapshot(channel)
+	if err != nil {
+		logs.Warnf("[websocket] snapshot fail channel:%v, err:%v", channel, err)
+	}
+	c.channels[channel] = redisChannel
+	if snapshot != nil {
+		c.enqueue(&S2C_WsMessage{Channel: channel, Type: WsType_Snapshot, Data: snapshot})
+	}
+	return nil
+}
+
+// 取消訂閱頻道
+func (c *WsClient) unsubscribe(channel string) {
+	c.lock.Lock()
+	redisChannel, ok := c.channels[channel]
+	delete(c.channels, channel)
+	delete(c.depthSeq, channel)
+	shared := c.sharesRedisChannel(redisChannel)
+	c.lock.Unlock()
+
+	// 訂單 餘額 共用用戶的通知頻道, 都取消才取消 redis 訂閱
+	if ok && !shared {
+		c.gateway.unsubscribe(c, redisChannel)
+	}
+}
+
+// 是否還有其他頻道 使用同一個 redis 頻道 (需持有鎖)
+func (c *WsClient) sharesRedisChannel(redisChannel string) bool {
+	for _, other := range c.channels {
+		if other == redisChannel {
+			return true
+		}
+	}
+	return false
+}
+
+// 目前訂閱的頻道
+func (c *WsClient) subscribed() []string {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	channels := make([]string, 0, len(c.channels))
+	for channel := range c.channels {
+		channels = append(channels, channel)
+	}
+	sort.Strings(channels)
+	return channels
+}
+
+// 訂閱時的目前資料, 沒有快照的頻道回傳 nil (需持有鎖)
+func (c *WsClient) snapshot(channel string) (interface{}, error) {
+
+	if channel == WsChannel_Balance {
+		return c.gateway.apps.UserApp.GetUserInfo(c.userID)
+	}
+
+	kind, productName, _ := strings.Cut(channel, ":")
+	switch kind {
+	case WsChannel_Depth:
+		snapshot, err := c.gateway.apps.ProductAPP.GetDepth(&model_product.DepthParams{ProductName: productName})
+		if err == application_product.Error_DepthNotFound {
+			snapshot = &model_product.DepthSnapshot{
+				ProductName: productName,
+				Bids:        []*model_product.DepthLevel{},
+				Asks:        []*model_product.DepthLevel{},
+			}
+		} else if err != nil {
+			return nil, err
+		}
+		c.depthSeq[channel] = snapshot.Seq
+		return snapshot, nil
+
+	case WsChannel_Ticker:
+		_, dataMap, err := c.gateway.apps.ProductAPP.GetMarketPrice(&model_product.MarketPriceParams{})
+		if err != nil {
+			return nil, err
+		}
+		marketPriceJson, ok := dataMap[productName]
+		if !ok {
+			return nil, nil
+		}
+		marketPriceDetail, err := model_product.NewMarketPriceRedis(marketPriceJson)
+		if err != nil {
+			return nil, err
+		}
+		return &model_product.TickerEvent{ProductName: productName, MarketPriceRedis: marketPriceDetail}, nil
+	}
+	return nil, nil
+}
+
+// 收到 redis 的事件, 轉發到有訂閱的頻道
+func (c *WsClient) onEvent(redisChannel string, payload []byte) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	for channel, subscribed := range c.channels {
+		if subscribed != redisChannel {
+			continue
+		}
+		data, ok := c.filter(channel, payload)
+		if !ok {
+			continue
+		}
+		c.enqueue(&S2C_WsMessage{Channel: channel, Type: WsType_Update, Data: data})
+	}
+}
+
+// 依頻道過濾事件 (需持有鎖)
+// 用戶通知依種類分到 訂單 與 餘額 頻道, 深度只送出比快照新的增量更新
+func (c *WsClient) filter(channel string, payload []byte) (json.RawMessage, bool) {
+
+	switch channel {
+	case WsChannel_Orders, WsChannel_Balance:
+		var notify struct {
+			Type string          `json:"type"`
+			Data json.RawMessage `json:"data"`
+		}
+		if err := json.Unmarshal(payload, &notify); err != nil {
+			return nil, false
+		}
+		if (channel == WsChannel_Orders) != (notify.Type == model_user.UserNotify_Order) {
+			return nil, false
+		}
+		return notify.Data, true
+	}
+
+	if seq, ok := c.depthSeq[channel]; ok {
+		update, err := model_product.NewDepthUpdate(string(payload))
+		if err != nil || update.Seq <= seq {
+			return nil, false
+		}
+		c.depthSeq[channel] = update.Seq
+	}
+	return json.RawMessage(payload), true
+}
+
+// 放入待送出的訊息, 客戶端太慢 (待送出的訊息已滿) 中斷連線
+func (c *WsClient) enqueue(message *S2C_WsMessage) {
+
+	byteArray, err := json.Marshal(message)
+	if err != nil {
+		logs.Errorf("[websocket] marshal fail message:%+v, err:%v", message, err)
+		return
+	}
+
+	select {
+	case c.send <- byteArray:
+	case <-c.done:
+	default:
+		logs.Warnf("[websocket] 客戶端太慢 中斷連線 userID:%v", c.userID)
+		go c.Close()
+	}
+}
+
+// 送出訊息
+func (c *WsClient) writeLoop() {
+	for {
+		select {
+		case message := <-c.send:
+			c.conn.SetWriteDeadline(time.Now().Add(wsWriteTimeout))
+			if err := websocket.Message.Send(c.conn, string(message)); err != nil {
+				logs.Debugf("[websocket] send fail userID:%v, err:%v", c.userID, err)
+				c.Close()
+				return
+			}
+		case <-c.done:
+			return
+		}
+	}
+}
+
+// 關閉連線 並取消所有訂閱
+func (c *WsClient) Close() {
+	c.closeOnce.Do(func() {
+		close(c.done)
+		c.conn.Close()
+
+		c.lock.Lock()
+		redisChannels := make(map[string]bool)
+		for _, redisChannel := range c.channels {
+			redisChannels[redisChannel] = true
+		}
+		c.channels = make(map[string]string)
+		c.lock.Unlock()
+
+		for redisChannel := range redisChannels {
+			c.gateway.unsubscribe(c, redisChannel)
+		}
+	})
+}
@@ -0,0 +1,174 @@
+package web
+
+import (
+	"context"
+	"fmt"
+	Infrastructure_bill "marketplace_server/internal/bill/Infrastructure_layer"
+	"marketplace_server/internal/common/logs"
+	Infrastructure_product "marketplace_server/internal/product/Infrastructure_layer"
+	application_server "marketplace_server/internal/servers/application_layer"
+	Infrastructure_user "marketplace_server/internal/user/Infrastructure_layer"
+	interface_user "marketplace_server/internal/user/interface_layer"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/gin-gonic/gin"
+	goredis "github.com/redis/go-redis/v9"
+	"golang.org/x/net/websocket"
+)
+
+// websocket 的頻道
+const (
+	WsChannel_Trades  = "trades"  // 成交 trades:<商品名稱>
+	WsChannel_Depth   = "depth"   // 深度 depth:<商品名稱> (訂閱時先送出快照, 之後為增量更新)
+	WsChannel_Candles = "candles" // K線 candles:<商品名稱> (所有週期)
+	WsChannel_Ticker  = "ticker"  // 市場價格 ticker:<商品名稱> (訂閱時先送出目前價格)
+	WsChannel_Orders  = "orders"  // 自己的訂單回報
+	WsChannel_Balance = "balance" // 自己的餘額變動 (訂閱時先送出目前餘額)
+)
+
+// websocket 即時推播
+// 所有連線共用一個 redis 訂閱, 依連線訂閱的頻道 動態增減 redis 頻道, 收到事件後轉發給有訂閱的連線
+type WsGateway struct {
+	apps    *application_server.Apps
+	pubsub  *goredis.PubSub
+	lock    sync.Mutex
+	clients map[string]map[*WsClient]bool // 訂閱的連線 key=redis 頻道
+	closed  bool
+}
+
+func NewWsGateway(apps *application_server.Apps) *WsGateway {
+	g := &WsGateway{
+		apps:    apps,
+		pubsub:  apps.Events.Subscribe(),
+		clients: make(map[string]map[*WsClient]bool),
+	}
+	go g.run()
+	return g
+}
+
+// 接收 redis 的事件 轉發給有訂閱的連線
+func (g *WsGateway) run() {
+	for msg := range g.pubsub.Channel() {
+		g.lock.Lock()
+		clients := make([]*WsClient, 0, len(g.clients[msg.Channel]))
+		for client := range g.clients[msg.Channel] {
+			clients = append(clients, client)
+		}
+		g.lock.Unlock()
+
+		for _, client := range clients {
+			client.onEvent(msg.Channel, []byte(msg.Payload))
+		}
+	}
+	logs.Debugf("[websocket] 停止接收事件")
+}
+
+// 連線訂閱 redis 頻道, 第一個訂閱的連線 才向 redis 訂閱
+func (g *WsGateway) subscribe(client *WsClient, channel string) error {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+
+	if g.closed {
+		return fmt.Errorf("gateway closed")
+	}
+	clients, ok := g.clients[channel]
+	if !ok {
+		if err := g.pubsub.Subscribe(context.TODO(), channel); err != nil {
+			return err
+		}
+		clients = make(map[*WsClient]bool)
+		g.clients[channel] = clients
+	}
+	clients[client] = true
+	return nil
+}
+
+// 連線取消訂閱 redis 頻道, 沒有連線訂閱時 才向 redis 取消訂閱
+func (g *WsGateway) unsubscribe(client *WsClient, channel string) {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+
+	clients, ok := g.clients[channel]
+	if !ok {
+		return
+	}
+	delete(clients, client)
+	if len(clients) > 0 || g.closed {
+		return
+	}
+	delete(g.clients, channel)
+	if err := g.pubsub.Unsubscribe(context.TODO(), channel); err != nil {
+		logs.Warnf("[websocket] unsubscribe fail channel:%v, err:%v", channel, err)
+	}
+}
+
+// 關閉 redis 訂閱 與 所有連線 (websocket 連線已被接管, 關閉 http server 不會中斷)
+func (g *WsGateway) Close() {
+	g.lock.Lock()
+	g.closed = true
+	var clients []*WsClient
+	for _, list := range g.clients {
+		for client := range list {
+			clients = append(clients, client)
+		}
+	}
+	g.lock.Unlock()
+
+	if err := g.pubsub.Close(); err != nil {
+		logs.Warnf("[websocket] close pubsub fail err:%v", err)
+	}
+	for _, client := range clients {
+		client.Close()
+	}
+}
+
+// 將頻道轉換成 redis 頻道, 商品頻道格式為 <種類>:<商品名稱>
+func redisChannel(channel string, userID int64) (string, error) {
+
+	switch channel {
+	case WsChannel_Orders, WsChannel_Balance:
+		return fmt.Sprintf(Infrastructure_user.Redis_UserNotify, userID), nil
+	}
+
+	kind, productName, ok := strings.Cut(channel, ":")
+	if !ok || len(productName) == 0 {
+		return "", fmt.Errorf("unknown channel:%v", channel)
+	}
+	switch kind {
+	case WsChannel_Trades:
+		return fmt.Sprintf(Infrastructure_bill.Redis_TradeChannel, productName), nil
+	case WsChannel_Depth:
+		return fmt.Sprintf(Infrastructure_product.Redis_DepthChannel, productName), nil
+	case WsChannel_Candles:
+		return fmt.Sprintf(Infrastructure_product.Redis_CandleChannel, productName), nil
+	case WsChannel_Ticker:
+		return fmt.Sprintf(Infrastructure_product.Redis_TickerChannel, productName), nil
+	}
+	return "", fmt.Errorf("unknown channel:%v", channel)
+}
+
+// PingExample godoc
+// @Summary websocket 即時推播
+// @Description subscribe to trades, depth, candles, ticker and private order / balance updates.
+// @Description send {"op":"subscribe","channels":["trades:BTC","depth:BTC","orders"]}, {"op":"unsubscribe",...} or {"op":"ping"}
+// @Schemes
+// @Tags product
+// @Param	token	query	string	false	"與 Authorization header 相同的 token (瀏覽器無法設定 header 時使用)"
+// @Failure     401		{object}	response.HTTPError
+// @Router /v1/ws [get]
+func (g *WsGateway) Serve(c *gin.Context) {
+
+	userID := c.GetInt64(interface_user.UserIDKey)
+	server := websocket.Server{
+		// 不檢查 Origin (與 api 的 cors 設定相同), 連線需要 token
+		Handshake: func(*websocket.Config, *http.Request) error {
+			return nil
+		},
+		Handler: func(conn *websocket.Conn) {
+			newWsClient(g, conn, userID).run()
+		},
+	}
+	server.ServeHTTP(c.Writer, c.Request)
+}
@@ -2,6 +2,7 @@ package Infrastructure_layer
 
 import (
 	"context"
+	"fmt"
 	"marketplace_server/internal/common/logs"
 	"marketplace_server/internal/user/model"
 	"strconv"
@@ -32,6 +33,7 @@ type AuthInterface interface {
 	Del(string) error
 	GetKey(userId int64) string
 	GetAuthUser(userId int64) (*model.AuthInfo, error)
+	UpdateAmount(userId int64, update func(auth *model.AuthInfo) error) (*model.AuthInfo, error) // 原子性的調整用戶緩存的餘額
 }
 
 const (
@@ -107,9 +109,16 @@ func (r *TokenAuth) GetAuthUser(userId int64) (*model.AuthInfo, error) {
 	return nil, nil
 }
 
+// jwt 沒有用戶緩存
+func (r *TokenAuth) UpdateAmount(userId int64, update func(auth *model.AuthInfo) error) (*model.AuthInfo, error) {
+
+	return nil, nil
+}
+
 // ---------------------------------------------------
 const (
 	encryptKeyPrefix = "user:auth_" // 用user當資料夾
+	maxUpdateRetry   = 100          // 調整餘額 衝突時的重試次數
 )
 
 // redis: cookie + session 认证
@@ -157,3 +166,36 @@ func (r *RedisAuth) GetAuthUser(userId int64) (*model.AuthInfo, error) {
 	key := r.GetKey(userId)
 	return r.Get(key)
 }
+
+// 原子性的調整用戶緩存的餘額 (WATCH/MULTI 樂觀鎖, 期間被其他程序修改就重新讀取再執行 update)
+// marketplace_server 與 transaction_server 都會調整餘額, 不能各自 讀取 -> 修改 -> 寫入
+// update 回傳錯誤時 不寫入 直接回傳該錯誤
+func (r *RedisAuth) UpdateAmount(userId int64, update func(auth *model.AuthInfo) error) (*model.AuthInfo, error) {
+
+	ctx := context.Background()
+	key := r.GetKey(userId)
+	for retry := 0; retry < maxUpdateRetry; retry++ {
+		auth := &model.AuthInfo{}
+		err := r.c.Watch(ctx, func(tx *redis.Tx) error {
+			if err := tx.Get(ctx, key).Scan(auth); err != nil {
+				return err
+			}
+			if err := update(auth); err != nil {
+				return err
+			}
+			_, err := tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+				pipe.Set(ctx, key, auth, r.expireTime)
+				return nil
+			})
+			return err
+		}, key)
+		if err == redis.TxFailedErr {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		return auth, nil
+	}
+	return nil, fmt.Errorf("update amount conflict userID:%v, retry:%d", userId, maxUpdateRetry)
+}
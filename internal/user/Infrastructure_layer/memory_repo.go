@@ -0,0 +1,232 @@
+package Infrastructure_layer
+
+import (
+	"fmt"
+	"marketplace_server/internal/user/model"
+	"sort"
+	"sync"
+
+	"github.com/shopspring/decimal"
+)
+
+// 記憶體的用戶持久層 (回放工具 測試使用, 不需要 mysql)
+// 讀取 與 寫入 都複製一份, 與 db 相同 修改取出的物件不影響已儲存的資料
+type MemoryUserRepo struct {
+	lock sync.RWMutex
+	list map[int64]*model.User // key=用戶ID
+}
+
+var _ UserRepo = &MemoryUserRepo{}
+
+func NewMemoryUserRepo() *MemoryUserRepo {
+	return &MemoryUserRepo{list: make(map[int64]*model.User)}
+}
+
+func (r *MemoryUserRepo) GetUserByLoginParams(params *model.LoginParams) (*model.User, error) {
+	if len(params.Username) == 0 || len(params.Password) == 0 {
+		return nil, ErrUserParamsInvalid
+	}
+	for _, user := range r.GetUserList() {
+		if user.Username == params.Username && user.Password == params.Password {
+			return user, nil
+		}
+	}
+	return nil, ErrUserUsernameOrPassword
+}
+
+func (r *MemoryUserRepo) GetUserByRegisterParams(params *model.RegisterParams) (*model.User, error) {
+	if len(params.Username) == 0 || len(params.Password) == 0 {
+		return nil, ErrUserParamsInvalid
+	}
+	for _, user := range r.GetUserList() {
+		if user.Username == params.Username {
+			return user, nil
+		}
+	}
+	return nil, ErrUserNotFound
+}
+
+// 取得用戶資訊
+func (r *MemoryUserRepo) GetUserInfo(userID int64) (*model.User, error) {
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+
+	user, ok := r.list[userID]
+	if !ok {
+		return nil, ErrUserNotFound
+	}
+	data := *user
+	return &data, nil
+}
+
+func (r *MemoryUserRepo) Save(user *model.User) (*model.User, error) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	data := *user
+	r.list[user.UserID] = &data
+	result := data
+	return &result, nil
+}
+
+// 調整用戶餘額 (以目前儲存的金額計算), 餘額不足 不更新
+func (r *MemoryUserRepo) UpdateAmount(user *model.User, changeAmount decimal.Decimal) (*model.User, error) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	stored, ok := r.list[user.UserID]
+	if !ok {
+		return nil, ErrUserNotFound
+	}
+	data := *stored
+	data.Amount = data.Amount.Add(changeAmount)
+	if data.Amount.IsNegative() {
+		return nil, fmt.Errorf("IsNegative userID:%v, changeAmount:%s", user.UserID, changeAmount.String())
+	}
+	r.list[user.UserID] = &data
+	result := data
+	return &result, nil
+}
+
+// 記錄目前的資料, 回傳還原到此時的函式 (事務 Rollback 使用)
+// 儲存的物件都是複製的 不會被修改, 只需複製清單
+func (r *MemoryUserRepo) Savepoint() func() {
+	r.lock.RLock()
+	list := make(map[int64]*model.User, len(r.list))
+	for userID, user := range r.list {
+		list[userID] = user
+	}
+	r.lock.RUnlock()
+
+	return func() {
+		r.lock.Lock()
+		defer r.lock.Unlock()
+		r.list = list
+	}
+}
+
+// 取得全部的用戶, 依用戶ID排列
+func (r *MemoryUserRepo) GetUserList() []*model.User {
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+
+	list := make([]*model.User, 0, len(r.list))
+	for _, user := range r.list {
+		data := *user
+		list = append(list, &data)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].UserID < list[j].UserID })
+	return list
+}
+
+// 記憶體的驗證緩存 (用戶的緩存餘額), token 即為 GetKey 的 key
+type MemoryAuthRepo struct {
+	lock sync.RWMutex
+	list map[int64]*model.AuthInfo // key=用戶ID
+}
+
+var _ AuthInterface = &MemoryAuthRepo{}
+
+func NewMemoryAuthRepo() *MemoryAuthRepo {
+	return &MemoryAuthRepo{list: make(map[int64]*model.AuthInfo)}
+}
+
+func (r *MemoryAuthRepo) Set(auth *model.AuthInfo) (string, error) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	data := *auth
+	r.list[auth.UserID] = &data
+	return r.GetKey(auth.UserID), nil
+}
+
+func (r *MemoryAuthRepo) Get(token string) (*model.AuthInfo, error) {
+	var userID int64
+	if _, err := fmt.Sscanf(token, encryptKeyPrefix+"%d", &userID); err != nil {
+		return nil, err
+	}
+	return r.GetAuthUser(userID)
+}
+
+func (r *MemoryAuthRepo) Del(token string) error {
+	var userID int64
+	if _, err := fmt.Sscanf(token, encryptKeyPrefix+"%d", &userID); err != nil {
+		return err
+	}
+
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	delete(r.list, userID)
+	return nil
+}
+
+func (r *MemoryAuthRepo) GetKey(userID int64) string {
+	return fmt.Sprintf("%s%d", encryptKeyPrefix, userID)
+}
+
+func (r *MemoryAuthRepo) GetAuthUser(userId int64) (*model.AuthInfo, error) {
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+
+	auth, ok := r.list[userId]
+	if !ok {
+		return nil, ErrUserNotFound
+	}
+	data := *auth
+	return &data, nil
+}
+
+// 調整用戶緩存的餘額 (持有鎖 讀取 修改 寫入), update 回傳錯誤時 不寫入
+func (r *MemoryAuthRepo) UpdateAmount(userId int64, update func(auth *model.AuthInfo) error) (*model.AuthInfo, error) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	auth, ok := r.list[userId]
+	if !ok {
+		return nil, ErrUserNotFound
+	}
+	data := *auth
+	if err := update(&data); err != nil {
+		return nil, err
+	}
+	r.list[userId] = &data
+	result := data
+	return &result, nil
+}
+
+// 記憶體的指令序號 與 已處理的成交回報 (測試使用)
+type MemorySeqRepo struct {
+	lock    sync.Mutex
+	seqs    map[int64]int64     // key=用戶ID
+	reports map[string]struct{} // key=回報ID
+}
+
+var _ SeqRepo = &MemorySeqRepo{}
+
+func NewMemorySeqRepo() *MemorySeqRepo {
+	return &MemorySeqRepo{seqs: make(map[int64]int64), reports: make(map[string]struct{})}
+}
+
+func (r *MemorySeqRepo) NextCommandSeq(userID int64) (int64, error) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.seqs[userID]++
+	return r.seqs[userID], nil
+}
+
+func (r *MemorySeqRepo) MarkReport(reportID string) (bool, error) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if _, ok := r.reports[reportID]; ok {
+		return false, nil
+	}
+	r.reports[reportID] = struct{}{}
+	return true, nil
+}
+
+func (r *MemorySeqRepo) UnmarkReport(reportID string) error {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	delete(r.reports, reportID)
+	return nil
+}
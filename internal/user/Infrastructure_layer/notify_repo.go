@@ -0,0 +1,32 @@
+package Infrastructure_layer
+
+import (
+	"context"
+	"fmt"
+
+	redis "github.com/redis/go-redis/v9"
+)
+
+const (
+	Redis_UserNotify = "user:notify:%d" // redis 用戶通知頻道 (pub/sub) %d=用戶ID
+)
+
+// [Infrastructure層] 推播通知給用戶
+type NotifyRepo interface {
+	PublishUser(userID int64, message []byte) error
+}
+
+var _ NotifyRepo = &RedisNotifyRepo{}
+
+type RedisNotifyRepo struct {
+	c *redis.Client
+}
+
+func NewRedisNotifyRepo(c *redis.Client) *RedisNotifyRepo {
+	return &RedisNotifyRepo{c: c}
+}
+
+// 發佈到用戶的通知頻道
+func (r *RedisNotifyRepo) PublishUser(userID int64, message []byte) error {
+	return r.c.Publish(context.TODO(), fmt.Sprintf(Redis_UserNotify, userID), message).Err()
+}
@@ -99,24 +99,22 @@ func (r *MysqlUserRepo) Save(user *model.User) (*model.User, error) {
 	return userPO.ToDomain()
 }
 
+// 調整用戶餘額, 以 db 目前的金額條件更新 (不會覆蓋同時的修改), 餘額不足 不更新
 func (r *MysqlUserRepo) UpdateAmount(user *model.User, changeAmount decimal.Decimal) (*model.User, error) {
-	var userPO = user.ToPO()
 
-	finishAmount := userPO.Amount.Add(changeAmount)
-	logs.Debugf("finishAmount:%s", finishAmount.String(), userPO.Amount.String())
-	if finishAmount.IsNegative() {
-		return nil, fmt.Errorf("IsNegative amount:%s", finishAmount.String())
+	result := r.db.Model(&model.UserPO{}).
+		Where("user_id = ? AND amount + ? >= 0", user.UserID, changeAmount).
+		Updates(map[string]interface{}{
+			"amount":    gorm.Expr("amount + ?", changeAmount),
+			"update_at": time.Now(),
+		})
+	if result.Error != nil {
+		return nil, result.Error
 	}
-	if err := r.db.Save(&userPO).Error; err != nil {
-		return nil, err
+	if result.RowsAffected == 0 {
+		return nil, fmt.Errorf("IsNegative userID:%v, changeAmount:%s", user.UserID, changeAmount.String())
 	}
+	logs.Debugf("userID:%v, changeAmount:%s", user.UserID, changeAmount.String())
 
-	// userPO.Amount = userPO.Amount.Add(changeAmount)
-	// logs.Debugf("amount:%s", userPO.Amount.String())
-	// err := r.db.Model(&userPO).Where("user_id = ?", userPO.UserID).Update("amount", userPO.Amount).Error
-	// if err != nil {
-	// 	logs.Debugf("err:%v", err)
-	// }
-
-	return userPO.ToDomain()
+	return r.GetUserInfo(user.UserID)
 }
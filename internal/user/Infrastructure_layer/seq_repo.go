@@ -0,0 +1,44 @@
+package Infrastructure_layer
+
+import (
+	"context"
+	"strconv"
+	"time"
+
+	redis "github.com/redis/go-redis/v9"
+)
+
+const (
+	Redis_CommandSeq    = "user:command_seq"     // redis 用戶指令序號 hash field=用戶ID
+	Redis_ReportHandled = "user:report_handled:" // redis 已處理的成交回報 key=前綴+回報ID
+	ReportHandledExpire = 7 * 24 * time.Hour     // 已處理的成交回報 保留時間 (需大於 mq 重送的時間)
+)
+
+// [Infrastructure層] 指令序號 與 已處理的成交回報
+type SeqRepo interface {
+	NextCommandSeq(userID int64) (int64, error) // 取得用戶下一個指令序號
+	MarkReport(reportID string) (bool, error)   // 記錄已處理的成交回報, 第一次記錄回傳 true (重複的回報回傳 false)
+	UnmarkReport(reportID string) error         // 處理失敗 移除記錄 讓重送的回報可以再處理
+}
+
+var _ SeqRepo = &RedisSeqRepo{}
+
+type RedisSeqRepo struct {
+	c *redis.Client
+}
+
+func NewRedisSeqRepo(c *redis.Client) *RedisSeqRepo {
+	return &RedisSeqRepo{c: c}
+}
+
+func (r *RedisSeqRepo) NextCommandSeq(userID int64) (int64, error) {
+	return r.c.HIncrBy(context.TODO(), Redis_CommandSeq, strconv.FormatInt(userID, 10), 1).Result()
+}
+
+func (r *RedisSeqRepo) MarkReport(reportID string) (bool, error) {
+	return r.c.SetNX(context.TODO(), Redis_ReportHandled+reportID, 1, ReportHandledExpire).Result()
+}
+
+func (r *RedisSeqRepo) UnmarkReport(reportID string) error {
+	return r.c.Del(context.TODO(), Redis_ReportHandled+reportID).Err()
+}
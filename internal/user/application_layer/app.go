@@ -4,8 +4,11 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	Infrastructure_backpack "marketplace_server/internal/backpack/Infrastructure_layer"
+	model_backpack "marketplace_server/internal/backpack/model"
 	Infrastructure_bill "marketplace_server/internal/bill/Infrastructure_layer"
 	application_bill "marketplace_server/internal/bill/application_layer"
+	domain_bill "marketplace_server/internal/bill/domain_layer"
 	model_bill "marketplace_server/internal/bill/model"
 	"marketplace_server/internal/common/logs"
 	"marketplace_server/internal/common/rabbitmqx"
@@ -22,6 +25,9 @@ import (
 var (
 	Error_UserAlreadyExists = errors.New("用户已存在")
 	Error_VerifyFailed      = errors.New("验证失败")
+	Error_NotOwner          = errors.New("不是用户的交易单")
+	Error_TransactionClosed = errors.New("交易单已结束")
+	Error_PriceOutOfBand    = errors.New("委托价格超出价格限制")
 )
 
 // [應用層]
@@ -32,31 +38,48 @@ type UserAppInterface interface {
 	Register(register *model.RegisterParams) (*model.S2C_Login, error)
 
 	TransactionProduct(pirchase *model.ProductTransactionParams) (*model_bill.Transaction, error) // 買 / 賣 商品
-	CancelProduct(pirchase *model.ProductCancelParams) error                                      // 取消交易
+	CancelProduct(pirchase *model.ProductCancelParams) (*model.S2C_CancelProduct, error)          // 取消交易
+	CancelAllProduct(cancelAll *model.ProductCancelAllParams) error                               // 取消全部交易 (指定用戶 / 指定商品)
+	AmendProduct(amend *model.ProductAmendParams) error                                           // 修改交易 (價格 / 數量)
+	HandleExecutionReport(report *model.ExecutionReport) error                                    // 處理搓合引擎的成交回報
 }
 
 // 用戶應用層物件
 type UserApp struct {
 	userRepo        Infrastructure_user.UserRepo
 	authRepo        Infrastructure_user.AuthInterface
+	notifyRepo      Infrastructure_user.NotifyRepo
+	seqRepo         Infrastructure_user.SeqRepo
 	transferService domain_user.TransferService
 	rateService     domain_user.RateService
+	feeService      domain_bill.FeeService // 手續費 (買單預扣)
 
 	transactionApp  application_bill.TransactionAppInterface
-	transactionRepo Infrastructure_bill.TransactionRepo // 交易清單
+	transactionRepo Infrastructure_bill.TransactionRepo  // 交易清單
+	backpackRepo    Infrastructure_backpack.BackpackRepo // 背包
 
 	productAPP application_product.ProductAppInterface // 產品應用層
+
+	acceptor *OrderAcceptor // 受理買賣單 (預扣 與 寫入交易單)
+
+	publish func(exchange, bindKey string, body []byte) error // 發送到 mq (nil 使用 rabbitmqx.GetMq())
 }
 
-func NewUserApp(userRepo Infrastructure_user.UserRepo, authRepo Infrastructure_user.AuthInterface, transactionRepo Infrastructure_bill.TransactionRepo, productAPP application_product.ProductAppInterface) UserAppInterface {
+func NewUserApp(userRepo Infrastructure_user.UserRepo, authRepo Infrastructure_user.AuthInterface, notifyRepo Infrastructure_user.NotifyRepo, seqRepo Infrastructure_user.SeqRepo, transactionRepo Infrastructure_bill.TransactionRepo, backpackRepo Infrastructure_backpack.BackpackRepo, productAPP application_product.ProductAppInterface, feeService domain_bill.FeeService) UserAppInterface {
+	rateService := domain_user.NewRateService()
 	return &UserApp{
 		userRepo:        userRepo,
 		authRepo:        authRepo,
+		notifyRepo:      notifyRepo,
+		seqRepo:         seqRepo,
 		transferService: domain_user.NewTransferService(),
-		rateService:     domain_user.NewRateService(),
+		rateService:     rateService,
+		feeService:      feeService,
 		transactionApp:  application_bill.NewTransactionApp(transactionRepo),
 		transactionRepo: transactionRepo,
+		backpackRepo:    backpackRepo,
 		productAPP:      productAPP,
+		acceptor:        NewOrderAcceptor(authRepo, transactionRepo, backpackRepo, rateService, feeService),
 	}
 }
 
@@ -156,24 +179,22 @@ func (u *UserApp) TransactionProduct(transactionParams *model.ProductTransaction
 		return nil, err
 	}
 
-	// 讀取匯率
-	rate, err := u.rateService.GetRate(fromUser.Currency, transactionParams.Currency)
+	// 讀取 redis 目前市場價格 ( 橫向調用了 )
+	marketPriceRedis, err := u.getMarketPrice(transactionParams.ProductName)
 	if err != nil {
 		return nil, err
 	}
 
-	// 讀取 redis 目前市場價格 ( 橫向調用了 )
-	_, dataMap, err := u.productAPP.GetMarketPrice(nil)
-	if err != nil {
+	// 委託價格 換算成商品訂單簿的報價幣種 (搓合與成交都使用報價幣種)
+	if err = u.normalizeOrder(transactionParams, marketPriceRedis.Currency); err != nil {
+		logs.Errorf("normalizeOrder fail params:%+v, err:%v", transactionParams, err)
 		return nil, err
 	}
-	// 解析 redis 資料
-	var marketPriceRedis model_product.MarketPriceRedis
-	err = json.Unmarshal([]byte(dataMap[transactionParams.ProductName]), &marketPriceRedis)
-	if err != nil {
-		logs.Errorf("productName:%v, json:%+v, err:%v",
-			transactionParams.ProductName, dataMap[transactionParams.ProductName], err)
-		return nil, err
+
+	// 委託價格 需在最新成交價的價格限制內
+	if transactionParams.HasLimitPrice() && !marketPriceRedis.InBand(transactionParams.Amount) {
+		logs.Errorf("price out of band params:%+v, marketPrice:%+v", transactionParams, marketPriceRedis)
+		return nil, Error_PriceOutOfBand
 	}
 
 	// 取得用戶緩存
@@ -183,48 +204,18 @@ func (u *UserApp) TransactionProduct(transactionParams *model.ProductTransaction
 		return nil, err
 	}
 
-	logs.Debugf("productName:%v, marketPriceRedis:%v  rate:%v, auth:%+v",
-		transactionParams.ProductName, marketPriceRedis, rate.Get().String(), auth)
-
-	// 取得買或賣的數量
-	operateCount := decimal.NewFromInt(int64(transactionParams.OperateCount))
-
-	// 還沒到搓合階段, 無法知道真實成交價
-	var productNeedPrice decimal.Decimal
-	switch model.TransferMode(transactionParams.TransferMode) {
-	case model.Purchase: // 買單
-		// 計算 購買商品的價格 = redis 的商品價格 * 操作數量 * 匯率
-		productNeedPrice = marketPriceRedis.Amount.Mul(operateCount).Mul(rate.Get())
-		logs.Debugf("用戶的錢:%s, 操作數量:%v, 匯率:%v 購買商品的價格:%s, 商品名稱:%s",
-			fromUser.Amount.String(), operateCount.String(), rate.Get().String(), productNeedPrice.String(), transactionParams.ProductName)
-
-		//判斷用戶是否足夠錢買 (使用redis的緩存錢來判斷, db的用戶金額是真實交易時才會異動)
-		if !auth.Amount.GreaterThan(productNeedPrice) {
-			errMsg := fmt.Errorf("不夠錢買 %s < %s", auth.Amount.String(), productNeedPrice.String())
-			logs.Errorf("err:%v", errMsg)
-			return nil, errMsg
-		}
-	case model.Sell: // 賣單
-		// todo:撈取db 看賣家是否有足夠數量
-	default:
-		return nil, fmt.Errorf("transferMode fail mode:%v", transactionParams.TransferMode)
-	}
+	logs.Debugf("productName:%v, marketPriceRedis:%v, auth:%+v", transactionParams.ProductName, marketPriceRedis, auth)
 
-	// 時間戳
-	transactionParams.TimeStamp = time.Now().UnixNano()
+	// 時間戳, 交易單號由伺服器產生
+	now := time.Now()
+	transactionParams.TimeStamp = now.UnixNano()
+	transactionParams.TransactionID = ""
 
-	// 產生交易ID 格式為 UserID + TransferMode(買或賣) + 流水id
-	var id int64
-	id, err = u.transactionRepo.GetLastInsterId()
+	// 預扣金額 / 凍結商品數量 並寫入等待搓合的交易單
+	transaction, err := u.acceptor.Accept(fromUser, transactionParams, marketPriceRedis.Amount, now)
 	if err != nil {
-		if err.Error() != "record not found" {
-			logs.Errorf("getLastInsterId err:%v", err)
-			return nil, err
-		}
+		return nil, err
 	}
-	id++
-	transactionId := fmt.Sprintf("%d-%d-%012d", transactionParams.UserID, transactionParams.TransferMode, id)
-	transactionParams.TransactionID = transactionId
 
 	// 寫進message queue 給搓合微服務 transaction_server
 	var cmd model.Notify_Cmd
@@ -234,90 +225,376 @@ func (u *UserApp) TransactionProduct(transactionParams *model.ProductTransaction
 	case model.Sell: // 賣
 		cmd = model.Notify_Cmd_Sell
 	}
-	productTransactionNotify := model.ProductTransactionNotify{
-		Cmd:  cmd,
-		Data: transactionParams,
+	err = u.publishCommand(cmd, transactionParams.UserID, transactionParams)
+	if err != nil {
+		// 沒送出的訂單 標記為錯誤
+		transaction.Status = int8(model_bill.Transaction_Status_Error)
+		if saveErr := u.transactionRepo.Save(transaction); saveErr != nil {
+			logs.Errorf("transactionRepo save err:%v", saveErr)
+		}
+		u.acceptor.Release(transaction)
+		return nil, err
 	}
-	mqDataBytes, err := json.Marshal(productTransactionNotify)
+
+	logs.Debugf("成功發送到mq exchangeName:%s, routeKey:%s, transactionParams:%+v",
+		model.TransactionExchange, model.BindKeyPurchaseProduct, transactionParams)
+
+	return transaction, nil
+}
+
+// 讀取 redis 商品的市場價格
+func (u *UserApp) getMarketPrice(productName string) (*model_product.MarketPriceRedis, error) {
+
+	_, dataMap, err := u.productAPP.GetMarketPrice(nil)
 	if err != nil {
-		return nil, fmt.Errorf("marshal fail err=%v", err)
+		return nil, err
 	}
-	err = rabbitmqx.GetMq().PutIntoQueue(model.TransactionExchange, model.BindKeyPurchaseProduct, mqDataBytes)
+	// 解析 redis 資料
+	var marketPriceRedis model_product.MarketPriceRedis
+	err = json.Unmarshal([]byte(dataMap[productName]), &marketPriceRedis)
 	if err != nil {
-		logs.Errorf("putIntoQueue err:%v, exchange:%v, bindKey:%v",
-			err, model.TransactionExchange, model.BindKeyPurchaseProduct)
+		logs.Errorf("productName:%v, json:%+v, err:%v", productName, dataMap[productName], err)
 		return nil, err
 	}
+	return &marketPriceRedis, nil
+}
 
-	logs.Debugf("成功發送到mq exchangeName:%s, routeKey:%s, transactionParams:%+v",
-		model.TransactionExchange, model.BindKeyPurchaseProduct, transactionParams)
+// 將訂單的 委託價格 觸發價格 換算成商品的報價幣種, 沒有報價幣種 (舊商品) 不換算
+func (u *UserApp) normalizeOrder(transactionParams *model.ProductTransactionParams, quoteCurrency string) (err error) {
+
+	if len(quoteCurrency) == 0 || transactionParams.Currency == quoteCurrency {
+		return nil
+	}
+	transactionParams.Amount, err = domain_user.Exchange(u.rateService, transactionParams.Amount, transactionParams.Currency, quoteCurrency)
+	if err != nil {
+		return err
+	}
+	transactionParams.TriggerPrice, err = domain_user.Exchange(u.rateService, transactionParams.TriggerPrice, transactionParams.Currency, quoteCurrency)
+	if err != nil {
+		return err
+	}
+	logs.Debugf("換算報價幣種 transactionParams:%+v, from:%v", transactionParams, transactionParams.Currency)
+	transactionParams.Currency = quoteCurrency
+	return nil
+}
+
+// 取消交易單, 只能取消自己的訂單
+// 已經結束的訂單 直接回傳結果, 等待搓合中的訂單送到搓合引擎 (最終結果由成交回報通知)
+func (u *UserApp) CancelProduct(cancelParams *model.ProductCancelParams) (*model.S2C_CancelProduct, error) {
+	if cancelParams == nil {
+		return nil, fmt.Errorf("cancel == nil")
+	}
 
-	// 寫入db或redis, 狀態設定為 wait 搓合
-	transaction := &model_bill.Transaction{
-		TransactionID: transactionId,                            // 交易單號
-		TransferMode:  transactionParams.TransferMode,           // 交易模式 0:買 1:賣
-		TransferType:  transactionParams.TransferType,           // 交易種類 0:限價 1:市價
-		FromUserID:    transactionParams.UserID,                 // 發起人的用戶ID
-		ToUserID:      0,                                        // 交易對象的用戶ID (等交易完成後更新)
-		ProductName:   transactionParams.ProductName,            // 產品名稱
-		ProductCount:  transactionParams.OperateCount,           // 產品數量
-		Amount:        decimal.NewFromFloat(0),                  // 金額 (等交易完成後更新)
-		Currency:      transactionParams.Currency,               // 貨幣
-		CreatedAt:     time.Now(),                               // 創建時間
-		UodateAt:      time.Now(),                               // 更新時間
-		Status:        int8(model_bill.Transaction_Status_Wait), // 交易狀態 0:未完成 1:已完成
-	}
-	if err = u.transactionRepo.Save(transaction); err != nil {
-		logs.Errorf("transactionRepo save err:%v", err)
+	result := &model.S2C_CancelProduct{
+		TransactionID: cancelParams.TransactionID,
+		Result:        model.Cancel_Result_NotFound,
+	}
+
+	// 讀取db是否有此交易單
+	transaction, err := u.transactionRepo.GetTransactionInfo(cancelParams.TransactionID)
+	if err != nil {
+		if err.Error() == "record not found" {
+			return result, nil
+		}
 		return nil, err
 	}
-	logs.Debugf("寫入transaction:%+v", transaction)
+	// 不是自己的訂單 當作找不到
+	if transaction.FromUserID != cancelParams.UserID {
+		return result, nil
+	}
+
+	switch model_bill.Transaction_Status(transaction.Status) {
+	case model_bill.Transaction_Status_Wait, model_bill.Transaction_Status_PartialFilled:
+	case model_bill.Transaction_Status_Finish:
+		result.Result = model.Cancel_Result_AlreadyFilled
+		return result, nil
+	case model_bill.Transaction_Status_Cancel:
+		result.Result = model.Cancel_Result_Cancelled
+		return result, nil
+	default:
+		return result, nil
+	}
 
-	// 更新用戶的緩存 (如果是買單 先預扣)
-	auth.Amount = auth.Amount.Sub(productNeedPrice)
-	if _, err = u.authRepo.Set(auth); err != nil {
-		logs.Errorf("update user cache err:%v", err)
+	// 通知 mq
+	err = u.publishCommand(model.Notify_Cmd_Cancel, cancelParams.UserID, cancelParams)
+	if err != nil {
 		return nil, err
 	}
 
-	return transaction, nil
+	logs.Debugf("成功發送到mq exchangeName:%s, routeKey:%s, cancelParams:%+v, ProductName:%v, Status:%v",
+		model.TransactionExchange, model.BindKeyPurchaseProduct, cancelParams, transaction.ProductName, transaction.Status)
+
+	result.Result = model.Cancel_Result_Pending
+	return result, nil
 }
 
-// 取消交易單
-func (u *UserApp) CancelProduct(cancelParams *model.ProductCancelParams) error {
-	if cancelParams == nil {
-		return fmt.Errorf("cancel == nil")
+// 取消全部交易單 (指定用戶 / 指定商品), 每筆訂單的結果由成交回報通知
+func (u *UserApp) CancelAllProduct(cancelAllParams *model.ProductCancelAllParams) error {
+	if cancelAllParams == nil {
+		return fmt.Errorf("cancelAll == nil")
 	}
 
-	// 讀取db用戶數據 (來源)
-	user, err := u.userRepo.GetUserInfo(cancelParams.UserID)
+	// 通知 mq (指令序號使用下指令的用戶)
+	err := u.publishCommand(model.Notify_Cmd_CancelAll, cancelAllParams.Operator, cancelAllParams)
 	if err != nil {
 		return err
 	}
+
+	logs.Debugf("成功發送到mq exchangeName:%s, routeKey:%s, cancelAllParams:%+v",
+		model.TransactionExchange, model.BindKeyPurchaseProduct, cancelAllParams)
+
+	return nil
+}
+
+// 修改交易單 (價格 / 數量)
+// 這裡只檢查, 實際修改訂單簿 db 與 預扣金額 / 凍結數量 的差額 由搓合引擎處理 (避免與搓合同時異動)
+func (u *UserApp) AmendProduct(amendParams *model.ProductAmendParams) error {
+	if amendParams == nil {
+		return fmt.Errorf("amend == nil")
+	}
+
 	// 讀取db是否有此交易單
-	transaction, err := u.transactionRepo.GetTransactionInfo(cancelParams.TransactionID)
+	transaction, err := u.transactionRepo.GetTransactionInfo(amendParams.TransactionID)
+	if err != nil {
+		return err
+	}
+	if transaction.FromUserID != amendParams.UserID {
+		return Error_NotOwner
+	}
+	switch model_bill.Transaction_Status(transaction.Status) {
+	case model_bill.Transaction_Status_Wait, model_bill.Transaction_Status_PartialFilled:
+	default:
+		return Error_TransactionClosed
+	}
+
+	// 市價單沒有委託價格
+	if amendParams.Amount.IsPositive() {
+		switch model.TransferType(transaction.TransferType) {
+		case model.LimitPrice, model.StopLimit:
+		default:
+			return Error_VerifyFailed
+		}
+	}
+
+	// 新的委託價格 換算成商品的報價幣種 (與原訂單相同)
+	if amendParams.Amount.IsPositive() {
+		amendParams.Amount, err = domain_user.Exchange(u.rateService, amendParams.Amount, amendParams.Currency, transaction.Currency)
+		if err != nil {
+			return err
+		}
+		amendParams.Currency = transaction.Currency
+
+		// 新的委託價格 需在最新成交價的價格限制內
+		marketPriceRedis, err := u.getMarketPrice(transaction.ProductName)
+		if err != nil {
+			return err
+		}
+		if !marketPriceRedis.InBand(amendParams.Amount) {
+			return Error_PriceOutOfBand
+		}
+	}
+
+	// 新的委託數量 需大於已成交數量
+	if amendParams.OperateCount > 0 && amendParams.OperateCount <= transaction.FilledCount {
+		return Error_VerifyFailed
+	}
+
+	// 買單 修改後的預扣金額 (新的價格 * 新的剩餘數量) 增加時, 先檢查緩存餘額是否足夠差額
+	// 賣單 增加數量時, 先檢查背包可用數量是否足夠
+	switch model.TransferMode(transaction.TransferMode) {
+	case model.Purchase:
+		holdAmount, err := u.amendHoldAmount(transaction, amendParams)
+		if err != nil {
+			return err
+		}
+		if needAmount := holdAmount.Sub(transaction.RemainHoldAmount()); needAmount.IsPositive() {
+			auth, err := u.authRepo.GetAuthUser(amendParams.UserID)
+			if err != nil {
+				return err
+			}
+			if auth != nil && !auth.Amount.GreaterThan(needAmount) {
+				return fmt.Errorf("不夠錢買 %s < %s", auth.Amount.String(), needAmount.String())
+			}
+		}
+	case model.Sell:
+		if addCount := amendParams.OperateCount - transaction.ProductCount; amendParams.OperateCount > 0 && addCount > 0 {
+			backpack, err := u.backpackRepo.GetBackpackByUserId(amendParams.UserID, transaction.ProductName)
+			if err != nil {
+				return err
+			}
+			if backpack.ProductCount < addCount {
+				return model_backpack.Error_ProductNotEnough
+			}
+		}
+	}
+
+	// 時間戳 (修改價格或增加數量 失去時間優先)
+	amendParams.TimeStamp = time.Now().UnixNano()
+
+	// 通知 mq
+	if err = u.publishCommand(model.Notify_Cmd_Amend, amendParams.UserID, amendParams); err != nil {
+		return err
+	}
+
+	logs.Debugf("成功發送到mq exchangeName:%s, routeKey:%s, amendParams:%+v",
+		model.TransactionExchange, model.BindKeyPurchaseProduct, amendParams)
+
+	return nil
+}
+
+// 買單修改後 剩餘數量需要的預扣金額 (與搓合引擎的計算相同)
+// 有委託價格 = (新的委託價格 * 新的剩餘數量 + 最多可能的手續費) * 匯率, 市價單沒有委託價格 維持每單位的預扣金額
+func (u *UserApp) amendHoldAmount(transaction *model_bill.Transaction, amendParams *model.ProductAmendParams) (decimal.Decimal, error) {
+
+	remainCount := transaction.RemainCount
+	if amendParams.OperateCount > 0 {
+		remainCount = amendParams.OperateCount - transaction.FilledCount
+	}
+
+	switch model.TransferType(transaction.TransferType) {
+	case model.LimitPrice, model.StopLimit:
+	default:
+		return transaction.HoldAmountOf(remainCount), nil
+	}
+
+	price := transaction.Price
+	if amendParams.Amount.IsPositive() {
+		price = amendParams.Amount
+	}
+	user, err := u.userRepo.GetUserInfo(transaction.FromUserID)
 	if err != nil {
+		return decimal.Zero, err
+	}
+	rate, err := u.rateService.GetRate(transaction.Currency, user.Currency)
+	if err != nil {
+		return decimal.Zero, err
+	}
+	return domain_bill.HoldAmount(u.feeService, transaction.ProductName, user.VipLevel, price, remainCount, rate.Get()), nil
+}
+
+// 寫進message queue 給搓合微服務 transaction_server, 每個用戶的指令依序編號
+func (u *UserApp) publishCommand(cmd model.Notify_Cmd, userID int64, data interface{}) error {
+
+	seq, err := u.seqRepo.NextCommandSeq(userID)
+	if err != nil {
+		logs.Errorf("nextCommandSeq fail userID:%v, err:%v", userID, err)
 		return err
 	}
 
-	// 組合通知 mq (todo 放到底層)
 	productTransactionNotify := model.ProductTransactionNotify{
-		Cmd:  model.Notify_Cmd_Cancel,
-		Data: cancelParams,
+		Cmd:    cmd,
+		UserID: userID,
+		Seq:    seq,
+		Data:   data,
 	}
 	mqDataBytes, err := json.Marshal(productTransactionNotify)
 	if err != nil {
 		return fmt.Errorf("marshal fail err=%v", err)
 	}
-	err = rabbitmqx.GetMq().PutIntoQueue(model.TransactionExchange, model.BindKeyPurchaseProduct, mqDataBytes)
+	publish := u.publish
+	if publish == nil {
+		publish = rabbitmqx.GetMq().PutIntoQueue
+	}
+	err = publish(model.TransactionExchange, model.BindKeyPurchaseProduct, mqDataBytes)
 	if err != nil {
 		logs.Errorf("putIntoQueue err:%v, exchange:%v, bindKey:%v",
 			err, model.TransactionExchange, model.BindKeyPurchaseProduct)
 		return err
 	}
+	return nil
+}
+
+// 處理搓合引擎的成交回報, 成交時調整用戶緩存的餘額, 並推播通知給用戶
+// (取消 過期 的退款 搓合引擎已寫回用戶緩存)
+func (u *UserApp) HandleExecutionReport(report *model.ExecutionReport) error {
+	if report == nil {
+		return fmt.Errorf("report == nil")
+	}
+
+	switch report.ExecType {
+	case model.Exec_PartialFilled, model.Exec_Filled:
+		settled, err := u.settleAuthAmount(report)
+		if err != nil {
+			logs.Errorf("settleAuthAmount fail report:%+v, err:%v", report, err)
+			return err
+		}
+		if !settled {
+			// 重複的回報 (mq 重送) 已經處理過
+			logs.Warnf("duplicate report reportID:%v", report.ReportID)
+			return nil
+		}
+	}
 
-	logs.Debugf("成功發送到mq exchangeName:%s, routeKey:%s, cancelParams:%+v, Username:%v, ProductName:%v, Status:%v",
-		model.TransactionExchange, model.BindKeyPurchaseProduct, cancelParams, user.Username, transaction.ProductName, transaction.Status)
+	// 推播失敗 不影響回報處理
+	u.notifyUser(report.UserID, model.UserNotify_Order, report)
+
+	// 餘額有變動 推播最新餘額
+	if report.ExecType.ChangesBalance() {
+		userInfo, err := u.GetUserInfo(report.UserID)
+		if err != nil {
+			logs.Errorf("getUserInfo fail userID:%v, err:%v", report.UserID, err)
+			return nil
+		}
+		u.notifyUser(report.UserID, model.UserNotify_Balance, userInfo)
+	}
 
 	return nil
 }
+
+// 推播通知給用戶
+func (u *UserApp) notifyUser(userID int64, notifyType string, data interface{}) {
+
+	byteArray, err := json.Marshal(&model.UserNotify{
+		Type: notifyType,
+		Data: data,
+	})
+	if err != nil {
+		logs.Errorf("marshal fail userID:%v, type:%v, err:%v", userID, notifyType, err)
+		return
+	}
+	if err = u.notifyRepo.PublishUser(userID, byteArray); err != nil {
+		logs.Errorf("publishUser fail userID:%v, type:%v, err:%v", userID, notifyType, err)
+	}
+}
+
+// 成交後調整用戶緩存的餘額 (搓合引擎也會退款到用戶緩存, 使用原子操作調整)
+// 買單: 退還 此次成交釋放的預扣金額 (搓合引擎成交時計算), 再扣除 實際成交金額 與 手續費
+// 賣單: 加上 成交金額 扣除 手續費
+// 依回報ID 丟棄重複的回報 (mq 重送), 重複的回報回傳 false
+func (u *UserApp) settleAuthAmount(report *model.ExecutionReport) (bool, error) {
+
+	if len(report.ReportID) > 0 {
+		first, err := u.seqRepo.MarkReport(report.ReportID)
+		if err != nil || !first {
+			return false, err
+		}
+	}
+
+	// 有結算幣種時 使用換算成用戶幣種的 成交金額 與 手續費
+	fillAmount, fee := report.FillPrice.Mul(decimal.NewFromInt(report.FillCount)), report.Fee
+	if len(report.SettleCurrency) > 0 {
+		fillAmount, fee = report.SettleAmount, report.SettleFee
+	}
+	changeAmount := decimal.Zero
+	switch model.TransferMode(report.TransferMode) {
+	case model.Purchase:
+		changeAmount = report.ReleaseAmount.Sub(fillAmount).Sub(fee)
+	case model.Sell:
+		changeAmount = fillAmount.Sub(fee)
+	}
+
+	// 沒有用戶緩存 (jwt 驗證) 回傳 nil, 失敗時移除記錄 讓重送的回報可以再處理
+	_, err := u.authRepo.UpdateAmount(report.UserID, func(auth *model.AuthInfo) error {
+		auth.Amount = auth.Amount.Add(changeAmount)
+		return nil
+	})
+	if err != nil {
+		if len(report.ReportID) > 0 {
+			if unmarkErr := u.seqRepo.UnmarkReport(report.ReportID); unmarkErr != nil {
+				logs.Errorf("unmarkReport fail reportID:%v, err:%v", report.ReportID, unmarkErr)
+			}
+		}
+		return false, err
+	}
+	return true, nil
+}
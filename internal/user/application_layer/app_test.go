@@ -0,0 +1,300 @@
+package application_layer
+
+import (
+	"encoding/json"
+	"errors"
+	"marketplace_server/config"
+	Infrastructure_backpack "marketplace_server/internal/backpack/Infrastructure_layer"
+	model_backpack "marketplace_server/internal/backpack/model"
+	Infrastructure_bill "marketplace_server/internal/bill/Infrastructure_layer"
+	domain_bill "marketplace_server/internal/bill/domain_layer"
+	model_bill "marketplace_server/internal/bill/model"
+	"marketplace_server/internal/common/logs"
+	Infrastructure_user "marketplace_server/internal/user/Infrastructure_layer"
+	domain_user "marketplace_server/internal/user/domain_layer"
+	"marketplace_server/internal/user/model"
+	"os"
+	"sync"
+	"testing"
+
+	"github.com/shopspring/decimal"
+)
+
+func TestMain(m *testing.M) {
+	logs.Init(config.Log{Env: "prd"})
+	os.Exit(m.Run())
+}
+
+// 測試用的用戶應用層 (記憶體的持久層)
+type testApp struct {
+	*UserApp
+	t            *testing.T
+	users        *Infrastructure_user.MemoryUserRepo
+	auths        *Infrastructure_user.MemoryAuthRepo
+	seqs         *Infrastructure_user.MemorySeqRepo
+	transactions *Infrastructure_bill.MemoryTransactionRepo
+	backpacks    *Infrastructure_backpack.MemoryBackpackRepo
+	published    []*model.ProductTransactionNotify // 送到 mq 的指令
+	publishErr   error                             // 送到 mq 回傳的錯誤
+}
+
+func newTestApp(t *testing.T) *testApp {
+	fee, err := domain_bill.NewFeeService(config.Fee{PlatformUserID: 99, MakerRate: "0.001", TakerRate: "0.002"})
+	if err != nil {
+		t.Fatalf("err:%v", err)
+	}
+	a := &testApp{
+		t:            t,
+		users:        Infrastructure_user.NewMemoryUserRepo(),
+		auths:        Infrastructure_user.NewMemoryAuthRepo(),
+		seqs:         Infrastructure_user.NewMemorySeqRepo(),
+		transactions: Infrastructure_bill.NewMemoryTransactionRepo(),
+		backpacks:    Infrastructure_backpack.NewMemoryBackpackRepo(),
+	}
+	a.UserApp = &UserApp{
+		userRepo:        a.users,
+		authRepo:        a.auths,
+		seqRepo:         a.seqs,
+		transactionRepo: a.transactions,
+		backpackRepo:    a.backpacks,
+		publish:         a.publish,
+	}
+	a.acceptor = NewOrderAcceptor(a.auths, a.transactions, a.backpacks, domain_user.NewRateService(), fee)
+	return a
+}
+
+// 記錄送到 mq 的指令
+func (a *testApp) publish(exchange, bindKey string, body []byte) error {
+	if a.publishErr != nil {
+		return a.publishErr
+	}
+	notify := &model.ProductTransactionNotify{}
+	if err := json.Unmarshal(body, notify); err != nil {
+		a.t.Fatalf("err:%v", err)
+	}
+	a.published = append(a.published, notify)
+	return nil
+}
+
+// 建立交易單 (用戶 1 的賣單 BTC 4 @ 100)
+func (a *testApp) addTransaction(transactionID string, status model_bill.Transaction_Status) {
+	err := a.transactions.Save(&model_bill.Transaction{
+		TransactionID: transactionID,
+		TransferMode:  int(model.Sell),
+		FromUserID:    1,
+		ProductName:   "BTC",
+		ProductCount:  4,
+		RemainCount:   4,
+		Price:         decimal.NewFromInt(100),
+		Currency:      "TWD",
+		Status:        int8(status),
+	})
+	if err != nil {
+		a.t.Fatalf("err:%v", err)
+	}
+}
+
+// 建立用戶 (TWD 緩存餘額 amount, 持有 BTC count)
+func (a *testApp) addUser(userID int64, amount string, count int64) {
+	if _, err := a.auths.Set(&model.AuthInfo{UserID: userID, Currency: "TWD", Amount: decimal.RequireFromString(amount)}); err != nil {
+		a.t.Fatalf("err:%v", err)
+	}
+	if count > 0 {
+		if err := a.backpacks.Save(&model_backpack.Backpack{UserID: userID, ProductName: "BTC", ProductCount: count}); err != nil {
+			a.t.Fatalf("err:%v", err)
+		}
+	}
+}
+
+// 用戶緩存的餘額
+func (a *testApp) authAmount(userID int64) decimal.Decimal {
+	auth, err := a.auths.GetAuthUser(userID)
+	if err != nil {
+		a.t.Fatalf("err:%v", err)
+	}
+	return auth.Amount
+}
+
+// 用戶持有的 BTC
+func (a *testApp) backpack(userID int64) *model_backpack.Backpack {
+	backpack, err := a.backpacks.GetBackpackByUserId(userID, "BTC")
+	if err != nil {
+		a.t.Fatalf("err:%v", err)
+	}
+	return backpack
+}
+
+func Test_HoldProduct(t *testing.T) {
+	a := newTestApp(t)
+	a.addUser(1, "0", 5)
+
+	// 沒有背包 數量不足
+	if err := a.acceptor.holdProduct(2, "BTC", 1); err != model_backpack.Error_ProductNotEnough {
+		t.Fatalf("err:%v", err)
+	}
+	if err := a.acceptor.holdProduct(1, "BTC", 6); err != model_backpack.Error_ProductNotEnough {
+		t.Fatalf("err:%v", err)
+	}
+
+	// 同時凍結 只有可用數量內的成功, 不會互相覆蓋
+	var wg sync.WaitGroup
+	var lock sync.Mutex
+	success := 0
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := a.acceptor.holdProduct(1, "BTC", 1); err == nil {
+				lock.Lock()
+				success++
+				lock.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+	if backpack := a.backpack(1); success != 5 || backpack.ProductCount != 0 || backpack.HoldCount != 5 {
+		t.Fatalf("success:%d, backpack:%+v", success, backpack)
+	}
+
+	// 送出失敗 歸還凍結的數量
+	a.acceptor.releaseProduct(1, "BTC", 2)
+	if backpack := a.backpack(1); backpack.ProductCount != 2 || backpack.HoldCount != 3 {
+		t.Fatalf("backpack:%+v", backpack)
+	}
+}
+
+func Test_SettleAuthAmount(t *testing.T) {
+	a := newTestApp(t)
+	a.addUser(1, "9800", 0)
+	a.addUser(2, "0", 0)
+
+	// 買單 成交 2 @ 90 手續費 1, 釋放預扣 200: 200 - 180 - 1 = 19
+	buy := &model.ExecutionReport{
+		ReportID:      "T1-1-0-1",
+		ExecType:      model.Exec_Filled,
+		TransactionID: "1-0-1",
+		TransferMode:  int(model.Purchase),
+		UserID:        1,
+		FillPrice:     decimal.NewFromInt(90),
+		FillCount:     2,
+		Fee:           decimal.NewFromInt(1),
+		ReleaseAmount: decimal.NewFromInt(200),
+	}
+	if settled, err := a.settleAuthAmount(buy); err != nil || !settled {
+		t.Fatalf("settled:%v, err:%v", settled, err)
+	}
+	if !a.authAmount(1).Equal(decimal.NewFromInt(9819)) {
+		t.Fatalf("auth:%v", a.authAmount(1))
+	}
+
+	// 重送的回報 不重複調整
+	if settled, err := a.settleAuthAmount(buy); err != nil || settled {
+		t.Fatalf("settled:%v, err:%v", settled, err)
+	}
+	if !a.authAmount(1).Equal(decimal.NewFromInt(9819)) {
+		t.Fatalf("auth:%v", a.authAmount(1))
+	}
+
+	// 賣單 使用結算幣種的金額: 180 - 2
+	sell := &model.ExecutionReport{
+		ReportID:       "T1-2-1-1",
+		ExecType:       model.Exec_Filled,
+		TransactionID:  "2-1-1",
+		TransferMode:   int(model.Sell),
+		UserID:         2,
+		FillPrice:      decimal.NewFromInt(90),
+		FillCount:      2,
+		SettleCurrency: "TWD",
+		SettleAmount:   decimal.NewFromInt(180),
+		SettleFee:      decimal.NewFromInt(2),
+	}
+	if settled, err := a.settleAuthAmount(sell); err != nil || !settled {
+		t.Fatalf("settled:%v, err:%v", settled, err)
+	}
+	if !a.authAmount(2).Equal(decimal.NewFromInt(178)) {
+		t.Fatalf("auth:%v", a.authAmount(2))
+	}
+
+	// 調整失敗 移除記錄, 重送的回報可以再處理
+	sell.ReportID, sell.UserID = "T2-3-1-1", 3
+	if settled, err := a.settleAuthAmount(sell); err == nil || settled {
+		t.Fatalf("settled:%v, err:%v", settled, err)
+	}
+	a.addUser(3, "0", 0)
+	if settled, err := a.settleAuthAmount(sell); err != nil || !settled {
+		t.Fatalf("settled:%v, err:%v", settled, err)
+	}
+	if !a.authAmount(3).Equal(decimal.NewFromInt(178)) {
+		t.Fatalf("auth:%v", a.authAmount(3))
+	}
+}
+
+func Test_CancelProduct_Publish(t *testing.T) {
+	a := newTestApp(t)
+	a.addUser(1, "0", 0)
+	a.addTransaction("1-1-1", model_bill.Transaction_Status_Wait)
+	a.addTransaction("1-1-2", model_bill.Transaction_Status_Finish)
+
+	// 已經結束 或 不是自己的訂單 直接回傳結果, 不送到搓合引擎
+	result, err := a.CancelProduct(&model.ProductCancelParams{TransactionID: "1-1-2", UserID: 1})
+	if err != nil || result.Result != model.Cancel_Result_AlreadyFilled {
+		t.Fatalf("result:%+v, err:%v", result, err)
+	}
+	result, err = a.CancelProduct(&model.ProductCancelParams{TransactionID: "1-1-1", UserID: 2})
+	if err != nil || result.Result != model.Cancel_Result_NotFound {
+		t.Fatalf("result:%+v, err:%v", result, err)
+	}
+	if len(a.published) != 0 {
+		t.Fatalf("published:%d", len(a.published))
+	}
+
+	// 等待搓合中 送到搓合引擎, 帶用戶的指令序號
+	result, err = a.CancelProduct(&model.ProductCancelParams{TransactionID: "1-1-1", UserID: 1})
+	if err != nil || result.Result != model.Cancel_Result_Pending {
+		t.Fatalf("result:%+v, err:%v", result, err)
+	}
+	if len(a.published) != 1 || a.published[0].Cmd != model.Notify_Cmd_Cancel || a.published[0].UserID != 1 || a.published[0].Seq != 1 {
+		t.Fatalf("published:%+v", a.published)
+	}
+
+	// 送到 mq 失敗 回傳錯誤
+	a.publishErr = errors.New("mq down")
+	if _, err = a.CancelProduct(&model.ProductCancelParams{TransactionID: "1-1-1", UserID: 1}); err == nil {
+		t.Fatalf("err:%v", err)
+	}
+}
+
+func Test_AmendProduct_Publish(t *testing.T) {
+	a := newTestApp(t)
+	a.addUser(1, "0", 1)
+	a.addTransaction("1-1-1", model_bill.Transaction_Status_Wait)
+	a.addTransaction("1-1-2", model_bill.Transaction_Status_Cancel)
+
+	// 不是自己的訂單 已經結束 背包數量不足 不送到搓合引擎
+	if err := a.AmendProduct(&model.ProductAmendParams{TransactionID: "1-1-1", UserID: 2, OperateCount: 3}); err != Error_NotOwner {
+		t.Fatalf("err:%v", err)
+	}
+	if err := a.AmendProduct(&model.ProductAmendParams{TransactionID: "1-1-2", UserID: 1, OperateCount: 3}); err != Error_TransactionClosed {
+		t.Fatalf("err:%v", err)
+	}
+	if err := a.AmendProduct(&model.ProductAmendParams{TransactionID: "1-1-1", UserID: 1, OperateCount: 6}); err != model_backpack.Error_ProductNotEnough {
+		t.Fatalf("err:%v", err)
+	}
+	if len(a.published) != 0 {
+		t.Fatalf("published:%d", len(a.published))
+	}
+
+	// 減少數量 送到搓合引擎
+	if err := a.AmendProduct(&model.ProductAmendParams{TransactionID: "1-1-1", UserID: 1, OperateCount: 3}); err != nil {
+		t.Fatalf("err:%v", err)
+	}
+	if len(a.published) != 1 || a.published[0].Cmd != model.Notify_Cmd_Amend || a.published[0].Seq != 1 {
+		t.Fatalf("published:%+v", a.published)
+	}
+
+	// 送到 mq 失敗 回傳錯誤
+	a.publishErr = errors.New("mq down")
+	if err := a.AmendProduct(&model.ProductAmendParams{TransactionID: "1-1-1", UserID: 1, OperateCount: 2}); err == nil {
+		t.Fatalf("err:%v", err)
+	}
+}
@@ -0,0 +1,159 @@
+package application_layer
+
+import (
+	"fmt"
+	Infrastructure_backpack "marketplace_server/internal/backpack/Infrastructure_layer"
+	Infrastructure_bill "marketplace_server/internal/bill/Infrastructure_layer"
+	domain_bill "marketplace_server/internal/bill/domain_layer"
+	model_bill "marketplace_server/internal/bill/model"
+	"marketplace_server/internal/common/logs"
+	Infrastructure_user "marketplace_server/internal/user/Infrastructure_layer"
+	domain_user "marketplace_server/internal/user/domain_layer"
+	"marketplace_server/internal/user/model"
+	"time"
+
+	"github.com/shopspring/decimal"
+)
+
+// 受理買賣單: 賣單凍結商品數量, 買單預扣金額, 寫入等待搓合的交易單
+// marketplace_server 下單 與 engine_replay 回放 共用, 兩邊的交易單與預扣金額一致
+type OrderAcceptor struct {
+	authRepo        Infrastructure_user.AuthInterface
+	transactionRepo Infrastructure_bill.TransactionRepo
+	backpackRepo    Infrastructure_backpack.BackpackRepo
+	rateService     domain_user.RateService
+	feeService      domain_bill.FeeService
+}
+
+func NewOrderAcceptor(authRepo Infrastructure_user.AuthInterface, transactionRepo Infrastructure_bill.TransactionRepo, backpackRepo Infrastructure_backpack.BackpackRepo, rateService domain_user.RateService, feeService domain_bill.FeeService) *OrderAcceptor {
+	return &OrderAcceptor{
+		authRepo:        authRepo,
+		transactionRepo: transactionRepo,
+		backpackRepo:    backpackRepo,
+		rateService:     rateService,
+		feeService:      feeService,
+	}
+}
+
+// 受理訂單 (委託價格需已換算成報價幣種, 時間戳由呼叫端設定)
+// 沒有交易單號時 產生新的單號, 寫入 db 失敗時 退還 預扣金額 / 凍結數量
+func (a *OrderAcceptor) Accept(user *model.User, transactionParams *model.ProductTransactionParams, marketPrice decimal.Decimal, now time.Time) (*model_bill.Transaction, error) {
+
+	// 還沒到搓合階段, 無法知道真實成交價
+	var productNeedPrice decimal.Decimal
+	switch model.TransferMode(transactionParams.TransferMode) {
+	case model.Purchase: // 買單
+		// 讀取匯率 (報價幣種 -> 用戶的幣種), 預扣金額使用用戶的幣種
+		rate, err := a.rateService.GetRate(transactionParams.Currency, user.Currency)
+		if err != nil {
+			return nil, err
+		}
+		// 計算 購買商品的價格 = (委託價格 * 操作數量 + 最多可能的手續費) * 匯率, 市價單使用市場價格
+		productNeedPrice = domain_bill.HoldAmount(a.feeService, transactionParams.ProductName, user.VipLevel,
+			transactionParams.HoldPrice(marketPrice), transactionParams.OperateCount, rate.Get())
+		logs.Debugf("操作數量:%v, 匯率:%v 購買商品的價格:%s, 商品名稱:%s",
+			transactionParams.OperateCount, rate.Get().String(), productNeedPrice.String(), transactionParams.ProductName)
+
+		//判斷用戶是否足夠錢買 (使用redis的緩存錢來判斷, db的用戶金額是真實交易時才會異動)
+		// 送出訂單前 先預扣用戶緩存的金額 (搓合引擎收到訂單就會搓合, IOC FOK 剩餘的部分會立即退款)
+		_, err = a.authRepo.UpdateAmount(transactionParams.UserID, func(auth *model.AuthInfo) error {
+			if !auth.Amount.GreaterThan(productNeedPrice) {
+				return fmt.Errorf("不夠錢買 %s < %s", auth.Amount.String(), productNeedPrice.String())
+			}
+			auth.Amount = auth.Amount.Sub(productNeedPrice)
+			return nil
+		})
+		if err != nil {
+			logs.Errorf("err:%v", err)
+			return nil, err
+		}
+	case model.Sell: // 賣單
+		// 撈取db 看賣家是否有足夠數量, 足夠就先凍結 (成交時扣除凍結數量, 取消時歸還)
+		if err := a.holdProduct(transactionParams.UserID, transactionParams.ProductName, transactionParams.OperateCount); err != nil {
+			logs.Errorf("holdProduct fail userID:%v, productName:%v, err:%v",
+				transactionParams.UserID, transactionParams.ProductName, err)
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("transferMode fail mode:%v", transactionParams.TransferMode)
+	}
+
+	// 先寫入db, 狀態設定為 wait 搓合 (搓合引擎收到訂單就會立即搓合, 所以要比 mq 早寫入)
+	transaction := &model_bill.Transaction{
+		TransferMode:      transactionParams.TransferMode,           // 交易模式 0:買 1:賣
+		TransferType:      transactionParams.TransferType,           // 交易種類 0:限價 1:市價 2:停損市價 3:停損限價
+		FromUserID:        transactionParams.UserID,                 // 發起人的用戶ID
+		ToUserID:          0,                                        // 交易對象的用戶ID (等交易完成後更新)
+		ProductName:       transactionParams.ProductName,            // 產品名稱
+		ProductCount:      transactionParams.OperateCount,           // 產品數量
+		Price:             transactionParams.Amount,                 // 委託價格 (重啟時重建訂單簿使用)
+		TriggerPrice:      transactionParams.TriggerPrice,           // 觸發價格 (停損單)
+		TimeInForce:       transactionParams.TimeInForce,            // 有效期限 0:GTC 1:IOC 2:FOK 3:GTD
+		ExpireTime:        transactionParams.ExpireTime,             // 到期時間 (GTD)
+		RemainCount:       transactionParams.OperateCount,           // 剩餘數量 (等交易成交後更新)
+		ProductNeedAmount: productNeedPrice,                         // 商品需要的預扣金額
+		HoldAmount:        productNeedPrice,                         // 剩餘數量的預扣金額 (成交時釋放 取消時退款)
+		Amount:            decimal.NewFromFloat(0),                  // 金額 (等交易完成後更新)
+		Currency:          transactionParams.Currency,               // 貨幣
+		QueueTime:         transactionParams.TimeStamp,              // 排隊時間 (重啟時重建訂單簿使用)
+		CreatedAt:         now,                                      // 創建時間
+		UodateAt:          now,                                      // 更新時間
+		Status:            int8(model_bill.Transaction_Status_Wait), // 交易狀態 0:未完成 1:已完成
+	}
+
+	// 產生交易ID 格式為 UserID + TransferMode(買或賣) + 流水id
+	if len(transactionParams.TransactionID) == 0 {
+		id, err := a.transactionRepo.GetLastInsterId()
+		if err != nil && err.Error() != "record not found" {
+			logs.Errorf("getLastInsterId err:%v", err)
+			a.Release(transaction)
+			return nil, err
+		}
+		transactionParams.TransactionID = fmt.Sprintf("%d-%d-%012d", transactionParams.UserID, transactionParams.TransferMode, id+1)
+	}
+	transaction.TransactionID = transactionParams.TransactionID // 交易單號
+
+	if err := a.transactionRepo.Save(transaction); err != nil {
+		logs.Errorf("transactionRepo save err:%v", err)
+		a.Release(transaction)
+		return nil, err
+	}
+	logs.Debugf("寫入transaction:%+v", transaction)
+	return transaction, nil
+}
+
+// 訂單沒有成功送出, 賣單歸還凍結的商品數量, 買單退還預扣的金額
+func (a *OrderAcceptor) Release(transaction *model_bill.Transaction) {
+	switch model.TransferMode(transaction.TransferMode) {
+	case model.Purchase:
+		a.refundAmount(transaction.FromUserID, transaction.HoldAmount)
+	case model.Sell:
+		a.releaseProduct(transaction.FromUserID, transaction.ProductName, transaction.ProductCount)
+	}
+}
+
+// 凍結賣家背包內的商品數量 (條件更新, 與搓合引擎同時異動背包 不會互相覆蓋)
+func (a *OrderAcceptor) holdProduct(userID int64, productName string, count int64) error {
+	return a.backpackRepo.HoldProduct(userID, productName, count)
+}
+
+// 退還用戶緩存預扣的金額
+func (a *OrderAcceptor) refundAmount(userID int64, amount decimal.Decimal) {
+
+	_, err := a.authRepo.UpdateAmount(userID, func(auth *model.AuthInfo) error {
+		auth.Amount = auth.Amount.Add(amount)
+		return nil
+	})
+	if err != nil {
+		logs.Errorf("update user cache fail userID:%v, amount:%v, err:%v", userID, amount, err)
+	}
+}
+
+// 賣單沒有成功送出, 歸還凍結的商品數量
+func (a *OrderAcceptor) releaseProduct(userID int64, productName string, count int64) {
+
+	err := a.backpackRepo.ReleaseProduct(userID, productName, count)
+	if err != nil {
+		logs.Errorf("releaseProduct fail userID:%v, productName:%v, count:%d, err:%v", userID, productName, count, err)
+	}
+}
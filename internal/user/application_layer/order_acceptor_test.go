@@ -0,0 +1,66 @@
+package application_layer
+
+import (
+	model_bill "marketplace_server/internal/bill/model"
+	"marketplace_server/internal/user/model"
+	"testing"
+	"time"
+
+	"github.com/shopspring/decimal"
+)
+
+func Test_OrderAcceptor_Accept(t *testing.T) {
+	a := newTestApp(t)
+	a.addUser(1, "1000", 0)
+	a.addUser(2, "0", 5)
+	user := &model.User{UserID: 1, Currency: "TWD"}
+	now := time.Unix(1700000000, 0)
+
+	// 買單 預扣 (100 * 2 + 最多可能的手續費 0.4), 記錄剩餘數量的預扣金額 與 排隊時間
+	buy := &model.ProductTransactionParams{UserID: 1, TransferMode: int(model.Purchase), ProductName: "BTC",
+		Currency: "TWD", Amount: decimal.NewFromInt(100), OperateCount: 2, TimeStamp: now.UnixNano()}
+	transaction, err := a.acceptor.Accept(user, buy, decimal.NewFromInt(90), now)
+	if err != nil {
+		t.Fatalf("err:%v", err)
+	}
+	holdAmount := decimal.RequireFromString("200.4")
+	if !transaction.HoldAmount.Equal(holdAmount) || !transaction.ProductNeedAmount.Equal(holdAmount) ||
+		transaction.QueueTime != now.UnixNano() || transaction.Status != int8(model_bill.Transaction_Status_Wait) {
+		t.Fatalf("transaction:%+v", transaction)
+	}
+	if buy.TransactionID != "1-0-000000000001" || transaction.TransactionID != buy.TransactionID {
+		t.Fatalf("transactionID:%v", buy.TransactionID)
+	}
+	if !a.authAmount(1).Equal(decimal.NewFromInt(1000).Sub(holdAmount)) {
+		t.Fatalf("auth:%v", a.authAmount(1))
+	}
+
+	// 已有交易單號 (回放) 沿用原本的單號
+	sell := &model.ProductTransactionParams{TransactionID: "2-1-000000000009", UserID: 2, TransferMode: int(model.Sell),
+		ProductName: "BTC", Currency: "TWD", Amount: decimal.NewFromInt(100), OperateCount: 3, TimeStamp: now.UnixNano()}
+	if transaction, err = a.acceptor.Accept(&model.User{UserID: 2, Currency: "TWD"}, sell, decimal.NewFromInt(90), now); err != nil {
+		t.Fatalf("err:%v", err)
+	}
+	if transaction.TransactionID != "2-1-000000000009" {
+		t.Fatalf("transaction:%+v", transaction)
+	}
+	if backpack := a.backpack(2); backpack.ProductCount != 2 || backpack.HoldCount != 3 {
+		t.Fatalf("backpack:%+v", backpack)
+	}
+
+	// 送出失敗 退還
+	a.acceptor.Release(transaction)
+	if backpack := a.backpack(2); backpack.ProductCount != 5 || backpack.HoldCount != 0 {
+		t.Fatalf("backpack:%+v", backpack)
+	}
+
+	// 餘額不足 不寫入交易單
+	buy = &model.ProductTransactionParams{UserID: 1, TransferMode: int(model.Purchase), ProductName: "BTC",
+		Currency: "TWD", Amount: decimal.NewFromInt(1000), OperateCount: 1, TimeStamp: now.UnixNano()}
+	if _, err = a.acceptor.Accept(user, buy, decimal.NewFromInt(90), now); err == nil {
+		t.Fatalf("accept without enough amount")
+	}
+	if len(buy.TransactionID) != 0 || !a.authAmount(1).Equal(decimal.NewFromInt(1000).Sub(holdAmount)) {
+		t.Fatalf("transactionID:%v, auth:%v", buy.TransactionID, a.authAmount(1))
+	}
+}
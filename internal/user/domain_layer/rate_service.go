@@ -17,6 +17,10 @@ const (
 	TWD = "TWD"
 )
 
+const (
+	AmountPrecision = 2 // 金額的小數位數 (與 db 的 decimal(20,2) 相同)
+)
+
 type RateService interface {
 	GetRate(fromCurrency string, toCurrency string) (*model.Rate, error)
 }
@@ -52,3 +56,15 @@ func (r *RateServiceImpl) GetRate(fromCurrency string, toCurrency string) (*mode
 	}
 	return nil, ErrorRateNotFound
 }
+
+// 換算金額 (相同幣種 或 沒有指定幣種 不換算), 四捨五入到金額的小數位數
+func Exchange(rateService RateService, amount decimal.Decimal, fromCurrency string, toCurrency string) (decimal.Decimal, error) {
+	if fromCurrency == toCurrency || len(fromCurrency) == 0 || len(toCurrency) == 0 {
+		return amount, nil
+	}
+	rate, err := rateService.GetRate(fromCurrency, toCurrency)
+	if err != nil {
+		return decimal.Zero, err
+	}
+	return rate.Exchange(amount).Round(AmountPrecision), nil
+}
@@ -11,16 +11,23 @@ import (
 const (
 	AuthorizationKey = "Authorization"
 	UserIDKey        = "username"
+	TokenQueryKey    = "token" // websocket 的 token (瀏覽器無法設定 header)
 )
 
 type AuthMiddleware struct {
-	UserApp application_user.UserAppInterface
+	UserApp      application_user.UserAppInterface
+	adminUserIDs map[int64]bool // 管理員的用戶ID
 }
 
-func NewAuthMiddleware(userApp application_user.UserAppInterface) *AuthMiddleware {
-	return &AuthMiddleware{
-		UserApp: userApp,
+func NewAuthMiddleware(userApp application_user.UserAppInterface, adminUserIDs []int64) *AuthMiddleware {
+	a := &AuthMiddleware{
+		UserApp:      userApp,
+		adminUserIDs: make(map[int64]bool),
 	}
+	for _, userID := range adminUserIDs {
+		a.adminUserIDs[userID] = true
+	}
+	return a
 }
 
 func (a *AuthMiddleware) Auth(c *gin.Context) {
@@ -43,3 +50,21 @@ func (a *AuthMiddleware) Auth(c *gin.Context) {
 	// 保存用户信息
 	c.Set(UserIDKey, authInfo.UserID)
 }
+
+// websocket 認證, 與 Auth 相同, 沒有 header 時 改用 query 的 token
+func (a *AuthMiddleware) AuthWebSocket(c *gin.Context) {
+	if c.GetHeader(AuthorizationKey) == "" {
+		c.Request.Header.Set(AuthorizationKey, c.Query(TokenQueryKey))
+	}
+	a.Auth(c)
+}
+
+// 檢查是否為管理員 (需在 Auth 之後)
+func (a *AuthMiddleware) Admin(c *gin.Context) {
+	userID := c.GetInt64(UserIDKey)
+	if !a.adminUserIDs[userID] {
+		response.Err(c, http.StatusForbidden, "permission denied")
+		c.Abort()
+		return
+	}
+}
@@ -196,8 +196,8 @@ func (u *UserHandler) TransactionProduct(c *gin.Context) {
 // @Tags user
 // @Accept json
 // @Produce json
-// @Param			message	body	model.C2S_CancelProduct		true		"要交易的商品"
-// @Success 	200 	{object} 	model_bill.Transaction
+// @Param			message	body	model.C2S_CancelProduct		true		"要取消的交易單"
+// @Success 	200 	{object} 	model.S2C_CancelProduct
 // @Failure     500		{object}	response.HTTPError
 // @Failure     400		{object}	response.HTTPError
 // @Router /auth/cancel_product [post]
@@ -213,6 +213,11 @@ func (u *UserHandler) CancelProduct(c *gin.Context) {
 		return
 	}
 
+	// 只能取消自己的訂單
+	if userID := c.GetInt64(UserIDKey); userID > 0 {
+		req.UserID = userID
+	}
+
 	// 转化为领域对象 + 参数验证
 	transactionProductParams, err := req.ToDomain()
 	if err != nil {
@@ -224,7 +229,147 @@ func (u *UserHandler) CancelProduct(c *gin.Context) {
 	// todo 後續 增加 同一用戶封包太頻繁交易就阻擋
 
 	// 呼叫應用層 取消交易
-	err = u.UserApp.CancelProduct(transactionProductParams)
+	result, err := u.UserApp.CancelProduct(transactionProductParams)
+	if err != nil {
+		response.Err(c, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	response.Ok(c, result)
+}
+
+// PingExample godoc
+// @Summary 取消自己全部的 買商品 賣商品
+// @Description cancel all my orders (optional product_name)
+// @Schemes
+// @Tags user
+// @Accept json
+// @Produce json
+// @Param			message	body	model.C2S_CancelAllProduct		true		"要取消的商品 (空的 = 所有商品)"
+// @Success 	200 	{object} 	response.Response
+// @Failure     500		{object}	response.HTTPError
+// @Failure     400		{object}	response.HTTPError
+// @Router /v1/cancel_all_product [post]
+func (u *UserHandler) CancelAllProduct(c *gin.Context) {
+
+	logPrefix := "cancelAllProduct"
+	req := &model.C2S_CancelAllProduct{}
+	var err error
+
+	// 解析参数
+	if err = c.ShouldBindJSON(req); err != nil {
+		response.Err(c, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	// 只能取消自己的訂單
+	if userID := c.GetInt64(UserIDKey); userID > 0 {
+		req.UserID = userID
+	}
+
+	// 转化为领域对象 + 参数验证
+	cancelAllParams, err := req.ToDomain(req.UserID)
+	if err == nil && cancelAllParams.UserID == 0 {
+		// 所有用戶 只有管理員可用
+		err = model.Error_VerifyFailed
+	}
+	if err != nil {
+		logs.Errorf("%s failed, err: %+v", logPrefix, err)
+		response.Err(c, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	// 呼叫應用層 取消全部交易
+	err = u.UserApp.CancelAllProduct(cancelAllParams)
+	if err != nil {
+		response.Err(c, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	response.Ok(c)
+}
+
+// PingExample godoc
+// @Summary 管理員 取消指定用戶 / 指定商品 全部的 買商品 賣商品
+// @Description admin cancel all orders of a user or a product
+// @Schemes
+// @Tags admin
+// @Accept json
+// @Produce json
+// @Param			message	body	model.C2S_CancelAllProduct		true		"要取消的用戶 (0 = 所有用戶) 與商品 (空的 = 所有商品)"
+// @Success 	200 	{object} 	response.Response
+// @Failure     500		{object}	response.HTTPError
+// @Failure     400		{object}	response.HTTPError
+// @Router /v1/admin/cancel_all_product [post]
+func (u *UserHandler) AdminCancelAllProduct(c *gin.Context) {
+
+	logPrefix := "adminCancelAllProduct"
+	req := &model.C2S_CancelAllProduct{}
+	var err error
+
+	// 解析参数
+	if err = c.ShouldBindJSON(req); err != nil {
+		response.Err(c, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	// 转化为领域对象 + 参数验证
+	cancelAllParams, err := req.ToDomain(c.GetInt64(UserIDKey))
+	if err != nil {
+		logs.Errorf("%s failed, err: %+v", logPrefix, err)
+		response.Err(c, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	// 呼叫應用層 取消全部交易
+	err = u.UserApp.CancelAllProduct(cancelAllParams)
+	if err != nil {
+		response.Err(c, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	response.Ok(c)
+}
+
+// PingExample godoc
+// @Summary 修改 買商品 賣商品 (價格 / 數量)
+// @Description amend buy or sell product
+// @Schemes
+// @Tags user
+// @Accept json
+// @Produce json
+// @Param			message	body	model.C2S_AmendProduct		true		"要修改的交易單"
+// @Success 	200 	{object} 	response.Response
+// @Failure     500		{object}	response.HTTPError
+// @Failure     400		{object}	response.HTTPError
+// @Router /v1/amend_product [post]
+func (u *UserHandler) AmendProduct(c *gin.Context) {
+
+	logPrefix := "amendProduct"
+	req := &model.C2S_AmendProduct{}
+	var err error
+
+	// 解析参数
+	if err = c.ShouldBindJSON(req); err != nil {
+		response.Err(c, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	// 只能修改自己的訂單
+	if userID := c.GetInt64(UserIDKey); userID > 0 {
+		req.UserID = userID
+	}
+
+	// 转化为领域对象 + 参数验证
+	amendParams, err := req.ToDomain()
+	if err != nil {
+		logs.Errorf("%s failed, err: %+v", logPrefix, err)
+		response.Err(c, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	// 呼叫應用層 修改交易
+	err = u.UserApp.AmendProduct(amendParams)
 	if err != nil {
 		response.Err(c, http.StatusInternalServerError, err.Error())
 		return
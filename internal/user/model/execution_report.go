@@ -0,0 +1,57 @@
+package model
+
+import (
+	"github.com/shopspring/decimal"
+)
+
+type ExecType int
+
+const (
+	Exec_Accepted           ExecType = iota // 0:已接受 (進入訂單簿或停損單觸發清單)
+	Exec_Rejected                           // 1:拒絕
+	Exec_PartialFilled                      // 2:部分成交
+	Exec_Filled                             // 3:全部成交
+	Exec_Cancelled                          // 4:已取消
+	Exec_Expired                            // 5:過期 (IOC FOK 未成交的部分 或 GTD 到期)
+	Exec_Amended                            // 6:已修改 (價格 / 數量)
+	Exec_CancelRejected                     // 7:取消失敗 (已經全部成交 或 找不到訂單)
+	Exec_SelfTradePrevented                 // 8:自成交防範 (與自己的訂單可成交, 依模式 取消 或 減少數量, 剩餘數量 0 代表已取消)
+)
+
+const (
+	ExecutionReportExchange = "execution_report_exchange" // 成交回報交换机 (transaction_server -> marketplace_server)
+	BindKeyExecutionReport  = "execution_report_key"      // 成交回報绑定key
+)
+
+// 是否會改變用戶的餘額 (成交 或 退還預扣金額)
+func (e ExecType) ChangesBalance() bool {
+	switch e {
+	case Exec_PartialFilled, Exec_Filled, Exec_Cancelled, Exec_Expired, Exec_Rejected, Exec_Amended, Exec_SelfTradePrevented:
+		return true
+	}
+	return false
+}
+
+// 成交回報 (搓合引擎 回報 訂單狀態的變化)
+type ExecutionReport struct {
+	ReportID       string          `json:"report_id"`      // 回報ID (重送時不變, 接收端用來丟棄重複的回報)
+	ExecType       ExecType        `json:"exec_type"`      // 回報種類
+	TransactionID  string          `json:"transaction_id"` // 交易單號
+	TransferMode   int             `json:"transaction_mode"`
+	ProductName    string          `json:"product_name"`    // 商品名稱
+	UserID         int64           `json:"user_id"`         // 下單用戶
+	Currency       string          `json:"currency"`        // 幣種 (商品的報價幣種, 成交價 手續費 使用此幣種)
+	TradeID        string          `json:"trade_id"`        // 成交單號 (成交時)
+	FillPrice      decimal.Decimal `json:"fill_price"`      // 成交價 (成交時)
+	FillCount      int64           `json:"fill_count"`      // 此次成交數量 (成交時)
+	Fee            decimal.Decimal `json:"fee"`             // 此次成交的手續費 (成交時)
+	SettleCurrency string          `json:"settle_currency"` // 結算幣種 = 用戶的幣種 (成交時)
+	SettleAmount   decimal.Decimal `json:"settle_amount"`   // 此次成交金額 換算成結算幣種 (成交時)
+	SettleFee      decimal.Decimal `json:"settle_fee"`      // 此次成交的手續費 換算成結算幣種 (成交時)
+	ReleaseAmount  decimal.Decimal `json:"release_amount"`  // 此次成交釋放的預扣金額 (買單成交時)
+	RemainCount    int64           `json:"remain_count"`    // 剩餘未成交數量
+	RefundAmount   decimal.Decimal `json:"refund_amount"`   // 退還的預扣金額 (取消 過期時, 修改數量時為差額 負數代表追加預扣)
+	Reason         string          `json:"reason"`          // 拒絕原因 (自成交防範時為防範模式)
+	CancelResult   CancelResult    `json:"cancel_result"`   // 取消結果 (取消 取消失敗時)
+	TimeStamp      int64           `json:"timestamp"`       // 時間搓
+}
@@ -3,6 +3,7 @@ package model
 import (
 	"encoding/json"
 	"marketplace_server/internal/common/logs"
+	"time"
 
 	"github.com/shopspring/decimal"
 )
@@ -100,6 +101,17 @@ type TransferType int
 const (
 	LimitPrice  TransferType = iota // 0:限價單
 	MarketPrice                     // 1:市價單
+	StopMarket                      // 2:停損市價單 (最新成交價觸及觸發價格後 轉成市價單)
+	StopLimit                       // 3:停損限價單 (最新成交價觸及觸發價格後 轉成限價單)
+)
+
+type TimeInForce int
+
+const (
+	GTC TimeInForce = iota // 0:取消前有效 (Good Till Cancel)
+	IOC                    // 1:立即成交否則取消 (Immediate Or Cancel), 未成交的部分取消
+	FOK                    // 2:全部成交或取消 (Fill Or Kill), 無法全部成交就整筆取消
+	GTD                    // 3:指定時間前有效 (Good Till Date), 到期取消
 )
 
 const (
@@ -131,12 +143,15 @@ func (c *C2S_Transfer) Verify() error {
 // C2S_TransactionProduct 買商品 賣商品
 type C2S_TransactionProduct struct {
 	TransferMode int             `json:"transaction_mode"` // 交易模式 0:買 1:賣
-	TransferType int             `json:"transaction_type"` // 交易種類 0:限價 1:市價
+	TransferType int             `json:"transaction_type"` // 交易種類 0:限價 1:市價 2:停損市價 3:停損限價
 	ProductName  string          `json:"product_name"`     // 商品名稱
 	UserID       int64           `json:"user_id"`          // 發起交易人
 	Currency     string          `json:"currency"`         // 幣種
 	Amount       decimal.Decimal `json:"amount"`           // 購買價格 LimitPrice 時會參考
+	TriggerPrice decimal.Decimal `json:"trigger_price"`    // 觸發價格 StopMarket StopLimit 時會參考
 	OperateCount int64           `json:"operate_count"`    // 操作數量 ( 買 / 賣)
+	TimeInForce  int             `json:"time_in_force"`    // 有效期限 0:GTC 1:IOC 2:FOK 3:GTD
+	ExpireTime   int64           `json:"expire_time"`      // 到期時間 unix 秒 GTD 時會參考
 }
 
 func (c *C2S_TransactionProduct) ToDomain() (*ProductTransactionParams, error) {
@@ -154,7 +169,10 @@ func (c *C2S_TransactionProduct) ToDomain() (*ProductTransactionParams, error) {
 		UserID:       c.UserID,
 		Currency:     c.Currency,
 		Amount:       c.Amount,
+		TriggerPrice: c.TriggerPrice,
 		OperateCount: c.OperateCount,
+		TimeInForce:  c.TimeInForce,
+		ExpireTime:   c.ExpireTime,
 	}, nil
 }
 
@@ -172,10 +190,27 @@ func (c *C2S_TransactionProduct) Verify() error {
 		return Error_VerifyFailed
 	}
 
-	// 如果交易種類不是 市價 或 現價
+	// 如果交易種類不是 市價 現價 或 停損單
 	switch TransferType(c.TransferType) {
 	case LimitPrice:
 	case MarketPrice:
+	case StopMarket, StopLimit:
+		// 停損單 需要觸發價格
+		if !c.TriggerPrice.GreaterThan(decimal.Zero) {
+			return Error_VerifyFailed
+		}
+	default:
+		return Error_VerifyFailed
+	}
+
+	// 有效期限
+	switch TimeInForce(c.TimeInForce) {
+	case GTC, IOC, FOK:
+	case GTD:
+		// 到期時間 需要大於現在時間
+		if c.ExpireTime <= time.Now().Unix() {
+			return Error_VerifyFailed
+		}
 	default:
 		return Error_VerifyFailed
 	}
@@ -186,13 +221,17 @@ func (c *C2S_TransactionProduct) Verify() error {
 // 購買/販賣 單
 type ProductTransactionParams struct {
 	TransferMode  int             `json:"transaction_mode"` // 交易模式 0:買 1:賣
-	TransferType  int             `json:"transaction_type"` // 交易種類 0:限價 1:市價
+	TransferType  int             `json:"transaction_type"` // 交易種類 0:限價 1:市價 2:停損市價 3:停損限價
 	TransactionID string          `json:"transaction_id"`   // 交易單號
 	ProductName   string          `json:"product_name"`     // 購買的商品名稱
 	UserID        int64           `json:"user_id"`          // 購買人
 	Currency      string          `json:"currency"`         // 幣種
 	Amount        decimal.Decimal `json:"amount"`           // 用戶想購買價格 LimitPrice 時會參考
-	OperateCount  int64           `json:"operate_count"`    // 操作數量 (買 / 賣) (但先固定一次買賣一張, 多張的很複雜)
+	TriggerPrice  decimal.Decimal `json:"trigger_price"`    // 觸發價格 StopMarket StopLimit 時會參考
+	OperateCount  int64           `json:"operate_count"`    // 操作數量 (買 / 賣)
+	RemainCount   int64           `json:"remain_count"`     // 剩餘未成交數量 (可部分成交)
+	TimeInForce   int             `json:"time_in_force"`    // 有效期限 0:GTC 1:IOC 2:FOK 3:GTD
+	ExpireTime    int64           `json:"expire_time"`      // 到期時間 unix 秒 GTD 時會參考
 	TimeStamp     int64           `json:"timestamp"`        // 時間搓
 }
 
@@ -216,6 +255,67 @@ func (c *ProductTransactionParams) GetPrice(marketPrice decimal.Decimal) (price
 	return
 }
 
+// 是否為尚未觸發的停損單
+func (c *ProductTransactionParams) IsStop() bool {
+	switch TransferType(c.TransferType) {
+	case StopMarket, StopLimit:
+		return true
+	}
+	return false
+}
+
+// 是否有委託價格 (限價單 停損限價單)
+func (c *ProductTransactionParams) HasLimitPrice() bool {
+	switch TransferType(c.TransferType) {
+	case LimitPrice, StopLimit:
+		return true
+	}
+	return false
+}
+
+// 預扣金額使用的價格: 有委託價格使用委託價格 (成交價不會高於委託價格), 市價單使用市場價格
+func (c *ProductTransactionParams) HoldPrice(marketPrice decimal.Decimal) decimal.Decimal {
+	if c.HasLimitPrice() {
+		return c.Amount
+	}
+	return marketPrice
+}
+
+// 停損單是否觸發: 買單 最新成交價 >= 觸發價格, 賣單 最新成交價 <= 觸發價格
+func (c *ProductTransactionParams) IsTriggered(lastPrice decimal.Decimal) bool {
+	switch TransferMode(c.TransferMode) {
+	case Purchase:
+		return lastPrice.GreaterThanOrEqual(c.TriggerPrice)
+	case Sell:
+		return lastPrice.LessThanOrEqual(c.TriggerPrice)
+	}
+	return false
+}
+
+// 是否為 搓合後剩餘數量要立即取消的訂單 (IOC FOK)
+func (c *ProductTransactionParams) IsImmediate() bool {
+	switch TimeInForce(c.TimeInForce) {
+	case IOC, FOK:
+		return true
+	}
+	return false
+}
+
+// 是否已到期 (GTD)
+func (c *ProductTransactionParams) IsExpired(now time.Time) bool {
+	return TimeInForce(c.TimeInForce) == GTD && c.ExpireTime > 0 && now.Unix() >= c.ExpireTime
+}
+
+// 觸發停損單, 轉成 市價單 或 限價單
+func (c *ProductTransactionParams) Activate() {
+	switch TransferType(c.TransferType) {
+	case StopMarket:
+		c.TransferType = int(MarketPrice)
+	case StopLimit:
+		c.TransferType = int(LimitPrice)
+	}
+}
+
 // func (c *ProductTransactionParams) ToDomain() (*modelProduct.Product, error) {
 
 // 	// todo 驗證用戶參數
@@ -329,6 +429,22 @@ func (c *C2S_CancelProduct) Verify() error {
 	return nil
 }
 
+// 取消結果
+type CancelResult int
+
+const (
+	Cancel_Result_Cancelled     CancelResult = iota // 0:已取消
+	Cancel_Result_AlreadyFilled                     // 1:已經全部成交 無法取消
+	Cancel_Result_NotFound                          // 2:找不到訂單 (不存在 不是自己的 或 已經結束)
+	Cancel_Result_Pending                           // 3:已送出 等待搓合引擎處理 (結果由成交回報通知)
+)
+
+// 取消 購買/販賣 單 回應
+type S2C_CancelProduct struct {
+	TransactionID string       `json:"transaction_id"` // 交易清單
+	Result        CancelResult `json:"result"`         // 取消結果 0:已取消 1:已經全部成交 2:找不到訂單 3:已送出
+}
+
 // 取消 購買/販賣 單
 type ProductCancelParams struct {
 	TransactionID string `json:"transaction_id"` // 交易清單
@@ -352,3 +468,143 @@ func NewProductCancelParams(Data interface{}) (*ProductCancelParams, error) {
 
 	return &productCancelParams, nil
 }
+
+// 取消全部 購買/販賣 單
+// 用戶只能取消自己的訂單, 管理員可以指定用戶 (0 = 所有用戶)
+type C2S_CancelAllProduct struct {
+	UserID      int64  `json:"user_id"`      // 訂單的用戶 (0 = 所有用戶, 只有管理員可用)
+	ProductName string `json:"product_name"` // 商品名稱 (空的 = 所有商品)
+}
+
+func (c *C2S_CancelAllProduct) ToDomain(operator int64) (*ProductCancelAllParams, error) {
+
+	// 驗證用戶參數
+	if err := c.Verify(); err != nil {
+		return nil, err
+	}
+
+	// 將用戶參數轉換為領域對象
+	return &ProductCancelAllParams{
+		UserID:      c.UserID,
+		ProductName: c.ProductName,
+		Operator:    operator,
+	}, nil
+}
+
+// 驗證商品
+func (c *C2S_CancelAllProduct) Verify() error {
+	if c.UserID < 0 {
+		return Error_VerifyFailed
+	}
+	// 不能同時 所有用戶 所有商品
+	if c.UserID == 0 && len(c.ProductName) == 0 {
+		return Error_VerifyFailed
+	}
+
+	return nil
+}
+
+// 取消全部 購買/販賣 單
+type ProductCancelAllParams struct {
+	UserID      int64  `json:"user_id"`      // 訂單的用戶 (0 = 所有用戶)
+	ProductName string `json:"product_name"` // 商品名稱 (空的 = 所有商品)
+	Operator    int64  `json:"operator"`     // 下指令的用戶 (用戶本人 或 管理員)
+}
+
+// 訂單是否符合取消條件
+func (c *ProductCancelAllParams) Match(order *ProductTransactionParams) bool {
+	if c.UserID > 0 && order.UserID != c.UserID {
+		return false
+	}
+	if len(c.ProductName) > 0 && order.ProductName != c.ProductName {
+		return false
+	}
+	return true
+}
+
+// 修改 購買/販賣 單 (價格 / 數量), 不修改的欄位填 0
+type C2S_AmendProduct struct {
+	TransactionID string          `json:"transaction_id"` // 交易清單
+	UserID        int64           `json:"user_id"`        // 發起交易人
+	Amount        decimal.Decimal `json:"amount"`         // 新的委託價格 (限價單 停損限價單)
+	Currency      string          `json:"currency"`       // 新的委託價格的幣種 (可選, 不填 = 商品的報價幣種)
+	OperateCount  int64           `json:"operate_count"`  // 新的委託數量 (包含已成交的數量)
+}
+
+func (c *C2S_AmendProduct) ToDomain() (*ProductAmendParams, error) {
+
+	// 驗證用戶參數
+	if err := c.Verify(); err != nil {
+		return nil, err
+	}
+
+	// 將用戶參數轉換為領域對象
+	return &ProductAmendParams{
+		TransactionID: c.TransactionID,
+		UserID:        c.UserID,
+		Amount:        c.Amount,
+		Currency:      c.Currency,
+		OperateCount:  c.OperateCount,
+	}, nil
+}
+
+// 驗證商品
+func (c *C2S_AmendProduct) Verify() error {
+	if len(c.TransactionID) == 0 || c.UserID <= 0 {
+		return Error_VerifyFailed
+	}
+	if c.Amount.IsNegative() || c.OperateCount < 0 {
+		return Error_VerifyFailed
+	}
+	// 價格 數量 至少要修改一個
+	if c.Amount.IsZero() && c.OperateCount == 0 {
+		return Error_VerifyFailed
+	}
+
+	return nil
+}
+
+// 修改 購買/販賣 單
+type ProductAmendParams struct {
+	TransactionID string          `json:"transaction_id"` // 交易清單
+	UserID        int64           `json:"user_id"`        // 下單人
+	Amount        decimal.Decimal `json:"amount"`         // 新的委託價格, 0 不修改
+	Currency      string          `json:"currency"`       // 新的委託價格的幣種 (送給搓合引擎前 換算成商品的報價幣種)
+	OperateCount  int64           `json:"operate_count"`  // 新的委託數量 (包含已成交的數量), 0 不修改
+	TimeStamp     int64           `json:"timestamp"`      // 時間搓 (失去時間優先時 使用的新時間)
+}
+
+// 是否修改價格
+func (c *ProductAmendParams) IsPriceChanged(order *ProductTransactionParams) bool {
+	return c.Amount.IsPositive() && !c.Amount.Equal(order.Amount)
+}
+
+// 修改後的剩餘數量 = 新的委託數量 - 已成交數量
+func (c *ProductAmendParams) NewRemainCount(order *ProductTransactionParams) int64 {
+	if c.OperateCount == 0 {
+		return order.RemainCount
+	}
+	return c.OperateCount - (order.OperateCount - order.RemainCount)
+}
+
+// 暫停 / 恢復 商品搓合 (管理員)
+type ProductPauseParams struct {
+	ProductName string `json:"product_name"` // 商品名稱
+}
+
+// 集合競價 開始 / 結束 (搓合引擎內部)
+type ProductAuctionParams struct {
+	ProductName string `json:"product_name"` // 商品名稱
+	AuctionEnd  int64  `json:"auction_end"`  // 集合競價結束時間 unix 秒
+}
+
+// 熔斷結束 恢復交易 (搓合引擎內部)
+type ProductHaltParams struct {
+	ProductName string `json:"product_name"` // 商品名稱
+	HaltEnd     int64  `json:"halt_end"`     // 熔斷暫停交易的結束時間 unix 秒
+}
+
+// 訂單到期 (搓合引擎內部)
+type ProductExpireParams struct {
+	TransactionID string `json:"transaction_id"` // 交易單號
+}
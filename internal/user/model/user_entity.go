@@ -30,6 +30,7 @@ type User struct {
 	Password  string
 	Currency  string
 	Amount    decimal.Decimal
+	VipLevel  int // vip 等級 (影響手續費折扣)
 	CreatedAt time.Time
 	UpdateAt  time.Time
 }
@@ -84,6 +85,7 @@ func (u *User) ToPO() *UserPO {
 		Password: u.Password,
 		Currency: u.Currency,
 		Amount:   u.Amount,
+		VipLevel: u.VipLevel,
 	}
 }
 
@@ -136,14 +138,24 @@ func (r *Rate) Get() decimal.Decimal {
 type Notify_Cmd int
 
 const (
-	Notify_Cmd_Unknow   Notify_Cmd = iota // 未定義
-	Notify_Cmd_Purchase                   // 買商品
-	Notify_Cmd_Sell                       // 賣商品
-	Notify_Cmd_Cancel                     // 取消商品
+	Notify_Cmd_Unknow       Notify_Cmd = iota // 未定義
+	Notify_Cmd_Purchase                       // 買商品
+	Notify_Cmd_Sell                           // 賣商品
+	Notify_Cmd_Cancel                         // 取消商品
+	Notify_Cmd_Amend                          // 修改商品 (價格 / 數量)
+	Notify_Cmd_CancelAll                      // 取消全部商品 (指定用戶 / 指定商品)
+	Notify_Cmd_Pause                          // 暫停商品搓合 (管理員)
+	Notify_Cmd_Resume                         // 恢復商品搓合 (管理員)
+	Notify_Cmd_AuctionStart                   // 集合競價開始 (搓合引擎內部)
+	Notify_Cmd_AuctionEnd                     // 集合競價結束 (搓合引擎內部)
+	Notify_Cmd_HaltEnd                        // 熔斷結束 恢復交易 (搓合引擎內部)
+	Notify_Cmd_Expire                         // 訂單到期 (搓合引擎內部)
 )
 
 // 產品交易通知封包
 type ProductTransactionNotify struct {
-	Cmd  Notify_Cmd  `json:"cmd"`
-	Data interface{} `json:"data"`
+	Cmd    Notify_Cmd  `json:"cmd"`
+	UserID int64       `json:"user_id"` // 下指令的用戶
+	Seq    int64       `json:"seq"`     // 指令序號 (每個用戶遞增, 搓合引擎用來丟棄重複的指令)
+	Data   interface{} `json:"data"`
 }
@@ -0,0 +1,12 @@
+package model
+
+const (
+	UserNotify_Order   = "order"   // 訂單回報 (ExecutionReport)
+	UserNotify_Balance = "balance" // 餘額變動 (S2C_UserInfo)
+)
+
+// 用戶推播通知 (發佈到用戶的通知頻道)
+type UserNotify struct {
+	Type string      `json:"type"` // 種類 order | balance
+	Data interface{} `json:"data"` // 內容
+}
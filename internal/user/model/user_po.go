@@ -20,6 +20,7 @@ type UserPO struct {
 	Password  string          `gorm:"size:100;not null; comment:'使用者密碼'" json:"password"`
 	Currency  string          `gorm:"size:32;not null; comment:'幣種'" json:"currency"`
 	Amount    decimal.Decimal `gorm:"type:decimal(20,2); comment:'金額'" json:"amount"`
+	VipLevel  int             `gorm:"type:int(12);default:0; comment:'vip 等級'" json:"vip_level"`
 	CreatedAt time.Time       `gorm:"autoCreateTime;comment:'創建時間'" json:"created_at"`
 	UpdateAt  time.Time       `gorm:"autoUpdateTime;comment:'更新時間'" json:"update_at"`
 }
@@ -42,6 +43,7 @@ func (u *UserPO) ToDomain() (*User, error) {
 		Password:  u.Password,
 		Currency:  u.Currency,
 		Amount:    u.Amount,
+		VipLevel:  u.VipLevel,
 		CreatedAt: u.CreatedAt,
 		UpdateAt:  u.UpdateAt,
 	}